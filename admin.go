@@ -0,0 +1,209 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	adminDefaultKeysLimit = 100
+	adminMaxKeysLimit     = 10000
+
+	// 关闭时等待正在处理的请求（例如 /debug/pprof/profile）的最长时间，超时后直接断开连接
+	adminShutdownTimeout = 5 * time.Second
+)
+
+// 管理接口的HTTP服务（配置了 AdminAddr 时使用）
+type adminServer struct {
+	server   *http.Server
+	listener net.Listener
+	served   chan struct{}  // Serve 已返回
+	merges   sync.WaitGroup // 通过 /merge 在后台运行的merge
+}
+
+// /keys 的返回结果，Next 不为空时表示还有更多的key，作为下一页的 after 参数
+type adminKeysPage struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"`
+}
+
+// /health 的返回结果
+type adminHealth struct {
+	Status string `json:"status"`
+}
+
+// 在addr上启动管理接口的HTTP服务
+func (db *DB) startAdminServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	admin := &adminServer{listener: listener, served: make(chan struct{})}
+	admin.server = &http.Server{Handler: db.adminHandler(admin)}
+	db.admin = admin
+	go func() {
+		defer close(admin.served)
+		if err := admin.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			db.options.Logger.Errorf("admin server stopped: %v", err)
+		}
+	}()
+	db.options.Logger.Infof("admin server listening on %s", listener.Addr())
+	return nil
+}
+
+// 停止管理接口的HTTP服务，等待正在处理的请求和后台merge完成
+func (db *DB) stopAdminServer() error {
+	if db.admin == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+	err := db.admin.server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = db.admin.server.Close()
+	}
+	<-db.admin.served
+	db.admin.merges.Wait()
+	return err
+}
+
+// 管理接口的HTTP处理器，可以挂载到调用方自己的HTTP服务中：
+//
+//	GET  /stat                           统计信息（Stat），JSON格式
+//	GET  /keys?prefix=&after=&limit=N    按顺序分页列出key，after为上一页返回的next，limit默认100
+//	POST /merge                          在后台开始merge（忽略 DataFileMergeRatio），返回202
+//	POST /sync                           持久化数据文件
+//	GET  /health                         可以正常读写时返回200，正在merge或已关闭时返回503
+//	     /debug/pprof/                   net/http/pprof 性能分析
+func (db *DB) AdminHandler() http.Handler {
+	return db.adminHandler(nil)
+}
+
+// admin为nil时后台merge不计入关闭时的等待
+func (db *DB) adminHandler(admin *adminServer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stat", func(writer http.ResponseWriter, request *http.Request) {
+		if db.closed.Load() {
+			http.Error(writer, ErrDatabaseClosed.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeAdminJSON(writer, http.StatusOK, db.Stat())
+	})
+	mux.HandleFunc("GET /keys", func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		limit := adminDefaultKeysLimit
+		if s := query.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 || n > adminMaxKeysLimit {
+				http.Error(writer, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		keys, more, err := db.keysPage([]byte(query.Get("prefix")), []byte(query.Get("after")), limit)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		page := adminKeysPage{Keys: make([]string, len(keys))}
+		for i, key := range keys {
+			page.Keys[i] = string(key)
+		}
+		if more {
+			page.Next = page.Keys[len(page.Keys)-1]
+		}
+		writeAdminJSON(writer, http.StatusOK, page)
+	})
+	mux.HandleFunc("POST /merge", func(writer http.ResponseWriter, request *http.Request) {
+		if db.closed.Load() {
+			http.Error(writer, ErrDatabaseClosed.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if admin != nil {
+			admin.merges.Add(1)
+		}
+		go func() {
+			if admin != nil {
+				defer admin.merges.Done()
+			}
+			if err := db.MergeForce(); err != nil {
+				db.options.Logger.Errorf("merge triggered by admin api failed: %v", err)
+			}
+		}()
+		writer.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("POST /sync", func(writer http.ResponseWriter, request *http.Request) {
+		if err := db.Sync(); err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /health", func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case db.closed.Load():
+			writeAdminJSON(writer, http.StatusServiceUnavailable, adminHealth{Status: "closed"})
+		case db.merging():
+			writeAdminJSON(writer, http.StatusServiceUnavailable, adminHealth{Status: "merging"})
+		default:
+			writeAdminJSON(writer, http.StatusOK, adminHealth{Status: "ok"})
+		}
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+func writeAdminJSON(writer http.ResponseWriter, status int, v any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(v)
+}
+
+// 是否正在进行merge、vacuum、GC或Compact
+func (db *DB) merging() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.isMerging
+}
+
+// 按顺序列出prefix下大于after的最多limit个未过期的key，more表示之后是否还有key
+func (db *DB) keysPage(prefix, after []byte, limit int) (keys [][]byte, more bool, err error) {
+	if db.closed.Load() {
+		return nil, false, ErrDatabaseClosed
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	start := prefix
+	if bytes.Compare(after, start) > 0 {
+		start = after
+	}
+	now := time.Now().UnixNano()
+	for iterator.Seek(start); iterator.Valid() && bytes.HasPrefix(iterator.Key(), prefix); iterator.Next() {
+		if len(after) > 0 && bytes.Equal(iterator.Key(), after) {
+			continue
+		}
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		if len(keys) == limit {
+			return keys, true, nil
+		}
+		keys = append(keys, iterator.Key())
+	}
+	return keys, false, nil
+}
@@ -0,0 +1,148 @@
+package bitcask_go
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(t *testing.T, method, url string, expectedStatus int, result any) {
+	t.Helper()
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != expectedStatus {
+		t.Fatalf("%s %s: expected status %d, got %d", method, url, expectedStatus, response.StatusCode)
+	}
+	if result != nil {
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_AdminHandler(t *testing.T) {
+	mergeEnd := make(chan error, 1)
+	db := openTestDB(t, func(options *Options) {
+		options.Hooks.OnMergeEnd = func(err error) { mergeEnd <- err }
+	})
+	for i := 0; i < 5; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("user:%d", i)), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put([]byte("other"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	putExpired(t, db, []byte("user:expired"), []byte("v"))
+	server := httptest.NewServer(db.AdminHandler())
+	defer server.Close()
+
+	var stat Stat
+	adminRequest(t, http.MethodGet, server.URL+"/stat", http.StatusOK, &stat)
+	if stat.KeyNum != 7 || stat.DataFileNum != 1 {
+		t.Fatalf("unexpected stat %+v", stat)
+	}
+	adminRequest(t, http.MethodPost, server.URL+"/stat", http.StatusMethodNotAllowed, nil)
+
+	// 分页列出key，已过期的key不列出
+	var keys []string
+	next := ""
+	for pages := 0; ; pages++ {
+		var page adminKeysPage
+		adminRequest(t, http.MethodGet, server.URL+"/keys?prefix=user:&limit=2&after="+next, http.StatusOK, &page)
+		keys = append(keys, page.Keys...)
+		if page.Next == "" {
+			if pages != 2 {
+				t.Fatalf("expected 3 pages, got %d", pages+1)
+			}
+			break
+		}
+		next = page.Next
+	}
+	if strings.Join(keys, ",") != "user:0,user:1,user:2,user:3,user:4" {
+		t.Fatalf("unexpected keys %q", keys)
+	}
+	var page adminKeysPage
+	adminRequest(t, http.MethodGet, server.URL+"/keys", http.StatusOK, &page)
+	if len(page.Keys) != 6 || page.Next != "" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+	adminRequest(t, http.MethodGet, server.URL+"/keys?limit=0", http.StatusBadRequest, nil)
+	adminRequest(t, http.MethodGet, server.URL+"/keys?limit=x", http.StatusBadRequest, nil)
+
+	adminRequest(t, http.MethodPost, server.URL+"/sync", http.StatusOK, nil)
+
+	// 正在merge时健康检查返回503
+	var health adminHealth
+	adminRequest(t, http.MethodGet, server.URL+"/health", http.StatusOK, &health)
+	db.mu.Lock()
+	db.isMerging = true
+	db.mu.Unlock()
+	adminRequest(t, http.MethodGet, server.URL+"/health", http.StatusServiceUnavailable, &health)
+	if health.Status != "merging" {
+		t.Fatalf("unexpected health %+v", health)
+	}
+	db.mu.Lock()
+	db.isMerging = false
+	db.mu.Unlock()
+
+	adminRequest(t, http.MethodPost, server.URL+"/merge", http.StatusAccepted, nil)
+	select {
+	case err := <-mergeEnd:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("merge triggered by admin api did not finish")
+	}
+
+	adminRequest(t, http.MethodGet, server.URL+"/debug/pprof/", http.StatusOK, nil)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	adminRequest(t, http.MethodGet, server.URL+"/health", http.StatusServiceUnavailable, &health)
+	adminRequest(t, http.MethodGet, server.URL+"/keys", http.StatusServiceUnavailable, nil)
+}
+
+// Open 时启动管理接口，Close 时关闭；地址被占用时 Open 失败并释放数据目录
+func TestDB_AdminAddr(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.AdminAddr = "127.0.0.1:0"
+	})
+	url := "http://" + db.admin.listener.Addr().String()
+	adminRequest(t, http.MethodGet, url+"/health", http.StatusOK, nil)
+
+	options := DefaultOptions
+	options.DirPath = t.TempDir()
+	options.AdminAddr = db.admin.listener.Addr().String()
+	if _, err := Open(options); err == nil {
+		t.Fatal("expected open to fail when the admin address is in use")
+	}
+	options.AdminAddr = ""
+	other, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(url + "/health"); err == nil {
+		t.Fatal("expected admin server to be stopped after close")
+	}
+}
@@ -0,0 +1,168 @@
+package bitcask_go
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+
+	"bitcask-go/index"
+)
+
+// 持久化的ART索引文件中的元数据：事务序列号、可回收的数据量，以及保存时每个数据文件的大小
+// 打开时数据文件的集合和大小都和保存时一致，才说明之后没有写入（异常退出、以其他索引类型打开后写入等情况）
+type artIndexMeta struct {
+	seqNo       uint64
+	reclaimSize int64
+	fileSizes   map[uint32]int64
+}
+
+func (meta *artIndexMeta) encode() []byte {
+	fileIds := make([]uint32, 0, len(meta.fileSizes))
+	for fid := range meta.fileSizes {
+		fileIds = append(fileIds, fid)
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	buf := make([]byte, 0, binary.MaxVarintLen64*(3+2*len(fileIds)))
+	buf = binary.AppendUvarint(buf, meta.seqNo)
+	buf = binary.AppendVarint(buf, meta.reclaimSize)
+	buf = binary.AppendUvarint(buf, uint64(len(fileIds)))
+	for _, fid := range fileIds {
+		buf = binary.AppendUvarint(buf, uint64(fid))
+		buf = binary.AppendVarint(buf, meta.fileSizes[fid])
+	}
+	return buf
+}
+
+func decodeArtIndexMeta(buf []byte) (*artIndexMeta, bool) {
+	var index int
+	readUvarint := func() (uint64, bool) {
+		v, n := binary.Uvarint(buf[index:])
+		index += n
+		return v, n > 0
+	}
+	readVarint := func() (int64, bool) {
+		v, n := binary.Varint(buf[index:])
+		index += n
+		return v, n > 0
+	}
+
+	meta := &artIndexMeta{fileSizes: make(map[uint32]int64)}
+	var ok bool
+	if meta.seqNo, ok = readUvarint(); !ok {
+		return nil, false
+	}
+	if meta.reclaimSize, ok = readVarint(); !ok {
+		return nil, false
+	}
+	count, ok := readUvarint()
+	if !ok {
+		return nil, false
+	}
+	for i := uint64(0); i < count; i++ {
+		fid, ok := readUvarint()
+		if !ok {
+			return nil, false
+		}
+		size, ok := readVarint()
+		if !ok {
+			return nil, false
+		}
+		meta.fileSizes[uint32(fid)] = size
+	}
+	return meta, index == len(buf)
+}
+
+// 打开时加载持久化的ART索引，返回是否加载成功
+// 文件不存在、损坏或者和数据文件不一致时返回false，由调用方从hint文件和数据文件加载索引
+// 加载成功后删除文件，之后的写入不会反映在文件中，异常退出时下次打开需要重放数据文件
+func (db *DB) loadARTIndex() (bool, error) {
+	artIndex, ok := db.index.(*index.PersistentART)
+	if !ok || db.activeFile == nil {
+		return false, nil
+	}
+	buf, err := artIndex.Load()
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err == index.ErrARTIndexCorrupted {
+		db.options.Logger.Warnf("art index file is corrupted, rebuilding index from data files")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	meta, ok := decodeArtIndexMeta(buf)
+	if ok {
+		ok, err = db.matchDataFileSizes(meta.fileSizes)
+		if err != nil {
+			return false, err
+		}
+	}
+	if !ok {
+		// 加载的索引已经替换了当前的树，需要重新创建
+		db.options.Logger.Warnf("art index file does not match data files, rebuilding index from data files")
+		db.index = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites, db.options.BTreeDegree)
+		return false, nil
+	}
+
+	db.seqNo = meta.seqNo
+	db.reclaimSize = meta.reclaimSize
+	db.activeFile.WriteOff = meta.fileSizes[db.activeFile.FileId]
+	if !db.options.ReadOnly {
+		if err := index.RemoveARTIndexFile(db.options.DirPath); err != nil {
+			return false, err
+		}
+	}
+	db.options.Logger.Infof("loaded %d keys from art index file", db.index.Size())
+	return true, nil
+}
+
+// 当前的数据文件和保存索引时是否一致
+func (db *DB) matchDataFileSizes(fileSizes map[uint32]int64) (bool, error) {
+	if len(fileSizes) != len(db.olderFiles)+1 {
+		return false, nil
+	}
+	for fid, expected := range fileSizes {
+		dataFile := db.olderFiles[fid]
+		if fid == db.activeFile.FileId {
+			dataFile = db.activeFile
+		}
+		if dataFile == nil {
+			return false, nil
+		}
+		size, err := dataFile.IOManager.Size()
+		if err != nil {
+			return false, err
+		}
+		if size != expected {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// 关闭时将ART索引保存到文件中（调用方需持有写锁）
+func (db *DB) saveARTIndex() error {
+	artIndex, ok := db.index.(*index.PersistentART)
+	if !ok {
+		return nil
+	}
+	meta := &artIndexMeta{
+		seqNo:       db.seqNo,
+		reclaimSize: db.reclaimSize,
+		fileSizes:   make(map[uint32]int64, len(db.olderFiles)+1),
+	}
+	for fid, dataFile := range db.olderFiles {
+		size, err := dataFile.DataSize()
+		if err != nil {
+			return err
+		}
+		meta.fileSizes[fid] = size
+	}
+	meta.fileSizes[db.activeFile.FileId] = db.activeFile.WriteOff
+	return artIndex.Save(meta.encode())
+}
@@ -0,0 +1,157 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bitcask-go/index"
+)
+
+func artIndexFileExists(t *testing.T, db *DB) bool {
+	t.Helper()
+	_, err := os.Stat(filepath.Join(db.options.DirPath, index.ARTIndexFileName))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+// 关闭后重新打开时直接加载索引文件，不重放数据文件
+func TestDB_PersistentARTIndex(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.MMapAtStartup = true },
+		func(options *Options) { options.ChecksumMode = PerBlock },
+		func(options *Options) { options.ValueLogSeparationThreshold = 16 },
+	} {
+		logger := &captureLogger{}
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = PersistentART
+			options.DataFileSize = 8 * 1024
+			options.Logger = logger
+			if configure != nil {
+				configure(options)
+			}
+		})
+		writeReplicationData(t, db, 0)
+		writeReplicationData(t, db, 1)
+		if err := db.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		for round := 0; round < 2; round++ {
+			expected := dumpDB(t, db)
+			seqNo := db.SeqNo()
+			stat := db.Stat()
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !artIndexFileExists(t, db) {
+				t.Fatal("expected art index file after close")
+			}
+
+			db = reopenTestDB(t, db)
+			if logger.count("INFO loaded") != round+1 {
+				t.Fatalf("expected index loaded from file, got %q", logger.lines)
+			}
+			if artIndexFileExists(t, db) {
+				t.Fatal("expected art index file removed after open")
+			}
+			expectContent(t, db, expected)
+			if db.SeqNo() != seqNo {
+				t.Fatalf("expected seq no %d, got %d", seqNo, db.SeqNo())
+			}
+			if reopened := db.Stat(); reopened.KeyNum != stat.KeyNum || reopened.ReclaimableSize != stat.ReclaimableSize {
+				t.Fatalf("unexpected stat after reopen: %+v, before %+v", reopened, stat)
+			}
+			if pos := db.index.Get([]byte("ttl")); pos == nil || pos.Expire == 0 {
+				t.Fatalf("expected expire time kept, got %+v", pos)
+			}
+
+			// 加载之后可以继续写入
+			writeReplicationData(t, db, round+2)
+		}
+	}
+}
+
+// 索引文件不存在、损坏或者和数据文件不一致时，从hint文件和数据文件加载索引
+func TestDB_PersistentARTIndexFallback(t *testing.T) {
+	logger := &captureLogger{}
+	db := openTestDB(t, func(options *Options) {
+		options.IndexType = PersistentART
+		options.DataFileSize = 8 * 1024
+		options.Logger = logger
+	})
+	writeReplicationData(t, db, 0)
+	expected := dumpDB(t, db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	indexFile := filepath.Join(db.options.DirPath, index.ARTIndexFileName)
+
+	// 索引文件损坏
+	content, err := os.ReadFile(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)/2] ^= 0xff
+	if err := os.WriteFile(indexFile, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if logger.count("WARN art index file is corrupted") != 1 || logger.count("INFO loaded") != 0 {
+		t.Fatalf("expected corrupted index ignored, got %q", logger.lines)
+	}
+	expectContent(t, db, expected)
+
+	// 以其他索引类型打开之后写入，索引文件和数据文件不一致
+	options := db.options
+	options.IndexType = Btree
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeReplicationData(t, db, 1)
+	expected = dumpDB(t, db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	options.IndexType = PersistentART
+	db, err = Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.count("WARN art index file does not match data files") != 1 || logger.count("INFO loaded") != 0 {
+		t.Fatalf("expected stale index ignored, got %q", logger.lines)
+	}
+	expectContent(t, db, expected)
+
+	// merge的结果生效时删除索引文件，从hint文件加载
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if logger.count("INFO applied merge results") != 1 || logger.count("INFO loaded") != 0 {
+		t.Fatalf("expected index rebuilt after merge, got %q", logger.lines)
+	}
+	expectContent(t, db, expected)
+
+	// 索引文件不存在
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(indexFile); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(db.options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	expectContent(t, db, expected)
+}
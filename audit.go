@@ -0,0 +1,250 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// 审计日志文件名，只追加写入，不参与merge
+const AuditLogFileName = "audit.log"
+
+// 审计记录的编码：crc(4) | 时间戳(8) | 操作类型(1) | key的SHA-256(32) | 协程ID(8) | 事务序列号(8)
+// 记录是定长的，可以直接从文件末尾向前读取最近的记录
+const auditRecordSize = crc32.Size + 8 + 1 + sha256.Size + 8 + 8
+
+type AuditOp = byte
+
+const (
+	// AuditPut 写入key（Put、Update以及批量写入中的Put）
+	AuditPut AuditOp = iota + 1
+
+	// AuditDelete 删除key（Delete、Update以及批量写入中的Delete）
+	AuditDelete
+
+	// AuditFlushAll 清空数据库（FlushAll），KeyHash为空key的哈希值
+	AuditFlushAll
+)
+
+// 一条审计记录，只保存key的哈希值，不保存key本身
+type AuditEntry struct {
+	Timestamp   int64             // 写入时间，Unix纳秒
+	Op          AuditOp           // 操作类型
+	KeyHash     [sha256.Size]byte // key的SHA-256
+	GoroutineID uint64            // 调用方的协程ID
+	SeqNo       uint64            // 事务序列号，非事务写入且未开启多版本时为0
+}
+
+// 审计日志文件
+type auditLog struct {
+	mu         sync.Mutex
+	ioManager  *fio.FileIO
+	size       int64
+	syncWrites bool
+	closed     bool
+}
+
+// 打开审计日志文件，丢弃末尾不完整的记录（写入时崩溃留下的）
+func openAuditLog(dirPath string, syncWrites bool) (*auditLog, error) {
+	ioManager, err := fio.NewFileIOManager(filepath.Join(dirPath, AuditLogFileName))
+	if err != nil {
+		return nil, err
+	}
+	size, err := ioManager.Size()
+	if err != nil {
+		_ = ioManager.Close()
+		return nil, err
+	}
+	if tail := size % auditRecordSize; tail != 0 {
+		size -= tail
+		if err := ioManager.Truncate(size); err != nil {
+			_ = ioManager.Close()
+			return nil, err
+		}
+	}
+	return &auditLog{ioManager: ioManager, size: size, syncWrites: syncWrites}, nil
+}
+
+// 追加审计记录，多条记录合并为一次写入；写入失败时截断到写入之前的大小，不留下不完整的记录
+func (al *auditLog) write(entries []AuditEntry) error {
+	buf := make([]byte, 0, len(entries)*auditRecordSize)
+	for i := range entries {
+		buf = append(buf, encodeAuditEntry(&entries[i])...)
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.closed {
+		return ErrDatabaseClosed
+	}
+	if _, err := al.ioManager.Write(buf); err != nil {
+		if truncateErr := al.ioManager.Truncate(al.size); truncateErr != nil {
+			return truncateErr
+		}
+		return err
+	}
+	al.size += int64(len(buf))
+	if al.syncWrites {
+		return al.ioManager.Sync()
+	}
+	return nil
+}
+
+func (al *auditLog) sync() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.closed {
+		return nil
+	}
+	return al.ioManager.Sync()
+}
+
+// 关闭审计日志文件，重复关闭时直接返回
+func (al *auditLog) close() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.closed {
+		return nil
+	}
+	al.closed = true
+	if err := al.ioManager.Sync(); err != nil {
+		return err
+	}
+	return al.ioManager.Close()
+}
+
+func encodeAuditEntry(entry *AuditEntry) []byte {
+	buf := make([]byte, auditRecordSize)
+	index := crc32.Size
+	binary.LittleEndian.PutUint64(buf[index:], uint64(entry.Timestamp))
+	index += 8
+	buf[index] = entry.Op
+	index++
+	copy(buf[index:], entry.KeyHash[:])
+	index += sha256.Size
+	binary.LittleEndian.PutUint64(buf[index:], entry.GoroutineID)
+	index += 8
+	binary.LittleEndian.PutUint64(buf[index:], entry.SeqNo)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[crc32.Size:]))
+	return buf
+}
+
+func decodeAuditEntry(buf []byte) (AuditEntry, error) {
+	var entry AuditEntry
+	if crc32.ChecksumIEEE(buf[crc32.Size:auditRecordSize]) != binary.LittleEndian.Uint32(buf) {
+		return entry, data.ErrInvalidCRC
+	}
+	index := crc32.Size
+	entry.Timestamp = int64(binary.LittleEndian.Uint64(buf[index:]))
+	index += 8
+	entry.Op = buf[index]
+	index++
+	copy(entry.KeyHash[:], buf[index:])
+	index += sha256.Size
+	entry.GoroutineID = binary.LittleEndian.Uint64(buf[index:])
+	index += 8
+	entry.SeqNo = binary.LittleEndian.Uint64(buf[index:])
+	return entry, nil
+}
+
+// 构造一条审计记录
+func newAuditEntry(op AuditOp, key []byte, seqNo, goroutineID uint64) AuditEntry {
+	return AuditEntry{
+		Timestamp:   time.Now().UnixNano(),
+		Op:          op,
+		KeyHash:     sha256.Sum256(key),
+		GoroutineID: goroutineID,
+		SeqNo:       seqNo,
+	}
+}
+
+// 写入审计记录，未开启审计日志时直接返回
+// 审计记录在数据写入成功之后写入，此时数据已经生效，写入失败只记录错误日志，不作为写入失败返回给调用方
+func (db *DB) audit(entries ...AuditEntry) {
+	if db.auditLog == nil || len(entries) == 0 {
+		return
+	}
+	if err := db.auditLog.write(entries); err != nil {
+		db.options.Logger.Errorf("failed to write %d audit entries: %v", len(entries), err)
+	}
+}
+
+// 记录当前协程的一次写入或删除
+func (db *DB) auditWrite(op AuditOp, key []byte, seqNo uint64) {
+	if db.auditLog == nil {
+		return
+	}
+	db.audit(newAuditEntry(op, key, seqNo, goroutineID()))
+}
+
+// 读取审计日志中最近的n条记录，按写入顺序返回
+// 只读模式下也可以读取（只读实例不写入审计日志）
+func (db *DB) TailAuditLog(n int) ([]AuditEntry, error) {
+	if !db.options.AuditLog {
+		return nil, ErrAuditLogDisabled
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	// 和写入互斥，避免读到正在写入的记录
+	if db.auditLog != nil {
+		db.auditLog.mu.Lock()
+		defer db.auditLog.mu.Unlock()
+	}
+
+	file, err := os.Open(filepath.Join(db.options.DirPath, AuditLogFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := stat.Size() - stat.Size()%auditRecordSize
+	count := size / auditRecordSize
+	if int64(n) < count {
+		count = int64(n)
+	}
+
+	buf := make([]byte, count*auditRecordSize)
+	if _, err := file.ReadAt(buf, size-int64(len(buf))); err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, count)
+	for i := range entries {
+		entry, err := decodeAuditEntry(buf[i*auditRecordSize:])
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+// 获取当前协程的ID，从调用栈的第一行 "goroutine 123 [running]:" 中解析
+func goroutineID() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	fields := bytes.Fields(buf[:n])
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return id
+}
@@ -0,0 +1,311 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+func expectAuditEntry(t *testing.T, entry AuditEntry, op AuditOp, key string) {
+	t.Helper()
+	if entry.Op != op || entry.KeyHash != sha256.Sum256([]byte(key)) {
+		t.Fatalf("expected %d %s, got %d %x", op, key, entry.Op, entry.KeyHash)
+	}
+}
+
+func TestDB_AuditLog(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.AuditLog = true
+	})
+
+	if err := db.Put([]byte("k1"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("k2"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete([]byte("k1")); err != nil {
+		t.Fatal(err)
+	}
+	// 删除不存在的key不产生审计记录
+	if err := db.Delete([]byte("missing")); err != nil {
+		t.Fatal(err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	if err := wb.Put([]byte("k3"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Delete([]byte("k2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.TailAuditLog(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, got %d", len(entries))
+	}
+	expectAuditEntry(t, entries[0], AuditPut, "k1")
+	expectAuditEntry(t, entries[1], AuditPut, "k2")
+	expectAuditEntry(t, entries[2], AuditDelete, "k1")
+	// 批量写入中的记录顺序不固定，共用同一个事务序列号
+	batch := map[[sha256.Size]byte]AuditOp{entries[3].KeyHash: entries[3].Op, entries[4].KeyHash: entries[4].Op}
+	if batch[sha256.Sum256([]byte("k3"))] != AuditPut || batch[sha256.Sum256([]byte("k2"))] != AuditDelete {
+		t.Fatalf("unexpected batch entries: %v", batch)
+	}
+	if entries[3].SeqNo == nonTransactionSeqNo || entries[3].SeqNo != entries[4].SeqNo {
+		t.Fatalf("unexpected batch seq no %d %d", entries[3].SeqNo, entries[4].SeqNo)
+	}
+
+	gid := goroutineID()
+	for i, entry := range entries {
+		if i < 3 && entry.SeqNo != nonTransactionSeqNo {
+			t.Fatalf("entry %d: expected seq no 0, got %d", i, entry.SeqNo)
+		}
+		if entry.GoroutineID != gid {
+			t.Fatalf("entry %d: expected goroutine %d, got %d", i, gid, entry.GoroutineID)
+		}
+		if i > 0 && entry.Timestamp < entries[i-1].Timestamp {
+			t.Fatalf("entry %d: timestamp went backwards", i)
+		}
+	}
+
+	// 只返回最近的n条
+	last, err := db.TailAuditLog(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 2 || last[0] != entries[3] || last[1] != entries[4] {
+		t.Fatalf("unexpected tail: %v", last)
+	}
+
+	// 审计日志中只保存key的哈希值
+	content, err := os.ReadFile(filepath.Join(db.options.DirPath, AuditLogFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("k1")) {
+		t.Fatal("audit log contains raw key")
+	}
+}
+
+func TestDB_AuditLogWritePaths(t *testing.T) {
+	for _, configure := range []func(*Options){
+		func(options *Options) { options.GroupCommit = true },
+		func(options *Options) { options.StripedLockCount = 16 },
+		func(options *Options) { options.MaxVersionsPerKey = 3 },
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.AuditLog = true
+			configure(options)
+		})
+		if err := db.Put([]byte("k"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Update([]byte("k"), func(oldValue []byte) ([]byte, error) { return nil, nil }); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := db.TailAuditLog(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("expected 2 entries, got %d", len(entries))
+		}
+		expectAuditEntry(t, entries[0], AuditPut, "k")
+		expectAuditEntry(t, entries[1], AuditDelete, "k")
+		if entries[0].GoroutineID != goroutineID() {
+			t.Fatalf("expected caller goroutine %d, got %d", goroutineID(), entries[0].GoroutineID)
+		}
+		// 开启多版本时非事务写入也会分配序列号
+		if db.options.MaxVersionsPerKey > 1 && (entries[0].SeqNo == 0 || entries[1].SeqNo <= entries[0].SeqNo) {
+			t.Fatalf("unexpected seq no %d %d", entries[0].SeqNo, entries[1].SeqNo)
+		}
+	}
+}
+
+// 审计日志在重启和merge之后保留
+func TestDB_AuditLogSurvivesRestartAndMerge(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.AuditLog = true
+		options.DataFileSize = 4 * 1024
+	})
+	for i := 0; i < 200; i++ {
+		if err := db.Put(testKey(i%10), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if err := db.Put([]byte("after"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.TailAuditLog(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 201 {
+		t.Fatalf("expected 201 entries, got %d", len(entries))
+	}
+	expectAuditEntry(t, entries[0], AuditPut, string(testKey(0)))
+	expectAuditEntry(t, entries[200], AuditPut, "after")
+
+	// 只读模式可以读取但不写入
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	options := db.options
+	options.ReadOnly = true
+	readOnly, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	if entries, err := readOnly.TailAuditLog(1); err != nil || len(entries) != 1 {
+		t.Fatalf("TailAuditLog in read only mode = %v, %v", entries, err)
+	}
+}
+
+func TestDB_AuditLogCorruption(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.AuditLog = true
+	})
+	for i := 0; i < 3; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 末尾不完整的记录在打开时被丢弃
+	path := filepath.Join(db.options.DirPath, AuditLogFileName)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("torn")); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	db, err = Open(db.options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put(testKey(3), testValue(3)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := db.TailAuditLog(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	expectAuditEntry(t, entries[3], AuditPut, string(testKey(3)))
+
+	// 损坏的记录校验失败
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[auditRecordSize+10] ^= 0xff
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TailAuditLog(10); err != data.ErrInvalidCRC {
+		t.Fatalf("expected ErrInvalidCRC, got %v", err)
+	}
+	if entries, err := db.TailAuditLog(2); err != nil || len(entries) != 2 {
+		t.Fatalf("TailAuditLog(2) = %v, %v", entries, err)
+	}
+}
+
+// 写入审计日志失败时数据已经生效，只记录错误日志，写入和批量提交仍然返回成功
+func TestDB_AuditLogWriteFailure(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.GroupCommit = true },
+		func(options *Options) { options.StripedLockCount = 16 },
+	} {
+		logger := &captureLogger{}
+		db := openTestDB(t, func(options *Options) {
+			options.AuditLog = true
+			options.Logger = logger
+			if configure != nil {
+				configure(options)
+			}
+		})
+
+		// 换成已经关闭的文件，之后的审计记录都写入失败
+		broken, err := fio.NewFileIOManager(filepath.Join(t.TempDir(), AuditLogFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = broken.Close()
+		original := db.auditLog.ioManager
+		db.auditLog.ioManager = broken
+
+		if err := db.Put([]byte("k"), []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete([]byte("k")); err != nil {
+			t.Fatal(err)
+		}
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		if err := wb.Put([]byte("k"), []byte("v2")); err != nil {
+			t.Fatal(err)
+		}
+		if err := wb.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		// 提交成功后释放暂存的key，其他批次可以继续写入同一个key
+		other := db.NewWriteBatch(DefaultWriteBatchOptions)
+		if err := other.Put([]byte("k"), []byte("v3")); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := db.Get([]byte("k")); err != nil || string(value) != "v3" {
+			t.Fatalf("Get = %q, %v", value, err)
+		}
+		if logger.count("ERROR failed to write") != 4 {
+			t.Fatalf("expected 4 audit error logs, got %q", logger.lines)
+		}
+
+		db.auditLog.ioManager = original
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_AuditLogDisabled(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TailAuditLog(1); err != ErrAuditLogDisabled {
+		t.Fatalf("expected ErrAuditLogDisabled, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, AuditLogFileName)); !os.IsNotExist(err) {
+		t.Fatalf("audit log should not exist: %v", err)
+	}
+}
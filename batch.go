@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"bitcask-go/data"
 )
@@ -19,7 +20,74 @@ type WriteBatch struct {
 	options       WriteBatchOptions
 	mu            *sync.Mutex
 	db            *DB
-	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据，实现一次性批量写入文件
+	pendingWrites map[string]*data.LogRecord    // 暂存用户写入的数据，实现一次性批量写入文件
+	id            uint64                        // 批次id（开启冲突检测时使用）
+	stagedKeys    map[string]struct{}           // 在冲突检测中登记过的key（开启冲突检测时使用）
+	expired       chan struct{}                 // 提交超时后关闭（设置了 CommitTimeout 时使用）
+	readSet       map[string]*data.LogRecordPos // 事务读取的key以及读取时的位置，提交时位置发生变化则返回 ErrConflict（通过 Txn 使用时）
+	savepoints    []savepoint                   // 有效的保存点，按创建顺序排列
+	undoLog       []batchUndo                   // 创建第一个保存点之后对暂存区的修改，用于回滚到保存点
+	nextSavepoint uint64                        // 下一个保存点的id
+}
+
+// 批次中的保存点，RollbackToSavepoint 可以撤销保存点之后暂存的写入和删除
+// 批次提交成功或者调用 Rollback 之后保存点失效
+type SavepointHandle struct {
+	batch *WriteBatch
+	id    uint64
+}
+
+type savepoint struct {
+	id      uint64
+	undoLen int // 创建保存点时 undoLog 的长度
+}
+
+// 对暂存区的一次修改，回滚时恢复修改之前的状态
+type batchUndo struct {
+	key    string
+	prev   *data.LogRecord // 修改之前暂存的记录，为nil表示之前没有暂存
+	staged bool            // 此次修改是否第一次在冲突检测中登记key
+}
+
+// 检测并发的批量写入之间的冲突：记录每个key被哪个未提交的批次暂存
+// 批次的提交在数据库的写锁下串行执行，不会真正死锁；但两个批次各自读取后写入对方也在修改的key时，先提交的结果会被后提交的覆盖
+// 第一个暂存key的批次拥有这个key，其他批次暂存了相同的key时提交返回 ErrPotentialDeadlock
+// 两个批次可能各自拥有对方需要的key，调用方应当 Rollback 释放登记的key，再按指数退避（加上随机抖动）重新读取并构造批次
+type DeadlockDetector struct {
+	owners sync.Map // key -> 批次id
+	nextID uint64
+}
+
+// 分配新的批次id
+func (d *DeadlockDetector) newBatchID() uint64 {
+	return atomic.AddUint64(&d.nextID, 1)
+}
+
+// 登记批次暂存的key，已经被其他批次登记时保持不变
+func (d *DeadlockDetector) stage(key string, batchID uint64) {
+	d.owners.LoadOrStore(key, batchID)
+}
+
+// 检查批次暂存的key是否被其他未提交的批次登记
+func (d *DeadlockDetector) conflicts(keys map[string]struct{}, batchID uint64) bool {
+	for key := range keys {
+		if owner, ok := d.owners.Load(key); ok && owner.(uint64) != batchID {
+			return true
+		}
+	}
+	return false
+}
+
+// 提交或回滚之后释放批次登记的key
+func (d *DeadlockDetector) release(keys map[string]struct{}, batchID uint64) {
+	for key := range keys {
+		d.owners.CompareAndDelete(key, batchID)
+	}
+}
+
+// 回滚到保存点时释放保存点之后登记的一个key
+func (d *DeadlockDetector) releaseKey(key string, batchID uint64) {
+	d.owners.CompareAndDelete(key, batchID)
 }
 
 // 初始化WriteBatch
@@ -29,12 +97,47 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 		panic("cannot use write batch, seq no file not exists")
 	}
 
-	return &WriteBatch{
+	wb := &WriteBatch{
 		options:       opts,
 		mu:            new(sync.Mutex),
 		db:            db,
 		pendingWrites: make(map[string]*data.LogRecord),
 	}
+	if opts.DetectConflicts {
+		wb.id = db.deadlockDetector.newBatchID()
+		wb.stagedKeys = make(map[string]struct{})
+	}
+	return wb
+}
+
+// 登记暂存的key（开启冲突检测时，调用方需持有批次的锁）
+func (wb *WriteBatch) stage(key []byte) {
+	if wb.stagedKeys == nil {
+		return
+	}
+	wb.stagedKeys[string(key)] = struct{}{}
+	wb.db.deadlockDetector.stage(string(key), wb.id)
+}
+
+// 修改暂存区中key的记录，record为nil时从暂存区中删除，stage为true时登记key（调用方需持有批次的锁）
+// 存在保存点时，先记录修改之前的状态
+func (wb *WriteBatch) setPending(key []byte, record *data.LogRecord, stage bool) {
+	if len(wb.savepoints) > 0 {
+		undo := batchUndo{key: string(key), prev: wb.pendingWrites[string(key)]}
+		if stage && wb.stagedKeys != nil {
+			_, staged := wb.stagedKeys[string(key)]
+			undo.staged = !staged
+		}
+		wb.undoLog = append(wb.undoLog, undo)
+	}
+	if record == nil {
+		delete(wb.pendingWrites, string(key))
+	} else {
+		wb.pendingWrites[string(key)] = record
+	}
+	if stage {
+		wb.stage(key)
+	}
 }
 
 // 批量写数据
@@ -51,7 +154,7 @@ func (wb *WriteBatch) Put(key, value []byte) error {
 		Key:   key,
 		Value: value,
 	}
-	wb.pendingWrites[string(key)] = logRecord
+	wb.setPending(key, logRecord, true)
 	return nil
 }
 
@@ -69,7 +172,7 @@ func (wb *WriteBatch) Delete(key []byte) error {
 		// 如果内存中不存在
 		if wb.pendingWrites[string(key)] != nil {
 			// 如果暂存区还有数据，则删除暂存区的此数据
-			delete(wb.pendingWrites, string(key))
+			wb.setPending(key, nil, false)
 		}
 		return nil
 	}
@@ -79,45 +182,257 @@ func (wb *WriteBatch) Delete(key []byte) error {
 		Key:  key,
 		Type: data.LogRecordDeleted,
 	}
-	wb.pendingWrites[string(key)] = logRecord
+	wb.setPending(key, logRecord, true)
 	return nil
 }
 
+// 丢弃暂存区的内容，开启冲突检测时释放登记的key，所有保存点失效
+func (wb *WriteBatch) Rollback() {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.releaseStagedKeys()
+	wb.clearSavepoints()
+}
+
+// 在暂存区的当前状态创建保存点，可以嵌套创建多个
+func (wb *WriteBatch) Savepoint() SavepointHandle {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.nextSavepoint++
+	wb.savepoints = append(wb.savepoints, savepoint{id: wb.nextSavepoint, undoLen: len(wb.undoLog)})
+	return SavepointHandle{batch: wb, id: wb.nextSavepoint}
+}
+
+// 撤销保存点之后暂存的写入和删除，暂存区恢复到创建保存点时的状态，开启冲突检测时释放保存点之后才登记的key
+// 保存点本身仍然有效，可以再次回滚到它；之后创建的保存点失效
+// 保存点不属于此批次，或者批次已经提交或回滚时返回 ErrInvalidSavepoint
+func (wb *WriteBatch) RollbackToSavepoint(sp SavepointHandle) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if sp.batch != wb {
+		return ErrInvalidSavepoint
+	}
+	idx := -1
+	for i, s := range wb.savepoints {
+		if s.id == sp.id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return ErrInvalidSavepoint
+	}
+
+	undoLen := wb.savepoints[idx].undoLen
+	for i := len(wb.undoLog) - 1; i >= undoLen; i-- {
+		undo := wb.undoLog[i]
+		if undo.prev == nil {
+			delete(wb.pendingWrites, undo.key)
+		} else {
+			wb.pendingWrites[undo.key] = undo.prev
+		}
+		if undo.staged {
+			delete(wb.stagedKeys, undo.key)
+			wb.db.deadlockDetector.releaseKey(undo.key, wb.id)
+		}
+	}
+	wb.undoLog = wb.undoLog[:undoLen]
+	wb.savepoints = wb.savepoints[:idx+1]
+	return nil
+}
+
+// 提交或回滚之后清除所有保存点（调用方需持有批次的锁）
+func (wb *WriteBatch) clearSavepoints() {
+	wb.savepoints = nil
+	wb.undoLog = nil
+}
+
+// 释放在冲突检测中登记的key（调用方需持有批次的锁）
+func (wb *WriteBatch) releaseStagedKeys() {
+	if wb.stagedKeys == nil {
+		return
+	}
+	wb.db.deadlockDetector.release(wb.stagedKeys, wb.id)
+	wb.stagedKeys = make(map[string]struct{})
+}
+
 // 提交事务，将暂存区的内容批量写入文件，并更新内存索引
 func (wb *WriteBatch) Commit() error {
+	if wb.db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	// 加锁保证事务提交串行化；校验、限速等待和写入期间暂存区都不会被并发的Put/Delete修改
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
 	if len(wb.pendingWrites) == 0 {
+		// 暂存后又被删除的key同样需要释放
+		wb.releaseStagedKeys()
+		wb.clearSavepoints()
 		return nil
 	}
+	if wb.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 
 	// 检查是否超出最大批量写入数量
 	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchNum {
 		return ErrExceedMaxBatchNum
 	}
 
-	// 加锁保证事务提交串行化
-	wb.mu.Lock()
-	defer wb.mu.Unlock()
+	// 写入前逐个校验，任何一个key被拒绝时整个批次都不写入，暂存区保留
+	size, quotaSize, err := wb.validate()
+	if err != nil {
+		return err
+	}
+	if err := wb.db.checkDiskQuota(quotaSize); err != nil {
+		return err
+	}
+
+	// 写入限速，在获取数据库的锁之前等待
+	if err := wb.db.writeLimiter.wait(size); err != nil {
+		return err
+	}
+
+	// 其他未提交的批次暂存了相同的key时不提交，保留暂存区，调用方可以稍后重试
+	if wb.stagedKeys != nil && wb.db.deadlockDetector.conflicts(wb.stagedKeys, wb.id) {
+		return ErrPotentialDeadlock
+	}
+	if wb.options.CommitTimeout > 0 {
+		expired := make(chan struct{})
+		wb.expired = expired
+		timer := time.AfterFunc(wb.options.CommitTimeout, func() {
+			close(expired)
+		})
+		defer func() {
+			timer.Stop()
+			wb.expired = nil
+		}()
+		if !wb.lockBeforeTimeout() {
+			return ErrCommitTimeout
+		}
+	} else {
+		wb.db.mu.Lock()
+	}
+	defer wb.db.mu.Unlock()
+	if wb.readSetChanged() {
+		return ErrConflict
+	}
+	if err := wb.commit(); err != nil {
+		return err
+	}
+	wb.releaseStagedKeys()
+	wb.clearSavepoints()
+	return nil
+}
+
+// 使用 BeforeWrite 逐个校验暂存区中的记录，返回写入的数据量和计入限额的大小（访问此方法前必须持有批次的锁）
+func (wb *WriteBatch) validate() (int, int64, error) {
+	var size int
+	var quotaSize int64
+	for _, record := range wb.pendingWrites {
+		if err := wb.db.beforeWrite(record.Key, record.Value, record.Type == data.LogRecordDeleted); err != nil {
+			return 0, 0, err
+		}
+		size += len(record.Key) + len(record.Value)
+		// 删除用于释放空间，不受限额限制
+		if record.Type != data.LogRecordDeleted {
+			quotaSize += quotaRecordSize(record.Key, record.Value)
+		}
+	}
+	return size, quotaSize, nil
+}
 
+// 读取的key在数据文件中的位置是否发生了变化（调用方需持有数据库的锁）
+// 每次写入都会追加新的记录，位置相同说明读取之后没有被修改；merge和vacuum重写记录后位置同样会变化
+func (wb *WriteBatch) readSetChanged() bool {
+	for key, readPos := range wb.readSet {
+		pos := wb.db.index.Get([]byte(key))
+		if pos == nil || readPos == nil {
+			if pos != readPos {
+				return true
+			}
+			continue
+		}
+		if pos.Fid != readPos.Fid || pos.Offset != readPos.Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// 在提交超时之前获取数据库的写锁，超时返回false，之后获取到的锁会立即释放
+func (wb *WriteBatch) lockBeforeTimeout() bool {
+	locked := make(chan struct{})
+	go func() {
+		wb.db.mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return true
+	case <-wb.expired:
+		go func() {
+			<-locked
+			wb.db.mu.Unlock()
+		}()
+		return false
+	}
+}
+
+// 提交是否已经超时
+func (wb *WriteBatch) timedOut() bool {
+	if wb.expired == nil {
+		return false
+	}
+	select {
+	case <-wb.expired:
+		return true
+	default:
+		return false
+	}
+}
+
+// 将暂存区的内容写入文件并更新内存索引（调用方需持有数据库的写锁）
+func (wb *WriteBatch) commit() error {
 	// 获取当前最新的事务序列号+1（此次批量写，使用这个事务序列号）
 	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
 
 	// 临时缓冲区，存放内存索引的map，用于更新内存
 	position := make(map[string]*data.LogRecordPos)
+	// 记录写入顺序，按相同的顺序更新索引和通知复制流
+	records := make([]*data.LogRecord, 0, len(wb.pendingWrites))
 
 	// 遍历缓冲区，将数据写到到文件中
+	// 超时时停止写入：没有事务完成标识的记录在加载索引、merge和复制时都会被忽略，已经写入的部分不会生效，只计入可回收的数据量
+	// 不需要再写入删除记录回滚：事务序列号不会重复使用，这个批次的完成标识不会再出现；
+	// 以同一序列号写入的删除记录同样没有完成标识会被忽略，而以非事务方式写入的删除记录会删掉提交之前的旧值
+	var written int64
 	for _, record := range wb.pendingWrites {
+		if wb.timedOut() {
+			wb.db.reclaimSize += written
+			return ErrCommitTimeout
+		}
 		// 将key和事务序列号进行编码作为新的key，将整体数据写入文件
 		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+			Key:    logRecordKeyWithSeq(record.Key, seqNo),
+			Value:  record.Value,
+			Type:   record.Type,
+			Expire: record.Expire,
 		})
 		if err != nil {
 			return err
 		}
 
+		written += int64(logRecordPos.Size)
 		// 暂存进临时缓冲区（此key为原始key），用于批量更新内存
 		position[string(record.Key)] = logRecordPos
+		records = append(records, record)
+	}
+
+	if wb.timedOut() {
+		wb.db.reclaimSize += written
+		return ErrCommitTimeout
 	}
 
 	// 向数据文件中，写一条标识事务完成的数据
@@ -131,20 +446,38 @@ func (wb *WriteBatch) Commit() error {
 
 	// 根据配置决定是否持久化
 	if wb.options.syncWrites && wb.db.activeFile != nil {
+		if err := wb.db.syncVlog(); err != nil {
+			return err
+		}
 		if err := wb.db.activeFile.Sync(); err != nil {
 			return err
 		}
 	}
 
 	// 更新内存索引
-	for _, record := range wb.pendingWrites {
+	var auditEntries []AuditEntry
+	var gid uint64
+	if wb.db.auditLog != nil {
+		gid = goroutineID()
+	}
+	for _, record := range records {
 		pos := position[string(record.Key)]
 		var oldPos *data.LogRecordPos
 		if record.Type == data.LogRecordNormal {
 			oldPos = wb.db.index.Put(record.Key, pos)
+			wb.db.addVersion(record.Key, seqNo, oldPos, pos)
+			wb.db.notifyCommit(record.Key, record.Value, KeyEventPut, pos)
+			if wb.db.auditLog != nil {
+				auditEntries = append(auditEntries, newAuditEntry(AuditPut, record.Key, seqNo, gid))
+			}
 		}
 		if record.Type == data.LogRecordDeleted {
 			oldPos, _ = wb.db.index.Delete(record.Key)
+			wb.db.addVersion(record.Key, seqNo, oldPos, nil)
+			wb.db.notifyCommit(record.Key, nil, KeyEventDelete, pos)
+			if wb.db.auditLog != nil {
+				auditEntries = append(auditEntries, newAuditEntry(AuditDelete, record.Key, seqNo, gid))
+			}
 		}
 		if oldPos != nil {
 			wb.db.reclaimSize += int64(oldPos.Size)
@@ -154,9 +487,31 @@ func (wb *WriteBatch) Commit() error {
 	// 清空暂存数据
 	wb.pendingWrites = make(map[string]*data.LogRecord)
 
+	// 同一批次的审计记录一次写入
+	wb.db.audit(auditEntries...)
 	return nil
 }
 
+// PutBatch 中的一个键值对
+type KV struct {
+	Key, Value []byte
+}
+
+// 原子地写入一组键值对，相当于使用 DefaultWriteBatchOptions 创建批次、依次 Put 并提交
+// 键值对数量超过 MaxBatchNum 时返回 ErrExceedMaxBatchNum，任何一个键值对不合法时整组都不写入；重复的key以最后一个为准
+func (db *DB) PutBatch(pairs []KV) error {
+	if uint(len(pairs)) > DefaultWriteBatchOptions.MaxBatchNum {
+		return ErrExceedMaxBatchNum
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for _, pair := range pairs {
+		if err := wb.Put(pair.Key, pair.Value); err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
 // 编码
 // 将事务序列号seqNo和实际key进行编码，拼接成新的字节切片，作为新的key
 func logRecordKeyWithSeq(key []byte, seqNo uint64) []byte {
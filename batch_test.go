@@ -0,0 +1,468 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// 两个批次以相反的顺序读取后修改相同的两个key，冲突时回滚并按指数退避重试
+func TestWriteBatch_DetectConflicts(t *testing.T) {
+	db := openTestDB(t, nil)
+	opts := DefaultWriteBatchOptions
+	opts.DetectConflicts = true
+
+	var staged sync.WaitGroup
+	staged.Add(2)
+	var conflicts [2]int
+	run := func(id int, keys [][]byte) error {
+		value := []byte{byte('0' + id)}
+		backoff := time.Millisecond
+		for attempt := 0; ; attempt++ {
+			wb := db.NewWriteBatch(opts)
+			for _, key := range keys {
+				// 读取之后写入
+				_, _ = db.Get(key)
+				if err := wb.Put(key, value); err != nil {
+					return err
+				}
+			}
+			// 第一次尝试时等待另一个批次也暂存完成，保证两个批次同时未提交
+			if attempt == 0 {
+				staged.Done()
+				staged.Wait()
+			}
+			err := wb.Commit()
+			if err != ErrPotentialDeadlock {
+				return err
+			}
+			conflicts[id]++
+			wb.Rollback()
+			time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff))))
+			backoff *= 2
+		}
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- run(0, [][]byte{[]byte("a"), []byte("b")}) }()
+	go func() { errs <- run(1, [][]byte{[]byte("b"), []byte("a")}) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conflicts[0]+conflicts[1] == 0 {
+		t.Fatal("expected a conflict to be detected")
+	}
+	// 两个key都来自最后提交的批次
+	a, err := db.Get([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := db.Get([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != string(b) {
+		t.Fatalf("expected both keys from the same batch, got %q and %q", a, b)
+	}
+	// 提交之后释放所有登记的key
+	db.deadlockDetector.owners.Range(func(key, value any) bool {
+		t.Fatalf("unexpected staged key %s", key)
+		return false
+	})
+}
+
+func TestWriteBatch_DetectConflictsRelease(t *testing.T) {
+	db := openTestDB(t, nil)
+	opts := DefaultWriteBatchOptions
+	opts.DetectConflicts = true
+
+	first := db.NewWriteBatch(opts)
+	if err := first.Put([]byte("k"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	second := db.NewWriteBatch(opts)
+	if err := second.Put([]byte("k"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	// 没有开启检测的批次不受影响
+	plain := db.NewWriteBatch(DefaultWriteBatchOptions)
+	if err := plain.Put([]byte("k"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Commit(); err != ErrPotentialDeadlock {
+		t.Fatalf("expected ErrPotentialDeadlock, got %v", err)
+	}
+	// 回滚之后其他批次可以提交
+	first.Rollback()
+	if err := second.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get([]byte("k")); err != nil || string(value) != "2" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+	// 回滚的批次不写入任何数据
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get([]byte("k")); err != nil || string(value) != "2" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+}
+
+// 暂存区的内容，删除记录的value为"<deleted>"
+func pendingContent(wb *WriteBatch) map[string]string {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	content := make(map[string]string)
+	for key, record := range wb.pendingWrites {
+		if record.Type == data.LogRecordDeleted {
+			content[key] = "<deleted>"
+		} else {
+			content[key] = string(record.Value)
+		}
+	}
+	return content
+}
+
+func expectPending(t *testing.T, wb *WriteBatch, expected map[string]string) {
+	t.Helper()
+	if content := pendingContent(wb); !reflect.DeepEqual(content, expected) {
+		t.Fatalf("pending writes %v, want %v", content, expected)
+	}
+}
+
+func TestWriteBatch_Savepoint(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("x"), []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	put := func(key, value string) {
+		t.Helper()
+		if err := wb.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	del := func(key string) {
+		t.Helper()
+		if err := wb.Delete([]byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put("a", "1")
+	sp1 := wb.Savepoint()
+	put("b", "1")
+	put("a", "2")
+	sp2 := wb.Savepoint()
+	put("c", "1")
+	del("b") // 只在暂存区中的key直接从暂存区删除
+	del("x")
+	sp3 := wb.Savepoint()
+	put("d", "1")
+	expectPending(t, wb, map[string]string{"a": "2", "c": "1", "x": "<deleted>", "d": "1"})
+
+	// 回滚到中间的保存点，之后创建的保存点失效
+	if err := wb.RollbackToSavepoint(sp2); err != nil {
+		t.Fatal(err)
+	}
+	expectPending(t, wb, map[string]string{"a": "2", "b": "1"})
+	if err := wb.RollbackToSavepoint(sp3); err != ErrInvalidSavepoint {
+		t.Fatalf("expected ErrInvalidSavepoint, got %v", err)
+	}
+
+	// 回滚之后继续写入，再回滚到更早的保存点
+	put("e", "1")
+	sp4 := wb.Savepoint()
+	put("a", "3")
+	if err := wb.RollbackToSavepoint(sp4); err != nil {
+		t.Fatal(err)
+	}
+	expectPending(t, wb, map[string]string{"a": "2", "b": "1", "e": "1"})
+	if err := wb.RollbackToSavepoint(sp1); err != nil {
+		t.Fatal(err)
+	}
+	expectPending(t, wb, map[string]string{"a": "1"})
+	// 保存点本身仍然有效
+	put("f", "1")
+	if err := wb.RollbackToSavepoint(sp1); err != nil {
+		t.Fatal(err)
+	}
+	expectPending(t, wb, map[string]string{"a": "1"})
+	if err := wb.RollbackToSavepoint(sp4); err != ErrInvalidSavepoint {
+		t.Fatalf("expected ErrInvalidSavepoint, got %v", err)
+	}
+
+	// 其他批次的保存点
+	other := db.NewWriteBatch(DefaultWriteBatchOptions)
+	if err := other.RollbackToSavepoint(sp1); err != ErrInvalidSavepoint {
+		t.Fatalf("expected ErrInvalidSavepoint, got %v", err)
+	}
+
+	// 提交之后保存点失效
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, map[string]string{"a": "1", "x": "0"})
+	if err := wb.RollbackToSavepoint(sp1); err != ErrInvalidSavepoint {
+		t.Fatalf("expected ErrInvalidSavepoint after commit, got %v", err)
+	}
+
+	// 回滚之后保存点失效
+	sp5 := wb.Savepoint()
+	put("g", "1")
+	wb.Rollback()
+	if err := wb.RollbackToSavepoint(sp5); err != ErrInvalidSavepoint {
+		t.Fatalf("expected ErrInvalidSavepoint after rollback, got %v", err)
+	}
+}
+
+// 回滚到保存点时释放保存点之后才登记的key，之前登记的key保持不变
+func TestWriteBatch_SavepointReleasesStagedKeys(t *testing.T) {
+	db := openTestDB(t, nil)
+	opts := DefaultWriteBatchOptions
+	opts.DetectConflicts = true
+
+	wb := db.NewWriteBatch(opts)
+	if err := wb.Put([]byte("k1"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	sp := wb.Savepoint()
+	for _, key := range []string{"k1", "k2"} {
+		if err := wb.Put([]byte(key), []byte("2")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wb.RollbackToSavepoint(sp); err != nil {
+		t.Fatal(err)
+	}
+
+	second := db.NewWriteBatch(opts)
+	if err := second.Put([]byte("k2"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Put([]byte("k1"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Commit(); err != ErrPotentialDeadlock {
+		t.Fatalf("expected ErrPotentialDeadlock, got %v", err)
+	}
+	second.Rollback()
+
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, map[string]string{"k1": "1", "k2": "3"})
+}
+
+// 每次写入之后调用回调的IOManager
+type writeCallbackIO struct {
+	fio.IOManager
+	afterWrite func()
+}
+
+func (w *writeCallbackIO) Write(b []byte) (int, error) {
+	n, err := w.IOManager.Write(b)
+	w.afterWrite()
+	return n, err
+}
+
+// 等待写锁超时时不写入任何数据，暂存区保留，之后可以重新提交
+func TestWriteBatch_CommitTimeoutWaitingForLock(t *testing.T) {
+	db := openTestDB(t, nil)
+	opts := DefaultWriteBatchOptions
+	opts.CommitTimeout = 50 * time.Millisecond
+	wb := db.NewWriteBatch(opts)
+	for i := 0; i < 10; i++ {
+		if err := wb.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.mu.Lock()
+	start := time.Now()
+	err := wb.Commit()
+	elapsed := time.Since(start)
+	db.mu.Unlock()
+	if err != ErrCommitTimeout {
+		t.Fatalf("expected ErrCommitTimeout, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("expected Commit to return after the timeout, took %v", elapsed)
+	}
+	if db.activeFile != nil && db.activeFile.WriteOff != 0 {
+		t.Fatalf("expected nothing written, got %d bytes", db.activeFile.WriteOff)
+	}
+	expectKeyCount(t, db, 0)
+
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expectKeyCount(t, db, 10)
+}
+
+// 写入部分记录后超时，已写入的记录不会生效，重新打开后同样不可见
+func TestWriteBatch_CommitTimeoutAfterPartialWrite(t *testing.T) {
+	db := openTestDB(t, nil)
+	for i := 0; i < 10; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := dumpDB(t, db)
+	reclaimSize := db.reclaimSize
+
+	opts := DefaultWriteBatchOptions
+	opts.CommitTimeout = time.Hour
+	wb := db.NewWriteBatch(opts)
+	for i := 0; i < 20; i++ {
+		if err := wb.Put(testKey(i), testValue(100+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wb.Delete(testKey(0)); err != nil {
+		t.Fatal(err)
+	}
+	// 写入3条记录之后超时
+	var writes int
+	db.activeFile.IOManager = &writeCallbackIO{IOManager: db.activeFile.IOManager, afterWrite: func() {
+		if writes++; writes == 3 {
+			close(wb.expired)
+		}
+	}}
+	if err := wb.Commit(); err != ErrCommitTimeout {
+		t.Fatalf("expected ErrCommitTimeout, got %v", err)
+	}
+	if writes != 3 {
+		t.Fatalf("expected 3 records written, got %d", writes)
+	}
+	expectContent(t, db, expected)
+	if db.reclaimSize <= reclaimSize {
+		t.Fatalf("expected the partial writes to be reclaimable, %d -> %d", reclaimSize, db.reclaimSize)
+	}
+
+	db = reopenTestDB(t, db)
+	expectContent(t, db, expected)
+}
+
+// 提交的同时向同一个批次暂存数据，校验和写入都在批次的锁内遍历暂存区
+func TestWriteBatch_CommitConcurrentPut(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.WriteRateLimit = 64
+		options.BeforeWrite = func(key, value []byte, isDelete bool) error { return nil }
+	})
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			if err := wb.Put(testKey(i), testValue(i)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for committing := true; committing; {
+		select {
+		case <-done:
+			committing = false
+		default:
+		}
+		if err := wb.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectKeyCount(t, db, 2000)
+}
+
+func TestDB_PutBatch(t *testing.T) {
+	db := openTestDB(t, nil)
+	keys := make([][]byte, 10)
+	for i := range keys {
+		keys[i] = testKey(i)
+	}
+	roundPairs := func(round int) []KV {
+		pairs := make([]KV, len(keys))
+		for i, key := range keys {
+			pairs[i] = KV{Key: key, Value: []byte(fmt.Sprintf("round-%d", round))}
+		}
+		return pairs
+	}
+	if err := db.PutBatch(roundPairs(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发读取时所有key的value始终来自同一个批次
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			values, errs := db.MultiGet(keys)
+			for i, err := range errs {
+				if err != nil {
+					t.Errorf("get %s: %v", keys[i], err)
+					return
+				}
+				if !bytes.Equal(values[string(keys[i])], values[string(keys[0])]) {
+					t.Errorf("observed a partial batch: %q", values)
+					return
+				}
+			}
+		}
+	}()
+	for round := 1; round <= 200; round++ {
+		if err := db.PutBatch(roundPairs(round)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	// 超过 MaxBatchNum 或者包含不合法的key时整组都不写入
+	tooLarge := make([]KV, DefaultWriteBatchOptions.MaxBatchNum+1)
+	for i := range tooLarge {
+		tooLarge[i] = KV{Key: []byte(fmt.Sprintf("large-%d", i)), Value: []byte("v")}
+	}
+	if err := db.PutBatch(tooLarge); err != ErrExceedMaxBatchNum {
+		t.Fatalf("expected ErrExceedMaxBatchNum, got %v", err)
+	}
+	invalid := append(roundPairs(-1), KV{Key: nil, Value: []byte("v")})
+	if err := db.PutBatch(invalid); err != ErrKeyIsEmpty {
+		t.Fatalf("expected ErrKeyIsEmpty, got %v", err)
+	}
+	if err := db.PutBatch(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	db = reopenTestDB(t, db)
+	expectKeyCount(t, db, len(keys))
+	for _, key := range keys {
+		if value, err := db.Get(key); err != nil || string(value) != "round-200" {
+			t.Fatalf("get %s = %q, %v", key, value, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package bitcask_go
+
+import (
+	"cmp"
+	"iter"
+
+	"bitcask-go/data"
+	"bitcask-go/index"
+)
+
+// 每次合并写入数据文件的最大记录数和数据量
+const (
+	bulkLoadChunkRecords = 1024
+	bulkLoadChunkSize    = 1024 * 1024
+)
+
+// 批量导入键值对的快速路径：记录分组合并写入数据文件，每个分组只获取一次写锁，写入数据文件之后一次性更新内存索引
+// 写入的是非事务记录（序列号为0），和逐条 Put 的效果相同，key重复时后面的value覆盖前面的
+// records 和 BeforeWrite 在不持有锁时调用；每个分组写入之前检查 MaxDiskSize 限额并等待写入限速，和 Put 一样
+// 中途出错（key为空、BeforeWrite 拒绝、超过 MaxDiskSize、写入失败）时返回此错误，之前已经写入数据文件的分组仍然生效，
+// 超过 MaxDiskSize 时整个分组都不写入，其他错误之前的记录仍然写入
+// 不调用 Hooks，不通知订阅者和复制流，也不写入审计日志，适合初始化数据库或离线导入
+func (db *DB) BulkLoad(records iter.Seq2[[]byte, []byte]) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	var chunkKeys [][]byte
+	var chunk []*data.LogRecord
+	var chunkSize int
+	var chunkQuota int64
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := db.checkDiskQuota(chunkQuota); err != nil {
+			return err
+		}
+		// 写入限速，在获取锁之前等待
+		if err := db.writeLimiter.wait(chunkSize); err != nil {
+			return err
+		}
+		db.mu.Lock()
+		// 分组之间不持有锁，期间数据库可能已经关闭
+		if db.closed.Load() {
+			db.mu.Unlock()
+			return ErrDatabaseClosed
+		}
+		chunkPositions, err := db.appendLogRecords(chunk)
+		if err == nil {
+			db.indexBulkLoaded(chunkKeys, chunkPositions)
+		}
+		db.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		chunk, chunkKeys, chunkSize, chunkQuota = chunk[:0], chunkKeys[:0], 0, 0
+		return nil
+	}
+
+	for key, value := range records {
+		// 出错之前的记录仍然写入，写入失败时返回写入的错误
+		if len(key) == 0 {
+			return cmp.Or(flush(), ErrKeyIsEmpty)
+		}
+		if err := db.beforeWrite(key, value, false); err != nil {
+			return cmp.Or(flush(), err)
+		}
+		// 调用方可能复用key和value的缓冲区，拷贝一份
+		key = append([]byte(nil), key...)
+		chunk = append(chunk, &data.LogRecord{
+			Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+			Value: append([]byte(nil), value...),
+			Type:  data.LogRecordNormal,
+		})
+		chunkKeys = append(chunkKeys, key)
+		chunkSize += len(key) + len(value)
+		chunkQuota += quotaRecordSize(key, value)
+		if len(chunk) >= bulkLoadChunkRecords || chunkSize >= bulkLoadChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// 将批量导入的记录写入内存索引（调用方需持有写锁）
+func (db *DB) indexBulkLoaded(keys [][]byte, positions []*data.LogRecordPos) {
+	var oldPositions []*data.LogRecordPos
+	if batchPutter, ok := db.index.(index.BatchPutter); ok {
+		oldPositions = batchPutter.PutBatch(keys, positions)
+	} else {
+		oldPositions = make([]*data.LogRecordPos, len(keys))
+		for i, key := range keys {
+			oldPositions[i] = db.index.Put(key, positions[i])
+		}
+	}
+	for i, oldPos := range oldPositions {
+		if oldPos != nil {
+			db.reclaimSize += int64(oldPos.Size)
+		}
+		db.addNonTxnVersion(keys[i], oldPos, positions[i])
+	}
+}
@@ -0,0 +1,158 @@
+package bitcask_go
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"testing"
+)
+
+// 依次产生 [start, end) 的测试键值对，每次复用同一个key缓冲区
+func testRecords(start, end int) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		key := make([]byte, 0, 32)
+		for i := start; i < end; i++ {
+			key = append(key[:0], testKey(i)...)
+			if !yield(key, testValue(i)) {
+				return
+			}
+		}
+	}
+}
+
+func TestDB_BulkLoad(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree} {
+		t.Run(fmt.Sprint(indexType), func(t *testing.T) {
+			db := openTestDB(t, func(options *Options) {
+				options.IndexType = indexType
+				options.DataFileSize = 32 * 1024
+			})
+			// 已经存在的key被覆盖
+			if err := db.Put(testKey(10), []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.BulkLoad(testRecords(0, 3000)); err != nil {
+				t.Fatal(err)
+			}
+			// 同一次导入中重复的key以最后一次为准
+			if err := db.BulkLoad(func(yield func([]byte, []byte) bool) {
+				_ = yield([]byte("dup"), []byte("first")) && yield([]byte("dup"), []byte("second"))
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := map[string]string{"dup": "second"}
+			for i := 0; i < 3000; i++ {
+				expected[string(testKey(i))] = string(testValue(i))
+			}
+			expectContent(t, db, expected)
+			if db.reclaimSize == 0 {
+				t.Fatal("expected overwritten records to be reclaimable")
+			}
+			db = reopenTestDB(t, db)
+			expectContent(t, db, expected)
+		})
+	}
+}
+
+// 中途出错时已经写入的记录生效，之后的记录不写入
+func TestDB_BulkLoadError(t *testing.T) {
+	errRejected := errors.New("rejected")
+	db := openTestDB(t, func(options *Options) {
+		options.BeforeWrite = func(key, value []byte, isDelete bool) error {
+			if string(key) == string(testKey(5)) {
+				return errRejected
+			}
+			return nil
+		}
+	})
+	if err := db.BulkLoad(testRecords(0, 10)); err != errRejected {
+		t.Fatalf("expected errRejected, got %v", err)
+	}
+	expectKeyCount(t, db, 5)
+	if _, err := db.Get(testKey(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.BulkLoad(func(yield func([]byte, []byte) bool) {
+		yield(nil, []byte("value"))
+	}); err != ErrKeyIsEmpty {
+		t.Fatalf("expected ErrKeyIsEmpty, got %v", err)
+	}
+	db = reopenTestDB(t, db)
+	expectKeyCount(t, db, 5)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.BulkLoad(testRecords(0, 1)); err != ErrDatabaseClosed {
+		t.Fatalf("expected ErrDatabaseClosed, got %v", err)
+	}
+}
+
+func BenchmarkDB_BulkLoad(b *testing.B) {
+	const count = 100000
+	keys, values := make([][]byte, count), make([][]byte, count)
+	for i := range keys {
+		keys[i], values[i] = testKey(i), testValue(i)
+	}
+	open := func(b *testing.B) *DB {
+		options := DefaultOptions
+		options.DirPath = b.TempDir()
+		db, err := Open(options)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return db
+	}
+
+	b.Run("Put", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db := open(b)
+			for j := range keys {
+				if err := db.Put(keys[j], values[j]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			_ = db.Close()
+		}
+	})
+	b.Run("BulkLoad", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db := open(b)
+			if err := db.BulkLoad(func(yield func([]byte, []byte) bool) {
+				for j := range keys {
+					if !yield(keys[j], values[j]) {
+						return
+					}
+				}
+			}); err != nil {
+				b.Fatal(err)
+			}
+			_ = db.Close()
+		}
+	})
+}
+
+// 分组之间不持有写锁，遍历 records 时可以读写数据库
+func TestDB_BulkLoadConcurrentAccess(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.BulkLoad(func(yield func([]byte, []byte) bool) {
+		for i := 0; i < 3*bulkLoadChunkRecords; i++ {
+			if !yield(testKey(i), testValue(i)) {
+				return
+			}
+			if i == 2*bulkLoadChunkRecords {
+				// 之前的分组已经写入
+				if _, err := db.Get(testKey(0)); err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Put([]byte("inside"), []byte("v")); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expectKeyCount(t, db, 3*bulkLoadChunkRecords+1)
+}
@@ -0,0 +1,77 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestDB_BlockCacheHotKeys(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.BlockCacheSize = 64 * 1024
+		options.DataFileSize = 64 * 1024
+	})
+	for i := 0; i < 2000; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db = reopenTestDB(t, db)
+
+	// 反复读取少量的热点key，除了第一次读取外都命中缓存
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 20; i++ {
+			value, err := db.Get(testKey(i))
+			if err != nil || !bytes.Equal(value, testValue(i)) {
+				t.Fatalf("get %s = %q, %v", testKey(i), value, err)
+			}
+		}
+	}
+	stat := db.Stat()
+	if stat.CacheHits == 0 || stat.CacheHitRatio < 0.8 {
+		t.Fatalf("cache hits = %d, misses = %d, ratio = %f", stat.CacheHits, stat.CacheMisses, stat.CacheHitRatio)
+	}
+}
+
+// 90%的读取集中在1%的key上，对比是否开启块缓存的读取性能
+func BenchmarkDB_GetHotKeys(b *testing.B) {
+	const keys = 100000
+	for _, cacheSize := range []int64{0, 4 * 1024 * 1024} {
+		b.Run(fmt.Sprintf("cache-%d", cacheSize), func(b *testing.B) {
+			options := DefaultOptions
+			options.DirPath = b.TempDir()
+			options.BlockCacheSize = cacheSize
+			options.MMapAtStartup = false
+			db, err := Open(options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			value := bytes.Repeat([]byte("v"), 256)
+			for i := 0; i < keys; i++ {
+				if err := db.Put(testKey(i), value); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := db.Close(); err != nil {
+				b.Fatal(err)
+			}
+			if db, err = Open(options); err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				k := r.Intn(keys)
+				if r.Intn(10) < 9 {
+					k = r.Intn(keys / 100)
+				}
+				if _, err := db.Get(testKey(k)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"math/rand"
+
+	"bitcask-go/data"
+)
+
+// 检查报告中最多记录的问题数量
+const maxCheckProblems = 100
+
+// 一致性检查的配置
+type CheckOptions struct {
+	// 随机抽查的索引条目数量，为0时检查所有条目
+	SampleSize int
+}
+
+// 一致性检查的结果
+type CheckReport struct {
+	Checked    int            // 检查的索引条目数量
+	OK         int            // 记录可以读取、crc校验通过并且key一致的条目数量
+	Mismatched int            // 指向的记录和key不一致或者不是有效数据的条目数量
+	Corrupt    int            // 指向的记录无法读取或校验失败的条目数量
+	Problems   []CheckProblem // 发现的问题，最多记录 maxCheckProblems 个
+}
+
+// 检查发现的一个问题
+// 记录校验失败时 Err 为 *data.CorruptionError，其中包含损坏的记录所在的文件和偏移（value存储在value log中时为value log中的位置）
+type CheckProblem struct {
+	Key    []byte
+	Fid    uint32
+	Offset int64
+	Err    error
+}
+
+// 检查所有索引条目和数据文件是否一致，等同于 CheckWithOptions(CheckOptions{})
+func (db *DB) Check() (*CheckReport, error) {
+	return db.CheckWithOptions(CheckOptions{})
+}
+
+// 检查索引和数据文件是否一致：读取索引条目指向的记录，校验crc（value存储在value log中时同时校验value log中的记录），并确认记录中的key和索引一致
+// 整个检查过程持有读锁，不修改任何数据
+func (db *DB) CheckWithOptions(opts CheckOptions) (*CheckReport, error) {
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 抽查时使用蓄水池抽样，每个条目被选中的概率相同
+	type entry struct {
+		key []byte
+		pos *data.LogRecordPos
+	}
+	var entries []entry
+	var seen int
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		e := entry{key: append([]byte(nil), iterator.Key()...), pos: iterator.Value()}
+		seen++
+		if opts.SampleSize <= 0 || len(entries) < opts.SampleSize {
+			entries = append(entries, e)
+		} else if i := rand.Intn(seen); i < opts.SampleSize {
+			entries[i] = e
+		}
+	}
+	iterator.Close()
+
+	report := &CheckReport{}
+	for _, e := range entries {
+		report.Checked++
+		err := db.checkRecord(e.key, e.pos)
+		switch err {
+		case nil:
+			report.OK++
+			continue
+		case ErrIndexMismatch:
+			report.Mismatched++
+		default:
+			report.Corrupt++
+		}
+		if len(report.Problems) < maxCheckProblems {
+			report.Problems = append(report.Problems, CheckProblem{Key: e.key, Fid: e.pos.Fid, Offset: e.pos.Offset, Err: err})
+		}
+	}
+	return report, nil
+}
+
+// 读取并校验索引条目指向的记录
+func (db *DB) checkRecord(key []byte, pos *data.LogRecordPos) error {
+	db.fileMu.RLock()
+	defer db.fileMu.RUnlock()
+
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == pos.Fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[pos.Fid]
+	}
+	if dataFile == nil {
+		return ErrDataFileNotFound
+	}
+
+	logRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return err
+	}
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+	if !bytes.Equal(realKey, key) {
+		return ErrIndexMismatch
+	}
+	switch logRecord.Type {
+	case data.LogRecordNormal:
+		return nil
+	case data.LogRecordValuePointer:
+		_, err := db.readValueLog(logRecord.Value, false)
+		return err
+	default:
+		// 删除记录、事务完成标识等不应该被索引引用
+		return ErrIndexMismatch
+	}
+}
@@ -0,0 +1,104 @@
+package bitcask_go
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"bitcask-go/data"
+)
+
+func TestDB_Check(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.ValueLogSeparationThreshold = 64 },
+		func(options *Options) { options.IndexType = ART },
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 8 * 1024
+			if configure != nil {
+				configure(options)
+			}
+		})
+		writeReplicationData(t, db, 0)
+
+		report, err := db.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := db.CountKeys(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Checked != count || report.OK != count || len(report.Problems) != 0 {
+			t.Fatalf("unexpected report for consistent db: %+v", report)
+		}
+
+		// 抽查只检查指定数量的条目
+		report, err = db.CheckWithOptions(CheckOptions{SampleSize: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Checked != 10 || report.OK != 10 {
+			t.Fatalf("unexpected sample report: %+v", report)
+		}
+
+		// key2的索引指向key4的记录
+		db.index.Put(testKey(2), db.index.Get(testKey(4)))
+		// 破坏key5的记录中的一个字节
+		pos := db.index.Get(testKey(5))
+		if err := db.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.OpenFile(data.GetDataFileName(db.options.DirPath, pos.Fid), os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1)
+		offset := pos.Offset + int64(pos.Size) - 1
+		if _, err := file.ReadAt(buf, offset); err != nil {
+			t.Fatal(err)
+		}
+		buf[0] ^= 0xff
+		if _, err := file.WriteAt(buf, offset); err != nil {
+			t.Fatal(err)
+		}
+		_ = file.Close()
+		expected := db.index.Size()
+
+		report, err = db.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Checked != count || report.Mismatched != 1 || report.Corrupt != 1 || report.OK != count-2 {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+		if len(report.Problems) != 2 {
+			t.Fatalf("expected 2 problems, got %+v", report.Problems)
+		}
+		for _, problem := range report.Problems {
+			switch string(problem.Key) {
+			case string(testKey(2)):
+				if problem.Err != ErrIndexMismatch {
+					t.Fatalf("expected ErrIndexMismatch, got %v", problem.Err)
+				}
+			case string(testKey(5)):
+				var corruptionErr *data.CorruptionError
+				if !errors.As(problem.Err, &corruptionErr) || !errors.Is(problem.Err, data.ErrInvalidCRC) {
+					t.Fatalf("expected a crc CorruptionError, got %v", problem.Err)
+				}
+				// 开启键值分离时损坏的是value log中的记录
+				if db.options.ValueLogSeparationThreshold == 0 && (corruptionErr.FileId != pos.Fid || corruptionErr.Offset != pos.Offset) {
+					t.Fatalf("expected corruption at %d/%d, got %+v", pos.Fid, pos.Offset, corruptionErr)
+				}
+			default:
+				t.Fatalf("unexpected problem %+v", problem)
+			}
+		}
+		// 检查不修改数据
+		if db.index.Size() != expected {
+			t.Fatalf("index changed after check")
+		}
+		_ = db.Close()
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	bitcask "bitcask-go"
+)
+
+// 离线查看数据目录的命令行工具
+// 用法：
+//
+//	bitcask-cli inspect <dir>
+//	bitcask-cli dump [-format=text|json|hex] <dir> [prefix]
+//	bitcask-cli verify <dir>
+//	bitcask-cli merge [-force] <dir>
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+const usage = `usage:
+  bitcask-cli inspect [-block-checksum] <dir>
+  bitcask-cli dump [-block-checksum] [-format=text|json|hex] <dir> [prefix]
+  bitcask-cli verify [-block-checksum] <dir>
+  bitcask-cli merge [-block-checksum] [-force] <dir>`
+
+// 命令行参数不正确
+var errUsage = errors.New("invalid usage")
+
+// 执行子命令，输出写入 stdout
+func run(args []string, stdout io.Writer) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	switch args[0] {
+	case "inspect":
+		return inspect(args[1:], stdout)
+	case "dump":
+		return dump(args[1:], stdout)
+	case "verify":
+		return verify(args[1:], stdout)
+	case "merge":
+		return merge(args[1:], stdout)
+	default:
+		return errUsage
+	}
+}
+
+// 子命令的公共参数
+type commonFlags struct {
+	flagSet       *flag.FlagSet
+	blockChecksum *bool
+}
+
+func newCommonFlags(name string) *commonFlags {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	return &commonFlags{
+		flagSet:       flagSet,
+		blockChecksum: flagSet.Bool("block-checksum", false, "数据文件使用按块校验（PerBlock）格式"),
+	}
+}
+
+// 解析参数，返回数据目录以及剩余的参数
+func (c *commonFlags) parse(args []string) (string, []string, error) {
+	if err := c.flagSet.Parse(args); err != nil {
+		return "", nil, errUsage
+	}
+	if c.flagSet.NArg() < 1 {
+		return "", nil, errUsage
+	}
+	return c.flagSet.Arg(0), c.flagSet.Args()[1:], nil
+}
+
+// 打开数据库，readOnly为true时不获取文件锁、不修改数据文件，不影响正在运行的实例
+func (c *commonFlags) open(dir string, readOnly bool) (*bitcask.DB, error) {
+	options := bitcask.DefaultOptions
+	options.DirPath = dir
+	options.ReadOnly = readOnly
+	if *c.blockChecksum {
+		options.ChecksumMode = bitcask.PerBlock
+	}
+	return bitcask.Open(options)
+}
+
+// 打印数据库的统计信息
+func inspect(args []string, stdout io.Writer) error {
+	flags := newCommonFlags("inspect")
+	dir, _, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+
+	db, err := flags.open(dir, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	stat := db.Stat()
+	fmt.Fprintf(stdout, "keys:              %d\n", stat.KeyNum)
+	fmt.Fprintf(stdout, "data files:        %d\n", stat.DataFileNum)
+	fmt.Fprintf(stdout, "reclaimable bytes: %d\n", stat.ReclaimableSize)
+	fmt.Fprintf(stdout, "disk size:         %d\n", stat.DiskSize)
+	return nil
+}
+
+// dump json格式输出的一条记录
+type dumpEntry struct {
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value"`
+	Fid    uint32 `json:"fid"`
+	Offset int64  `json:"offset"`
+}
+
+// 按key的顺序输出所有数据，可以指定key的前缀
+func dump(args []string, stdout io.Writer) error {
+	flags := newCommonFlags("dump")
+	format := flags.flagSet.String("format", "text", "输出格式：text、json 或 hex")
+	dir, rest, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+
+	var prefix []byte
+	if len(rest) > 0 {
+		prefix = []byte(rest[0])
+	}
+	if *format != "text" && *format != "json" && *format != "hex" {
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+
+	db, err := flags.open(dir, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	iterator := db.NewIterator(bitcask.IteratorOptions{Prefix: prefix})
+	defer iterator.Close()
+
+	encoder := json.NewEncoder(stdout)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		key, pos := iterator.Key(), iterator.Pos()
+		value, err := iterator.Value()
+		if err != nil {
+			return fmt.Errorf("read key %q: %w", key, err)
+		}
+
+		switch *format {
+		case "json":
+			if err := encoder.Encode(&dumpEntry{Key: key, Value: value, Fid: pos.Fid, Offset: pos.Offset}); err != nil {
+				return err
+			}
+		case "hex":
+			fmt.Fprintf(stdout, "key=%s value=%s fid=%d offset=%d\n", hex.EncodeToString(key), hex.EncodeToString(value), pos.Fid, pos.Offset)
+		default:
+			fmt.Fprintf(stdout, "key=%q value=%q fid=%d offset=%d\n", key, value, pos.Fid, pos.Offset)
+		}
+	}
+	return nil
+}
+
+// 读取索引中的每条数据，校验其是否完整
+func verify(args []string, stdout io.Writer) error {
+	flags := newCommonFlags("verify")
+	dir, _, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+
+	db, err := flags.open(dir, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	iterator := db.NewIterator(bitcask.DefaultIteratorOptions)
+	defer iterator.Close()
+
+	var ok, corrupted int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if _, err := iterator.Value(); err != nil {
+			pos := iterator.Pos()
+			fmt.Fprintf(stdout, "corrupted: key=%q fid=%d offset=%d: %v\n", iterator.Key(), pos.Fid, pos.Offset, err)
+			corrupted++
+			continue
+		}
+		ok++
+	}
+
+	fmt.Fprintf(stdout, "ok: %d, corrupted: %d\n", ok, corrupted)
+	if corrupted > 0 {
+		return fmt.Errorf("found %d corrupted records", corrupted)
+	}
+	return nil
+}
+
+// 对数据目录执行merge，需要独占数据目录
+func merge(args []string, stdout io.Writer) error {
+	flags := newCommonFlags("merge")
+	force := flags.flagSet.Bool("force", false, "忽略merge阈值，强制merge")
+	dir, _, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+
+	db, err := flags.open(dir, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	if *force {
+		err = db.MergeForce()
+	} else {
+		err = db.Merge()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "merge finished, it takes effect on next open")
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bitcask "bitcask-go"
+)
+
+// 创建测试数据库并写入数据，返回仍处于打开状态的数据库和数据目录
+func openTestDB(t *testing.T, mode bitcask.ChecksumMode, n int) (*bitcask.DB, string) {
+	t.Helper()
+	options := bitcask.DefaultOptions
+	options.DirPath = t.TempDir()
+	options.ChecksumMode = mode
+	db, err := bitcask.Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key-%03d", i)
+		if i%2 == 1 {
+			key = fmt.Sprintf("other-%03d", i)
+		}
+		if err := db.Put([]byte(key), []byte(fmt.Sprintf("value-%03d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, options.DirPath
+}
+
+func runCLI(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var stdout bytes.Buffer
+	err := run(args, &stdout)
+	return stdout.String(), err
+}
+
+// 读取目录下所有文件的内容
+func readDir(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = content
+	}
+	return files
+}
+
+func TestCLI_Inspect(t *testing.T) {
+	db, dir := openTestDB(t, bitcask.PerRecord, 10)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runCLI(t, "inspect", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "keys:              10\n") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestCLI_Dump(t *testing.T) {
+	db, dir := openTestDB(t, bitcask.PerRecord, 10)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// text格式，按前缀过滤
+	out, err := runCLI(t, "dump", dir, "key-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], `key="key-000" value="value-000" fid=0 offset=0`) {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+
+	// json格式，key和value为base64编码
+	out, err = runCLI(t, "dump", "-format=json", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("expected 10 lines, got %d", len(lines))
+	}
+	var entry dumpEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if string(entry.Key) != "key-000" || string(entry.Value) != "value-000" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	// hex格式
+	out, err = runCLI(t, "dump", "-format=hex", dir, "other-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("key=%s value=%s", hex.EncodeToString([]byte("other-001")), hex.EncodeToString([]byte("value-001")))
+	if !strings.HasPrefix(out, expected) {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+
+	if _, err := runCLI(t, "dump", "-format=xml", dir); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+func TestCLI_Verify(t *testing.T) {
+	db, dir := openTestDB(t, bitcask.PerRecord, 10)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runCLI(t, "verify", dir)
+	if err != nil || !strings.Contains(out, "ok: 10, corrupted: 0") {
+		t.Fatalf("verify = %q, %v", out, err)
+	}
+
+	// merge后启动时从hint文件加载索引，不再校验数据文件，损坏的记录在verify时才会被发现
+	if _, err := runCLI(t, "merge", "-force", dir); err != nil {
+		t.Fatal(err)
+	}
+	reopenTestDB(t, dir)
+	fileName := filepath.Join(dir, "000000000.data")
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := bytes.Index(content, []byte("value-000"))
+	content[index] ^= 0xff
+	if err := os.WriteFile(fileName, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = runCLI(t, "verify", dir)
+	if err == nil || !strings.Contains(out, `corrupted: key="key-000"`) || !strings.Contains(out, "ok: 9, corrupted: 1") {
+		t.Fatalf("verify = %q, %v", out, err)
+	}
+}
+
+// 以读写模式打开并关闭数据库，使merge的结果生效
+func reopenTestDB(t *testing.T, dir string) {
+	t.Helper()
+	options := bitcask.DefaultOptions
+	options.DirPath = dir
+	db, err := bitcask.Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCLI_Merge(t *testing.T) {
+	db, dir := openTestDB(t, bitcask.PerRecord, 10)
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%03d", i*2)), []byte("overwritten")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runCLI(t, "merge", "-force", dir)
+	if err != nil || !strings.Contains(out, "merge finished") {
+		t.Fatalf("merge = %q, %v", out, err)
+	}
+	reopenTestDB(t, dir)
+	out, err = runCLI(t, "inspect", dir)
+	if err != nil || !strings.Contains(out, "keys:              15\n") || !strings.Contains(out, "reclaimable bytes: 0\n") {
+		t.Fatalf("inspect after merge = %q, %v", out, err)
+	}
+}
+
+// 只读命令可以在数据库运行时执行，不修改任何数据文件
+func TestCLI_ReadOnlyWithLiveInstance(t *testing.T) {
+	for _, mode := range []bitcask.ChecksumMode{bitcask.PerRecord, bitcask.PerBlock} {
+		db, dir := openTestDB(t, mode, 100)
+		before := readDir(t, dir)
+
+		var flags []string
+		if mode == bitcask.PerBlock {
+			flags = append(flags, "-block-checksum")
+		}
+		for _, command := range []string{"inspect", "dump", "verify"} {
+			args := append(append([]string{command}, flags...), dir)
+			if _, err := runCLI(t, args...); err != nil {
+				t.Fatalf("mode %d: %s: %v", mode, command, err)
+			}
+		}
+
+		after := readDir(t, dir)
+		for name, content := range before {
+			if !bytes.Equal(after[name], content) {
+				t.Fatalf("mode %d: file %s modified by read only commands", mode, name)
+			}
+		}
+
+		// 正在运行的实例不受影响
+		if err := db.Put([]byte("live"), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := db.Get([]byte("live")); err != nil || string(value) != "value" {
+			t.Fatalf("mode %d: Get = %q, %v", mode, value, err)
+		}
+
+		// merge 需要独占数据目录
+		if _, err := runCLI(t, append(append([]string{"merge"}, flags...), dir)...); err == nil {
+			t.Fatalf("mode %d: expected merge to fail while the database is open", mode)
+		}
+	}
+}
+
+func TestCLI_Usage(t *testing.T) {
+	for _, args := range [][]string{nil, {"unknown"}, {"dump"}, {"inspect", "-unknown", "dir"}} {
+		if _, err := runCLI(t, args...); !errors.Is(err, errUsage) {
+			t.Fatalf("run(%q) = %v, want errUsage", args, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bitcask "bitcask-go"
+)
+
+// value无法解码为目标类型时返回的错误（通过 errors.Is 判断，和 ErrKeyNotFound 区分），同时包装了解码器返回的原始错误
+var ErrDecodeValue = errors.New("failed to decode value")
+
+// 将v编码为JSON后写入key
+func PutJSON(db *bitcask.DB, key []byte, v any) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return db.Put(key, value)
+}
+
+// 读取key的value并按JSON解码为T，key不存在时返回 bitcask.ErrKeyNotFound
+func GetJSON[T any](db *bitcask.DB, key []byte) (T, error) {
+	var v T
+	value, err := db.Get(key)
+	if err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return v, fmt.Errorf("%w: key %q: %w", ErrDecodeValue, key, err)
+	}
+	return v, nil
+}
+
+// 将v按gob编码后写入key，适合只在Go程序之间读写、需要更紧凑编码的数据
+func PutGob(db *bitcask.DB, key []byte, v any) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	return db.Put(key, buf.Bytes())
+}
+
+// 读取key的value并按gob解码为T，key不存在时返回 bitcask.ErrKeyNotFound
+func GetGob[T any](db *bitcask.DB, key []byte) (T, error) {
+	var v T
+	value, err := db.Get(key)
+	if err != nil {
+		return v, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&v); err != nil {
+		return v, fmt.Errorf("%w: key %q: %w", ErrDecodeValue, key, err)
+	}
+	return v, nil
+}
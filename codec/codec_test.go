@@ -0,0 +1,111 @@
+package codec
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	bitcask "bitcask-go"
+)
+
+type user struct {
+	Name  string
+	Age   int
+	Tags  []string
+	Admin bool
+}
+
+// 在临时目录中打开数据库，测试结束时自动关闭
+func openTestDB(t *testing.T) *bitcask.DB {
+	t.Helper()
+	options := bitcask.DefaultOptions
+	options.DirPath = t.TempDir()
+	db, err := bitcask.Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func TestJSON_RoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	u := user{Name: "alice", Age: 30, Tags: []string{"a", "b"}, Admin: true}
+	if err := PutJSON(db, []byte("user"), u); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetJSON[user](db, []byte("user")); err != nil || !reflect.DeepEqual(got, u) {
+		t.Fatalf("GetJSON = %+v, %v", got, err)
+	}
+	// 写入的是普通的JSON，可以直接读取
+	if value, err := db.Get([]byte("user")); err != nil || string(value) != `{"Name":"alice","Age":30,"Tags":["a","b"],"Admin":true}` {
+		t.Fatalf("raw value = %s, %v", value, err)
+	}
+
+	scores := []float64{1.5, 2, -3}
+	if err := PutJSON(db, []byte("scores"), scores); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetJSON[[]float64](db, []byte("scores")); err != nil || !reflect.DeepEqual(got, scores) {
+		t.Fatalf("GetJSON = %v, %v", got, err)
+	}
+}
+
+func TestGob_RoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	u := user{Name: "bob", Age: 25, Tags: []string{"x"}}
+	if err := PutGob(db, []byte("user"), u); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetGob[user](db, []byte("user")); err != nil || !reflect.DeepEqual(got, u) {
+		t.Fatalf("GetGob = %+v, %v", got, err)
+	}
+
+	users := []user{u, {Name: "carol", Age: 41}}
+	if err := PutGob(db, []byte("users"), users); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetGob[[]user](db, []byte("users")); err != nil || !reflect.DeepEqual(got, users) {
+		t.Fatalf("GetGob = %+v, %v", got, err)
+	}
+}
+
+func TestGet_Errors(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := GetJSON[user](db, []byte("missing")); err != bitcask.ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := GetGob[user](db, []byte("missing")); err != bitcask.ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	// value的格式和目标类型不匹配
+	if err := db.Put([]byte("bad"), []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	for _, get := range []func() error{
+		func() error { _, err := GetJSON[user](db, []byte("bad")); return err },
+		func() error { _, err := GetGob[user](db, []byte("bad")); return err },
+	} {
+		err := get()
+		if !errors.Is(err, ErrDecodeValue) || errors.Is(err, bitcask.ErrKeyNotFound) {
+			t.Fatalf("expected ErrDecodeValue, got %v", err)
+		}
+	}
+	if err := PutJSON(db, []byte("list"), []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetJSON[user](db, []byte("list")); !errors.Is(err, ErrDecodeValue) {
+		t.Fatalf("expected ErrDecodeValue, got %v", err)
+	}
+
+	// 无法编码的值不写入
+	if err := PutJSON(db, []byte("chan"), make(chan int)); err == nil {
+		t.Fatal("expected encode error")
+	}
+	if _, err := db.Get([]byte("chan")); err != bitcask.ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package bitcask_go
+
+import (
+	"errors"
+
+	"bitcask-go/data"
+)
+
+// 组提交时一次最多合并的写入请求数
+const maxCommitBatchNum = 256
+
+// 组提交的写入请求
+type writeRequest struct {
+	key    []byte             // 实际key，用于更新内存索引
+	record *data.LogRecord    // 要写入的日志记录
+	result chan error         // 写入结果
+	pos    *data.LogRecordPos // 写入成功时的位置，在发送写入结果之前设置
+	gid    uint64             // 调用方的协程ID，开启审计日志时记录
+}
+
+// 启动组提交的后台写协程
+func (db *DB) startCommitWriter() {
+	db.commitQueue = make(chan *writeRequest, maxCommitBatchNum)
+	db.commitStop = make(chan struct{})
+	db.commitDone = make(chan struct{})
+	go db.runCommitWriter()
+}
+
+// 将写入请求交给后台写协程，并等待写入完成，返回写入的位置
+func (db *DB) groupCommit(key []byte, logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	req := &writeRequest{
+		key:    key,
+		record: logRecord,
+		result: make(chan error, 1),
+	}
+	if db.auditLog != nil {
+		req.gid = goroutineID()
+	}
+
+	select {
+	case db.commitQueue <- req:
+	case <-db.commitStop:
+		return nil, ErrDatabaseClosed
+	}
+
+	select {
+	case err := <-req.result:
+		return req.pos, err
+	case <-db.commitDone:
+		// 写协程退出前可能已经处理了此请求
+		select {
+		case err := <-req.result:
+			return req.pos, err
+		default:
+			return nil, ErrDatabaseClosed
+		}
+	}
+}
+
+// 后台写协程：每次取出队列中所有等待的请求，一次性写入并持久化
+func (db *DB) runCommitWriter() {
+	defer close(db.commitDone)
+	for {
+		select {
+		case req := <-db.commitQueue:
+			db.commitBatch(db.drainCommitQueue([]*writeRequest{req}))
+		case <-db.commitStop:
+			// 写完队列中剩余的请求后退出
+			if batch := db.drainCommitQueue(nil); len(batch) > 0 {
+				db.commitBatch(batch)
+			}
+			return
+		}
+	}
+}
+
+// 非阻塞地取出队列中等待的请求
+func (db *DB) drainCommitQueue(batch []*writeRequest) []*writeRequest {
+	for len(batch) < maxCommitBatchNum {
+		select {
+		case req := <-db.commitQueue:
+			batch = append(batch, req)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// 批量写入一组请求，更新内存索引后通知所有调用方
+func (db *DB) commitBatch(batch []*writeRequest) {
+	logRecords := make([]*data.LogRecord, len(batch))
+	for i, req := range batch {
+		logRecords[i] = req.record
+	}
+
+	db.mu.Lock()
+	positions, err := db.appendLogRecords(logRecords)
+	if err == nil {
+		// 在锁内按写入顺序更新索引，保证同一个key的多次写入以最后一次为准
+		var auditEntries []AuditEntry
+		for i, req := range batch {
+			oldPos := db.index.Put(req.key, positions[i])
+			if oldPos != nil {
+				db.reclaimSize += int64(oldPos.Size)
+			}
+			req.pos = positions[i]
+			seqNo := db.addNonTxnVersion(req.key, oldPos, positions[i])
+			db.notifyCommit(req.key, req.record.Value, KeyEventPut, positions[i])
+			if db.auditLog != nil {
+				auditEntries = append(auditEntries, newAuditEntry(AuditPut, req.key, seqNo, req.gid))
+			}
+		}
+		db.audit(auditEntries...)
+	}
+	db.mu.Unlock()
+
+	for _, req := range batch {
+		req.result <- err
+	}
+}
+
+// 将多条日志记录合并为尽量少的写入调用，并且最多持久化一次（访问此方法前必须持有锁）
+// 写入失败时截断此次写入的所有数据，包括轮转之前已经写入旧文件的部分，重启后不会加载没有生效的记录
+func (db *DB) appendLogRecords(logRecords []*data.LogRecord) ([]*data.LogRecordPos, error) {
+	// 判断当前活跃文件是否存在，因为数据库没有写入时没有文件生成
+	if db.activeFile == nil {
+		if err := db.setActiveFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 记录每个写入过的文件在写入前的位置，用于失败时回滚
+	type writtenFile struct {
+		dataFile *data.DataFile
+		offset   int64
+	}
+	written := []writtenFile{{dataFile: db.activeFile, offset: db.activeFile.WriteOff}}
+	rollback := func(err error) error {
+		for i := len(written) - 1; i >= 0; i-- {
+			if truncateErr := written[i].dataFile.Truncate(written[i].offset); truncateErr != nil {
+				return errors.Join(err, truncateErr)
+			}
+		}
+		// 轮转之后封存的文件大小发生了变化
+		if len(written) > 1 {
+			if manifestErr := db.writeManifest(); manifestErr != nil {
+				return errors.Join(err, manifestErr)
+			}
+		}
+		return err
+	}
+
+	// 将缓冲区中的数据写入活跃文件
+	var buf []byte
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		if err := db.activeFile.Write(buf); err != nil {
+			return err
+		}
+		db.bytesWrite += uint(len(buf))
+		buf = buf[:0]
+		return nil
+	}
+
+	positions := make([]*data.LogRecordPos, len(logRecords))
+	for i, logRecord := range logRecords {
+		// 按配置压缩和加密value；开启了键值分离时，较大的value写入value log，数据文件中只保存指向它的位置
+		logRecord, err := db.encodeValue(logRecord)
+		if err != nil {
+			return nil, rollback(err)
+		}
+		logRecord, err = db.separateValue(logRecord)
+		if err != nil {
+			return nil, rollback(err)
+		}
+		encRecord, size := db.activeFile.EncodeLogRecord(logRecord)
+
+		// 如果写入的数据超过活跃文件的阈值，先写完缓冲区，再打开新的活跃文件
+		writeOff := db.activeFile.WriteOff + int64(len(buf))
+		if writeOff+size > db.options.DataFileSize {
+			if err := flush(); err != nil {
+				return nil, rollback(err)
+			}
+			if err := db.rotateActiveFile(); err != nil {
+				return nil, rollback(err)
+			}
+			written = append(written, writtenFile{dataFile: db.activeFile, offset: db.activeFile.WriteOff})
+			writeOff = db.activeFile.WriteOff
+		}
+
+		buf = append(buf, encRecord...)
+		db.writeCount.Add(1)
+		positions[i] = &data.LogRecordPos{
+			Fid:    db.activeFile.FileId,
+			Offset: writeOff,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, rollback(err)
+	}
+
+	// 持久化活跃文件
+	if err := db.syncIfNeeded(); err != nil {
+		return nil, rollback(err)
+	}
+	return positions, nil
+}
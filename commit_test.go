@@ -0,0 +1,157 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"bitcask-go/data"
+)
+
+func TestDB_GroupCommitConcurrentPut(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.GroupCommit = true
+		options.SyncWrites = true
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if err := db.Put(testKey(g*1000+i), testValue(i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// 重启后所有写入都能读到
+	db = reopenTestDB(t, db)
+	for g := 0; g < 8; g++ {
+		for i := 0; i < 200; i++ {
+			value, err := db.Get(testKey(g*1000 + i))
+			if err != nil || !bytes.Equal(value, testValue(i)) {
+				t.Fatalf("get %s = %q, %v", testKey(g*1000+i), value, err)
+			}
+		}
+	}
+}
+
+// 关闭时正在等待组提交的写入返回 ErrDatabaseClosed
+func TestDB_GroupCommitClose(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.GroupCommit = true
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				if err := db.Put(testKey(g*100000+i), testValue(i)); err != nil {
+					if !errors.Is(err, ErrDatabaseClosed) {
+						t.Errorf("expected ErrDatabaseClosed, got %v", err)
+					}
+					return
+				}
+			}
+		}(g)
+	}
+	if err := db.Put(testKey(-1), testValue(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+// 组提交写入失败时截断此次写入的所有记录，包括轮转之前已经写入旧文件的部分，重启后不会被加载
+func TestDB_GroupCommitRollbackOnFailure(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 2048
+		options.ValueLogSeparationThreshold = 512
+	})
+	largeValue := bytes.Repeat([]byte("v"), 512)
+	if err := db.Put([]byte("large"), largeValue); err != nil {
+		t.Fatal(err)
+	}
+	// 之后写入value log失败，分组中最后一条记录出错
+	faulty := &diskFullIO{IOManager: db.activeVlog.IOManager, failWrites: true}
+	db.activeVlog.IOManager = faulty
+
+	var records []*data.LogRecord
+	for i := 0; i < 100; i++ {
+		records = append(records, &data.LogRecord{Key: logRecordKeyWithSeq(testKey(i), nonTransactionSeqNo), Value: testValue(i)})
+	}
+	records = append(records, &data.LogRecord{Key: logRecordKeyWithSeq([]byte("failed"), nonTransactionSeqNo), Value: largeValue})
+
+	startFile := db.activeFile
+	startOff := startFile.WriteOff
+	db.mu.Lock()
+	_, err := db.appendLogRecords(records)
+	db.mu.Unlock()
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected ENOSPC, got %v", err)
+	}
+	if db.activeFile == startFile {
+		t.Fatal("expected the batch to rotate the active file")
+	}
+	if startFile.WriteOff != startOff || db.activeFile.WriteOff != 0 {
+		t.Fatalf("expected written data to be truncated, got %d (want %d) and %d", startFile.WriteOff, startOff, db.activeFile.WriteOff)
+	}
+
+	faulty.failWrites = false
+	db = reopenTestDB(t, db)
+	for i := 0; i < 100; i++ {
+		if _, err := db.Get(testKey(i)); err != ErrKeyNotFound {
+			t.Fatalf("Get(%s) after reopen: expected ErrKeyNotFound, got %v", testKey(i), err)
+		}
+	}
+	if value, err := db.Get([]byte("large")); err != nil || !bytes.Equal(value, largeValue) {
+		t.Fatalf("Get(large) = %d bytes, %v", len(value), err)
+	}
+	if err := db.Put(testKey(0), testValue(0)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 8个协程并发写入，对比是否开启组提交的吞吐
+func BenchmarkDB_ConcurrentPut(b *testing.B) {
+	for _, groupCommit := range []bool{false, true} {
+		b.Run(fmt.Sprintf("group-commit-%v", groupCommit), func(b *testing.B) {
+			options := DefaultOptions
+			options.DirPath = b.TempDir()
+			options.SyncWrites = true
+			options.GroupCommit = groupCommit
+			db, err := Open(options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+
+			var counter int64
+			value := bytes.Repeat([]byte("v"), 128)
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&counter, 1)
+					if err := db.Put(testKey(int(i)), value); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package bitcask_go
+
+import (
+	"math"
+	"os"
+	"sort"
+
+	"bitcask-go/utils"
+)
+
+// 一次完成的维护操作（适合定时任务调用）：忽略 DataFileMergeRatio 回收所有旧数据文件中的无效数据，更新hint文件，再删除失效的文件
+// 已经merge过的文件原地重写并更新hint文件中的位置，还没有merge过的文件通过增量merge重写并加入hint文件，结果在本次调用中直接生效，不需要重新打开
+// 整个过程只设置一次 isMerging，期间 Merge、MergeN、Vacuum、GC 和 Truncate 返回 ErrMergeIsProgress
+// 返回数据目录在操作前后的大小之差；B+树索引不支持原地重写，返回 ErrIncrementalMergeUnsupported
+func (db *DB) Compact() (reclaimed int64, err error) {
+	if db.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+	if db.options.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	if db.options.InMemory {
+		return 0, ErrInMemoryUnsupported
+	}
+	if db.options.IndexType == BPlusTree {
+		return 0, ErrIncrementalMergeUnsupported
+	}
+
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return 0, ErrMergeIsProgress
+	}
+	// 如果数据库为空，则直接返回
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return 0, nil
+	}
+	db.isMerging = true
+	hintBound, err := db.hintFileIdBound()
+	var mergedIds []uint32
+	for fid := range db.olderFiles {
+		if fid < hintBound {
+			mergedIds = append(mergedIds, fid)
+		}
+	}
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+	if err != nil {
+		return 0, err
+	}
+
+	sizeBefore, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		return 0, err
+	}
+	db.options.Logger.Infof("compaction started: %d merged data files", len(mergedIds))
+
+	// 原地重写merge过的文件，hint文件中的位置随之更新
+	if len(mergedIds) > 0 {
+		sort.Slice(mergedIds, func(i, j int) bool {
+			return mergedIds[i] < mergedIds[j]
+		})
+		vacuumPath := db.getVacuumPath()
+		if err := os.RemoveAll(vacuumPath); err != nil {
+			return 0, err
+		}
+		if err := os.MkdirAll(vacuumPath, os.ModePerm); err != nil {
+			return 0, err
+		}
+		defer func() {
+			_ = os.RemoveAll(vacuumPath)
+		}()
+		for i, fid := range mergedIds {
+			if err := db.vacuumFile(vacuumPath, fid, i == 0, true); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// 之后的旧数据文件全部通过增量merge重写，索引写入hint文件
+	if _, err := db.mergeIncremental(math.MaxInt); err != nil {
+		return 0, err
+	}
+
+	db.mu.Lock()
+	_, err = db.truncateFiles()
+	db.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	sizeAfter, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		return 0, err
+	}
+	// hint文件变大时数据目录可能反而增大，不返回负数
+	if reclaimed = sizeBefore - sizeAfter; reclaimed < 0 {
+		reclaimed = 0
+	}
+	db.options.Logger.Infof("compaction finished: reclaimed %d bytes", reclaimed)
+	return reclaimed, nil
+}
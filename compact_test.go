@@ -0,0 +1,88 @@
+package bitcask_go
+
+import (
+	"testing"
+
+	"bitcask-go/utils"
+)
+
+func TestDB_Compact(t *testing.T) {
+	// 第二轮之前已经merge过，hint文件覆盖前面的文件
+	for _, merged := range []bool{false, true} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 8 * 1024
+		})
+		for i := 0; i < 2000; i++ {
+			if err := db.Put(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if merged {
+			if err := db.MergeForce(); err != nil {
+				t.Fatal(err)
+			}
+			db = reopenTestDB(t, db)
+		}
+		// 覆盖大部分key并删除一部分
+		for round := 0; round < 3; round++ {
+			for i := 0; i < 1500; i++ {
+				if err := db.Put(testKey(i), []byte("new")); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		for i := 1500; i < 1800; i++ {
+			if err := db.Delete(testKey(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected := dumpDB(t, db)
+		sizeBefore, err := utils.DirSize(db.options.DirPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reclaimed, err := db.Compact()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizeAfter, err := utils.DirSize(db.options.DirPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reclaimed <= sizeBefore/2 || reclaimed != sizeBefore-sizeAfter {
+			t.Fatalf("reclaimed %d, dir size %d -> %d", reclaimed, sizeBefore, sizeAfter)
+		}
+		if bound, err := db.hintFileIdBound(); err != nil || bound != db.activeFile.FileId {
+			t.Fatalf("expected hint file covering all older files, got %d, %v", bound, err)
+		}
+		expectContent(t, db, expected)
+
+		// 没有新的无效数据，再次执行不会回收
+		if reclaimed, err := db.Compact(); err != nil || reclaimed != 0 {
+			t.Fatalf("second compaction reclaimed %d, %v", reclaimed, err)
+		}
+
+		db = reopenTestDB(t, db)
+		expectContent(t, db, expected)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_CompactExclusive(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	db.mu.Lock()
+	db.isMerging = true
+	db.mu.Unlock()
+	if _, err := db.Compact(); err != ErrMergeIsProgress {
+		t.Fatalf("expected ErrMergeIsProgress, got %v", err)
+	}
+	db.mu.Lock()
+	db.isMerging = false
+	db.mu.Unlock()
+}
@@ -1,25 +1,57 @@
 package data
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"os"
 	"path/filepath"
 
 	"bitcask-go/fio"
 )
 
 var (
-	ErrInvalidCRC = errors.New("invalid crc value, log record maybe corrupted") // crc值校验失败
+	ErrInvalidCRC   = errors.New("invalid crc value, log record maybe corrupted")                // crc值校验失败
+	ErrPartialWrite = errors.New("failed to roll back partial write, data file is not writable") // 写入失败后无法回滚已写入的部分数据
+
+	ErrHintChecksumMismatch = errors.New("hint file checksum mismatch") // hint文件缺少末尾的校验和或者校验和不一致
 )
 
+// 文件中的记录损坏，包含损坏记录所在的文件id和偏移，便于排查
+// 可以使用 errors.As 获取具体的位置，errors.Is(err, ErrInvalidCRC) 和 errors.Is(err, fio.ErrInvalidBlockCRC) 仍然可以判断损坏的原因
+type CorruptionError struct {
+	FileId uint32 // 文件id
+	Offset int64  // 损坏的记录在文件中的偏移
+	Cause  string // 损坏的原因
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("log record at offset %d of file %d is corrupted: %s", e.Offset, e.FileId, e.Cause)
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return (target == ErrInvalidCRC || target == fio.ErrInvalidBlockCRC) && e.Cause == target.Error()
+}
+
+// 为记录读取时的校验错误加上文件id和偏移，其他错误原样返回
+func (df *DataFile) corruptionError(err error, offset int64) error {
+	if err != ErrInvalidCRC && err != fio.ErrInvalidBlockCRC {
+		return err
+	}
+	return &CorruptionError{FileId: df.FileId, Offset: offset, Cause: err.Error()}
+}
+
 // 文件后缀
 const (
 	DataFileNameSuffix    = ".data"          // 数据文件后缀
+	ValueLogFileSuffix    = ".vlog"          // value log文件后缀（键值分离时存放较大的value）
 	HintFileName          = "hint-index"     // hint文件名
 	MergeFinishedFileName = "merge-finished" // 标识merge完成文件的文件名
 	SeqNoFileName         = "seq-no"         // 标识最新事务序列号的文件名（B+树索引专属）
+	VersionFileName       = "version-index"  // 关闭数据库时保存key历史版本的文件名（开启多版本时使用）
 )
 
 // 文件结构体
@@ -27,6 +59,13 @@ type DataFile struct {
 	FileId    uint32        // 文件id
 	WriteOff  int64         // 文件写入的位置（偏移量）
 	IOManager fio.IOManager // io读写管理
+
+	fileName     string            // 文件路径
+	preallocated bool              // 是否预分配过磁盘空间（关闭时需要释放未使用的部分）
+	checksumMode ChecksumMode      // 数据校验方式
+	checksumAlg  ChecksumAlgorithm // 新写入记录的crc算法，读取时按记录中的标记选择
+	writeErr     error             // 部分数据写入后无法回滚时记录的错误，之后的写入直接返回此错误
+	hintCrc      uint32            // hint文件中已写入的所有记录的crc32（WriteHintChecksum 使用）
 }
 
 // 初始化指定文件的IOManager（mmap加快文件启动速度，只有启动时打开数据文件用到mmap，其余用标准文件io）
@@ -40,6 +79,7 @@ func newDataFile(fileName string, field uint32, ioType fio.FileIOType) (*DataFil
 		FileId:    field,
 		WriteOff:  0,
 		IOManager: ioManager,
+		fileName:  fileName,
 	}, nil
 }
 
@@ -49,11 +89,27 @@ func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFi
 	return newDataFile(fileName, fileId, ioType)
 }
 
+// 根据文件路径打开数据文件（使用自定义的文件命名时）
+func OpenDataFileWithName(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	return newDataFile(fileName, fileId, ioType)
+}
+
 // 获取数据文件名
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
+// 根据文件路径和文件id打开value log文件
+func OpenValueLogFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := GetValueLogFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId, ioType)
+}
+
+// 获取value log文件名
+func GetValueLogFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+ValueLogFileSuffix)
+}
+
 // 打开hint索引文件（不存在则新建）
 func OpenHintFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
@@ -72,8 +128,31 @@ func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// 打开保存key历史版本的文件（不存在则新建）
+func OpenVersionFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, VersionFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
+// 提示即将顺序读取文件中 [offset, offset+length) 范围内的数据，文件IO不支持预读时忽略
+func (df *DataFile) Prefetch(offset, length int64) {
+	if prefetcher, ok := df.IOManager.(fio.Prefetcher); ok {
+		prefetcher.Prefetch(offset, length)
+	}
+}
+
 // 读取日志文件记录（返回日志记录、长度(用于更新文件偏移量)、错误）
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
+	return df.readLogRecord(offset, true)
+}
+
+// 读取日志文件记录，不校验日志记录的crc
+// 只用于可信存储上的读取路径，merge、迁移等会重写数据的场景必须使用 ReadLogRecord，避免把损坏的数据写成新的有效记录
+func (df *DataFile) ReadLogRecordSkipCRC(offset int64) (*LogRecord, int64, error) {
+	return df.readLogRecord(offset, false)
+}
+
+func (df *DataFile) readLogRecord(offset int64, verifyCRC bool) (*LogRecord, int64, error) {
 	// 获取文件大小
 	fileSize, err := df.IOManager.Size()
 	if err != nil {
@@ -89,11 +168,11 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 在文件中读取Header
 	headerBuf, err := df.readNBytes(headerBytes, offset)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, df.corruptionError(err, offset)
 	}
 
 	// 对Header进行解码
-	header, headerSize := decodeLogRecordHeader(headerBuf)
+	header, headerSize := decodeLogRecordHeader(headerBuf, df.checksumMode)
 	// 以下两个条件表示：读到了文件末尾
 	if header == nil {
 		return nil, 0, io.EOF
@@ -106,23 +185,25 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 
 	// logRecord为函数返回的日志记录
-	logRecord := &LogRecord{Type: header.recordType}
+	logRecord := &LogRecord{Type: header.recordType, Expire: header.expire, Flags: header.flags}
 
 	// 读取key和value
 	if keySize > 0 || valueSize > 0 {
 		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
 		if err != nil {
-			return nil, 0, err
+			return nil, 0, df.corruptionError(err, offset)
 		}
 
 		logRecord.Key = kvBuf[:keySize]
 		logRecord.Value = kvBuf[keySize:]
 	}
 
-	// 校验数据有效性
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
-	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+	// 校验数据有效性（按块校验时，读取数据时已经校验过所在的块）
+	if df.checksumMode == PerRecord && verifyCRC {
+		crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize], header.algorithm)
+		if crc != header.crc {
+			return nil, 0, df.corruptionError(ErrInvalidCRC, offset)
+		}
 	}
 
 	// 日志记录总长度
@@ -131,10 +212,43 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	return logRecord, recordSize, nil
 }
 
+// 按文件的校验方式对日志记录编码
+func (df *DataFile) EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return EncodeLogRecordWithMode(logRecord, df.checksumMode, df.checksumAlg)
+}
+
+// 设置之后写入的日志记录使用的crc算法，已有的记录不受影响
+func (df *DataFile) SetChecksumAlgorithm(algorithm ChecksumAlgorithm) {
+	df.checksumAlg = algorithm
+}
+
+// 切换为按块校验：数据按4KB分块写入，每个块末尾带有块中数据的crc
+// active 为 true 时（活跃文件）从头校验每个块来确定文件末尾
+func (df *DataFile) UseBlockChecksum(active bool) error {
+	ioManager, err := fio.NewBlockChecksumIO(df.IOManager, df.fileName, active)
+	if err != nil {
+		return err
+	}
+	df.IOManager = ioManager
+	df.checksumMode = PerBlock
+	return nil
+}
+
 // 写入数据
+// 写入失败时WriteOff保持不变：已写入的部分数据会被截断，保证之后写入的数据位于WriteOff处
 func (df *DataFile) Write(buf []byte) error {
+	if df.writeErr != nil {
+		return df.writeErr
+	}
+
 	n, err := df.IOManager.Write(buf)
+	if err == nil && n < len(buf) {
+		err = io.ErrShortWrite
+	}
 	if err != nil {
+		if n > 0 {
+			df.rollbackPartialWrite()
+		}
 		return err
 	}
 
@@ -143,6 +257,27 @@ func (df *DataFile) Write(buf []byte) error {
 	return nil
 }
 
+// 将文件截断回写入前的位置；无法截断时文件末尾残留了不完整的记录，停止写入此文件，重新打开时会丢弃这部分数据
+func (df *DataFile) rollbackPartialWrite() {
+	_ = df.Truncate(df.WriteOff)
+}
+
+// 将文件截断到指定位置并回退写入位置，丢弃之后写入的记录
+// 无法截断时停止写入此文件，避免新的记录追加在残留的数据之后
+func (df *DataFile) Truncate(size int64) error {
+	truncater, ok := df.IOManager.(fio.Truncater)
+	if !ok {
+		df.writeErr = ErrPartialWrite
+		return df.writeErr
+	}
+	if err := truncater.Truncate(size); err != nil {
+		df.writeErr = fmt.Errorf("%w: %v", ErrPartialWrite, err)
+		return df.writeErr
+	}
+	df.WriteOff = size
+	return nil
+}
+
 // 向hint文件（相当于merge引擎中的内存）中写数据
 func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 	record := &LogRecord{
@@ -150,7 +285,37 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 		Value: EncodeLogRecordPos(pos),
 	}
 	encodeLogRecord, _ := EncodeLogRecord(record)
-	return df.Write(encodeLogRecord)
+	if err := df.Write(encodeLogRecord); err != nil {
+		return err
+	}
+	df.hintCrc = crc32.Update(df.hintCrc, crc32.IEEETable, encodeLogRecord)
+	return nil
+}
+
+// 在hint文件末尾写入校验和，覆盖之前通过 WriteHintRecord 写入的所有记录，写入之后不能再写入其他记录
+func (df *DataFile) WriteHintChecksum() error {
+	return df.Write(encodeHintChecksum(df.hintCrc))
+}
+
+// 校验和记录的编码：key为空，value为4字节的crc32，编码后的长度固定
+func encodeHintChecksum(checksum uint32) []byte {
+	value := make([]byte, crc32.Size)
+	binary.LittleEndian.PutUint32(value, checksum)
+	encodeLogRecord, _ := EncodeLogRecord(&LogRecord{Value: value, Type: LogRecordHintChecksum})
+	return encodeLogRecord
+}
+
+// 校验hint文件的完整内容，返回末尾的校验和之前的记录部分；没有校验和或者校验和不一致时返回 ErrHintChecksumMismatch
+func VerifyHintChecksum(buf []byte) ([]byte, error) {
+	trailerSize := len(encodeHintChecksum(0))
+	if len(buf) < trailerSize {
+		return nil, ErrHintChecksumMismatch
+	}
+	records := buf[:len(buf)-trailerSize]
+	if !bytes.Equal(buf[len(records):], encodeHintChecksum(crc32.ChecksumIEEE(records))) {
+		return nil, ErrHintChecksumMismatch
+	}
+	return records, nil
 }
 
 // 持久化
@@ -165,15 +330,51 @@ func (df *DataFile) readNBytes(n int64, offset int64) (b []byte, err error) {
 	return
 }
 
+// 文件中数据的大小：本次打开后写入过的文件以写入位置为准（预分配或mmap扩展的部分不计入），其他文件以文件大小为准
+func (df *DataFile) DataSize() (int64, error) {
+	if df.WriteOff > 0 {
+		return df.WriteOff, nil
+	}
+	return df.IOManager.Size()
+}
+
 func (df *DataFile) Close() error {
-	return df.IOManager.Close()
+	if err := df.IOManager.Close(); err != nil {
+		return err
+	}
+	// 将文件截断到实际写入的位置，释放预分配但未使用的磁盘空间
+	if df.preallocated {
+		return os.Truncate(df.fileName, df.WriteOff)
+	}
+	return nil
+}
+
+// 为文件预先分配磁盘空间，减少文件碎片，并避免写入过程中才发现磁盘空间不足
+func (df *DataFile) Preallocate(size int64) error {
+	if err := fio.Preallocate(df.fileName, size); err != nil {
+		return err
+	}
+	df.preallocated = true
+	return nil
+}
+
+// 重新打开的文件末尾之后残留预分配的空间时（上次打开时进程异常退出，没有在关闭时截断），关闭时同样截断释放
+func (df *DataFile) DetectPreallocated() error {
+	preallocated, err := fio.HasPreallocatedSpace(df.fileName)
+	if err != nil {
+		return err
+	}
+	if preallocated {
+		df.preallocated = true
+	}
+	return nil
 }
 
-func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
+func (df *DataFile) SetIOManager(ioType fio.FileIOType) error {
 	if err := df.IOManager.Close(); err != nil {
 		return err
 	}
-	ioManager, err := fio.NewIOManager(GetDataFileName(dirPath, df.FileId), ioType)
+	ioManager, err := fio.NewIOManager(df.fileName, ioType)
 	if err != nil {
 		return err
 	}
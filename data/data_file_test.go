@@ -0,0 +1,99 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitcask-go/fio"
+)
+
+func TestDataFile_CorruptionError(t *testing.T) {
+	dir := t.TempDir()
+	dataFile, err := OpenDataFile(dir, 7, fio.StandardFIO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataFile.Close()
+
+	first, _ := EncodeLogRecord(&LogRecord{Key: []byte("k1"), Value: []byte("v1")})
+	second, _ := EncodeLogRecord(&LogRecord{Key: []byte("k2"), Value: []byte("v2")})
+	for _, encoded := range [][]byte{first, second} {
+		if err := dataFile.Write(encoded); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 破坏第二条记录的value
+	file, err := os.OpenFile(GetDataFileName(dir, 7), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{'x'}, int64(len(first)+len(second)-1)); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	if _, _, err := dataFile.ReadLogRecord(0); err != nil {
+		t.Fatalf("expected the first record to be valid, got %v", err)
+	}
+	_, _, err = dataFile.ReadLogRecord(int64(len(first)))
+	var corruptionErr *CorruptionError
+	if !errors.As(err, &corruptionErr) {
+		t.Fatalf("expected CorruptionError, got %v", err)
+	}
+	if corruptionErr.FileId != 7 || corruptionErr.Offset != int64(len(first)) || corruptionErr.Cause != ErrInvalidCRC.Error() {
+		t.Fatalf("unexpected corruption error %+v", corruptionErr)
+	}
+	if !errors.Is(err, ErrInvalidCRC) || errors.Is(err, fio.ErrInvalidBlockCRC) {
+		t.Fatalf("unexpected cause of %v", err)
+	}
+
+	// 跳过crc校验时不返回错误
+	if _, _, err := dataFile.ReadLogRecordSkipCRC(int64(len(first))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataFile_HintChecksum(t *testing.T) {
+	dir := t.TempDir()
+	hintFile, err := OpenHintFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hintFile.Close()
+	for i := 0; i < 10; i++ {
+		if err := hintFile.WriteHintRecord([]byte{byte(i)}, &LogRecordPos{Fid: 1, Offset: int64(i) * 100, Size: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hintFile.WriteHintChecksum(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(filepath.Join(dir, HintFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := VerifyHintChecksum(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 校验和记录本身是一条合法的记录
+	trailer, _, err := DecodeLogRecord(buf[len(records):])
+	if err != nil || trailer.Type != LogRecordHintChecksum {
+		t.Fatalf("unexpected trailer %+v, %v", trailer, err)
+	}
+
+	buf[0] ^= 0xff
+	if _, err := VerifyHintChecksum(buf); err != ErrHintChecksumMismatch {
+		t.Fatalf("expected ErrHintChecksumMismatch, got %v", err)
+	}
+	if _, err := VerifyHintChecksum(records); err != ErrHintChecksumMismatch {
+		t.Fatalf("expected ErrHintChecksumMismatch without trailer, got %v", err)
+	}
+	if _, err := VerifyHintChecksum(nil); err != ErrHintChecksumMismatch {
+		t.Fatalf("expected ErrHintChecksumMismatch for empty file, got %v", err)
+	}
+}
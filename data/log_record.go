@@ -3,22 +3,105 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"io"
 )
 
 // LogRecord中的type类型，不显式指定则默认为零值0
 type LogRecordType = byte
 
 const (
-	LogRecordNormal      LogRecordType = iota // 未被删除（正常数据）
-	LogRecordDeleted                          // 已被删除
-	LogRecordTxnFinished                      // 已被提交（批量写之后，再向数据文件中写入一条新数据，Type为LogRecordTxnFinished，表示此次事务已提交）
+	LogRecordNormal       LogRecordType = iota // 未被删除（正常数据）
+	LogRecordDeleted                           // 已被删除
+	LogRecordTxnFinished                       // 已被提交（批量写之后，再向数据文件中写入一条新数据，Type为LogRecordTxnFinished，表示此次事务已提交）
+	LogRecordValuePointer                      // value存储在value log中，Value部分为编码后的value log位置
+	LogRecordFlushAll                          // 清空数据库的标识，之前的所有记录均已失效
+	LogRecordHintChecksum                      // hint文件末尾的校验和，Value为之前所有记录的crc32（只出现在hint文件中）
 )
 
-// LogRecord的Header部分：crc(校验值) type(类型) keySize(key大小) valueSize(value大小)
+// 数据校验方式
+type ChecksumMode = byte
+
+const (
+	PerRecord ChecksumMode = iota // 每条日志记录单独计算crc
+	PerBlock                      // 数据文件按块计算crc，日志记录的Header中不再包含crc
+)
+
+// 日志记录crc使用的算法（仅PerRecord方式）
+type ChecksumAlgorithm = byte
+
+const (
+	CRC32IEEE ChecksumAlgorithm = iota // IEEE多项式，兼容旧的数据文件
+	CRC32C                             // Castagnoli多项式，现代CPU上有硬件加速
+)
+
+// 使用CRC32C的记录在type字节的最高位做标记，读取时根据标记选择算法，两种算法写入的记录可以在同一个目录甚至同一个文件中共存
+const crc32cTypeFlag byte = 0x80
+
+// 设置了过期时间的记录在type字节的次高位做标记，Header中valueSize之后多一个变长的过期时间，没有标记的记录格式不变
+const expireTypeFlag byte = 0x40
+
+// 记录的value经过的编码，标记在type字节的第4、5位，和记录类型（低4位）以及上面两个标记共存
+// 标记是Header的一部分，包含在crc的计算范围内；没有标记的记录（包括所有旧的记录）格式不变
+type LogRecordFlag = byte
+
+const (
+	FlagCompressed LogRecordFlag = 0x10 // value经过压缩
+	FlagEncrypted  LogRecordFlag = 0x20 // value经过加密
+
+	recordFlagsMask = FlagCompressed | FlagEncrypted
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 日志记录Header的格式版本（仅PerRecord方式）
+type LogRecordFormat = byte
+
+const (
+	LogRecordFormatLegacy LogRecordFormat = iota + 1 // 最初的格式：IEEE crc，type字节中没有标记
+	LogRecordFormatV2                                // type字节的最高位标记crc算法，使用CRC32C
+)
+
+// 最新的Header格式版本
+const LatestLogRecordFormat = LogRecordFormatV2
+
+// 使用指定的crc算法编码的记录的Header格式版本
+func LogRecordFormatOf(algorithm ChecksumAlgorithm) LogRecordFormat {
+	if algorithm == CRC32C {
+		return LogRecordFormatV2
+	}
+	return LogRecordFormatLegacy
+}
+
+// 编码为指定Header格式版本时使用的crc算法
+func FormatChecksumAlgorithm(format LogRecordFormat) ChecksumAlgorithm {
+	if format == LogRecordFormatV2 {
+		return CRC32C
+	}
+	return CRC32IEEE
+}
+
+// 读取buf开头的日志记录（PerRecord方式）的Header格式版本，不校验记录
+func DecodeLogRecordFormat(buf []byte) LogRecordFormat {
+	if len(buf) > crc32.Size && buf[crc32.Size]&crc32cTypeFlag != 0 {
+		return LogRecordFormatV2
+	}
+	return LogRecordFormatLegacy
+}
+
+// 获取算法对应的crc表
+func crcTable(algorithm ChecksumAlgorithm) *crc32.Table {
+	if algorithm == CRC32C {
+		return castagnoliTable
+	}
+	return crc32.IEEETable
+}
+
+// LogRecord的Header部分：crc(校验值) type(类型) keySize(key大小) valueSize(value大小) [expire(过期时间)]
 // crc 4字节
-// type 1字节
+// type 1字节：低4位为记录类型，第4、5位为压缩、加密标记，第6位为过期标记，最高位为crc算法标记
 // keySize和valueSize是变长的，每个最大为5字节
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5 // Header的最大大小
+// expire是变长的，最大为10字节，只有type中带有过期标记时存在
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64 + 5 // Header的最大大小
 
 // LogRecord的头部信息
 type logRecordHeader struct {
@@ -26,13 +109,19 @@ type logRecordHeader struct {
 	recordType LogRecordType // 标识LogRecord的类型 1字节
 	keySize    uint32        // key的长度 最大为5字节
 	valueSize  uint32        // value的长度 最大为5字节
+	expire     int64         // 过期时间 最大为10字节
+
+	algorithm ChecksumAlgorithm // crc算法，由type字节中的标记确定
+	flags     LogRecordFlag     // value的编码标记，由type字节中的标记确定
 }
 
 // 文件中的记录（因为数据文件的数据是追加写入，类似日志格式，所以叫日志）
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType // 数据类型
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType // 数据类型
+	Expire int64         // 过期时间，Unix纳秒，为0表示不过期
+	Flags  LogRecordFlag // value的编码标记（FlagCompressed、FlagEncrypted），为0表示value为原始数据
 }
 
 // 内存中的记录，表示key对应的value值
@@ -40,6 +129,12 @@ type LogRecordPos struct {
 	Fid    uint32 // 文件id，表示将数据存储到了哪个文件当中
 	Offset int64  // 偏移，表示将数据存储到了数据文件中的哪个位置
 	Size   uint32 // 数据在磁盘上的大小
+	Expire int64  // 记录的过期时间，Unix纳秒，为0表示不过期
+}
+
+// 记录在now（Unix纳秒）时是否已经过期
+func (pos *LogRecordPos) IsExpired(now int64) bool {
+	return pos.Expire > 0 && pos.Expire <= now
 }
 
 // 暂存的事务相关数据（用于从数据文件加载内存索引）
@@ -54,17 +149,37 @@ type TransactionRecord struct {
 // type 1字节
 // keySize和valueSize是变长的，每个最大为5
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return EncodeLogRecordWithMode(logRecord, PerRecord, CRC32IEEE)
+}
+
+// 按指定的校验方式和crc算法对LogRecord编码，PerBlock方式下Header中不包含crc（由所在的块统一校验），忽略algorithm
+func EncodeLogRecordWithMode(logRecord *LogRecord, mode ChecksumMode, algorithm ChecksumAlgorithm) ([]byte, int64) {
+	var crcSize int
+	if mode == PerRecord {
+		crcSize = crc32.Size
+	}
+
 	// 初始化header的字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
 
-	// 第五个字节存储Type
-	header[4] = logRecord.Type
-	var index = 5
+	// crc之后的第一个字节存储Type，使用CRC32C时带上算法标记
+	header[crcSize] = logRecord.Type
+	if mode == PerRecord && algorithm == CRC32C {
+		header[crcSize] |= crc32cTypeFlag
+	}
+	if logRecord.Expire != 0 {
+		header[crcSize] |= expireTypeFlag
+	}
+	header[crcSize] |= logRecord.Flags & recordFlagsMask
+	var index = crcSize + 1
 
-	// 第五个字节后，存储keySize和valueSize
+	// Type之后，存储keySize和valueSize
 	// 使用变长类型节省空间
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	if logRecord.Expire != 0 {
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
 	// 此时index的值为header的长度
 
 	// size为日志记录整体长度
@@ -79,24 +194,36 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
 
 	// 对整个LogRecord进行数据校验
-	crc := crc32.ChecksumIEEE(encBytes[4:])
-	binary.LittleEndian.PutUint32(encBytes[:4], crc)
+	if mode == PerRecord {
+		crc := crc32.Checksum(encBytes[4:], crcTable(algorithm))
+		binary.LittleEndian.PutUint32(encBytes[:4], crc)
+	}
 
 	return encBytes, int64(size)
 }
 
 // 对字节数组中的Header解码
-func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
-	if len(buf) <= 4 {
+func decodeLogRecordHeader(buf []byte, mode ChecksumMode) (*logRecordHeader, int64) {
+	var crcSize int
+	if mode == PerRecord {
+		crcSize = crc32.Size
+	}
+	if len(buf) <= crcSize {
 		return nil, 0
 	}
 
 	header := &logRecordHeader{
-		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[crcSize] &^ (crc32cTypeFlag | expireTypeFlag | recordFlagsMask),
+		flags:      buf[crcSize] & recordFlagsMask,
+	}
+	if buf[crcSize]&crc32cTypeFlag != 0 {
+		header.algorithm = CRC32C
+	}
+	if mode == PerRecord {
+		header.crc = binary.LittleEndian.Uint32(buf[:crcSize])
 	}
 
-	var index = 5
+	var index = crcSize + 1
 	// 取出实际的key和value
 	keySize, n := binary.Varint(buf[index:])
 	header.keySize = uint32(keySize)
@@ -106,32 +233,77 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	header.valueSize = uint32(valueSize)
 	index += n
 
+	if buf[crcSize]&expireTypeFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		header.expire = expire
+		index += n
+	}
+
 	return header, int64(index)
 }
 
-// 校验有效性
-func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
+// 根据内存中的Header计算一条日志记录的总长度（用于在整块读入的文件中切分记录），读到文件末尾时返回 io.EOF
+func LogRecordSize(buf []byte) (int64, error) {
+	header, headerSize := decodeLogRecordHeader(buf, PerRecord)
+	if header == nil || (header.crc == 0 && header.keySize == 0 && header.valueSize == 0) {
+		return 0, io.EOF
+	}
+	size := headerSize + int64(header.keySize) + int64(header.valueSize)
+	if size > int64(len(buf)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return size, nil
+}
+
+// 从内存中解码一条日志记录并校验crc，buf需要以记录的起始位置开头
+func DecodeLogRecord(buf []byte) (*LogRecord, int64, error) {
+	size, err := LogRecordSize(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	header, headerSize := decodeLogRecordHeader(buf, PerRecord)
+	keyEnd := headerSize + int64(header.keySize)
+	logRecord := &LogRecord{
+		Key:    buf[headerSize:keyEnd],
+		Value:  buf[keyEnd:size],
+		Type:   header.recordType,
+		Expire: header.expire,
+		Flags:  header.flags,
+	}
+	if getLogRecordCRC(logRecord, buf[crc32.Size:headerSize], header.algorithm) != header.crc {
+		return nil, 0, ErrInvalidCRC
+	}
+	return logRecord, size, nil
+}
+
+// 校验有效性，header为crc之后的Header部分（包含算法标记）
+func getLogRecordCRC(lr *LogRecord, header []byte, algorithm ChecksumAlgorithm) uint32 {
 	if lr == nil {
 		return 0
 	}
 
+	table := crcTable(algorithm)
 	// 先对 header 做 CRC
-	crc := crc32.ChecksumIEEE(header[:])
+	crc := crc32.Checksum(header[:], table)
 	// 累加计算 Key
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Key)
+	crc = crc32.Update(crc, table, lr.Key)
 	// 累加计算 Value
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Value)
+	crc = crc32.Update(crc, table, lr.Value)
 
 	return crc
 }
 
 // 对文件位置信息LogRecordPos进行编码（用于写入hint文件）
+// 过期时间只在设置时编码在末尾，没有过期时间的编码和之前的格式相同
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
 	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	if pos.Expire != 0 {
+		index += binary.PutVarint(buf[index:], pos.Expire)
+	}
 	return buf[:index]
 }
 
@@ -142,10 +314,16 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(buf[index:])
 	index += n
-	size, _ := binary.Varint(buf[index:])
+	size, n := binary.Varint(buf[index:])
+	index += n
+	var expire int64
+	if index < len(buf) {
+		expire, _ = binary.Varint(buf[index:])
+	}
 	return &LogRecordPos{
 		Fid:    uint32(fileId),
 		Offset: offset,
 		Size:   uint32(size),
+		Expire: expire,
 	}
 }
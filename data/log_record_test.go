@@ -0,0 +1,126 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeLogRecordWithMode_ChecksumAlgorithm(t *testing.T) {
+	record := &LogRecord{Key: []byte("key"), Value: []byte("value"), Type: LogRecordDeleted}
+	ieee, _ := EncodeLogRecordWithMode(record, PerRecord, CRC32IEEE)
+	castagnoli, _ := EncodeLogRecordWithMode(record, PerRecord, CRC32C)
+	if bytes.Equal(ieee[:4], castagnoli[:4]) {
+		t.Fatal("expected different crc values")
+	}
+	// IEEE的编码和旧格式一致，不带算法标记
+	if encoded, _ := EncodeLogRecord(record); !bytes.Equal(encoded, ieee) {
+		t.Fatal("default encoding changed")
+	}
+
+	for _, encoded := range [][]byte{ieee, castagnoli} {
+		decoded, size, err := DecodeLogRecord(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(encoded)) || decoded.Type != LogRecordDeleted ||
+			!bytes.Equal(decoded.Key, record.Key) || !bytes.Equal(decoded.Value, record.Value) {
+			t.Fatalf("unexpected record %+v", decoded)
+		}
+
+		corrupted := append([]byte(nil), encoded...)
+		corrupted[len(corrupted)-1] ^= 0xff
+		if _, _, err := DecodeLogRecord(corrupted); err != ErrInvalidCRC {
+			t.Fatalf("expected ErrInvalidCRC, got %v", err)
+		}
+	}
+
+	// 按块校验时不带crc，也不带算法标记
+	perBlock, _ := EncodeLogRecordWithMode(record, PerBlock, CRC32C)
+	if perBlock[0] != LogRecordDeleted {
+		t.Fatalf("unexpected type byte %x", perBlock[0])
+	}
+}
+
+// 带过期时间的记录在Header中多一个过期时间，没有过期时间的记录编码不变
+func TestEncodeLogRecord_Expire(t *testing.T) {
+	record := &LogRecord{Key: []byte("key"), Value: []byte("value"), Type: LogRecordNormal, Expire: 1700000000123456789}
+	for _, algorithm := range []ChecksumAlgorithm{CRC32IEEE, CRC32C} {
+		encoded, _ := EncodeLogRecordWithMode(record, PerRecord, algorithm)
+		decoded, size, err := DecodeLogRecord(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(encoded)) || decoded.Type != LogRecordNormal || decoded.Expire != record.Expire ||
+			!bytes.Equal(decoded.Key, record.Key) || !bytes.Equal(decoded.Value, record.Value) {
+			t.Fatalf("unexpected record %+v", decoded)
+		}
+	}
+
+	plain, _ := EncodeLogRecord(&LogRecord{Key: record.Key, Value: record.Value})
+	withExpire, _ := EncodeLogRecord(record)
+	if plain[4] != LogRecordNormal || len(withExpire) <= len(plain) {
+		t.Fatalf("unexpected encoding %x / %x", plain, withExpire)
+	}
+
+	// hint文件中的位置信息
+	for _, pos := range []*LogRecordPos{
+		{Fid: 3, Offset: 1024, Size: 40},
+		{Fid: 3, Offset: 1024, Size: 40, Expire: record.Expire},
+	} {
+		if decoded := DecodeLogRecordPos(EncodeLogRecordPos(pos)); *decoded != *pos {
+			t.Fatalf("expected %+v, got %+v", pos, decoded)
+		}
+	}
+	pos := &LogRecordPos{Expire: 100}
+	if pos.IsExpired(99) || !pos.IsExpired(100) || (&LogRecordPos{}).IsExpired(1<<62) {
+		t.Fatal("unexpected expiration")
+	}
+}
+
+// 压缩、加密标记和过期时间、crc算法标记共存，标记包含在crc的计算范围内，没有标记的记录编码不变
+func TestEncodeLogRecord_Flags(t *testing.T) {
+	for _, flags := range []LogRecordFlag{FlagCompressed, FlagEncrypted, FlagCompressed | FlagEncrypted} {
+		for _, recordType := range []LogRecordType{LogRecordNormal, LogRecordFlushAll} {
+			record := &LogRecord{Key: []byte("key"), Value: []byte("value"), Type: recordType, Expire: 1700000000123456789, Flags: flags}
+			encoded, _ := EncodeLogRecordWithMode(record, PerRecord, CRC32C)
+			decoded, size, err := DecodeLogRecord(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != int64(len(encoded)) || decoded.Type != recordType || decoded.Flags != flags ||
+				decoded.Expire != record.Expire || !bytes.Equal(decoded.Value, record.Value) {
+				t.Fatalf("unexpected record %+v", decoded)
+			}
+
+			// 清除标记后crc不再匹配
+			corrupted := append([]byte(nil), encoded...)
+			corrupted[4] &^= flags
+			if _, _, err := DecodeLogRecord(corrupted); err != ErrInvalidCRC {
+				t.Fatalf("expected ErrInvalidCRC, got %v", err)
+			}
+		}
+	}
+
+	record := &LogRecord{Key: []byte("key"), Value: []byte("value")}
+	plain, _ := EncodeLogRecord(record)
+	decoded, _, err := DecodeLogRecord(plain)
+	if err != nil || plain[4] != LogRecordNormal || decoded.Flags != 0 {
+		t.Fatalf("unexpected encoding %x, flags %x, %v", plain, decoded.Flags, err)
+	}
+}
+
+func BenchmarkEncodeLogRecord(b *testing.B) {
+	record := &LogRecord{Key: []byte("benchmark-key"), Value: bytes.Repeat([]byte("v"), 4096)}
+	for _, algorithm := range []struct {
+		name      string
+		algorithm ChecksumAlgorithm
+	}{{"crc32-ieee", CRC32IEEE}, {"crc32c", CRC32C}} {
+		b.Run(algorithm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(record.Key) + len(record.Value)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				EncodeLogRecordWithMode(record, PerRecord, algorithm.algorithm)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package bitcask_go
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,12 +12,15 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofrs/flock"
 
 	"bitcask-go/data"
 	"bitcask-go/fio"
 	"bitcask-go/index"
+	"bitcask-go/lock"
 	"bitcask-go/utils"
 )
 
@@ -28,22 +33,69 @@ const (
 type DB struct {
 	options Options       // 配置项
 	mu      *sync.RWMutex // 读写锁
+	fileMu  *sync.RWMutex // 数据文件的读写锁，开启分段锁时持有mu读锁的写入通过它互斥地追加数据和切换活跃文件
+	stripes *lock.Striped // 按key加锁的分段锁（配置了StripedLockCount时使用）
 	fileIds []int         // 文件id集合，只能在根据文件加载索引时使用，不能在其他地方更新和使用
 
 	activeFile *data.DataFile            // 当前活跃的数据文件，可以用于写入
 	olderFiles map[uint32]*data.DataFile // 旧的数据文件，可以用于读取
 	index      index.Indexer             // 内存索引
 
-	seqNo uint64 // 事务序列号，全局递增（批量操作时为全局递增，无事务时为0）
+	activeVlog *data.DataFile            // 当前活跃的value log文件（开启键值分离时使用）
+	olderVlogs map[uint32]*data.DataFile // 旧的value log文件
+
+	blockCache *fio.BlockCache // 数据文件读取的块缓存（配置了BlockCacheSize时使用）
+	valueCodec *valueCodec     // value的压缩和加密（配置了 Compression 或 EncryptionKey 时使用）
+
+	seqNo           uint64                     // 事务序列号，全局递增（批量操作时为全局递增，开启多版本时非事务写入也会递增）
+	versions        map[string][]*versionedPos // key的历史版本（MaxVersionsPerKey大于1时使用）
+	minVersionSeqNo uint64                     // 打开数据库时的事务序列号，之前的版本没有记录
+	versionWrites   uint64                     // 上次清理历史版本之后记录的版本数
+	mergedFileId    uint32                     // 打开时生效的merge中未参与merge的最小文件id，更小id的文件已被重写
+	mergedFormat    LogRecordFormat            // merge重写的数据文件中记录的Header格式版本，未知时为0
 
 	isMerging       bool // 是否正在merge（同一时刻只允许一个merge）
 	seqNoFileExists bool // 存储事务序列号的文件是否存在（B+树索引专属）
 	isInitial       bool // 是否是第一次初始化此数据目录
 
-	fileLock *flock.Flock // 文件锁保证多进程之间互斥
+	fileLock  *flock.Flock // 文件锁保证多进程之间互斥
+	closeOnce sync.Once    // 保证只关闭一次
+	closed    atomic.Bool  // 是否已经关闭
+
+	commitQueue chan *writeRequest // 组提交的写入请求队列（开启组提交时使用）
+	commitStop  chan struct{}      // 通知后台写协程退出
+	commitDone  chan struct{}      // 后台写协程已退出
+
+	writeLimiter *writeLimiter // 写入限速以及写入速度统计
+
+	watchMu  *sync.RWMutex         // 保护订阅者集合
+	watchers map[*watcher]struct{} // key变更事件的订阅者
+	auditLog *auditLog             // 审计日志，未开启或只读模式时为nil
+
+	replMu    *sync.RWMutex                 // 保护复制流集合
+	replicas  map[*replicationFeed]struct{} // 复制流
+	commitSeq *commitSequencer              // 分段锁模式下按追加顺序调用写入提交的回调
+
+	deadlockDetector *DeadlockDetector // 检测并发的批量写入是否暂存了相同的key
 
 	bytesWrite  uint  // 累计未持久化的数据量，字节（持久化时清零）
 	reclaimSize int64 // 存储回收的数据文件大小（磁盘中无效数据的大小总量），单位：字节
+
+	readCount    atomic.Uint64 // 从数据文件读取的记录数量（ResetStats时清零）
+	writeCount   atomic.Uint64 // 写入数据文件的记录数量（ResetStats时清零）
+	statsMu      sync.Mutex    // 保护统计采样协程的启停
+	statsSampler *statsSampler // 后台统计采样协程，未开启时为nil
+	statsHistory *statsHistory // 统计快照的环形缓冲区
+
+	accessTimes sync.Map // 记录位置(accessKey) -> 最近访问时间（Unix纳秒），由 Touch 以及开启 EnableLRUTracking 时的读写更新，不持久化
+
+	rotations *rotationNotifier // 数据文件封存的异步通知（配置了 OnFileRotation 时使用）
+
+	manifestMu sync.Mutex // 保证同一时刻只有一个协程更新数据文件清单（开启 Manifest 时使用）
+
+	diskQuota *diskQuota // 数据目录大小的限额（配置了 MaxDiskSize 时使用）
+
+	admin *adminServer // 管理接口的HTTP服务（配置了 AdminAddr 时使用）
 }
 
 // 存储引擎统计信息
@@ -52,6 +104,22 @@ type Stat struct {
 	DataFileNum     uint  // 数据文件的数量
 	ReclaimableSize int64 // 可以进行 merge 回收的数据量，字节为单位
 	DiskSize        int64 // 数据目录所占磁盘空间大小
+
+	CacheHits     uint64  // 块缓存命中次数
+	CacheMisses   uint64  // 块缓存未命中次数
+	CacheHitRatio float64 // 块缓存命中率
+
+	CurrentWriteRateMBs float64 // 最近的写入速度，MB/s
+
+	ReadCount  uint64 // 从数据文件读取的记录数量，ResetStats 时清零
+	WriteCount uint64 // 写入数据文件的记录数量（包括删除记录和事务完成标识），ResetStats 时清零
+
+	MergedFormat LogRecordFormat // merge重写的数据文件中记录的Header格式版本，没有merge或无法确定时为0
+
+	FileUsage []FileUsage // 每个数据文件的空间占用，按文件id排序，读取文件大小失败时为空
+
+	KeySizeHistogram   *Histogram // 索引中key的大小分布
+	ValueSizeHistogram *Histogram // 索引中value的大小分布（由记录在磁盘上的大小估算，不读取value）
 }
 
 // 打开存储引擎实例（初始化）
@@ -60,118 +128,357 @@ func Open(options Options) (*DB, error) {
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
+	if options.Logger == nil {
+		options.Logger = nopLogger{}
+	}
+	valueCodec, err := newValueCodec(options)
+	if err != nil {
+		return nil, err
+	}
 
 	// 是否是第一次初始化此数据目录
-	var isInitial bool
+	isInitial := true
+	var fileLock *flock.Flock
+	// 内存模式不创建数据目录，也不获取文件锁
+	if !options.InMemory {
+		var err error
+		if fileLock, isInitial, err = openDataDir(options); err != nil {
+			return nil, err
+		}
+		// 加载之前用清单校验数据文件，不一致时释放文件锁，以便调用 Repair
+		if options.Manifest {
+			if err := (&DB{options: options}).checkManifest(); err != nil {
+				_ = fileLock.Unlock()
+				return nil, err
+			}
+		}
+	}
 
-	// 判读数据文件目录是否存在，如果不存在则创建
-	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
-		isInitial = true
-		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+	// 初始化DB
+	db := &DB{
+		options:      options,
+		mu:           new(sync.RWMutex),
+		fileMu:       new(sync.RWMutex),
+		olderFiles:   make(map[uint32]*data.DataFile),
+		olderVlogs:   make(map[uint32]*data.DataFile),
+		versions:     make(map[string][]*versionedPos),
+		index:        index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites, options.BTreeDegree),
+		isInitial:    isInitial,
+		fileLock:     fileLock,
+		writeLimiter: newWriteLimiter(options.WriteRateLimit),
+		watchMu:      new(sync.RWMutex),
+		watchers:     make(map[*watcher]struct{}),
+		replMu:       new(sync.RWMutex),
+		replicas:     make(map[*replicationFeed]struct{}),
+		valueCodec:   valueCodec,
+
+		deadlockDetector: new(DeadlockDetector),
+		statsHistory:     newStatsHistory(statsHistorySize),
+	}
+	if options.StripedLockCount > 0 {
+		db.stripes = lock.NewStriped(options.StripedLockCount)
+		db.commitSeq = newCommitSequencer()
+	}
+	if options.BlockCacheSize > 0 {
+		db.blockCache = fio.NewBlockCache(options.BlockCacheSize)
+	}
+
+	// 从磁盘加载数据文件和索引，内存模式下没有需要加载的文件
+	if !options.InMemory {
+		if err := db.load(); err != nil {
+			return nil, err
+		}
+		if err := db.writeManifest(); err != nil {
 			return nil, err
 		}
+		// 加载完成之后统计一次数据目录的大小
+		if options.MaxDiskSize > 0 {
+			diskQuota, err := newDiskQuota(options.DirPath, options.MaxDiskSize)
+			if err != nil {
+				return nil, err
+			}
+			db.diskQuota = diskQuota
+		}
 	}
 
-	// 判断当前数据目录是否正在使用
-	// 创建一个文件锁
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	// 尝试获取读锁
-	hold, err := fileLock.TryLock()
-	if err != nil {
-		return nil, err
+	// 只读模式下不写入审计日志，TailAuditLog 直接读取文件
+	// 加载完成之后再打开，加载失败时不会留下打开的审计日志文件
+	if options.AuditLog && !options.ReadOnly {
+		auditLog, err := openAuditLog(options.DirPath, options.SyncWrites)
+		if err != nil {
+			return nil, err
+		}
+		db.auditLog = auditLog
 	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
+
+	// 启动组提交的后台写协程
+	if options.GroupCommit {
+		db.startCommitWriter()
 	}
 
-	// 获取数据文件目录下的所有文件
-	entries, err := os.ReadDir(options.DirPath)
-	if err != nil {
-		return nil, err
+	// 启动数据文件封存的通知协程
+	if options.Hooks.OnFileRotation != nil {
+		db.rotations = newRotationNotifier(options.Hooks.OnFileRotation)
 	}
-	// 如果文件为空，则说明是第一次初始化此数据目录
-	if len(entries) == 0 {
-		isInitial = true
+
+	// 启动统计采样协程
+	if options.StatsInterval > 0 {
+		db.startStatsSampler(options.StatsInterval)
 	}
 
-	// 初始化DB
-	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
+	// 最后启动管理接口，监听失败时关闭已经打开的数据库
+	if options.AdminAddr != "" {
+		if err := db.startAdminServer(options.AdminAddr); err != nil {
+			db.closed.Store(true)
+			_ = db.close()
+			return nil, err
+		}
 	}
 
-	// 加载merge数据目录
-	if err := db.loadMergeFiles(); err != nil {
-		return nil, err
+	return db, nil
+}
+
+// 加载merge的结果、数据文件、value log以及索引
+func (db *DB) load() error {
+	// 加载merge数据目录（只读模式下不移动文件，merge的结果在下次以读写模式打开时生效）
+	if !db.options.ReadOnly {
+		if err := db.loadMergeFiles(); err != nil {
+			return err
+		}
+	}
+
+	// 读取merge重写的数据文件的格式版本
+	mergedFormat, err := db.loadMergedFormat()
+	if err != nil {
+		return err
 	}
+	db.mergedFormat = mergedFormat
 
 	// 加载数据文件
 	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+		return err
+	}
+
+	// 加载value log文件
+	if err := db.loadValueLogFiles(); err != nil {
+		return err
 	}
 
 	// B+树索引，将索引存储在磁盘文件中，启动DB时无需从数据文件加载索引放入内存
 	// 如果不是B+树索引，再去加载索引放入内存
-	if options.IndexType != BPlusTree {
-		// 从merge目录中的hint索引文件中加载索引
-		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+	if db.options.IndexType != BPlusTree {
+		// 持久化的ART索引文件和数据文件一致时直接使用，否则从hint文件和数据文件加载
+		loaded, err := db.loadARTIndex()
+		if err != nil {
+			return err
 		}
+		if !loaded {
+			// 从merge目录中的hint索引文件中加载索引
+			hintLoaded, err := db.loadIndexFromHintFile()
+			if err != nil {
+				return err
+			}
 
-		// 从数据目录下的数据文件中加载索引（同时获取到最新事务序列号，赋值给DB中的字段）
-		if err := db.loadIndexFromDataFiles(); err != nil {
-			return nil, err
+			// 从数据目录下的数据文件中加载索引（同时获取到最新事务序列号，赋值给DB中的字段），hint文件不可用时merge过的文件也需要加载
+			if err := db.loadIndexFromDataFiles(!hintLoaded); err != nil {
+				return err
+			}
 		}
 
 		// 重置IO类型为标准文件IO
 		if db.options.MMapAtStartup {
 			if err := db.resetIoType(); err != nil {
-				return nil, err
+				return err
 			}
 		}
 	}
 
 	// 从指定文件中取出当前事务序列号（B+树索引专属）
-	if options.IndexType == BPlusTree {
+	if db.options.IndexType == BPlusTree {
 		if err := db.loadSeqNo(); err != nil {
-			return nil, err
+			return err
 		}
 		if db.activeFile != nil {
 			size, err := db.activeFile.IOManager.Size()
 			if err != nil {
-				return nil, err
+				return err
 			}
 			db.activeFile.WriteOff = size
 		}
 	}
 
-	return db, nil
+	// 活跃文件末尾之后残留的预分配空间在关闭时释放（按块校验和可写MMap不预分配）
+	if db.activeFile != nil && !db.options.ReadOnly && !db.options.MMapActiveFile && db.options.ChecksumMode == PerRecord {
+		if err := db.activeFile.DetectPreallocated(); err != nil {
+			return err
+		}
+	}
+
+	// 历史版本从打开数据库之后开始记录，上次关闭时保存了历史版本则继续使用
+	db.minVersionSeqNo = db.seqNo
+	if err := db.loadVersions(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 打开数据目录：目录不存在时创建，并获取文件锁（只读模式不获取），返回文件锁以及是否是第一次初始化此数据目录
+func openDataDir(options Options) (fileLock *flock.Flock, isInitial bool, err error) {
+	// 判读数据文件目录是否存在，如果不存在则创建
+	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		// 只读模式下不创建数据目录
+		if options.ReadOnly {
+			return nil, false, err
+		}
+		isInitial = true
+		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// 判断当前数据目录是否正在使用
+	// 创建一个文件锁
+	fileLock = flock.New(filepath.Join(options.DirPath, fileLockName))
+	// 尝试获取读锁（只读模式不获取，以便在其他实例运行时查看数据）
+	if !options.ReadOnly {
+		hold, err := tryLockWithTimeout(fileLock, options.LockTimeout)
+		if err != nil {
+			return nil, false, err
+		}
+		if !hold {
+			options.Logger.Errorf("failed to acquire file lock of %s, database is used by another process", options.DirPath)
+			return nil, false, ErrDatabaseIsUsing
+		}
+	}
+
+	// 获取数据文件目录下的所有文件
+	entries, err := os.ReadDir(options.DirPath)
+	if err != nil {
+		return nil, false, err
+	}
+	// 如果文件为空，则说明是第一次初始化此数据目录
+	if len(entries) == 0 {
+		isInitial = true
+	}
+	return fileLock, isInitial, nil
+}
+
+// 获取文件锁的重试间隔，从 minLockRetryDelay 开始每次加倍，最长为 maxLockRetryDelay
+const (
+	minLockRetryDelay = 10 * time.Millisecond
+	maxLockRetryDelay = 500 * time.Millisecond
+)
+
+// 尝试获取文件锁，锁被占用时按退避间隔重试，直到超过timeout
+func tryLockWithTimeout(fileLock *flock.Flock, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	delay := minLockRetryDelay
+	for {
+		hold, err := fileLock.TryLock()
+		if err != nil || hold {
+			return hold, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false, nil
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxLockRetryDelay {
+			delay = maxLockRetryDelay
+		}
+	}
 }
 
 // 检查配置项（用户自定义参数）
 func checkOptions(options Options) error {
-	if options.DirPath == "" {
+	// 内存模式不需要数据目录
+	if options.DirPath == "" && !options.InMemory {
 		return errors.New("database dir path is empty")
 	}
+	if options.WriteRateLimit < 0 {
+		return errors.New("database write rate limit is invalid")
+	}
 	if options.DataFileSize <= 0 {
 		return errors.New("database data file size is invalid")
 	}
+	// 阶数只对BTree索引生效，其他索引类型不校验
+	if options.IndexType == Btree && options.BTreeDegree < 2 {
+		return errors.New("database btree degree is invalid")
+	}
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("database data file merge ratio is invalid")
 	}
+	if options.ValueLogMergeRatio < 0 || options.ValueLogMergeRatio > 1 {
+		return errors.New("database value log merge ratio is invalid")
+	}
+	if options.ReadOnly && options.IndexType == BPlusTree {
+		return errors.New("read only mode does not support b+ tree index")
+	}
+	if options.ChecksumMode != PerRecord && options.ChecksumMode != PerBlock {
+		return errors.New("database checksum mode is invalid")
+	}
+	if options.ChecksumAlgorithm != CRC32IEEE && options.ChecksumAlgorithm != CRC32C {
+		return errors.New("database checksum algorithm is invalid")
+	}
+	if options.ChecksumMode == PerBlock && options.MMapActiveFile {
+		return errors.New("per block checksum mode does not support mmap active file")
+	}
+	if options.LockTimeout < 0 {
+		return errors.New("database lock timeout is invalid")
+	}
+	if options.StatsInterval < 0 {
+		return ErrInvalidStatsInterval
+	}
+	if options.MaxDiskSize < 0 {
+		return errors.New("database max disk size is invalid")
+	}
+	if options.CompactOnDiskQuota && options.IndexType == BPlusTree {
+		return errors.New("b+ tree index does not support compact on disk quota")
+	}
+	if options.StripedLockCount < 0 {
+		return errors.New("database striped lock count is invalid")
+	}
+	// 历史版本需要按写入顺序分配事务序列号，只能在全局锁下记录
+	if options.StripedLockCount > 0 && options.MaxVersionsPerKey > 1 {
+		return errors.New("striped lock does not support multi version")
+	}
+	if options.MaxVersionsPerKey > 1 && options.VersionRetention == 0 {
+		return errors.New("version retention must be greater than 0 when multi version is enabled")
+	}
+	if options.Compression != NoCompression && options.Compression != Flate {
+		return errors.New("database compression type is invalid")
+	}
+	if n := len(options.EncryptionKey); n != 0 && n != 16 && n != 24 && n != 32 {
+		return errors.New("database encryption key must be 16, 24 or 32 bytes")
+	}
+	if options.MMapActiveFile && options.IOUringActiveFile {
+		return errors.New("mmap active file and io_uring active file cannot be enabled together")
+	}
+	// B+树索引启动时不扫描数据文件，无法识别崩溃后活跃文件映射区域末尾的空洞
+	if options.IndexType == BPlusTree && options.MMapActiveFile {
+		return errors.New("b+ tree index does not support mmap active file")
+	}
+	// 内存模式不读写磁盘文件，B+树索引、审计日志以及直接操作文件的配置项都不能使用
+	if options.InMemory {
+		if options.ReadOnly || options.IndexType == BPlusTree || options.IndexType == PersistentART || options.AuditLog {
+			return errors.New("in memory mode does not support read only, b+ tree index, persistent art index or audit log")
+		}
+		if options.MMapActiveFile || options.IOUringActiveFile || options.DataFilePreAllocSize > 0 || options.ChecksumMode == PerBlock {
+			return errors.New("in memory mode does not support mmap active file, io_uring active file, pre allocation or per block checksum")
+		}
+	}
 	return nil
 }
 
-// 从磁盘加载数据文件
-func (db *DB) loadDataFiles() error {
+// 列出数据目录中所有数据文件的id，从小到大排列，最大的id即为活跃文件
+func (db *DB) listDataFileIds() ([]int, error) {
 	// 取出文件目录中所有的文件
 	dirEntries, err := os.ReadDir(db.options.DirPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 文件id集合
@@ -179,13 +486,20 @@ func (db *DB) loadDataFiles() error {
 
 	// 遍历目录中所有文件，找到所有以.data结尾的文件
 	for _, entry := range dirEntries {
+		// 自定义了文件命名时，根据文件名中的数字查找数据文件
+		if db.options.DataFileNamer != nil {
+			if fileId, ok := db.parseDataFileId(entry.Name()); ok {
+				fileIds = append(fileIds, int(fileId))
+			}
+			continue
+		}
 		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
 			// 如果是以.data（自定义的扩展名）结尾的文件，获取文件id
 			splitNames := strings.Split(entry.Name(), data.DataFileNameSuffix)
 			fileId, err := strconv.Atoi(splitNames[0])
 			if err != nil {
 				// 数据目录可能损坏
-				return ErrDataDirectoryCorrupted
+				return nil, ErrDataDirectoryCorrupted
 			}
 			// 将文件id加入集合
 			fileIds = append(fileIds, fileId)
@@ -195,16 +509,26 @@ func (db *DB) loadDataFiles() error {
 	// 对文件id排序，从小到大依次加载
 	// 文件id是递增的，写入也是追加写入，最大的文件id即为当前活跃文件
 	sort.Ints(fileIds)
+	return fileIds, nil
+}
+
+// 从磁盘加载数据文件
+func (db *DB) loadDataFiles() error {
+	fileIds, err := db.listDataFileIds()
+	if err != nil {
+		return err
+	}
 	db.fileIds = fileIds
 
 	// 遍历每个文件id，打开对应的数据文件，存入DB的当前活跃文件和旧文件集合中
 	for i, fid := range fileIds {
+		// B+树索引启动时不读取数据文件，不使用MMap（之后也不会重置IO类型）
 		ioType := fio.StandardFIO
-		if db.options.MMapAtStartup {
+		if db.options.MMapAtStartup && db.options.IndexType != BPlusTree {
 			ioType = fio.MemoryMap
 		}
 		// 打开数据文件
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+		dataFile, err := db.openDataFile(uint32(fid), ioType, i == len(fileIds)-1)
 		if err != nil {
 			return err
 		}
@@ -221,7 +545,7 @@ func (db *DB) loadDataFiles() error {
 }
 
 // 从数据文件中加载内存索引
-func (db *DB) loadIndexFromDataFiles() error {
+func (db *DB) loadIndexFromDataFiles(replayAll bool) error {
 	if len(db.fileIds) == 0 {
 		return nil
 	}
@@ -229,11 +553,11 @@ func (db *DB) loadIndexFromDataFiles() error {
 	// 由于调用此方法前，已经从hint文件中加载过索引，所以只需要加载没有merge的文件，从其中加载索引
 	hasMerge, nonMergeFileId := false, uint32(0)
 
-	mergeFinFileName := db.getMergePath()
+	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
 	// 判断标识merge完成的文件是否存在，获取最小的未merge的文件id
-	if _, err := os.Stat(mergeFinFileName); err == nil {
+	if _, err := os.Stat(mergeFinFileName); err == nil && !replayAll {
 		// 如果存在
-		fid, err := db.getNonMergeFileId(db.options.DirPath)
+		fid, _, err := db.getNonMergeFileId(db.options.DirPath)
 		if err != nil {
 			return err
 		}
@@ -299,8 +623,18 @@ func (db *DB) loadIndexFromDataFiles() error {
 				return err
 			}
 
+			// 遇到清空数据库的标识，之前加载的索引和暂存的事务记录全部丢弃（FlushAll异常退出时之前的文件可能没有删除）
+			if logRecord.Type == data.LogRecordFlushAll {
+				db.index = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites, db.options.BTreeDegree)
+				db.reclaimSize = 0
+				transactionRecords = make(map[uint64][]*data.TransactionRecord)
+				currentSeqNo = nonTransactionSeqNo
+				offset += size
+				continue
+			}
+
 			// 构造内存索引并保存进内存
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
+			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size), Expire: logRecord.Expire}
 
 			// 解析key，拿到事务序列号
 			realKey, seqNo := parseLogRecordKey(logRecord.Key)
@@ -311,7 +645,7 @@ func (db *DB) loadIndexFromDataFiles() error {
 				if logRecord.Type == data.LogRecordTxnFinished {
 					// 将事务暂存集合的所有记录，逐个更新到内存中
 					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, logRecordPos)
+						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
 					}
 
 					// 清空事务暂存集合
@@ -338,6 +672,11 @@ func (db *DB) loadIndexFromDataFiles() error {
 		// 如果当前是活跃文件，更新下次写入文件的位置
 		if i == len(db.fileIds)-1 {
 			db.activeFile.WriteOff = offset
+
+			// 使用可写 MMap 时进程异常退出，文件末尾会残留预分配的空间，需要截断，否则后续追加写入的位置会和 WriteOff 不一致
+			if err := db.truncateActiveFileTail(); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -346,32 +685,128 @@ func (db *DB) loadIndexFromDataFiles() error {
 	return nil
 }
 
-// 关闭数据库
+// 将活跃文件截断到最后一条有效记录的位置
+func (db *DB) truncateActiveFileTail() error {
+	size, err := db.activeFile.IOManager.Size()
+	if err != nil {
+		return err
+	}
+	if size <= db.activeFile.WriteOff {
+		return nil
+	}
+	// 只读模式下不修改数据文件
+	if db.options.ReadOnly {
+		db.options.Logger.Warnf("ignored %d bytes after the last valid record of active file %d", size-db.activeFile.WriteOff, db.activeFile.FileId)
+		return nil
+	}
+	db.options.Logger.Warnf("truncated %d bytes after the last valid record of active file %d", size-db.activeFile.WriteOff, db.activeFile.FileId)
+	// 按块校验时文件大小是逻辑值，由 IOManager 负责截断
+	if db.options.ChecksumMode == PerBlock {
+		return db.activeFile.IOManager.(fio.Truncater).Truncate(db.activeFile.WriteOff)
+	}
+	return os.Truncate(db.getDataFileName(db.options.DirPath, db.activeFile.FileId), db.activeFile.WriteOff)
+}
+
+// 关闭数据库，可以重复调用：只有第一次调用会关闭文件并释放文件锁，之后的调用直接返回nil
+// 关闭之后的读写操作返回 ErrDatabaseClosed
 func (db *DB) Close() error {
-	// 释放文件锁
+	var err error
+	db.closeOnce.Do(func() {
+		db.closed.Store(true)
+		if err = db.close(); err == nil {
+			db.hookClose()
+		}
+	})
+	return err
+}
+
+// 关闭所有文件，无论是否出错都释放文件锁
+// 某一步出错时继续关闭其余的索引和文件，避免文件句柄泄漏，返回的错误为所有步骤错误的合并（errors.Join）
+func (db *DB) close() (err error) {
 	defer func() {
-		if err := db.fileLock.Unlock(); err != nil {
-			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
+		// 内存模式没有文件锁
+		if db.fileLock == nil {
+			return
+		}
+		if unlockErr := db.fileLock.Unlock(); unlockErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to unlock the directory, %v", unlockErr))
 		}
 	}()
 
+	// 先停止管理接口，等待正在处理的请求和后台merge完成
+	var errs []error
+	errs = append(errs, db.stopAdminServer())
+
+	// 停止统计采样协程
+	db.stopStatsSampler()
+
+	// 停止组提交的后台写协程，队列中剩余的请求会先写完
+	if db.commitStop != nil {
+		close(db.commitStop)
+		<-db.commitDone
+	}
+
+	// 等待已经封存的数据文件全部通知完成
+	if db.rotations != nil {
+		db.rotations.close()
+	}
+
+	// 关闭订阅者的通道，停止复制流
+	db.closeWatchers()
+	db.closeReplicas()
+
+	// 关闭审计日志
+	if db.auditLog != nil {
+		errs = append(errs, db.auditLog.close())
+	}
+
 	if db.activeFile == nil {
-		return nil
+		return errors.Join(errs...)
 	}
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	// 关闭索引迭代器（只有B+树需要）
+	// 关闭索引（B+树需要关闭bolt数据库），失败时仍然保存序列号并关闭数据文件
 	if err := db.index.Close(); err != nil {
-		return err
+		errs = append(errs, fmt.Errorf("failed to close the index, %w", err))
 	}
 
 	// B+树索引启动时不会从数据文件加载索引，所以也拿不到最新的事务序列号，因此要将当前最新事务序列号写入专门文件
+	// 内存模式关闭后数据丢失，不需要保存
+	if !db.options.ReadOnly && !db.options.InMemory {
+		errs = append(errs, db.saveSeqNo())
+
+		// 保存历史版本
+		errs = append(errs, db.saveVersions())
+
+		// 保存持久化的ART索引
+		errs = append(errs, db.saveARTIndex())
+	}
+
+	// 关闭当前活跃文件
+	errs = append(errs, db.activeFile.Close())
+
+	// 关闭旧的数据文件
+	for _, file := range db.olderFiles {
+		errs = append(errs, file.Close())
+	}
+
+	// 关闭value log文件
+	errs = append(errs, db.closeVlogs())
+
+	// 更新数据文件清单，记录活跃文件最终的大小
+	errs = append(errs, db.writeManifest())
+	return errors.Join(errs...)
+}
+
+// 将当前最新的事务序列号写入序列号文件
+func (db *DB) saveSeqNo() error {
 	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
 	if err != nil {
 		return err
 	}
+	defer seqNoFile.Close()
 	record := &data.LogRecord{
 		Key:   []byte(seqNoKey),
 		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
@@ -380,72 +815,114 @@ func (db *DB) Close() error {
 	if err := seqNoFile.Write(encRecord); err != nil {
 		return err
 	}
-	if err := seqNoFile.Sync(); err != nil {
-		return err
-	}
-
-	// 关闭当前活跃文件
-	if err := db.activeFile.Close(); err != nil {
-		return err
-	}
-
-	// 关闭旧的数据文件
-	for _, file := range db.olderFiles {
-		if err := file.Close(); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return seqNoFile.Sync()
 }
 
 // 持久化
 func (db *DB) Sync() error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
 	if db.activeFile == nil {
 		return nil
 	}
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	if err := db.syncVlog(); err != nil {
+		return err
+	}
+	if db.auditLog != nil {
+		if err := db.auditLog.sync(); err != nil {
+			return err
+		}
+	}
 	return db.activeFile.Sync()
 }
 
-// 将键值对写入文件
+// 将键值对写入文件（会清除key之前设置的过期时间）
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.put(key, value, 0)
+}
+
+// 写入键值对，expire为过期时间（Unix纳秒），为0表示不过期
+func (db *DB) put(key []byte, value []byte, expire int64) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := db.beforeWrite(key, value, false); err != nil {
+		return err
+	}
+	if err := db.checkDiskQuota(quotaRecordSize(key, value)); err != nil {
+		return err
+	}
+
+	// 写入限速，在获取锁之前等待
+	if err := db.writeLimiter.wait(len(key) + len(value)); err != nil {
+		return err
+	}
 
 	// 构造日志记录结构体（向文件中写入的是一条日志记录）
 	logRecord := data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo), // 将实际key和非事务序列号一起编码，作为新的key
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo), // 将实际key和非事务序列号一起编码，作为新的key
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
+	}
+
+	var pos *data.LogRecordPos
+	var err error
+	switch {
+	case db.options.GroupCommit:
+		// 开启了组提交时，交给后台写协程批量写入
+		pos, err = db.groupCommit(key, &logRecord)
+	case db.stripes != nil:
+		// 开启了分段锁时，只锁住key所在的分段
+		pos, err = db.putStriped(key, &logRecord)
+	default:
+		// 写入文件、更新内存索引和记录版本在同一个临界区内完成，保证索引和版本的顺序与写入顺序一致
+		db.mu.Lock()
+		pos, err = db.putLocked(key, &logRecord)
+		db.mu.Unlock()
+	}
+	if err != nil {
+		return err
 	}
+	if db.options.EnableLRUTracking {
+		db.recordAccess(pos)
+	}
+	db.hookPut(key, value, pos)
+	return nil
+}
 
+// 写入日志记录并更新内存索引，返回写入的位置（调用方需持有写锁）
+func (db *DB) putLocked(key []byte, logRecord *data.LogRecord) (*data.LogRecordPos, error) {
 	// 将日志记录写入文件
-	pos, err := db.appendLogRecordWithLock(&logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 更新内存索引
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
+	oldPos := db.index.Put(key, pos)
+	if oldPos != nil {
 		db.reclaimSize += int64(oldPos.Size)
 	}
+	seqNo := db.addNonTxnVersion(key, oldPos, pos)
+	db.notifyCommit(key, logRecord.Value, KeyEventPut, pos)
 
-	return nil
-}
-
-// 将日志记录写入文件（加锁版）
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	// 开启锁
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+	db.auditWrite(AuditPut, key, seqNo)
+	return pos, nil
 }
 
 // 将日志记录结构体写入文件（不加锁版）
+// 写入失败时活跃文件的WriteOff和bytesWrite保持不变，调用方直接返回错误，不更新内存索引
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
 	// 判断当前活跃文件是否存在，因为数据库没有写入时没有文件生成
 	if db.activeFile == nil {
@@ -455,52 +932,98 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
+	// 按配置压缩和加密value，之后分离到value log的value同样是编码后的
+	logRecord, err := db.encodeValue(logRecord)
+	if err != nil {
+		return nil, err
+	}
+	// 开启了键值分离时，较大的value写入value log，数据文件中只保存指向它的位置
+	logRecord, err = db.separateValue(logRecord)
+	if err != nil {
+		return nil, err
+	}
+
 	// 写入数据编码
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	encRecord, size := db.activeFile.EncodeLogRecord(logRecord)
 
 	// 如果写入的数据超过活跃文件的阈值，则关闭活跃文件并打开新的文件
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
-		// 将当前活跃文件持久化
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-
-		// 将当前活跃文件转换为旧的数据文件
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		// 打开新的数据文件
-		if err := db.setActiveFile(); err != nil {
+		if err := db.rotateActiveFile(); err != nil {
 			return nil, err
 		}
 	}
 
+	// 记录写入前的偏移量，即此条记录在文件中的位置
+	writeOff := db.activeFile.WriteOff
 	if err := db.activeFile.Write(encRecord); err != nil {
 		return nil, err
 	}
 	db.bytesWrite += uint(size)
+	db.writeCount.Add(1)
+
+	// 持久化活跃文件，失败时截断刚写入的记录，否则调用方收到错误后重启时这条记录仍然会被加载
+	if err := db.syncIfNeeded(); err != nil {
+		if truncateErr := db.activeFile.Truncate(writeOff); truncateErr != nil {
+			return nil, errors.Join(err, truncateErr)
+		}
+		db.bytesWrite -= uint(size)
+		return nil, err
+	}
+
+	// 构造内存记录并返回
+	return &data.LogRecordPos{
+		Fid:    db.activeFile.FileId,
+		Offset: writeOff,
+		Size:   uint32(size),
+		Expire: logRecord.Expire,
+	}, nil
+}
+
+// 将当前活跃文件持久化并转换为旧的数据文件，然后打开新的活跃文件（访问此方法前必须持有锁）
+func (db *DB) rotateActiveFile() error {
+	// 将当前活跃文件持久化（指针指向的value log数据需要先落盘）
+	if err := db.syncVlog(); err != nil {
+		return err
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+
+	// 将当前活跃文件转换为旧的数据文件
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	db.notifyFileSealed(db.activeFile)
+
+	// 打开新的数据文件
+	if err := db.setActiveFile(); err != nil {
+		return err
+	}
+	if err := db.writeManifest(); err != nil {
+		return err
+	}
+	db.options.Logger.Infof("data file %d is full, rotated to new active file %d", db.activeFile.FileId-1, db.activeFile.FileId)
+	return nil
+}
 
-	// 持久化活跃文件
+// 根据配置决定是否持久化活跃文件（访问此方法前必须持有锁）
+func (db *DB) syncIfNeeded() error {
 	var needSync = db.options.SyncWrites
 	// 如果累计未持久化的数据量大于用户指定的阈值，则进行持久化
 	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
 		needSync = true
 	}
 	if needSync {
+		if err := db.syncVlog(); err != nil {
+			return err
+		}
 		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
+			return err
 		}
 		// 清空未持久化数据量
 		if db.bytesWrite > 0 {
 			db.bytesWrite = 0
 		}
 	}
-
-	// 构造内存记录并返回
-	return &data.LogRecordPos{
-		Fid:    db.activeFile.FileId,
-		Offset: db.activeFile.WriteOff,
-		Size:   uint32(size),
-	}, nil
+	return nil
 }
 
 // 打开新的活跃文件（访问此方法前必须持有锁 ）
@@ -510,43 +1033,154 @@ func (db *DB) setActiveFile() error {
 		initialField = db.activeFile.FileId + 1
 	}
 
+	ioType := fio.StandardFIO
+	if db.options.MMapActiveFile {
+		ioType = fio.WritableMMap
+	}
+	if db.options.IOUringActiveFile {
+		ioType = fio.IOUring
+	}
+	if db.options.InMemory {
+		ioType = fio.MemoryFIO
+	}
+
 	// 打开新的数据文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialField, fio.StandardFIO)
+	dataFile, err := db.openDataFile(initialField, ioType, true)
 	if err != nil {
 		return err
 	}
 
+	// 为标准文件IO和 io_uring 的活跃文件预分配磁盘空间（可写MMap会自行扩展文件，按块校验时文件末尾需要保持为完整的块）
+	if db.options.DataFilePreAllocSize > 0 && (ioType == fio.StandardFIO || ioType == fio.IOUring) && db.options.ChecksumMode == PerRecord {
+		if err := dataFile.Preallocate(db.options.DataFilePreAllocSize); err != nil {
+			return err
+		}
+	}
+
+	// 新建的文件只有在目录持久化之后才能保证崩溃后仍然存在
+	if err := db.syncDir(); err != nil {
+		_ = dataFile.Close()
+		return err
+	}
+
 	db.activeFile = dataFile
 	return nil
 }
 
-// 获取所有key的集合
+// 开启 SyncDirOnRollover 时持久化数据目录
+func (db *DB) syncDir() error {
+	if !db.options.SyncDirOnRollover || db.options.InMemory {
+		return nil
+	}
+	return fio.SyncDir(db.options.DirPath)
+}
+
+// 获取所有key的集合（不包括已过期的key）
 func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	keys := make([][]byte, 0, db.index.Size())
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
 
+// 获取最小的key，只遍历索引，不读取value，数据库中没有key时返回false
+func (db *DB) FirstKey() ([]byte, bool) {
+	return db.boundaryKey(false)
+}
+
+// 获取最大的key，只遍历索引，不读取value，数据库中没有key时返回false
+func (db *DB) LastKey() ([]byte, bool) {
+	return db.boundaryKey(true)
+}
+
+// 正向或反向遍历索引，返回第一个没有过期的key（已删除的key不在索引中）
+func (db *DB) boundaryKey(reverse bool) ([]byte, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	iterator := db.index.Iterator(reverse)
+	defer iterator.Close()
+	now := time.Now().UnixNano()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		// B+树索引的key只在迭代器关闭前有效，拷贝一份返回
+		return append([]byte(nil), iterator.Key()...), true
+	}
+	return nil, false
+}
+
+// 统计key的数量，prefix为空时统计所有key
+// 只遍历索引，不读取数据文件；已过期但还没有写入删除记录的key不计入
+// prefix为空且索引中没有带过期时间的key时直接返回索引记录的数量（B+树索引为bolt bucket统计的key数量），复杂度为O(1)
+func (db *DB) CountKeys(prefix []byte) (int, error) {
+	if db.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if len(prefix) == 0 && db.index.ExpiringSize() == 0 {
+		return db.index.Size(), nil
+	}
+
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	now := time.Now().UnixNano()
+	var count int
+	for iterator.Seek(prefix); iterator.Valid() && bytes.HasPrefix(iterator.Key(), prefix); iterator.Next() {
+		if !iterator.Value().IsExpired(now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
 // 获取所有key value，并执行用户指定的操作，fn函数为用户传递的参数，表示用户指定的key value操作
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
+	return db.fold(false, func(key, value []byte, _ *data.LogRecordPos) bool {
+		return fn(key, value)
+	})
+}
+
+// 按key从大到小的顺序获取所有key value，并执行用户指定的操作，fn返回false时停止遍历
+// 适用于key按时间递增时读取最新的N条数据
+func (db *DB) ReverseFold(fn func(key []byte, value []byte) bool) error {
+	return db.fold(true, func(key, value []byte, _ *data.LogRecordPos) bool {
+		return fn(key, value)
+	})
+}
+
+// 遍历所有没有过期的key，fn同时接收key在索引中的位置
+func (db *DB) fold(reverse bool, fn func(key []byte, value []byte, pos *data.LogRecordPos) bool) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	iterator := db.index.Iterator(false)
+	iterator := db.index.Iterator(reverse)
 	defer iterator.Close()
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		value, err := db.getValueByPosition(iterator.Value())
+		pos := iterator.Value()
+		if pos.IsExpired(now) {
+			continue
+		}
+		value, err := db.getValueByPosition(pos)
 		if err != nil {
 			return err
 		}
 
 		// 传入key value进行用户指定的操作
-		if !fn(iterator.Key(), value) {
+		if !fn(iterator.Key(), value, pos) {
 			// 如果出错，则跳出循环终止操作
 			break
 		}
@@ -555,8 +1189,149 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	return nil
 }
 
+// 遍历所有数据，支持通过ctx取消
+// fn返回ErrStopIteration时正常结束遍历并返回nil，返回其他错误时终止遍历并返回此错误
+func (db *DB) ForEach(ctx context.Context, fn func(key []byte, value []byte) error) error {
+	return db.ForEachWithOptions(ctx, DefaultIteratorOptions, fn)
+}
+
+// 按迭代器配置遍历数据
+// 和Fold不同，遍历过程中每隔ForEachBatchSize个key会释放一次读锁，让并发的写入可以执行，
+// 因此读取到的是每个key在被遍历到时的最新值，遍历开始后被删除的key会被跳过
+// 持有读锁期间会调用fn，fn中不能对数据库进行写入
+func (db *DB) ForEachWithOptions(ctx context.Context, opts IteratorOptions, fn func(key []byte, value []byte) error) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	batchSize := opts.ForEachBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultIteratorOptions.ForEachBatchSize
+	}
+
+	iterator := db.NewIterator(opts)
+	defer iterator.Close()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		// 定期释放读锁，等待中的写入可以先执行
+		// B+树的迭代器持有bolt的读事务，写入时bolt扩展文件需要等待读事务结束，所以不释放读锁
+		if count > 0 && count%batchSize == 0 && db.options.IndexType != BPlusTree {
+			db.mu.RUnlock()
+			db.mu.RLock()
+		}
+		count++
+
+		// 释放过读锁，迭代器中的位置可能已经过期，重新从索引中获取
+		key := iterator.Key()
+		pos := db.index.Get(key)
+		if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+			continue
+		}
+		value, err := db.readValue(pos, iterator.readAhead)
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			return err
+		}
+
+		if err := fn(key, value); err != nil {
+			if errors.Is(err, ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// 按写入顺序遍历所有有效数据，对每个key只返回一次最新的值
+// 从最小的文件id开始顺序读取数据文件，只有索引中仍指向此位置的记录才会返回，因此顺序为key最后一次写入的顺序
+// 函数返回false时终止遍历
+func (db *DB) FoldByInsertOrder(fn func(key []byte, value []byte) bool) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.fileMu.RLock()
+	defer db.fileMu.RUnlock()
+
+	if db.activeFile == nil {
+		return nil
+	}
+
+	// 将所有数据文件按文件id从小到大排序
+	dataFiles := make([]*data.DataFile, 0, len(db.olderFiles)+1)
+	for _, dataFile := range db.olderFiles {
+		dataFiles = append(dataFiles, dataFile)
+	}
+	sort.Slice(dataFiles, func(i, j int) bool {
+		return dataFiles[i].FileId < dataFiles[j].FileId
+	})
+	dataFiles = append(dataFiles, db.activeFile)
+
+	now := time.Now().UnixNano()
+	for _, dataFile := range dataFiles {
+		var offset int64 = 0
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			recordOffset := offset
+			offset += size
+
+			// 删除记录和事务完成的标识不是有效数据
+			if logRecord.Type != data.LogRecordNormal && logRecord.Type != data.LogRecordValuePointer {
+				continue
+			}
+
+			// 索引中的位置和当前记录一致，说明是此key最新的记录
+			realKey, _ := parseLogRecordKey(logRecord.Key)
+			pos := db.index.Get(realKey)
+			if pos == nil || pos.Fid != dataFile.FileId || pos.Offset != recordOffset || pos.IsExpired(now) {
+				continue
+			}
+
+			var value []byte
+			if logRecord.Type == data.LogRecordValuePointer {
+				value, err = db.readValueLog(logRecord.Value, false)
+			} else {
+				value, err = db.decodeValue(logRecord)
+			}
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				value = []byte{}
+			}
+
+			if !fn(realKey, value) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
 // 根据key读取数据
 func (db *DB) Get(key []byte) ([]byte, error) {
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
 	// 读取时加读写锁
 	db.mu.RLock()
 	defer db.mu.RUnlock()
@@ -566,56 +1341,190 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 		return nil, ErrKeyIsEmpty
 	}
 
-	// 从内存索引中获取对应的位置信息
+	// 从内存索引中获取对应的位置信息，已过期的key视为不存在
 	logRecordPos := db.index.Get(key)
-	if logRecordPos == nil {
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
 		return nil, ErrKeyNotFound
 	}
 
 	// 从数据文件中获取value
-	return db.getValueByPosition(logRecordPos)
+	value, err := db.getValueByPosition(logRecordPos)
+	if err == nil && db.options.EnableLRUTracking {
+		db.recordAccess(logRecordPos)
+	}
+	return value, err
+}
+
+// 读取key对应的value，同时返回索引中记录的位置（数据文件id和偏移），用于排查merge、复制等问题
+// 开启键值分离时返回的是数据文件中指针记录的位置
+func (db *DB) GetWithMetadata(key []byte) (value []byte, fid uint32, offset int64, err error) {
+	if db.closed.Load() {
+		return nil, 0, 0, ErrDatabaseClosed
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if len(key) == 0 {
+		return nil, 0, 0, ErrKeyIsEmpty
+	}
+
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return nil, 0, 0, ErrKeyNotFound
+	}
+
+	value, err = db.getValueByPosition(logRecordPos)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return value, logRecordPos.Fid, logRecordPos.Offset, nil
+}
+
+// 获取key对应的记录在数据文件中占用的大小，只查询索引，不读取数据文件
+// 开启键值分离时返回的是数据文件中指针记录的大小，不包括value log中的value
+func (db *DB) SizeOf(key []byte) (int64, error) {
+	if db.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+		return 0, ErrKeyNotFound
+	}
+	return int64(pos.Size), nil
 }
 
 // 根据索引信息获取对应的value（使用此方法前加锁）
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	return db.readValue(logRecordPos, nil)
+}
+
+// 根据索引信息获取对应的value，ra 不为nil时检测顺序读取并预读（使用此方法前加锁）
+func (db *DB) readValue(logRecordPos *data.LogRecordPos, ra *readAhead) ([]byte, error) {
+	// 开启分段锁时写入只持有mu的读锁，读取文件时需要和追加写入互斥
+	db.fileMu.RLock()
+	defer db.fileMu.RUnlock()
+
 	// 根据文件id找到对应的数据文件
 	var dataFile *data.DataFile // 要访问的目标数据文件
 	if db.activeFile.FileId == logRecordPos.Fid {
 		dataFile = db.activeFile
 	} else {
 		dataFile = db.olderFiles[logRecordPos.Fid]
-		return nil, ErrDataFileNotFound
 	}
 
 	// 如果目标数据文件为空
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
 	}
+	if ra != nil {
+		ra.observe(dataFile, logRecordPos.Offset)
+	}
 
-	// 去目标文件读取数据
+	// 去目标文件读取数据，配置了 SkipReadCRC 时跳过crc校验
 	// 由于内存索引保存的一定是此key对应的最新日志文件的offset，所以读取到的一定是最新的记录
-	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+	var logRecord *data.LogRecord
+	var err error
+	if db.options.SkipReadCRC {
+		logRecord, _, err = dataFile.ReadLogRecordSkipCRC(logRecordPos.Offset)
+	} else {
+		logRecord, _, err = dataFile.ReadLogRecord(logRecordPos.Offset)
+	}
 	if err != nil {
 		return nil, err
 	}
+	db.readCount.Add(1)
 
 	// 判断是否为删除记录
 	if logRecord.Type == data.LogRecordDeleted {
 		return nil, ErrKeyNotFound
 	}
+	// value存储在value log中，根据指针去读取；带有压缩或加密标记的value解码之后返回
+	if logRecord.Type == data.LogRecordValuePointer {
+		value, err := db.readValueLog(logRecord.Value, db.options.SkipReadCRC)
+		if err != nil {
+			return nil, err
+		}
+		logRecord.Value = value
+	} else if logRecord.Value, err = db.decodeValue(logRecord); err != nil {
+		return nil, err
+	}
+
+	// 空value也是合法的数据，统一返回非nil的空切片，和key不存在区分开
+	if logRecord.Value == nil {
+		return []byte{}, nil
+	}
 	return logRecord.Value, nil
 }
 
+// 判断key是否存在（value为空也视为存在，只有被删除或已过期的记录才视为不存在）
+func (db *DB) Exists(key []byte) (bool, error) {
+	if db.closed.Load() {
+		return false, ErrDatabaseClosed
+	}
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 内存索引中只保存未被删除的key
+	pos := db.index.Get(key)
+	return pos != nil && !pos.IsExpired(time.Now().UnixNano()), nil
+}
+
 // 根据key删除对应的数据
 func (db *DB) Delete(key []byte) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
 	// 判断key的有效性
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := db.beforeWrite(key, nil, true); err != nil {
+		return err
+	}
+
+	// 写入限速，在获取锁之前等待
+	if err := db.writeLimiter.wait(len(key)); err != nil {
+		return err
+	}
+
+	var deleted bool
+	var err error
+	if db.stripes != nil {
+		// 开启了分段锁时，只锁住key所在的分段
+		deleted, err = db.deleteStriped(key)
+	} else {
+		db.mu.Lock()
+		deleted, err = db.deleteLocked(key)
+		db.mu.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+	if deleted {
+		db.hookDelete(key)
+	}
+	return nil
+}
 
+// 写入删除记录并从内存索引中删除key，返回key是否存在（调用方需持有写锁）
+func (db *DB) deleteLocked(key []byte) (bool, error) {
 	// 检查key是否存在
 	if pos := db.index.Get(key); pos == nil {
-		return nil
+		return false, nil
 	}
 
 	// 构造文件记录，标记为已删除
@@ -625,21 +1534,142 @@ func (db *DB) Delete(key []byte) error {
 	}
 
 	// 写入到当前文件当中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
-		return nil
+		return false, err
 	}
 	db.reclaimSize += int64(pos.Size)
 
 	// 从内存索引中将对应的key删除
 	oldPos, ok := db.index.Delete(key)
 	if !ok {
-		return ErrIndexUpdateFailed
+		return false, ErrIndexUpdateFailed
 	}
 	if oldPos != nil {
 		db.reclaimSize += int64(oldPos.Size)
 	}
-	return nil
+	seqNo := db.addNonTxnVersion(key, oldPos, nil)
+	db.notifyCommit(key, nil, KeyEventDelete, pos)
+	db.auditWrite(AuditDelete, key, seqNo)
+	return true, nil
+}
+
+// 原子地读取、修改并写回key对应的value，整个过程持有写锁，不会被其他写入插入
+// fn的参数为当前的value（key不存在时为nil），返回写入的新value；fn返回错误时不写入并返回此错误，返回的value为nil时删除key
+// 写入新value时清除key的过期时间
+// fn在持有写锁时调用，不能在fn中读写数据库；写入的数据量只计入写入速度统计，不等待写入限速
+func (db *DB) Update(key []byte, fn func(oldValue []byte) (newValue []byte, err error)) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 读取当前的value，已过期的key视为不存在
+	var oldValue []byte
+	if pos := db.index.Get(key); pos != nil && !pos.IsExpired(time.Now().UnixNano()) {
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return err
+		}
+		oldValue = value
+	}
+
+	newValue, err := fn(oldValue)
+	if err != nil {
+		return err
+	}
+	if err := db.beforeWrite(key, newValue, newValue == nil); err != nil {
+		return err
+	}
+
+	if newValue == nil {
+		db.recordLockedWrite(len(key))
+		_, err := db.deleteLocked(key)
+		return err
+	}
+	if err := db.checkDiskQuotaLocked(quotaRecordSize(key, newValue)); err != nil {
+		return err
+	}
+	db.recordLockedWrite(len(key) + len(newValue))
+	_, err = db.putLocked(key, &data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value: newValue,
+		Type:  data.LogRecordNormal,
+	})
+	return err
+}
+
+// 在key对应的value末尾追加suffix，返回追加后value的长度，key不存在（或已过期）时视为空value
+// 日志结构的存储无法原地修改记录，仍然会写入包含完整value的新记录，只是省去了调用方先读取再写入的往返，并且读取和写入之间不会被其他写入插入
+// 和 Update 一样，写入新value时清除key的过期时间
+func (db *DB) AppendValue(key, suffix []byte) (int, error) {
+	var length int
+	err := db.Update(key, func(oldValue []byte) ([]byte, error) {
+		newValue := make([]byte, 0, len(oldValue)+len(suffix))
+		newValue = append(append(newValue, oldValue...), suffix...)
+		length = len(newValue)
+		return newValue, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// 只有key不存在时才写入（Redis的SETNX），检查和写入在同一次持有写锁的过程中完成，不会被其他写入插入
+// 写入了value返回true；key已存在时不修改并返回false，已过期的key视为不存在
+// 写入限速在检查之前等待，key已存在没有写入时同样计入
+func (db *DB) PutIfAbsent(key, value []byte) (bool, error) {
+	if db.closed.Load() {
+		return false, ErrDatabaseClosed
+	}
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return false, ErrReadOnly
+	}
+
+	// 写入限速，在获取锁之前等待
+	if err := db.writeLimiter.wait(len(key) + len(value)); err != nil {
+		return false, err
+	}
+
+	db.mu.Lock()
+	if pos := db.index.Get(key); pos != nil && !pos.IsExpired(time.Now().UnixNano()) {
+		db.mu.Unlock()
+		return false, nil
+	}
+	if err := db.beforeWrite(key, value, false); err != nil {
+		db.mu.Unlock()
+		return false, err
+	}
+	if err := db.checkDiskQuotaLocked(quotaRecordSize(key, value)); err != nil {
+		db.mu.Unlock()
+		return false, err
+	}
+	pos, err := db.putLocked(key, &data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value: value,
+		Type:  data.LogRecordNormal,
+	})
+	db.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if db.options.EnableLRUTracking {
+		db.recordAccess(pos)
+	}
+	db.hookPut(key, value, pos)
+	return true, nil
 }
 
 // 从指定文件中加载最新事务序列号（B+树索引专属），获取成功后立即删除文件
@@ -677,14 +1707,56 @@ func (db *DB) resetIoType() error {
 		return nil
 	}
 
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+	if err := db.activeFile.SetIOManager(fio.StandardFIO); err != nil {
+		return err
+	}
+	if err := db.wrapDataFileIO(db.activeFile, true); err != nil {
 		return err
 	}
 	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+		if err := dataFile.SetIOManager(fio.StandardFIO); err != nil {
+			return err
+		}
+		if err := db.wrapDataFileIO(dataFile, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 打开数据文件，并按配置包装文件IO，active 表示是否为活跃文件
+func (db *DB) openDataFile(fileId uint32, ioType fio.FileIOType, active bool) (*data.DataFile, error) {
+	dataFile, err := data.OpenDataFileWithName(db.getDataFileName(db.options.DirPath, fileId), fileId, ioType)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.wrapDataFileIO(dataFile, active); err != nil {
+		return nil, err
+	}
+	return dataFile, nil
+}
+
+// 按配置包装数据文件的IO：设置crc算法，按块校验时先切换为按块读写，配置了块缓存时再使用块缓存
+func (db *DB) wrapDataFileIO(dataFile *data.DataFile, active bool) error {
+	dataFile.SetChecksumAlgorithm(db.options.ChecksumAlgorithm)
+	if db.options.ChecksumMode == PerBlock {
+		if err := dataFile.UseBlockChecksum(active); err != nil {
 			return err
 		}
 	}
+	return db.useBlockCache(dataFile)
+}
+
+// 使用块缓存包装数据文件的IO
+func (db *DB) useBlockCache(dataFile *data.DataFile) error {
+	if db.blockCache == nil {
+		return nil
+	}
+	cachedIO, err := fio.NewCachedIOManager(dataFile.IOManager, db.blockCache, dataFile.FileId)
+	if err != nil {
+		return err
+	}
+	dataFile.IOManager = cachedIO
 	return nil
 }
 
@@ -693,27 +1765,212 @@ func (db *DB) Stat() *Stat {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	// 只在读取数据文件列表、大小和可回收的数据量时持有 fileMu，遍历索引和统计目录大小期间分段锁模式下的写入不会被阻塞
+	db.fileMu.RLock()
 	var dataFiles = uint(len(db.olderFiles))
 	if db.activeFile != nil {
 		dataFiles += 1
 	}
+	reclaimSize := db.reclaimSize
+	usage, err := db.dataFileSizes()
+	db.fileMu.RUnlock()
 
-	dirSize, err := utils.DirSize(db.options.DirPath)
+	// 内存模式没有数据目录，占用的磁盘空间为0
+	var dirSize int64
+	if !db.options.InMemory {
+		var err error
+		if dirSize, err = utils.DirSize(db.options.DirPath); err != nil {
+			panic(fmt.Sprintf("failed to get dir size : %v", err))
+		}
+	}
+	// 统计失败时不影响其他指标，FileUsage 留空
 	if err != nil {
-		panic(fmt.Sprintf("failed to get dir size : %v", err))
+		db.options.Logger.Errorf("failed to get file usage: %v", err)
+		usage = nil
+	} else {
+		db.addValidBytes(usage)
 	}
-	return &Stat{
+	keySizes, valueSizes := db.sizeHistograms()
+	stat := &Stat{
 		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimSize,
+		ReclaimableSize: reclaimSize,
 		DiskSize:        dirSize,
+
+		CurrentWriteRateMBs: db.writeLimiter.rate(),
+
+		ReadCount:  db.readCount.Load(),
+		WriteCount: db.writeCount.Load(),
+
+		MergedFormat: db.mergedFormat,
+
+		FileUsage: sortedFileUsage(usage),
+
+		KeySizeHistogram:   keySizes,
+		ValueSizeHistogram: valueSizes,
+	}
+	if db.blockCache != nil {
+		stat.CacheHits = db.blockCache.Hits()
+		stat.CacheMisses = db.blockCache.Misses()
+		stat.CacheHitRatio = db.blockCache.HitRatio()
 	}
+	return stat
 }
 
 // 数据库备份
 func (db *DB) Backup(dir string) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if db.options.InMemory {
+		return ErrInMemoryUnsupported
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	db.fileMu.RLock()
+	defer db.fileMu.RUnlock()
 	// 复制目录到目标路径，并排除文件锁的文件
 	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
 }
+
+// 备份数据库到destDir并打开备份，返回的实例和当前实例没有共享的内存状态，之后的写入互不影响
+// 除了 DirPath 之外使用和当前实例相同的配置项，备份不启动管理接口（AdminAddr 为空）
+func (db *DB) Clone(destDir string) (*DB, error) {
+	if err := db.Backup(destDir); err != nil {
+		return nil, err
+	}
+	options := db.options
+	options.DirPath = destDir
+	options.AdminAddr = ""
+	return Open(options)
+}
+
+// 将oldKey重命名为newKey：读取oldKey的值写入newKey并删除oldKey，在一个事务中提交，整个过程持有写锁
+// oldKey不存在时返回 ErrKeyNotFound，newKey已存在时会被覆盖（与Redis的RENAME一致）
+func (db *DB) Rename(oldKey, newKey []byte) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if len(oldKey) == 0 || len(newKey) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	pos := db.index.Get(oldKey)
+	if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+		return ErrKeyNotFound
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return nil
+	}
+	value, err := db.getValueByPosition(pos)
+	if err != nil {
+		return err
+	}
+
+	if err := wb.Put(newKey, value); err != nil {
+		return err
+	}
+	// 新的key保留原来的过期时间
+	wb.pendingWrites[string(newKey)].Expire = pos.Expire
+	if err := wb.Delete(oldKey); err != nil {
+		return err
+	}
+	// 和 Commit 一样经过 BeforeWrite 校验和磁盘限额检查，持有写锁时不会尝试 Compact
+	size, quotaSize, err := wb.validate()
+	if err != nil {
+		return err
+	}
+	if err := db.checkDiskQuotaLocked(quotaSize); err != nil {
+		return err
+	}
+	db.recordLockedWrite(size)
+	return wb.commit()
+}
+
+// 将oldPrefix下的所有key改为以newPrefix开头：写入新key（保留原来的过期时间）并删除旧key，返回移动的key数量
+// 整个过程持有写锁，其他读写不会看到移动到一半的状态；已过期的key不移动，新key已存在时会被覆盖
+// 每个批次最多包含 DefaultWriteBatchOptions.MaxBatchNum 条记录，key较多时分成多个批次依次提交：
+// 每个批次单独保证原子性，进程在两个批次之间崩溃时，重启后只有已提交批次中的key被移动
+// 出错时返回已经提交的批次中移动的key数量
+func (db *DB) RenamePrefix(oldPrefix, newPrefix []byte) (int, error) {
+	if db.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+	if db.options.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 先收集所有要移动的key，再开始写入，前缀互相重叠时不会重复移动新写入的key
+	type renamed struct {
+		oldKey, newKey []byte
+		pos            *data.LogRecordPos
+	}
+	var keys []renamed
+	newKeys := make(map[string]struct{})
+	now := time.Now().UnixNano()
+	iterator := db.index.Iterator(false)
+	for iterator.Seek(oldPrefix); iterator.Valid() && bytes.HasPrefix(iterator.Key(), oldPrefix); iterator.Next() {
+		pos := iterator.Value()
+		if pos.IsExpired(now) {
+			continue
+		}
+		oldKey := append([]byte(nil), iterator.Key()...)
+		newKey := append(append([]byte(nil), newPrefix...), oldKey[len(oldPrefix):]...)
+		if len(newKey) == 0 {
+			iterator.Close()
+			return 0, ErrKeyIsEmpty
+		}
+		keys = append(keys, renamed{oldKey: oldKey, newKey: newKey, pos: pos})
+		newKeys[string(newKey)] = struct{}{}
+	}
+	iterator.Close()
+	if bytes.Equal(oldPrefix, newPrefix) {
+		return len(keys), nil
+	}
+
+	// 每个key最多写入两条记录
+	chunkSize := max(int(DefaultWriteBatchOptions.MaxBatchNum/2), 1)
+	for start := 0; start < len(keys); start += chunkSize {
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		for _, k := range keys[start:min(start+chunkSize, len(keys))] {
+			value, err := db.getValueByPosition(k.pos)
+			if err != nil {
+				return start, err
+			}
+			wb.pendingWrites[string(k.newKey)] = &data.LogRecord{
+				Key:    k.newKey,
+				Value:  value,
+				Type:   data.LogRecordNormal,
+				Expire: k.pos.Expire,
+			}
+			// 旧key同时是其他key移动后的新key时不删除
+			if _, ok := newKeys[string(k.oldKey)]; !ok {
+				wb.pendingWrites[string(k.oldKey)] = &data.LogRecord{Key: k.oldKey, Type: data.LogRecordDeleted}
+			}
+		}
+		// 每个批次和 Commit 一样经过 BeforeWrite 校验和磁盘限额检查
+		size, quotaSize, err := wb.validate()
+		if err != nil {
+			return start, err
+		}
+		if err := db.checkDiskQuotaLocked(quotaSize); err != nil {
+			return start, err
+		}
+		db.recordLockedWrite(size)
+		if err := wb.commit(); err != nil {
+			return start, err
+		}
+	}
+	return len(keys), nil
+}
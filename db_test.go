@@ -0,0 +1,1593 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+)
+
+// 在临时目录中打开数据库，测试结束时自动关闭
+func openTestDB(t *testing.T, configure func(options *Options)) *DB {
+	t.Helper()
+	options := DefaultOptions
+	options.DirPath = t.TempDir()
+	if configure != nil {
+		configure(&options)
+	}
+	db, err := Open(options)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+// 关闭数据库后使用相同的配置重新打开
+func reopenTestDB(t *testing.T, db *DB) *DB {
+	t.Helper()
+	if err := db.Close(); err != nil {
+		t.Fatalf("close db: %v", err)
+	}
+	newDB, err := Open(db.options)
+	if err != nil {
+		t.Fatalf("reopen db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = newDB.Close()
+	})
+	return newDB
+}
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key-%05d", i))
+}
+
+func testValue(i int) []byte {
+	return []byte(fmt.Sprintf("value-%05d", i))
+}
+
+func TestDB_EmptyValue(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("empty"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("zero"), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 空value视为存在，Get返回非nil的空切片
+	check := func(db *DB) {
+		for _, key := range []string{"empty", "zero"} {
+			exists, err := db.Exists([]byte(key))
+			if err != nil || !exists {
+				t.Fatalf("Exists(%s) = %v, %v", key, exists, err)
+			}
+			value, err := db.Get([]byte(key))
+			if err != nil {
+				t.Fatalf("Get(%s): %v", key, err)
+			}
+			if value == nil || len(value) != 0 {
+				t.Fatalf("Get(%s) = %#v, want non-nil empty slice", key, value)
+			}
+		}
+
+		iterator := db.NewIterator(DefaultIteratorOptions)
+		defer iterator.Close()
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			value, err := iterator.Value()
+			if err != nil || value == nil || len(value) != 0 {
+				t.Fatalf("iterator value of %s = %#v, %v", iterator.Key(), value, err)
+			}
+		}
+
+		var count int
+		err := db.Fold(func(key []byte, value []byte) bool {
+			if value == nil || len(value) != 0 {
+				t.Fatalf("fold value of %s = %#v", key, value)
+			}
+			count++
+			return true
+		})
+		if err != nil || count != 2 {
+			t.Fatalf("Fold = %v, visited %d keys", err, count)
+		}
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+
+	// 只有删除后才视为不存在
+	if err := db.Delete([]byte("empty")); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := db.Exists([]byte("empty")); err != nil || exists {
+		t.Fatalf("Exists after delete = %v, %v", exists, err)
+	}
+	if _, err := db.Get([]byte("empty")); err != ErrKeyNotFound {
+		t.Fatalf("Get after delete: %v", err)
+	}
+}
+
+func TestOpen_RejectsBPlusTreeWithMMapActiveFile(t *testing.T) {
+	options := DefaultOptions
+	options.DirPath = t.TempDir()
+	options.IndexType = BPlusTree
+	options.MMapActiveFile = true
+	if db, err := Open(options); err == nil {
+		_ = db.Close()
+		t.Fatal("open with b+ tree index and mmap active file succeeded")
+	}
+}
+
+func TestOpen_RejectsMMapWithIOUringActiveFile(t *testing.T) {
+	options := DefaultOptions
+	options.DirPath = t.TempDir()
+	options.MMapActiveFile = true
+	options.IOUringActiveFile = true
+	if db, err := Open(options); err == nil {
+		_ = db.Close()
+		t.Fatal("open with both mmap and io_uring active file succeeded")
+	}
+}
+
+// 按块校验时，活跃文件末尾不完整的记录在重新打开时被丢弃，之后的写入从最后一条有效记录之后开始
+func TestDB_PerBlockTornRecord(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.ChecksumMode = PerBlock
+	})
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 在活跃文件末尾追加半条记录
+	fileName := data.GetDataFileName(db.options.DirPath, db.activeFile.FileId)
+	inner, err := fio.NewFileIOManager(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bio, err := fio.NewBlockChecksumIO(inner, fileName, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _ := data.EncodeLogRecordWithMode(&data.LogRecord{Key: []byte("torn"), Value: testValue(0)}, data.PerBlock, data.CRC32IEEE)
+	if _, err := bio.Write(record[:len(record)/2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bio.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(db.options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if _, err := db.Get([]byte("torn")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := db.Put(testKey(1000), testValue(1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	db = reopenTestDB(t, db)
+	for i := 0; i <= 1000; i++ {
+		value, err := db.Get(testKey(i))
+		if err != nil || !bytes.Equal(value, testValue(i)) {
+			t.Fatalf("Get(%s) = %s, %v", testKey(i), value, err)
+		}
+	}
+}
+
+// 上次打开时预分配的空间没有在关闭时释放（进程异常退出），重新打开之后关闭时释放
+func TestDB_ReopenTrimsPreallocatedSpace(t *testing.T) {
+	db := openTestDB(t, nil)
+	for i := 0; i < 100; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := dumpDB(t, db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fileName := data.GetDataFileName(db.options.DirPath, db.activeFile.FileId)
+	if err := fio.Preallocate(fileName, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if preallocated, err := fio.HasPreallocatedSpace(fileName); err != nil || !preallocated {
+		t.Skipf("preallocation unsupported: %v", err)
+	}
+
+	db = reopenTestDB(t, db)
+	expectContent(t, db, expected)
+	if err := db.Put([]byte("after-reopen"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	expected["after-reopen"] = "v"
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if preallocated, err := fio.HasPreallocatedSpace(fileName); err != nil || preallocated {
+		t.Fatalf("expected preallocated space released, got %v, %v", preallocated, err)
+	}
+	db = reopenTestDB(t, db)
+	expectContent(t, db, expected)
+}
+
+// 只读模式打开按块校验的数据库时不修改数据文件
+func TestDB_PerBlockReadOnlyDoesNotModifyFiles(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.ChecksumMode = PerBlock
+	})
+	for i := 0; i < 100; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := data.GetDataFileName(db.options.DirPath, db.activeFile.FileId)
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 末尾附加半个块，模拟没有写完的块
+	content = append(content, make([]byte, fio.ChecksumBlockSize/2)...)
+	if err := os.WriteFile(fileName, content, fio.DataFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	options := db.options
+	options.ReadOnly = true
+	readOnlyDB, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		value, err := readOnlyDB.Get(testKey(i))
+		if err != nil || !bytes.Equal(value, testValue(i)) {
+			t.Fatalf("Get(%s) = %s, %v", testKey(i), value, err)
+		}
+	}
+	if err := readOnlyDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(current, content) {
+		t.Fatal("data file modified in read only mode")
+	}
+}
+
+// 切换crc算法之后，两种算法写入的数据文件和value log可以在同一个目录中共存
+func TestDB_MixedChecksumAlgorithms(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+		options.ValueLogSeparationThreshold = 64
+	})
+	largeValue := bytes.Repeat([]byte("v"), 128)
+	put := func(db *DB, from, to int) {
+		for i := from; i < to; i++ {
+			value := testValue(i)
+			if i%10 == 0 {
+				value = append(value, largeValue...)
+			}
+			if err := db.Put(testKey(i), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	verify := func(db *DB, count int) {
+		for i := 0; i < count; i++ {
+			expected := testValue(i)
+			if i%10 == 0 {
+				expected = append(expected, largeValue...)
+			}
+			if value, err := db.Get(testKey(i)); err != nil || !bytes.Equal(value, expected) {
+				t.Fatalf("Get(%s) = %s, %v", testKey(i), value, err)
+			}
+		}
+	}
+
+	put(db, 0, 200)
+	db.options.ChecksumAlgorithm = CRC32C
+	db = reopenTestDB(t, db)
+	verify(db, 200)
+	put(db, 200, 400)
+
+	// 再切换回IEEE，从数据文件重建索引时两种记录都能通过校验
+	db.options.ChecksumAlgorithm = CRC32IEEE
+	db = reopenTestDB(t, db)
+	verify(db, 400)
+	put(db, 100, 300)
+
+	db.options.ChecksumAlgorithm = CRC32C
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	verify(db, 400)
+}
+
+func TestOpen_RejectsInvalidChecksumAlgorithm(t *testing.T) {
+	options := DefaultOptions
+	options.DirPath = t.TempDir()
+	options.ChecksumAlgorithm = 2
+	if _, err := Open(options); err == nil {
+		t.Fatal("expected error for invalid checksum algorithm")
+	}
+}
+
+func TestOpen_LockTimeout(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 锁一直被占用，超时之后返回错误
+	options := db.options
+	options.LockTimeout = 100 * time.Millisecond
+	start := time.Now()
+	if _, err := Open(options); err != ErrDatabaseIsUsing {
+		t.Fatalf("expected ErrDatabaseIsUsing, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < options.LockTimeout {
+		t.Fatalf("expected to wait %v, returned after %v", options.LockTimeout, elapsed)
+	}
+
+	// 等待期间锁被释放，打开成功
+	options.LockTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = db.Close()
+	}()
+	start = time.Now()
+	newDB, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newDB.Close()
+	if elapsed := time.Since(start); elapsed >= options.LockTimeout {
+		t.Fatalf("expected to open within %v, took %v", options.LockTimeout, elapsed)
+	}
+	if value, err := newDB.Get([]byte("k")); err != nil || string(value) != "v" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	options.LockTimeout = -time.Second
+	if _, err := Open(options); err == nil {
+		t.Fatal("expected error for invalid lock timeout")
+	}
+}
+
+func TestDB_CloseTwice(t *testing.T) {
+	for _, write := range []bool{false, true} {
+		db := openTestDB(t, nil)
+		if write {
+			if err := db.Put([]byte("k"), []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("expected second Close to return nil, got %v", err)
+		}
+
+		// 文件锁已经释放，可以再次打开
+		reopened, err := Open(db.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reopened.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_OperationsAfterClose(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	if err := wb.Put([]byte("batch"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, op := range map[string]func() error{
+		"Put":        func() error { return db.Put([]byte("k"), []byte("v")) },
+		"PutWithTTL": func() error { return db.PutWithTTL([]byte("k"), []byte("v"), time.Hour) },
+		"Get": func() error {
+			_, err := db.Get([]byte("k"))
+			return err
+		},
+		"GetEx": func() error {
+			_, _, err := db.GetEx([]byte("k"))
+			return err
+		},
+		"Exists": func() error {
+			_, err := db.Exists([]byte("k"))
+			return err
+		},
+		"Delete": func() error { return db.Delete([]byte("k")) },
+		"Update": func() error {
+			return db.Update([]byte("k"), func(oldValue []byte) ([]byte, error) { return oldValue, nil })
+		},
+		"Rename": func() error { return db.Rename([]byte("k"), []byte("other")) },
+		"PutIfAbsent": func() error {
+			_, err := db.PutIfAbsent([]byte("k"), []byte("v"))
+			return err
+		},
+		"PutBatch": func() error { return db.PutBatch([]KV{{Key: []byte("k"), Value: []byte("v")}}) },
+		"RenamePrefix": func() error {
+			_, err := db.RenamePrefix([]byte("k"), []byte("other"))
+			return err
+		},
+		"Sync":   func() error { return db.Sync() },
+		"Fold":   func() error { return db.Fold(func(key []byte, value []byte) bool { return true }) },
+		"Commit": func() error { return wb.Commit() },
+		"Merge":  func() error { return db.MergeForce() },
+		"Vacuum": func() error { return db.Vacuum() },
+		"Backup": func() error { return db.Backup(t.TempDir()) },
+		"Check": func() error {
+			_, err := db.Check()
+			return err
+		},
+	} {
+		if err := op(); err != ErrDatabaseClosed {
+			t.Fatalf("%s: expected ErrDatabaseClosed, got %v", name, err)
+		}
+	}
+}
+
+func TestDB_FoldByInsertOrder(t *testing.T) {
+	// 数据文件较小，写入会跨越多个文件
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+	})
+
+	// 按与字节序不同的顺序写入
+	var written []string
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("%05d", (i*7919)%500)
+		if err := db.Put([]byte(key), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, key)
+	}
+	if len(db.olderFiles) == 0 {
+		t.Fatal("expected multiple data files")
+	}
+
+	// 覆盖写入的key以最后一次写入的位置为准，删除的key不返回
+	if err := db.Put([]byte(written[0]), []byte("overwritten")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete([]byte(written[1])); err != nil {
+		t.Fatal(err)
+	}
+	expected := append(append([]string(nil), written[2:]...), written[0])
+
+	check := func(db *DB) {
+		var got []string
+		err := db.FoldByInsertOrder(func(key []byte, value []byte) bool {
+			got = append(got, string(key))
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("expected %d keys, got %d", len(expected), len(got))
+		}
+		for i := range expected {
+			if got[i] != expected[i] {
+				t.Fatalf("key %d: expected %s, got %s", i, expected[i], got[i])
+			}
+		}
+	}
+	check(db)
+	check(reopenTestDB(t, db))
+}
+
+func TestDB_FoldByInsertOrderStop(t *testing.T) {
+	db := openTestDB(t, nil)
+	for i := 0; i < 10; i++ {
+		if err := db.Put(testKey(9-i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got [][]byte
+	err := db.FoldByInsertOrder(func(key []byte, value []byte) bool {
+		got = append(got, key)
+		return len(got) < 3
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || !bytes.Equal(got[0], testKey(9)) || !bytes.Equal(got[2], testKey(7)) {
+		t.Fatalf("unexpected keys: %q", got)
+	}
+}
+
+func TestDB_ReverseFold(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+		})
+		for i := 0; i < 50; i++ {
+			if err := db.Put(testKey((i*7)%50), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.Delete(testKey(25)); err != nil {
+			t.Fatal(err)
+		}
+
+		collect := func(fold func(fn func(key []byte, value []byte) bool) error) (keys, values []string) {
+			err := fold(func(key []byte, value []byte) bool {
+				keys = append(keys, string(key))
+				values = append(values, string(value))
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return keys, values
+		}
+		forwardKeys, forwardValues := collect(db.Fold)
+		reverseKeys, reverseValues := collect(db.ReverseFold)
+
+		if len(forwardKeys) != 49 || len(reverseKeys) != len(forwardKeys) {
+			t.Fatalf("index %d: forward %d keys, reverse %d keys", indexType, len(forwardKeys), len(reverseKeys))
+		}
+		for i := range forwardKeys {
+			j := len(forwardKeys) - 1 - i
+			if forwardKeys[i] != reverseKeys[j] || forwardValues[i] != reverseValues[j] {
+				t.Fatalf("index %d: forward[%d] = %s, reverse[%d] = %s", indexType, i, forwardKeys[i], j, reverseKeys[j])
+			}
+			if i > 0 && reverseKeys[i-1] <= reverseKeys[i] {
+				t.Fatalf("index %d: reverse keys not descending: %s, %s", indexType, reverseKeys[i-1], reverseKeys[i])
+			}
+		}
+
+		// fn返回false时停止，只读取最大的几个key
+		var latest [][]byte
+		err := db.ReverseFold(func(key []byte, value []byte) bool {
+			latest = append(latest, key)
+			return len(latest) < 3
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(latest) != 3 || !bytes.Equal(latest[0], testKey(49)) || !bytes.Equal(latest[2], testKey(47)) {
+			t.Fatalf("index %d: unexpected keys: %q", indexType, latest)
+		}
+	}
+}
+
+// 在数据库打开时修改数据文件中value的第一个字节
+func corruptValue(t *testing.T, db *DB, value []byte) {
+	t.Helper()
+	fileName := data.GetDataFileName(db.options.DirPath, db.activeFile.FileId)
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := bytes.Index(content, value)
+	if index < 0 {
+		t.Fatalf("value %q not found", value)
+	}
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt([]byte{value[0] ^ 0xff}, int64(index)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_SkipReadCRC(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		db := openTestDB(t, func(options *Options) {
+			options.SkipReadCRC = skip
+		})
+		for i := 0; i < 10; i++ {
+			if err := db.Put(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		corruptValue(t, db, testValue(3))
+
+		value, err := db.Get(testKey(3))
+		if !skip {
+			// 关闭时损坏的记录可以被发现
+			var corruptionErr *data.CorruptionError
+			if !errors.As(err, &corruptionErr) || corruptionErr.Offset != db.index.Get(testKey(3)).Offset {
+				t.Fatalf("expected CorruptionError, got %q, %v", value, err)
+			}
+		} else if err != nil || bytes.Equal(value, testValue(3)) {
+			t.Fatalf("expected the corrupted value without crc check, got %q, %v", value, err)
+		}
+
+		// merge 始终校验crc，不会把损坏的数据重写为有效的记录
+		if err := db.MergeForce(); !errors.Is(err, data.ErrInvalidCRC) {
+			t.Fatalf("skip=%v: expected merge to fail with ErrInvalidCRC, got %v", skip, err)
+		}
+	}
+}
+
+func TestMigrateChecksumMode_VerifiesCRCWithSkipReadCRC(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.SkipReadCRC = true
+	})
+	for i := 0; i < 10; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 合并一次，使重新打开时从hint文件加载索引，不扫描损坏的数据文件
+	db = reopenTestDB(t, db)
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fileName := data.GetDataFileName(db.options.DirPath, 0)
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[bytes.Index(content, testValue(3))] ^= 0xff
+	if err := os.WriteFile(fileName, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = MigrateChecksumMode(db.options, t.TempDir(), PerBlock)
+	var corruptionErr *data.CorruptionError
+	if !errors.As(err, &corruptionErr) || corruptionErr.FileId != 0 || corruptionErr.Cause != data.ErrInvalidCRC.Error() {
+		t.Fatalf("expected ErrInvalidCRC, got %v", err)
+	}
+}
+
+func BenchmarkDB_GetSkipReadCRC(b *testing.B) {
+	value := bytes.Repeat([]byte("v"), 4096)
+	for _, skip := range []bool{false, true} {
+		b.Run(fmt.Sprintf("skip=%v", skip), func(b *testing.B) {
+			options := DefaultOptions
+			options.DirPath = b.TempDir()
+			options.SkipReadCRC = skip
+			db, err := Open(options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			for i := 0; i < 1000; i++ {
+				if err := db.Put(testKey(i), value); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.SetBytes(int64(len(value)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Get(testKey(i % 1000)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDB_ForEach(t *testing.T) {
+	db := openTestDB(t, nil)
+	for i := 0; i < 10; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 遍历所有数据
+	var count int
+	err := db.ForEach(context.Background(), func(key []byte, value []byte) error {
+		if string(value) != string(testValue(count)) {
+			t.Fatalf("unexpected value %q for key %q", value, key)
+		}
+		count++
+		return nil
+	})
+	if err != nil || count != 10 {
+		t.Fatalf("ForEach = %v, visited %d keys", err, count)
+	}
+
+	// ErrStopIteration 正常结束遍历
+	count = 0
+	err = db.ForEach(context.Background(), func(key []byte, value []byte) error {
+		count++
+		if count == 3 {
+			return ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil || count != 3 {
+		t.Fatalf("ForEach with ErrStopIteration = %v, visited %d keys", err, count)
+	}
+
+	// 其他错误直接返回
+	errTest := errors.New("test error")
+	err = db.ForEach(context.Background(), func(key []byte, value []byte) error {
+		return errTest
+	})
+	if !errors.Is(err, errTest) {
+		t.Fatalf("ForEach = %v, want %v", err, errTest)
+	}
+
+	// ctx 取消后终止遍历
+	ctx, cancel := context.WithCancel(context.Background())
+	count = 0
+	err = db.ForEach(ctx, func(key []byte, value []byte) error {
+		count++
+		if count == 5 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || count != 5 {
+		t.Fatalf("ForEach with canceled ctx = %v, visited %d keys", err, count)
+	}
+}
+
+func TestDB_ForEachReleasesLock(t *testing.T) {
+	db := openTestDB(t, nil)
+	for i := 0; i < 10; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 遍历过程中删除一个还未遍历到的key，释放读锁后删除可以执行，被删除的key不会被遍历到
+	opts := DefaultIteratorOptions
+	opts.ForEachBatchSize = 2
+	deleted := make(chan error, 1)
+	var visited []string
+	err := db.ForEachWithOptions(context.Background(), opts, func(key []byte, value []byte) error {
+		if len(visited) == 0 {
+			go func() {
+				deleted <- db.Delete(testKey(5))
+			}()
+		}
+		if len(visited) == 1 {
+			// 等待删除阻塞在写锁上，下次释放读锁时删除会先执行
+			time.Sleep(100 * time.Millisecond)
+		}
+		visited = append(visited, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-deleted; err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range visited {
+		if key == string(testKey(5)) {
+			t.Fatalf("deleted key %s was visited", key)
+		}
+	}
+	if len(visited) != 9 {
+		t.Fatalf("visited %d keys, want 9", len(visited))
+	}
+}
+
+func TestDB_CountKeys(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree, PersistentART} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+		})
+		for i := 0; i < 30; i++ {
+			prefix := []string{"a:", "b:", "ab"}[i%3]
+			if err := db.Put([]byte(fmt.Sprintf("%s%05d", prefix, i)), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.Delete([]byte("a:00000")); err != nil {
+			t.Fatal(err)
+		}
+		// 已过期但还没有删除的key不计入，未过期的key正常计入；前缀为空时同样不计入，和各个前缀的数量之和一致
+		for _, key := range []string{"a:expired", "c:expired"} {
+			if err := db.PutWithTTL([]byte(key), []byte("v"), time.Nanosecond); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.PutWithTTL([]byte("c:live"), []byte("v"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+
+		for prefix, expected := range map[string]int{"": 30, "a": 19, "a:": 9, "b:": 10, "ab": 10, "c": 1, "b:00001": 1} {
+			count, err := db.CountKeys([]byte(prefix))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != expected {
+				t.Fatalf("index %d: CountKeys(%q) = %d, want %d", indexType, prefix, count, expected)
+			}
+		}
+
+		// GetEx 删除过期的key之后不再计入
+		for _, key := range []string{"a:expired", "c:expired"} {
+			if _, _, err := db.GetEx([]byte(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if count, err := db.CountKeys(nil); err != nil || count != 30 {
+			t.Fatalf("index %d: CountKeys after GetEx = %d, %v", indexType, count, err)
+		}
+		// 重新打开之后带过期时间的key的数量保持不变
+		db = reopenTestDB(t, db)
+		if expiring := db.index.ExpiringSize(); expiring != 1 {
+			t.Fatalf("index %d: expected 1 expiring key after reopen, got %d", indexType, expiring)
+		}
+		// 带过期时间的key全部删除之后直接使用索引的数量
+		if err := db.Delete([]byte("c:live")); err != nil {
+			t.Fatal(err)
+		}
+		if expiring := db.index.ExpiringSize(); expiring != 0 {
+			t.Fatalf("index %d: expected no expiring keys, got %d", indexType, expiring)
+		}
+		if count, err := db.CountKeys(nil); err != nil || count != 29 {
+			t.Fatalf("index %d: CountKeys without expiring keys = %d, %v", indexType, count, err)
+		}
+	}
+}
+
+func TestDB_FirstKeyLastKey(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+		})
+		if key, ok := db.FirstKey(); ok || key != nil {
+			t.Fatalf("index %d: FirstKey on empty db = %q, %v", indexType, key, ok)
+		}
+		if key, ok := db.LastKey(); ok || key != nil {
+			t.Fatalf("index %d: LastKey on empty db = %q, %v", indexType, key, ok)
+		}
+
+		for i := 0; i < 100; i++ {
+			if err := db.Put(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 删除和过期的边界key被跳过
+		if err := db.Delete(testKey(0)); err != nil {
+			t.Fatal(err)
+		}
+		putExpired(t, db, testKey(1), testValue(1))
+		if err := db.Delete(testKey(99)); err != nil {
+			t.Fatal(err)
+		}
+
+		if key, ok := db.FirstKey(); !ok || !bytes.Equal(key, testKey(2)) {
+			t.Fatalf("index %d: FirstKey = %q, %v", indexType, key, ok)
+		}
+		if key, ok := db.LastKey(); !ok || !bytes.Equal(key, testKey(98)) {
+			t.Fatalf("index %d: LastKey = %q, %v", indexType, key, ok)
+		}
+	}
+}
+
+func TestDB_Update(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	// key不存在时fn收到nil
+	err := db.Update([]byte("k"), func(oldValue []byte) ([]byte, error) {
+		if oldValue != nil {
+			t.Fatalf("expected nil old value, got %q", oldValue)
+		}
+		return []byte("v1"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update([]byte("k"), func(oldValue []byte) ([]byte, error) {
+		return append(oldValue, "-v2"...), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get([]byte("k")); err != nil || string(value) != "v1-v2" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	// fn返回错误时不写入
+	errAbort := errors.New("abort")
+	err = db.Update([]byte("k"), func(oldValue []byte) ([]byte, error) {
+		return []byte("ignored"), errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("expected errAbort, got %v", err)
+	}
+	if value, _ := db.Get([]byte("k")); string(value) != "v1-v2" {
+		t.Fatalf("value changed after aborted update: %q", value)
+	}
+
+	// 返回nil时删除key
+	if err := db.Update([]byte("k"), func(oldValue []byte) ([]byte, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte("k")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	// 空value和key不存在区分开
+	if err := db.Update([]byte("k"), func(oldValue []byte) ([]byte, error) { return []byte{}, nil }); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update([]byte("k"), func(oldValue []byte) ([]byte, error) {
+		if oldValue == nil {
+			t.Fatal("expected empty old value, got nil")
+		}
+		return oldValue, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(nil, func(oldValue []byte) ([]byte, error) { return oldValue, nil }); err != ErrKeyIsEmpty {
+		t.Fatalf("expected ErrKeyIsEmpty, got %v", err)
+	}
+
+	db = reopenTestDB(t, db)
+	if value, err := db.Get([]byte("k")); err != nil || len(value) != 0 {
+		t.Fatalf("Get after reopen = %q, %v", value, err)
+	}
+}
+
+// 并发地对同一个key加一，不会丢失更新
+func TestDB_UpdateConcurrentIncrement(t *testing.T) {
+	for _, stripedLockCount := range []int{0, 16} {
+		db := openTestDB(t, func(options *Options) {
+			options.StripedLockCount = stripedLockCount
+		})
+
+		incr := func(oldValue []byte) ([]byte, error) {
+			n, _ := strconv.Atoi(string(oldValue))
+			return []byte(strconv.Itoa(n + 1)), nil
+		}
+		const goroutines, perGoroutine = 8, 100
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < perGoroutine; i++ {
+					if err := db.Update([]byte("counter"), incr); err != nil {
+						t.Error(err)
+						return
+					}
+					// 同时有普通写入
+					if err := db.Put(testKey(g*perGoroutine+i), testValue(i)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		value, err := db.Get([]byte("counter"))
+		if err != nil || string(value) != strconv.Itoa(goroutines*perGoroutine) {
+			t.Fatalf("striped lock %d: counter = %q, %v", stripedLockCount, value, err)
+		}
+	}
+}
+
+func TestDB_AppendValue(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	// key不存在时视为空value
+	if n, err := db.AppendValue([]byte("k"), []byte("hello")); err != nil || n != 5 {
+		t.Fatalf("AppendValue = %d, %v", n, err)
+	}
+	if n, err := db.AppendValue([]byte("k"), []byte(" world")); err != nil || n != 11 {
+		t.Fatalf("AppendValue = %d, %v", n, err)
+	}
+	if value, err := db.Get([]byte("k")); err != nil || string(value) != "hello world" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	// 追加空的后缀不改变value
+	if n, err := db.AppendValue([]byte("k"), nil); err != nil || n != 11 {
+		t.Fatalf("AppendValue = %d, %v", n, err)
+	}
+	// 不存在的key追加空的后缀写入空value
+	if n, err := db.AppendValue([]byte("empty"), nil); err != nil || n != 0 {
+		t.Fatalf("AppendValue = %d, %v", n, err)
+	}
+	if value, err := db.Get([]byte("empty")); err != nil || len(value) != 0 {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	// 已过期的key视为空value，追加后清除过期时间
+	if err := db.PutWithTTL([]byte("ttl"), []byte("old"), time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if n, err := db.AppendValue([]byte("ttl"), []byte("new")); err != nil || n != 3 {
+		t.Fatalf("AppendValue = %d, %v", n, err)
+	}
+	if value, err := db.Get([]byte("ttl")); err != nil || string(value) != "new" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	if _, err := db.AppendValue(nil, []byte("v")); err != ErrKeyIsEmpty {
+		t.Fatalf("expected ErrKeyIsEmpty, got %v", err)
+	}
+
+	db = reopenTestDB(t, db)
+	if value, err := db.Get([]byte("k")); err != nil || string(value) != "hello world" {
+		t.Fatalf("Get after reopen = %q, %v", value, err)
+	}
+}
+
+func TestDB_Rename(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("old"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Rename([]byte("old"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte("old")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if value, err := db.Get([]byte("new")); err != nil || string(value) != "value" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	// 源key不存在
+	if err := db.Rename([]byte("old"), []byte("other")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := db.Get([]byte("other")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	// 目标key已存在时被覆盖
+	if err := db.Put([]byte("target"), []byte("overwritten")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Rename([]byte("new"), []byte("target")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get([]byte("target")); err != nil || string(value) != "value" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	// 重命名为自身
+	if err := db.Rename([]byte("target"), []byte("target")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Rename(nil, []byte("target")); err != ErrKeyIsEmpty {
+		t.Fatalf("expected ErrKeyIsEmpty, got %v", err)
+	}
+
+	db = reopenTestDB(t, db)
+	keys, err := db.CountKeys(nil)
+	if err != nil || keys != 1 {
+		t.Fatalf("CountKeys = %d, %v", keys, err)
+	}
+	if value, err := db.Get([]byte("target")); err != nil || string(value) != "value" {
+		t.Fatalf("Get after reopen = %q, %v", value, err)
+	}
+}
+
+func TestDB_GetWithMetadata(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+	})
+	if _, _, _, err := db.GetWithMetadata([]byte("missing")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	// 目标key之前写入大量会被覆盖的数据，merge之后这些数据被丢弃
+	for i := 0; i < 300; i++ {
+		if err := db.Put([]byte("junk"), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put([]byte("target"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := db.Put([]byte("junk"), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	value, fid, offset, err := db.GetWithMetadata([]byte("target"))
+	if err != nil || string(value) != "value" {
+		t.Fatalf("GetWithMetadata = %s, %v", value, err)
+	}
+	pos := db.index.Get([]byte("target"))
+	if fid != pos.Fid || offset != pos.Offset {
+		t.Fatalf("expected %d/%d, got %d/%d", pos.Fid, pos.Offset, fid, offset)
+	}
+	if fid == 0 {
+		t.Fatalf("expected target after the first data file, got fid %d", fid)
+	}
+
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+
+	// merge之后key位于重写的数据文件中（文件id小于merge时未参与merge的文件）
+	mergedValue, mergedFid, mergedOffset, err := db.GetWithMetadata([]byte("target"))
+	if err != nil || string(mergedValue) != "value" {
+		t.Fatalf("GetWithMetadata after merge = %s, %v", mergedValue, err)
+	}
+	if mergedFid == fid && mergedOffset == offset {
+		t.Fatalf("position did not change after merge: %d/%d", fid, offset)
+	}
+	if mergedFid >= db.mergedFileId {
+		t.Fatalf("expected merged file (< %d), got fid %d", db.mergedFileId, mergedFid)
+	}
+}
+
+func TestDB_SizeOf(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+		})
+		if _, err := db.SizeOf([]byte("missing")); err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+		if _, err := db.SizeOf(nil); err != ErrKeyIsEmpty {
+			t.Fatalf("expected ErrKeyIsEmpty, got %v", err)
+		}
+
+		small := []byte("v")
+		large := make([]byte, 1000)
+		if err := db.Put([]byte("small"), small); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put([]byte("large"), large); err != nil {
+			t.Fatal(err)
+		}
+		smallSize, err := db.SizeOf([]byte("small"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		largeSize, err := db.SizeOf([]byte("large"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 大小为整条记录的大小，包括Header和key
+		if smallSize <= int64(len("small")+len(small)) || largeSize-smallSize != int64(len(large)-len(small))+1 {
+			t.Fatalf("unexpected sizes %d, %d", smallSize, largeSize)
+		}
+
+		if err := db.Delete([]byte("small")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SizeOf([]byte("small")); err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound after delete, got %v", err)
+		}
+	}
+}
+
+func TestDB_Clone(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+			options.DataFileSize = 8 * 1024
+		})
+		writeFlushData(t, db, 0)
+		expected := dumpDB(t, db)
+
+		clone, err := db.Clone(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clone.options.IndexType != indexType || clone.options.DataFileSize != db.options.DataFileSize {
+			t.Fatalf("expected clone to inherit options, got %+v", clone.options)
+		}
+		expectContent(t, clone, expected)
+
+		// 两个实例的写入互不影响
+		if err := db.Put([]byte("original"), []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete(testKey(3)); err != nil {
+			t.Fatal(err)
+		}
+		if err := clone.Put([]byte("clone"), []byte("v2")); err != nil {
+			t.Fatal(err)
+		}
+		if err := clone.Put(testKey(4), []byte("changed")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Get([]byte("clone")); err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound in original, got %v", err)
+		}
+		if _, err := clone.Get([]byte("original")); err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound in clone, got %v", err)
+		}
+		if value, err := db.Get(testKey(4)); err != nil || !bytes.Equal(value, []byte(expected[string(testKey(4))])) {
+			t.Fatalf("unexpected value in original: %q, %v", value, err)
+		}
+		if value, err := clone.Get(testKey(3)); err != nil || !bytes.Equal(value, []byte(expected[string(testKey(3))])) {
+			t.Fatalf("unexpected value in clone: %q, %v", value, err)
+		}
+
+		// 关闭原实例之后克隆仍然可以使用，重新打开后数据保持独立
+		originalContent := dumpDB(t, db)
+		cloneContent := dumpDB(t, clone)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		clone = reopenTestDB(t, clone)
+		expectContent(t, clone, cloneContent)
+		db = reopenTestDB(t, db)
+		expectContent(t, db, originalContent)
+	}
+}
+
+func TestDB_BTreeDegree(t *testing.T) {
+	for _, degree := range []int{2, 128} {
+		db := openTestDB(t, func(options *Options) {
+			options.BTreeDegree = degree
+			options.DataFileSize = 8 * 1024
+		})
+		writeReplicationData(t, db, 0)
+		writeReplicationData(t, db, 1)
+		expected := dumpDB(t, db)
+
+		// 反向遍历同样有序
+		iterator := db.NewIterator(IteratorOptions{Reverse: true})
+		var prev []byte
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			if prev != nil && bytes.Compare(prev, iterator.Key()) <= 0 {
+				t.Fatalf("keys out of order: %s, %s", prev, iterator.Key())
+			}
+			prev = append(prev[:0], iterator.Key()...)
+		}
+		iterator.Close()
+
+		db = reopenTestDB(t, db)
+		expectContent(t, db, expected)
+	}
+
+	options := DefaultOptions
+	options.DirPath = t.TempDir()
+	options.BTreeDegree = 1
+	if _, err := Open(options); err == nil {
+		t.Fatal("expected error for btree degree 1")
+	}
+
+	// 其他索引类型不使用阶数，不需要配置
+	db := openTestDB(t, func(options *Options) {
+		options.IndexType = ART
+		options.BTreeDegree = 0
+	})
+	if err := db.Put(testKey(1), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkBTree_Degree(b *testing.B) {
+	const keyCount = 100000
+	keys := make([][]byte, keyCount)
+	for i := range keys {
+		keys[i] = testKey(i * 7919 % keyCount)
+	}
+	pos := &data.LogRecordPos{Fid: 1, Offset: 100, Size: 64}
+	for _, degree := range []int{4, 32, 128} {
+		b.Run(fmt.Sprintf("put/degree=%d", degree), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bt := index.NewBtree(degree)
+				for _, key := range keys {
+					bt.Put(key, pos)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("get/degree=%d", degree), func(b *testing.B) {
+			bt := index.NewBtree(degree)
+			for _, key := range keys {
+				bt.Put(key, pos)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if bt.Get(keys[i%keyCount]) == nil {
+					b.Fatal("key not found")
+				}
+			}
+		})
+	}
+}
+
+// 开启目录持久化时，切换活跃文件、新建value log以及打开时移入merge结果都会持久化数据目录
+func TestDB_SyncDirOnRollover(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+		options.ValueLogSeparationThreshold = 64
+		options.SyncDirOnRollover = true
+	})
+	for i := 0; i < 1000; i++ {
+		value := testValue(i)
+		if i%10 == 0 {
+			value = bytes.Repeat(value, 10)
+		}
+		if err := db.Put(testKey(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if countDataFiles(t, db.options.DirPath) < 3 {
+		t.Fatal("expected several data files")
+	}
+	expected := dumpDB(t, db)
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if db.mergedFileId == 0 {
+		t.Fatal("expected merge results applied")
+	}
+	expectContent(t, db, expected)
+}
+
+// 带有加密标记的记录在重启后仍能加载，没有配置密钥时读取返回 ErrUnsupportedRecordFlags，不会把编码后的value当作原始数据
+func TestDB_RecordFlags(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("plain"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	db.mu.Lock()
+	pos, err := db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("flagged"), nonTransactionSeqNo),
+		Value: []byte("encoded"),
+		Flags: data.FlagEncrypted,
+	})
+	if err == nil {
+		db.index.Put([]byte("flagged"), pos)
+	}
+	db.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 0; round < 2; round++ {
+		if _, err := db.Get([]byte("flagged")); err != ErrUnsupportedRecordFlags {
+			t.Fatalf("expected ErrUnsupportedRecordFlags, got %v", err)
+		}
+		if value, err := db.Get([]byte("plain")); err != nil || string(value) != "value" {
+			t.Fatalf("get plain = %q, %v", value, err)
+		}
+		db = reopenTestDB(t, db)
+	}
+}
+
+func TestDB_RenamePrefix(t *testing.T) {
+	db := openTestDB(t, nil)
+	expected := make(map[string]string)
+	// 超过一个批次的key数量，分多个批次提交
+	count := int(DefaultWriteBatchOptions.MaxBatchNum/2) + 100
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("v1:%06d", i)
+		if err := db.Put([]byte(key), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		expected["v2:"+key[3:]] = string(testValue(i))
+	}
+	for _, key := range []string{"v0:a", "v10", "v2"} {
+		if err := db.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = key
+	}
+	if err := db.PutWithTTL([]byte("v1:ttl"), []byte("ttl"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	expected["v2:ttl"] = "ttl"
+	putExpired(t, db, []byte("v1:expired"), []byte("expired"))
+
+	moved, err := db.RenamePrefix([]byte("v1:"), []byte("v2:"))
+	if err != nil || moved != count+1 {
+		t.Fatalf("RenamePrefix = %d, %v", moved, err)
+	}
+	expectContent(t, db, expected)
+	if pos := db.index.Get([]byte("v2:ttl")); pos == nil || pos.Expire == 0 {
+		t.Fatalf("expected expiration to be kept, got %+v", pos)
+	}
+	// 已过期的key不移动
+	if _, err := db.Get([]byte("v2:expired")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	db = reopenTestDB(t, db)
+	expectContent(t, db, expected)
+
+	// 前缀重叠时，同时是新key的旧key不会被删除
+	for _, key := range []string{"a1", "aa1"} {
+		if err := db.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if moved, err := db.RenamePrefix([]byte("a"), []byte("aa")); err != nil || moved != 2 {
+		t.Fatalf("RenamePrefix = %d, %v", moved, err)
+	}
+	expected["aa1"] = "a1"
+	expected["aaa1"] = "aa1"
+	expectContent(t, db, expected)
+
+	if moved, err := db.RenamePrefix([]byte("missing"), []byte("other")); err != nil || moved != 0 {
+		t.Fatalf("RenamePrefix = %d, %v", moved, err)
+	}
+	// 移动后key为空
+	if err := db.Put([]byte("x"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RenamePrefix([]byte("x"), nil); err != ErrKeyIsEmpty {
+		t.Fatalf("expected ErrKeyIsEmpty, got %v", err)
+	}
+}
+
+// 关闭失败的索引
+type closeErrIndex struct {
+	index.Indexer
+	err error
+}
+
+func (idx *closeErrIndex) Close() error {
+	_ = idx.Indexer.Close()
+	return idx.err
+}
+
+// 记录是否被关闭的数据文件IO
+type closeTrackingIO struct {
+	fio.IOManager
+	closed bool
+	err    error
+}
+
+func (c *closeTrackingIO) Close() error {
+	c.closed = true
+	if err := c.IOManager.Close(); err != nil {
+		return err
+	}
+	return c.err
+}
+
+// 索引关闭失败时仍然保存序列号并关闭所有数据文件、释放文件锁，返回所有步骤的错误
+func TestDB_CloseCollectsErrors(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+	})
+	for i := 0; i < 500; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.olderFiles) == 0 {
+		t.Fatal("expected older data files")
+	}
+
+	errIndex := errors.New("index close failed")
+	errActive := errors.New("active file close failed")
+	db.index = &closeErrIndex{Indexer: db.index, err: errIndex}
+	active := &closeTrackingIO{IOManager: db.activeFile.IOManager, err: errActive}
+	db.activeFile.IOManager = active
+	var older []*closeTrackingIO
+	for _, file := range db.olderFiles {
+		tracking := &closeTrackingIO{IOManager: file.IOManager}
+		file.IOManager = tracking
+		older = append(older, tracking)
+	}
+
+	err := db.Close()
+	if !errors.Is(err, errIndex) || !errors.Is(err, errActive) {
+		t.Fatalf("expected both errors, got %v", err)
+	}
+	if !active.closed {
+		t.Fatal("active file not closed")
+	}
+	for _, file := range older {
+		if !file.closed {
+			t.Fatal("older file not closed")
+		}
+	}
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.SeqNoFileName)); err != nil {
+		t.Fatalf("seq no file not saved: %v", err)
+	}
+
+	// 文件锁已经释放，可以重新打开
+	db = reopenTestDB(t, db)
+	expectKeyCount(t, db, 500)
+}
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.StripedLockCount = 16 },
+		func(options *Options) { options.IndexType = BPlusTree },
+	} {
+		db := openTestDB(t, configure)
+
+		if stored, err := db.PutIfAbsent([]byte("k"), []byte("v1")); err != nil || !stored {
+			t.Fatalf("PutIfAbsent new key = %v, %v", stored, err)
+		}
+		if stored, err := db.PutIfAbsent([]byte("k"), []byte("v2")); err != nil || stored {
+			t.Fatalf("PutIfAbsent existing key = %v, %v", stored, err)
+		}
+		// 已过期的key视为不存在
+		putExpired(t, db, []byte("expired"), []byte("old"))
+		if stored, err := db.PutIfAbsent([]byte("expired"), []byte("new")); err != nil || !stored {
+			t.Fatalf("PutIfAbsent expired key = %v, %v", stored, err)
+		}
+		if _, err := db.PutIfAbsent(nil, []byte("v")); err != ErrKeyIsEmpty {
+			t.Fatalf("expected ErrKeyIsEmpty, got %v", err)
+		}
+		expectContent(t, db, map[string]string{"k": "v1", "expired": "new"})
+
+		// 并发写入同一个key时只有一个成功
+		var wg sync.WaitGroup
+		var stored atomic.Int32
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ok, err := db.PutIfAbsent([]byte("race"), testValue(i))
+				if err != nil {
+					t.Error(err)
+				}
+				if ok {
+					stored.Add(1)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if stored.Load() != 1 {
+			t.Fatalf("expected exactly one PutIfAbsent to store, got %d", stored.Load())
+		}
+
+		db = reopenTestDB(t, db)
+		if value, err := db.Get([]byte("k")); err != nil || string(value) != "v1" {
+			t.Fatalf("get after reopen = %q, %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package bitcask_go
+
+import (
+	"sort"
+	"time"
+)
+
+// 单个数据文件的空间占用
+type FileUsage struct {
+	Fid              uint32 // 文件id
+	TotalBytes       int64  // 文件中数据的大小
+	ValidBytes       int64  // 索引引用的有效记录大小
+	ReclaimableBytes int64  // merge可以回收的数据量，TotalBytes - ValidBytes
+}
+
+// 数据文件的基本信息
+type DataFileInfo struct {
+	FileId   uint32 // 文件id
+	Size     int64  // 文件中数据的大小
+	IsActive bool   // 是否为当前活跃文件
+}
+
+// 列出所有数据文件（包括活跃文件），按文件id排序，只读取文件大小，不遍历索引
+func (db *DB) DataFiles() ([]DataFileInfo, error) {
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.fileMu.RLock()
+	defer db.fileMu.RUnlock()
+
+	files := make([]DataFileInfo, 0, len(db.olderFiles)+1)
+	for fid, dataFile := range db.olderFiles {
+		size, err := dataFile.DataSize()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, DataFileInfo{FileId: fid, Size: size})
+	}
+	if db.activeFile != nil {
+		files = append(files, DataFileInfo{FileId: db.activeFile.FileId, Size: db.activeFile.WriteOff, IsActive: true})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].FileId < files[j].FileId
+	})
+	return files, nil
+}
+
+// 统计每个数据文件的空间占用，可以根据有效数据的比例只merge最值得回收的文件
+// 有效数据量根据内存索引中的位置计算，不读取数据文件；已过期的key视为无效数据
+// 开启多版本时历史版本引用的记录不计入有效数据，merge实际能回收的数据量可能更少
+func (db *DB) DiskUsageByFile() (map[uint32]FileUsage, error) {
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	db.fileMu.RLock()
+	usage, err := db.dataFileSizes()
+	db.fileMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	db.addValidBytes(usage)
+	return usage, nil
+}
+
+// 统计每个数据文件的大小，有效数据量之后由 addValidBytes 计算（调用方需持有 mu 和 fileMu 的读锁）
+func (db *DB) dataFileSizes() (map[uint32]FileUsage, error) {
+	usage := make(map[uint32]FileUsage, len(db.olderFiles)+1)
+	for fid, dataFile := range db.olderFiles {
+		size, err := dataFile.DataSize()
+		if err != nil {
+			return nil, err
+		}
+		usage[fid] = FileUsage{Fid: fid, TotalBytes: size}
+	}
+	if db.activeFile != nil {
+		usage[db.activeFile.FileId] = FileUsage{Fid: db.activeFile.FileId, TotalBytes: db.activeFile.WriteOff}
+	}
+	return usage, nil
+}
+
+// 遍历内存索引计算每个数据文件中的有效数据量和可回收的数据量（调用方需持有 mu 的读锁）
+// 遍历期间不持有 fileMu，分段锁模式下的写入可以继续追加，之后写入的记录不在 usage 中的文件时不计入
+func (db *DB) addValidBytes(usage map[uint32]FileUsage) {
+	now := time.Now().UnixNano()
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.IsExpired(now) {
+			continue
+		}
+		if fileUsage, ok := usage[pos.Fid]; ok {
+			fileUsage.ValidBytes += int64(pos.Size)
+			usage[pos.Fid] = fileUsage
+		}
+	}
+	iterator.Close()
+
+	for fid, fileUsage := range usage {
+		fileUsage.ReclaimableBytes = fileUsage.TotalBytes - fileUsage.ValidBytes
+		usage[fid] = fileUsage
+	}
+}
+
+// 所有有效key在数据文件中的记录大小之和（包括记录的header和key），即merge之后数据文件的逻辑大小
+// 根据内存索引中的位置计算，不读取数据文件；已过期的key不计入，键值分离时value log中的value不计入
+func (db *DB) LiveSize() int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var size int64
+	now := time.Now().UnixNano()
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if pos := iterator.Value(); !pos.IsExpired(now) {
+			size += int64(pos.Size)
+		}
+	}
+	iterator.Close()
+	return size
+}
+
+// 按文件id排序的空间占用
+func sortedFileUsage(usage map[uint32]FileUsage) []FileUsage {
+	files := make([]FileUsage, 0, len(usage))
+	for _, fileUsage := range usage {
+		files = append(files, fileUsage)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Fid < files[j].Fid
+	})
+	return files
+}
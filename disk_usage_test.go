@@ -0,0 +1,270 @@
+package bitcask_go
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"bitcask-go/fio"
+)
+
+// 获取文件大小失败的IOManager
+type sizeErrIO struct {
+	fio.IOManager
+	err error
+}
+
+func (s *sizeErrIO) Size() (int64, error) {
+	return 0, s.err
+}
+
+func TestDB_DiskUsageByFile(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.DataFilePreAllocSize = 64 * 1024 },
+		func(options *Options) { options.IndexType = BPlusTree },
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 8 * 1024
+			if configure != nil {
+				configure(options)
+			}
+		})
+		for i := 0; i < 500; i++ {
+			if err := db.Put(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 覆盖写入后第一个文件中的数据全部无效
+		for i := 0; i < 500; i++ {
+			if err := db.Put(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.PutWithTTL([]byte("expired"), []byte("v"), time.Nanosecond); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+
+		usage, err := db.DiskUsageByFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(usage) != countDataFiles(t, db.options.DirPath) {
+			t.Fatalf("expected usage of %d files, got %d", countDataFiles(t, db.options.DirPath), len(usage))
+		}
+		var total, valid int64
+		for fid, fileUsage := range usage {
+			if fileUsage.Fid != fid || fileUsage.ReclaimableBytes != fileUsage.TotalBytes-fileUsage.ValidBytes || fileUsage.ValidBytes > fileUsage.TotalBytes {
+				t.Fatalf("unexpected usage %+v", fileUsage)
+			}
+			total += fileUsage.TotalBytes
+			valid += fileUsage.ValidBytes
+		}
+		if first := usage[0]; first.ValidBytes != 0 || first.ReclaimableBytes != first.TotalBytes || first.TotalBytes == 0 {
+			t.Fatalf("expected first file to be fully reclaimable, got %+v", first)
+		}
+		var indexed int64
+		for i := 0; i < 500; i++ {
+			indexed += int64(db.index.Get(testKey(i)).Size)
+		}
+		if valid != indexed {
+			t.Fatalf("expected %d valid bytes, got %d", indexed, valid)
+		}
+		if db.options.DataFilePreAllocSize == 0 {
+			if size := dataFilesSize(t, db.options.DirPath); total != size {
+				t.Fatalf("expected %d total bytes, got %d", size, total)
+			}
+		}
+
+		// Stat 中按文件id排序
+		stat := db.Stat()
+		if len(stat.FileUsage) != len(usage) {
+			t.Fatalf("expected %d files in stat, got %d", len(usage), len(stat.FileUsage))
+		}
+		for i, fileUsage := range stat.FileUsage {
+			if fileUsage != usage[fileUsage.Fid] || (i > 0 && stat.FileUsage[i-1].Fid >= fileUsage.Fid) {
+				t.Fatalf("unexpected stat file usage %+v", stat.FileUsage)
+			}
+		}
+
+		// 重新打开之后旧文件的大小从文件中读取
+		db = reopenTestDB(t, db)
+		reopened, err := db.DiskUsageByFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for fid, fileUsage := range usage {
+			if fid == db.activeFile.FileId {
+				continue
+			}
+			if reopened[fid] != fileUsage {
+				t.Fatalf("expected %+v after reopen, got %+v", fileUsage, reopened[fid])
+			}
+		}
+		_ = db.Close()
+	}
+}
+
+// 读取文件大小失败时 Stat 不panic，FileUsage 留空并记录错误日志
+func TestDB_StatFileUsageError(t *testing.T) {
+	logger := &captureLogger{}
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+		options.Logger = logger
+	})
+	for i := 0; i < 500; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 重新打开后旧文件的大小从文件中读取
+	db = reopenTestDB(t, db)
+	older := db.olderFiles[0]
+	inner := older.IOManager
+	older.IOManager = &sizeErrIO{IOManager: inner, err: errors.New("size failed")}
+	defer func() { older.IOManager = inner }()
+
+	stat := db.Stat()
+	if len(stat.FileUsage) != 0 || stat.KeyNum != 500 {
+		t.Fatalf("unexpected stat: %d files, %d keys", len(stat.FileUsage), stat.KeyNum)
+	}
+	if logger.count("ERROR failed to get file usage: size failed") != 1 {
+		t.Fatalf("expected an error log, got %q", logger.lines)
+	}
+}
+
+func TestDB_LiveSize(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, BPlusTree} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+			options.DataFileSize = 8 * 1024
+		})
+		if size := db.LiveSize(); size != 0 {
+			t.Fatalf("expected live size 0 for empty db, got %d", size)
+		}
+		for i := 0; i < 200; i++ {
+			if err := db.Put(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		initial := db.LiveSize()
+		if initial == 0 {
+			t.Fatal("expected live size after writes")
+		}
+
+		// 覆盖写入相同长度的value，磁盘占用增加，有效数据量不变
+		diskSize := db.Stat().DiskSize
+		for i := 0; i < 200; i++ {
+			if err := db.Put(testKey(i), testValue(i+1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if size := db.LiveSize(); size != initial {
+			t.Fatalf("expected live size %d after overwrite, got %d", initial, size)
+		}
+		if db.Stat().DiskSize <= diskSize {
+			t.Fatal("expected disk size to grow after overwrite")
+		}
+
+		// 覆盖写入更长的value，每条记录增加的大小相同
+		for i := 0; i < 200; i++ {
+			if err := db.Put(testKey(i), append(testValue(i), "-longer"...)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		longer := db.LiveSize()
+		if longer != initial+200*int64(len("-longer")) {
+			t.Fatalf("expected live size %d after longer overwrite, got %d", initial+200*int64(len("-longer")), longer)
+		}
+
+		// 删除的key和过期的key不计入
+		recordSize := int64(db.index.Get(testKey(0)).Size)
+		if err := db.Delete(testKey(0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.PutWithTTL([]byte("expired"), []byte("v"), time.Nanosecond); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+		if size := db.LiveSize(); size != longer-recordSize {
+			t.Fatalf("expected live size %d after delete, got %d", longer-recordSize, size)
+		}
+		expected := db.LiveSize()
+
+		// 重新打开后从数据文件或hint文件加载的位置同样带有记录大小
+		if indexType != BPlusTree {
+			if err := db.MergeForce(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db = reopenTestDB(t, db)
+		if size := db.LiveSize(); size != expected {
+			t.Fatalf("expected live size %d after reopen, got %d", expected, size)
+		}
+		_ = db.Close()
+	}
+}
+
+func TestDB_DataFiles(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+	})
+	if files, err := db.DataFiles(); err != nil || len(files) != 0 {
+		t.Fatalf("expected no data files, got %+v, %v", files, err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := db.DataFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != countDataFiles(t, db.options.DirPath) || len(files) < 3 {
+		t.Fatalf("expected all data files listed, got %d", len(files))
+	}
+	var active int
+	for i, file := range files {
+		if i > 0 && file.FileId <= files[i-1].FileId {
+			t.Fatalf("data files not sorted: %+v", files)
+		}
+		stat, err := os.Stat(db.getDataFileName(db.options.DirPath, file.FileId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.Size != stat.Size() || file.Size == 0 {
+			t.Fatalf("file %d size %d, on disk %d", file.FileId, file.Size, stat.Size())
+		}
+		if file.IsActive {
+			active++
+			if file.FileId != db.activeFile.FileId {
+				t.Fatalf("file %d reported active, active file is %d", file.FileId, db.activeFile.FileId)
+			}
+		}
+	}
+	if active != 1 || !files[len(files)-1].IsActive {
+		t.Fatalf("expected exactly one active file with the largest id, got %+v", files)
+	}
+
+	// 读取文件大小失败时返回错误
+	db = reopenTestDB(t, db)
+	older := db.olderFiles[0]
+	inner := older.IOManager
+	sizeErr := errors.New("size failed")
+	older.IOManager = &sizeErrIO{IOManager: inner, err: sizeErr}
+	if files, err := db.DataFiles(); !errors.Is(err, sizeErr) || files != nil {
+		t.Fatalf("expected size error, got %+v, %v", files, err)
+	}
+	older.IOManager = inner
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := db.DataFiles(); err != ErrDatabaseClosed || files != nil {
+		t.Fatalf("expected ErrDatabaseClosed after close, got %+v, %v", files, err)
+	}
+}
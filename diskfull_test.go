@@ -0,0 +1,152 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// 模拟磁盘空间不足的IOManager：开启failWrites后只写入一半的数据，然后返回错误
+type diskFullIO struct {
+	fio.IOManager
+	failWrites bool
+	shortWrite bool // 为true时不返回错误，只返回写入的字节数
+	failSync   bool
+}
+
+func (d *diskFullIO) Write(b []byte) (int, error) {
+	if !d.failWrites {
+		return d.IOManager.Write(b)
+	}
+	n, err := d.IOManager.Write(b[:len(b)/2])
+	if err != nil || d.shortWrite {
+		return n, err
+	}
+	return n, syscall.ENOSPC
+}
+
+func (d *diskFullIO) Sync() error {
+	if d.failSync {
+		return syscall.EIO
+	}
+	return d.IOManager.Sync()
+}
+
+func (d *diskFullIO) Truncate(size int64) error {
+	return d.IOManager.(fio.Truncater).Truncate(size)
+}
+
+func TestDB_PutOnFullDisk(t *testing.T) {
+	for _, shortWrite := range []bool{false, true} {
+		db := openTestDB(t, nil)
+		if err := db.Put(testKey(1), testValue(1)); err != nil {
+			t.Fatal(err)
+		}
+
+		faulty := &diskFullIO{IOManager: db.activeFile.IOManager, failWrites: true, shortWrite: shortWrite}
+		db.activeFile.IOManager = faulty
+		writeOff, bytesWrite := db.activeFile.WriteOff, db.bytesWrite
+
+		expectedErr := error(syscall.ENOSPC)
+		if shortWrite {
+			expectedErr = io.ErrShortWrite
+		}
+		if err := db.Put(testKey(2), testValue(2)); !errors.Is(err, expectedErr) {
+			t.Fatalf("shortWrite=%v: expected %v, got %v", shortWrite, expectedErr, err)
+		}
+		if err := db.Delete(testKey(1)); !errors.Is(err, expectedErr) {
+			t.Fatalf("shortWrite=%v: expected %v, got %v", shortWrite, expectedErr, err)
+		}
+
+		// 写入失败的key没有进入索引，写入位置没有前进，部分数据已被截断
+		if _, err := db.Get(testKey(2)); err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+		if value, err := db.Get(testKey(1)); err != nil || !bytes.Equal(value, testValue(1)) {
+			t.Fatalf("Get = %q, %v", value, err)
+		}
+		if db.activeFile.WriteOff != writeOff || db.bytesWrite != bytesWrite {
+			t.Fatalf("WriteOff %d -> %d, bytesWrite %d -> %d", writeOff, db.activeFile.WriteOff, bytesWrite, db.bytesWrite)
+		}
+		if size, _ := db.activeFile.IOManager.Size(); size != writeOff {
+			t.Fatalf("expected file size %d after rollback, got %d", writeOff, size)
+		}
+
+		// 磁盘空间恢复后重试成功
+		faulty.failWrites = false
+		if err := db.Put(testKey(2), testValue(2)); err != nil {
+			t.Fatal(err)
+		}
+		db = reopenTestDB(t, db)
+		for _, i := range []int{1, 2} {
+			if value, err := db.Get(testKey(i)); err != nil || !bytes.Equal(value, testValue(i)) {
+				t.Fatalf("shortWrite=%v: Get(%d) after reopen = %q, %v", shortWrite, i, value, err)
+			}
+		}
+	}
+}
+
+// 部分写入无法回滚时，之后对此文件的写入都返回错误，重新打开后丢弃末尾不完整的记录
+func TestDB_PutOnFullDiskWithoutRollback(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put(testKey(1), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	faulty := &diskFullIO{IOManager: db.activeFile.IOManager, failWrites: true}
+	// 隐藏Truncate方法，部分写入之后无法回滚
+	db.activeFile.IOManager = struct{ fio.IOManager }{faulty}
+	if err := db.Put(testKey(2), testValue(2)); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected ENOSPC, got %v", err)
+	}
+	faulty.failWrites = false
+	if err := db.Put(testKey(2), testValue(2)); !errors.Is(err, data.ErrPartialWrite) {
+		t.Fatalf("expected ErrPartialWrite, got %v", err)
+	}
+
+	db = reopenTestDB(t, db)
+	if value, err := db.Get(testKey(1)); err != nil || !bytes.Equal(value, testValue(1)) {
+		t.Fatalf("Get after reopen = %q, %v", value, err)
+	}
+	if err := db.Put(testKey(2), testValue(2)); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get(testKey(2)); err != nil || !bytes.Equal(value, testValue(2)) {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+}
+
+// 写入成功但持久化失败时截断这条记录，重新打开后也不会出现
+func TestDB_PutSyncFailure(t *testing.T) {
+	db := openTestDB(t, func(options *Options) { options.SyncWrites = true })
+	if err := db.Put(testKey(1), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	faulty := &diskFullIO{IOManager: db.activeFile.IOManager, failSync: true}
+	db.activeFile.IOManager = faulty
+	writeOff := db.activeFile.WriteOff
+	if err := db.Put(testKey(2), testValue(2)); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected EIO, got %v", err)
+	}
+	if _, err := db.Get(testKey(2)); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if size, _ := db.activeFile.IOManager.Size(); size != writeOff || db.activeFile.WriteOff != writeOff {
+		t.Fatalf("expected file size %d after rollback, got %d (WriteOff %d)", writeOff, size, db.activeFile.WriteOff)
+	}
+
+	faulty.failSync = false
+	db = reopenTestDB(t, db)
+	if _, err := db.Get(testKey(2)); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound after reopen, got %v", err)
+	}
+	if value, err := db.Get(testKey(1)); err != nil || !bytes.Equal(value, testValue(1)) {
+		t.Fatalf("Get after reopen = %q, %v", value, err)
+	}
+}
@@ -3,14 +3,46 @@ package bitcask_go
 import "errors"
 
 var (
-	ErrKeyIsEmpty             = errors.New("key为空")
-	ErrIndexUpdateFailed      = errors.New("更新索引失败")
-	ErrKeyNotFound            = errors.New("key未被找到")
-	ErrDataFileNotFound       = errors.New("数据文件未被找到")
-	ErrDataDirectoryCorrupted = errors.New("数据文件可能被损坏")
-	ErrExceedMaxBatchNum      = errors.New("超出最大批量写入数量")
-	ErrMergeIsProgress        = errors.New("正在进行merge")
-	ErrDatabaseIsUsing        = errors.New("数据库正在使用")
-	ErrMergeRatioUnreached    = errors.New("merge比率未达到")
-	ErrNoEnoughSpaceForMerge  = errors.New("merge所需空间不足")
+	ErrKeyIsEmpty                  = errors.New("key为空")
+	ErrIndexUpdateFailed           = errors.New("更新索引失败")
+	ErrKeyNotFound                 = errors.New("key未被找到")
+	ErrDataFileNotFound            = errors.New("数据文件未被找到")
+	ErrDataDirectoryCorrupted      = errors.New("数据文件可能被损坏")
+	ErrExceedMaxBatchNum           = errors.New("超出最大批量写入数量")
+	ErrPotentialDeadlock           = errors.New("其他未提交的批次暂存了相同的key，请稍后重试")
+	ErrMergeIsProgress             = errors.New("正在进行merge")
+	ErrDatabaseIsUsing             = errors.New("数据库正在使用")
+	ErrMergeRatioUnreached         = errors.New("merge比率未达到")
+	ErrNoEnoughSpaceForMerge       = errors.New("merge所需空间不足")
+	ErrMultiVersionDisabled        = errors.New("未开启多版本，MaxVersionsPerKey需要大于1")
+	ErrVersionNotFound             = errors.New("指定序列号的版本不存在或已被清理")
+	ErrInvalidExportFile           = errors.New("不是有效的导出文件")
+	ErrReadOnly                    = errors.New("数据库为只读模式")
+	ErrStopIteration               = errors.New("停止遍历")
+	ErrAuditLogDisabled            = errors.New("未开启审计日志")
+	ErrReplicationUnsupported      = errors.New("数据文件大小超过4GB，不支持复制")
+	ErrVacuumUnsupported           = errors.New("B+树索引不支持vacuum")
+	ErrInvalidTTL                  = errors.New("过期时间必须大于0")
+	ErrInvalidMergeFileCount       = errors.New("每次merge的文件数量必须大于0")
+	ErrInvalidStatsInterval        = errors.New("统计采样间隔不能小于0")
+	ErrIncrementalMergeUnsupported = errors.New("B+树索引不支持增量merge")
+	ErrIndexMismatch               = errors.New("索引指向的记录和key不一致")
+	ErrMergeTargetNotEmpty         = errors.New("merge的目标目录不为空")
+	ErrDatabaseClosed              = errors.New("数据库已关闭")
+	ErrCommitTimeout               = errors.New("批量写入提交超时")
+	ErrInMemoryUnsupported         = errors.New("内存模式不支持此操作")
+	ErrConflict                    = errors.New("事务读取的key已被其他写入修改")
+	ErrTxnFinished                 = errors.New("事务已提交或回滚")
+	ErrInvalidSavepoint            = errors.New("保存点不属于此批次，或者已经因为提交、回滚而失效")
+	ErrUnsupportedRecordFlags      = errors.New("日志记录的value经过加密，需要配置 EncryptionKey 才能读取")
+	ErrDecryptionFailed            = errors.New("日志记录的value解密失败，EncryptionKey 可能和写入时不一致")
+	ErrManifestMismatch            = errors.New("数据文件和清单不一致，确认数据文件无误后调用 Repair 重新生成清单")
+	ErrDiskQuotaExceeded           = errors.New("写入之后数据目录的大小会超过 MaxDiskSize")
+	ErrNamespaceNameTooLong        = errors.New("命名空间名称不能超过65535字节")
 )
+
+// merge写入出错时，通知并发扫描数据文件的协程提前退出，不会返回给调用方
+var errMergeScanStopped = errors.New("merge扫描已停止")
+
+// 增量merge的结果没有完成（merge目录中缺少merge完成的标识），不能替换原来的文件
+var errMergeNotApplied = errors.New("增量merge的结果未完成，没有生效")
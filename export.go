@@ -0,0 +1,107 @@
+package bitcask_go
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"bitcask-go/data"
+)
+
+const (
+	exportFormat  = "bitcask-export" // 导出文件的格式标识
+	exportVersion = 1                // 导出文件的格式版本
+)
+
+// 导出文件的头部，位于文件第一行
+type exportHeader struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// 导出文件中的一条键值对，key和value可能包含任意字节，json编码时使用base64
+type exportEntry struct {
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value"`
+	Type   string `json:"type"`
+	Expire int64  `json:"expire,omitempty"` // 过期时间，Unix纳秒，为0表示不过期
+}
+
+// 将所有键值对导出到json文件，每行一个json对象，第一行为文件头部；设置了过期时间的key同时导出过期时间，已过期的key不导出
+func (db *DB) ExportToJSON(destFile string) error {
+	file, err := os.OpenFile(destFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	if err := encoder.Encode(&exportHeader{
+		Format:    exportFormat,
+		Version:   exportVersion,
+		Timestamp: time.Now().Unix(),
+	}); err != nil {
+		return err
+	}
+
+	// 遍历所有数据，逐条写入
+	var encodeErr error
+	if err := db.fold(false, func(key []byte, value []byte, pos *data.LogRecordPos) bool {
+		encodeErr = encoder.Encode(&exportEntry{Key: key, Value: value, Type: "normal", Expire: pos.Expire})
+		return encodeErr == nil
+	}); err != nil {
+		return err
+	}
+	if encodeErr != nil {
+		return encodeErr
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// 从 ExportToJSON 导出的json文件中导入所有键值对，保留导出的过期时间，导入时已经过期的key跳过
+func (db *DB) ImportFromJSON(srcFile string) error {
+	file, err := os.Open(srcFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+
+	// 校验文件头部
+	var header exportHeader
+	if err := decoder.Decode(&header); err != nil {
+		return ErrInvalidExportFile
+	}
+	if header.Format != exportFormat || header.Version != exportVersion {
+		return ErrInvalidExportFile
+	}
+
+	for {
+		var entry exportEntry
+		if err := decoder.Decode(&entry); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if entry.Expire != 0 && entry.Expire <= time.Now().UnixNano() {
+			continue
+		}
+		if err := db.put(entry.Key, entry.Value, entry.Expire); err != nil {
+			return err
+		}
+	}
+}
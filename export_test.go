@@ -0,0 +1,100 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_ExportImportJSON(t *testing.T) {
+	src := openTestDB(t, nil)
+	entries := map[string][]byte{
+		"plain":           []byte("value"),
+		"empty":           {},
+		string([]byte{0}): {0xff, 0x00, '\n', '"'},
+		"unicode-键":       []byte("值"),
+		"binary\x01\x02":  {0x80, 0x81, 0xfe},
+	}
+	for key, value := range entries {
+		if err := src.Put([]byte(key), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 已删除的key不导出
+	if err := src.Put([]byte("deleted"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Delete([]byte("deleted")); err != nil {
+		t.Fatal(err)
+	}
+
+	exportFile := filepath.Join(t.TempDir(), "export.json")
+	if err := src.ExportToJSON(exportFile); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := openTestDB(t, nil)
+	if err := dest.ImportFromJSON(exportFile); err != nil {
+		t.Fatal(err)
+	}
+	if keys := dest.ListKeys(); len(keys) != len(entries) {
+		t.Fatalf("expected %d keys, got %d", len(entries), len(keys))
+	}
+	for key, value := range entries {
+		got, err := dest.Get([]byte(key))
+		if err != nil || !bytes.Equal(got, value) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", key, got, err, value)
+		}
+	}
+	if _, err := dest.Get([]byte("deleted")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestDB_ImportInvalidJSON(t *testing.T) {
+	db := openTestDB(t, nil)
+	fileName := filepath.Join(t.TempDir(), "invalid.json")
+	if err := os.WriteFile(fileName, []byte(`{"format":"other","version":1}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ImportFromJSON(fileName); err != ErrInvalidExportFile {
+		t.Fatalf("expected ErrInvalidExportFile, got %v", err)
+	}
+}
+
+// 导出再导入之后设置了过期时间的key保留原来的过期时间，导入时已经过期的key不写入
+func TestDB_ExportImportJSONExpire(t *testing.T) {
+	src := openTestDB(t, nil)
+	if err := src.Put([]byte("persistent"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.PutWithTTL([]byte("short"), []byte("v"), 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	exportFile := filepath.Join(t.TempDir(), "export.json")
+	if err := src.ExportToJSON(exportFile); err != nil {
+		t.Fatal(err)
+	}
+	// 导入之前过期
+	time.Sleep(150 * time.Millisecond)
+
+	dest := openTestDB(t, nil)
+	if err := dest.ImportFromJSON(exportFile); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, dest, map[string]string{"persistent": "v", "ttl": "v"})
+	if pos := dest.index.Get([]byte("persistent")); pos.Expire != 0 {
+		t.Fatalf("expected no expiry, got %d", pos.Expire)
+	}
+	if expected, pos := src.index.Get([]byte("ttl")).Expire, dest.index.Get([]byte("ttl")); pos.Expire != expected {
+		t.Fatalf("expected expiry %d, got %d", expected, pos.Expire)
+	}
+	if dest.index.Get([]byte("short")) != nil {
+		t.Fatal("expected the expired key to be skipped")
+	}
+}
@@ -0,0 +1,46 @@
+package bitcask_go
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"bitcask-go/data"
+)
+
+// 在默认的文件名前加上分片id的数据文件命名，例如 shard-3-000000012.data
+func ShardedNamer(shardId int) func(dirPath string, fileId uint32) string {
+	return func(dirPath string, fileId uint32) string {
+		return filepath.Join(dirPath, fmt.Sprintf("shard-%d-%09d%s", shardId, fileId, data.DataFileNameSuffix))
+	}
+}
+
+// 获取数据文件的路径，配置了 DataFileNamer 时使用自定义的命名
+func (db *DB) getDataFileName(dirPath string, fileId uint32) string {
+	if db.options.DataFileNamer != nil {
+		return db.options.DataFileNamer(dirPath, fileId)
+	}
+	return data.GetDataFileName(dirPath, fileId)
+}
+
+// 根据自定义命名的文件名解析数据文件id，不是数据文件时返回false
+// 依次尝试文件名中的每一段数字，按此id生成的文件名和原文件名一致时即为数据文件
+func (db *DB) parseDataFileId(name string) (uint32, bool) {
+	for start := 0; start < len(name); {
+		if name[start] < '0' || name[start] > '9' {
+			start++
+			continue
+		}
+		end := start
+		for end < len(name) && name[end] >= '0' && name[end] <= '9' {
+			end++
+		}
+		if fileId, err := strconv.ParseUint(name[start:end], 10, 32); err == nil {
+			if filepath.Base(db.options.DataFileNamer(db.options.DirPath, uint32(fileId))) == name {
+				return uint32(fileId), true
+			}
+		}
+		start = end
+	}
+	return 0, false
+}
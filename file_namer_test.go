@@ -0,0 +1,104 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitcask-go/data"
+)
+
+// 目录中的数据文件都使用分片命名
+func expectShardedFiles(t *testing.T, dirPath string, prefix string) {
+	t.Helper()
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			if !strings.HasPrefix(entry.Name(), prefix) {
+				t.Fatalf("unexpected data file %s", entry.Name())
+			}
+			count++
+		}
+	}
+	if count == 0 {
+		t.Fatal("expected data files")
+	}
+}
+
+func TestDB_DataFileNamer(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.MMapAtStartup = true },
+		func(options *Options) { options.IndexType = BPlusTree },
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 8 * 1024
+			options.DataFileNamer = ShardedNamer(3)
+			if configure != nil {
+				configure(options)
+			}
+		})
+		writeFlushData(t, db, 0)
+		expectShardedFiles(t, db.options.DirPath, "shard-3-")
+		if filepath.Base(db.getDataFileName(db.options.DirPath, 12)) != "shard-3-000000012.data" {
+			t.Fatalf("unexpected file name %s", db.getDataFileName(db.options.DirPath, 12))
+		}
+
+		// 其他分片的文件不会被当作数据文件加载
+		if err := os.WriteFile(filepath.Join(db.options.DirPath, "shard-4-000000001.data"), []byte("garbage"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		expected := dumpDB(t, db)
+		db = reopenTestDB(t, db)
+		expectContent(t, db, expected)
+
+		if db.options.IndexType != BPlusTree {
+			if err := db.MergeForce(); err != nil {
+				t.Fatal(err)
+			}
+			db = reopenTestDB(t, db)
+			expectContent(t, db, expected)
+
+			writeFlushData(t, db, 1)
+			if err := db.Vacuum(); err != nil {
+				t.Fatal(err)
+			}
+			expected = dumpDB(t, db)
+			db = reopenTestDB(t, db)
+			expectContent(t, db, expected)
+		}
+		_ = db.Close()
+	}
+}
+
+func TestDB_ParseDataFileId(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileNamer = ShardedNamer(7)
+	})
+	for name, expected := range map[string]int64{
+		"shard-7-000000000.data":  0,
+		"shard-7-000000123.data":  123,
+		"shard-7-4294967295.data": 4294967295,
+		"shard-8-000000123.data":  -1,
+		"000000123.data":          -1,
+		"shard-7-000000123.vlog":  -1,
+		"shard-7-123.data":        -1,
+		"hint-index":              -1,
+	} {
+		fileId, ok := db.parseDataFileId(name)
+		if expected < 0 {
+			if ok {
+				t.Fatalf("%s: expected not a data file, got %d", name, fileId)
+			}
+			continue
+		}
+		if !ok || int64(fileId) != expected {
+			t.Fatalf("%s: expected %d, got %d, %v", name, expected, fileId, ok)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package fio
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	ChecksumBlockSize = 4 * 1024                             // 按块校验时，每个块在磁盘上的大小
+	blockSlotSize     = 8                                    // 一个校验槽的大小：len(2字节) + gen(2字节) + crc(4字节)
+	blockTrailerSize  = 2 * blockSlotSize                    // 块末尾两个校验槽的大小
+	blockPayloadSize  = ChecksumBlockSize - blockTrailerSize // 每个块中实际数据的大小
+)
+
+var (
+	ErrInvalidBlockCRC = errors.New("invalid block crc, data file maybe corrupted") // 块的 crc 校验失败
+)
+
+// BlockChecksumIO 按块校验的 IO
+// 磁盘上的数据按固定的 4KB 分块，每个块末尾有两个校验槽，记录块中有效数据的长度、槽的版本号和 crc，
+// 读取时使用通过校验且版本号较新的槽，最后一个块可能不满（不满的部分填充0）
+// 向最后一个块追加数据时，新数据写在原有数据之后，新的校验写入另一个槽，原有数据和校验都不会被覆盖，
+// 写入过程中进程异常退出时，最后一个块仍然可以按原来的槽读取
+// 对外暴露的偏移量和大小都是去掉校验槽之后的逻辑值，上层无需感知分块
+type BlockChecksumIO struct {
+	inner    IOManager
+	fileName string
+	writer   *os.File // 按位置写入的文件句柄（inner 以追加方式打开，无法覆盖写入），第一次写入时打开
+	size     int64    // 逻辑大小
+	tail     []byte   // 最后一个不满的块中的数据
+	trailer  []byte   // 最后一个不满的块的两个校验槽
+	slot     int      // 最后一个不满的块中最新的校验槽
+}
+
+// NewBlockChecksumIO 使用按块校验包装 IOManager，不会修改文件
+// 默认只读取最后一个块确定文件大小；scanAll 为 true 时从头校验每个块，以第一个不满或无法通过校验的块作为末尾，
+// 用于活跃文件：进程异常退出时，末尾块的校验可能还没有写入，而其后的块已经写入了磁盘
+func NewBlockChecksumIO(inner IOManager, fileName string, scanAll bool) (*BlockChecksumIO, error) {
+	physical, err := inner.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	// 物理上不完整的块没有写完，视为不存在
+	blocks := physical / ChecksumBlockSize
+	bio := &BlockChecksumIO{inner: inner, fileName: fileName}
+	bio.resetTail()
+
+	var blockIndex int64
+	if !scanAll && blocks > 0 {
+		blockIndex = blocks - 1
+	}
+	block := make([]byte, ChecksumBlockSize)
+	for ; blockIndex < blocks; blockIndex++ {
+		if _, err := inner.Read(block, blockIndex*ChecksumBlockSize); err != nil && err != io.EOF {
+			return nil, err
+		}
+		payload, slot, ok := parseBlock(block)
+		if ok && len(payload) == blockPayloadSize {
+			continue
+		}
+
+		// 找到末尾块，无法通过校验的块视为空块
+		bio.size = blockIndex * blockPayloadSize
+		if ok {
+			bio.size += int64(len(payload))
+			bio.tail = append(bio.tail, payload...)
+			copy(bio.trailer, block[blockPayloadSize:])
+			bio.slot = slot
+		}
+		return bio, nil
+	}
+	bio.size = blocks * blockPayloadSize
+	return bio, nil
+}
+
+// 解析块末尾的校验槽，返回块中的有效数据和使用的槽
+func parseBlock(block []byte) ([]byte, int, bool) {
+	best, found := 0, false
+	var bestLen, bestGen uint16
+	for slot := 0; slot < 2; slot++ {
+		l, gen, ok := verifySlot(block, slot)
+		if !ok {
+			continue
+		}
+		// 版本号可能回绕，按差值判断新旧
+		if !found || int16(gen-bestGen) > 0 {
+			best, bestLen, bestGen, found = slot, l, gen, true
+		}
+	}
+	if !found {
+		return nil, 0, false
+	}
+	return block[:bestLen], best, true
+}
+
+// 校验一个槽，返回槽中记录的数据长度和版本号
+func verifySlot(block []byte, slot int) (uint16, uint16, bool) {
+	buf := block[blockPayloadSize+slot*blockSlotSize:]
+	l := binary.LittleEndian.Uint16(buf[0:2])
+	gen := binary.LittleEndian.Uint16(buf[2:4])
+	if l > blockPayloadSize {
+		return 0, 0, false
+	}
+	return l, gen, slotCRC(block[:l], buf[:4]) == binary.LittleEndian.Uint32(buf[4:8])
+}
+
+// 计算槽的 crc，覆盖块中的有效数据以及槽中的长度和版本号
+func slotCRC(payload []byte, header []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(payload), crc32.IEEETable, header)
+}
+
+// 将末尾块的新长度写入较旧的槽，成为最新的槽
+func (bio *BlockChecksumIO) writeSlot(tail []byte) {
+	gen := binary.LittleEndian.Uint16(bio.trailer[bio.slot*blockSlotSize+2:])
+	slot := 1 - bio.slot
+	buf := bio.trailer[slot*blockSlotSize:]
+	binary.LittleEndian.PutUint16(buf[0:2], uint16(len(tail)))
+	binary.LittleEndian.PutUint16(buf[2:4], gen+1)
+	binary.LittleEndian.PutUint32(buf[4:8], slotCRC(tail, buf[:4]))
+	bio.slot = slot
+}
+
+// 开始一个新的空块
+func (bio *BlockChecksumIO) resetTail() {
+	bio.tail = make([]byte, 0, blockPayloadSize)
+	bio.trailer = make([]byte, blockTrailerSize)
+	// 新块中两个槽都为空，第一次写入槽0
+	bio.slot = 1
+}
+
+func (bio *BlockChecksumIO) Read(b []byte, offset int64) (int, error) {
+	var n int
+	for n < len(b) {
+		pos := offset + int64(n)
+		if pos >= bio.size {
+			return n, io.EOF
+		}
+
+		blockIndex := pos / blockPayloadSize
+		payload, err := bio.readBlock(blockIndex)
+		if err != nil {
+			return n, err
+		}
+		n += copy(b[n:], payload[pos-blockIndex*blockPayloadSize:])
+	}
+	return n, nil
+}
+
+// 将逻辑范围换算为磁盘上所在的块，交给底层的文件预读
+func (bio *BlockChecksumIO) Prefetch(offset, length int64) {
+	prefetcher, ok := bio.inner.(Prefetcher)
+	if !ok {
+		return
+	}
+	firstBlock := offset / blockPayloadSize
+	lastBlock := (offset + length - 1) / blockPayloadSize
+	prefetcher.Prefetch(firstBlock*ChecksumBlockSize, (lastBlock-firstBlock+1)*ChecksumBlockSize)
+}
+
+// 读取并校验一个块，返回块中的数据
+func (bio *BlockChecksumIO) readBlock(blockIndex int64) ([]byte, error) {
+	// 最后一个不满的块保存在内存中
+	if blockIndex == bio.size/blockPayloadSize {
+		return bio.tail, nil
+	}
+
+	block := make([]byte, ChecksumBlockSize)
+	if _, err := bio.inner.Read(block, blockIndex*ChecksumBlockSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	payload, _, ok := parseBlock(block)
+	if !ok || len(payload) != blockPayloadSize {
+		return nil, ErrInvalidBlockCRC
+	}
+	return payload, nil
+}
+
+// 打开按位置写入的文件句柄
+func (bio *BlockChecksumIO) openWriter() error {
+	if bio.writer != nil {
+		return nil
+	}
+	fd, err := os.OpenFile(bio.fileName, os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return err
+	}
+	bio.writer = fd
+	return nil
+}
+
+// 从末尾块的有效数据之后开始，一次写入新数据以及涉及到的所有块的校验槽
+func (bio *BlockChecksumIO) Write(b []byte) (int, error) {
+	if err := bio.openWriter(); err != nil {
+		return 0, err
+	}
+
+	// 写入成功之前不修改末尾块的状态
+	tail, trailer, slot := bio.tail, bio.trailer, bio.slot
+	bio.tail = append(make([]byte, 0, blockPayloadSize), tail...)
+	bio.trailer = append([]byte(nil), trailer...)
+
+	offset := bio.size/blockPayloadSize*ChecksumBlockSize + int64(len(bio.tail))
+	buf := make([]byte, 0, len(b)+(len(b)/blockPayloadSize+2)*blockTrailerSize)
+	rest := b
+	for len(rest) > 0 {
+		n := blockPayloadSize - len(bio.tail)
+		if n > len(rest) {
+			n = len(rest)
+		}
+		bio.tail = append(bio.tail, rest[:n]...)
+		buf = append(buf, rest[:n]...)
+		rest = rest[n:]
+
+		// 块写满或数据写完时，写入块的校验槽，不满的部分填充0
+		if len(bio.tail) == blockPayloadSize || len(rest) == 0 {
+			buf = append(buf, make([]byte, blockPayloadSize-len(bio.tail))...)
+			bio.writeSlot(bio.tail)
+			buf = append(buf, bio.trailer...)
+		}
+		if len(bio.tail) == blockPayloadSize {
+			bio.resetTail()
+		}
+	}
+
+	if _, err := bio.writer.WriteAt(buf, offset); err != nil {
+		bio.tail, bio.trailer, bio.slot = tail, trailer, slot
+		return 0, err
+	}
+	bio.size += int64(len(b))
+	return len(b), nil
+}
+
+// Truncate 将文件截断到指定的逻辑大小（用于丢弃活跃文件末尾不完整的记录），新的末尾块写入新的校验槽
+func (bio *BlockChecksumIO) Truncate(size int64) error {
+	if size >= bio.size {
+		return nil
+	}
+	if err := bio.openWriter(); err != nil {
+		return err
+	}
+
+	blockIndex := size / blockPayloadSize
+	tailLen := size % blockPayloadSize
+	physical := blockIndex * ChecksumBlockSize
+	if tailLen == 0 {
+		bio.resetTail()
+	} else {
+		// 新的末尾块原本是一个已经写满的块时，从磁盘中读取它的数据和校验槽
+		if blockIndex != bio.size/blockPayloadSize {
+			block := make([]byte, ChecksumBlockSize)
+			if _, err := bio.inner.Read(block, physical); err != nil && err != io.EOF {
+				return err
+			}
+			payload, slot, ok := parseBlock(block)
+			if !ok {
+				return ErrInvalidBlockCRC
+			}
+			bio.tail = append(bio.tail[:0], payload...)
+			copy(bio.trailer, block[blockPayloadSize:])
+			bio.slot = slot
+		}
+		bio.tail = bio.tail[:tailLen]
+		bio.writeSlot(bio.tail)
+		if _, err := bio.writer.WriteAt(bio.trailer, physical+blockPayloadSize); err != nil {
+			return err
+		}
+		physical += ChecksumBlockSize
+	}
+
+	bio.size = size
+	return bio.writer.Truncate(physical)
+}
+
+func (bio *BlockChecksumIO) Sync() error {
+	if bio.writer != nil {
+		return bio.writer.Sync()
+	}
+	return bio.inner.Sync()
+}
+
+func (bio *BlockChecksumIO) Close() error {
+	if bio.writer != nil {
+		if err := bio.writer.Close(); err != nil {
+			return err
+		}
+	}
+	return bio.inner.Close()
+}
+
+func (bio *BlockChecksumIO) Size() (int64, error) {
+	return bio.size, nil
+}
@@ -0,0 +1,250 @@
+package fio
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestBlockChecksumIO(t *testing.T, fileName string, scanAll bool) *BlockChecksumIO {
+	t.Helper()
+	inner, err := NewFileIOManager(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bio, err := NewBlockChecksumIO(inner, fileName, scanAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = bio.Close() })
+	return bio
+}
+
+func readAll(t *testing.T, bio *BlockChecksumIO) []byte {
+	t.Helper()
+	size, _ := bio.Size()
+	buf := make([]byte, size)
+	if _, err := bio.Read(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func testPayload(n int, seed byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = seed + byte(i%251)
+	}
+	return b
+}
+
+func TestBlockChecksumIO_WriteRead(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "000000000.data")
+	bio := openTestBlockChecksumIO(t, fileName, true)
+
+	// 覆盖块内追加、刚好写满一个块以及跨越多个块的写入
+	var expected []byte
+	for _, n := range []int{10, 100, blockPayloadSize - 110, 1, 3 * blockPayloadSize, 7} {
+		b := testPayload(n, byte(n))
+		if _, err := bio.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, b...)
+	}
+	if !bytes.Equal(readAll(t, bio), expected) {
+		t.Fatal("data mismatch")
+	}
+	if _, err := bio.Read(make([]byte, 1), int64(len(expected))); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if err := bio.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 物理文件由完整的块组成
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size()%ChecksumBlockSize != 0 {
+		t.Fatalf("physical size %d is not block aligned", stat.Size())
+	}
+
+	for _, scanAll := range []bool{false, true} {
+		reopened := openTestBlockChecksumIO(t, fileName, scanAll)
+		if size, _ := reopened.Size(); size != int64(len(expected)) {
+			t.Fatalf("scanAll=%v: expected size %d, got %d", scanAll, len(expected), size)
+		}
+		if !bytes.Equal(readAll(t, reopened), expected) {
+			t.Fatalf("scanAll=%v: data mismatch after reopen", scanAll)
+		}
+	}
+}
+
+func TestBlockChecksumIO_AppendAfterReopen(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "000000000.data")
+	first := testPayload(100, 1)
+	bio := openTestBlockChecksumIO(t, fileName, true)
+	if _, err := bio.Write(first); err != nil {
+		t.Fatal(err)
+	}
+	_ = bio.Close()
+
+	second := testPayload(2*blockPayloadSize, 2)
+	reopened := openTestBlockChecksumIO(t, fileName, true)
+	if _, err := reopened.Write(second); err != nil {
+		t.Fatal(err)
+	}
+	expected := append(append([]byte(nil), first...), second...)
+	if !bytes.Equal(readAll(t, reopened), expected) {
+		t.Fatal("data mismatch")
+	}
+}
+
+// 模拟写入末尾块时进程异常退出：新的校验槽没有写入，而新数据和后续块已经写入磁盘
+func TestBlockChecksumIO_TornWrite(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "000000000.data")
+	bio := openTestBlockChecksumIO(t, fileName, true)
+	synced := testPayload(100, 1)
+	if _, err := bio.Write(synced); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bio.Write(testPayload(3*blockPayloadSize, 2)); err != nil {
+		t.Fatal(err)
+	}
+	_ = bio.Close()
+
+	// 恢复第一个块的校验槽，之后的块保持已写入的状态
+	after, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(after[blockPayloadSize:ChecksumBlockSize], before[blockPayloadSize:ChecksumBlockSize])
+	if err := os.WriteFile(fileName, after, DataFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestBlockChecksumIO(t, fileName, true)
+	if !bytes.Equal(readAll(t, reopened), synced) {
+		t.Fatal("expected only the synced data")
+	}
+
+	// 打开文件不会修改文件
+	current, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(current, after) {
+		t.Fatal("file modified on open")
+	}
+
+	// 继续写入会覆盖残留的块
+	more := testPayload(10, 3)
+	if _, err := reopened.Write(more); err != nil {
+		t.Fatal(err)
+	}
+	_ = reopened.Close()
+	expected := append(append([]byte(nil), synced...), more...)
+	if !bytes.Equal(readAll(t, openTestBlockChecksumIO(t, fileName, true)), expected) {
+		t.Fatal("data mismatch after append")
+	}
+}
+
+// 末尾块无法通过校验时，丢弃该块，前面完整的块不受影响
+func TestBlockChecksumIO_CorruptedTail(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "000000000.data")
+	bio := openTestBlockChecksumIO(t, fileName, true)
+	full := testPayload(blockPayloadSize, 1)
+	if _, err := bio.Write(full); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bio.Write(testPayload(50, 2)); err != nil {
+		t.Fatal(err)
+	}
+	_ = bio.Close()
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[ChecksumBlockSize+10] ^= 0xff
+	// 再附加半个块，模拟没有写完的块
+	content = append(content, make([]byte, ChecksumBlockSize/2)...)
+	if err := os.WriteFile(fileName, content, DataFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scanAll := range []bool{false, true} {
+		reopened := openTestBlockChecksumIO(t, fileName, scanAll)
+		if !bytes.Equal(readAll(t, reopened), full) {
+			t.Fatalf("scanAll=%v: expected only the full block", scanAll)
+		}
+	}
+}
+
+func TestBlockChecksumIO_CorruptedBlock(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "000000000.data")
+	bio := openTestBlockChecksumIO(t, fileName, false)
+	if _, err := bio.Write(testPayload(2*blockPayloadSize+10, 1)); err != nil {
+		t.Fatal(err)
+	}
+	_ = bio.Close()
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[10] ^= 0xff
+	if err := os.WriteFile(fileName, content, DataFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestBlockChecksumIO(t, fileName, false)
+	if _, err := reopened.Read(make([]byte, 10), 0); err != ErrInvalidBlockCRC {
+		t.Fatalf("expected ErrInvalidBlockCRC, got %v", err)
+	}
+	if _, err := reopened.Read(make([]byte, 10), blockPayloadSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlockChecksumIO_Truncate(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "000000000.data")
+	bio := openTestBlockChecksumIO(t, fileName, true)
+	data := testPayload(3*blockPayloadSize+100, 1)
+	if _, err := bio.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// 截断到末尾块内、已写满的块内以及块的边界
+	for _, size := range []int64{3*blockPayloadSize + 50, blockPayloadSize + 7, blockPayloadSize} {
+		if err := bio.Truncate(size); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(readAll(t, bio), data[:size]) {
+			t.Fatalf("size %d: data mismatch", size)
+		}
+		reopened := openTestBlockChecksumIO(t, fileName, true)
+		if !bytes.Equal(readAll(t, reopened), data[:size]) {
+			t.Fatalf("size %d: data mismatch after reopen", size)
+		}
+		_ = reopened.Close()
+	}
+
+	// 截断后继续写入
+	more := testPayload(20, 9)
+	if _, err := bio.Write(more); err != nil {
+		t.Fatal(err)
+	}
+	_ = bio.Close()
+	expected := append(append([]byte(nil), data[:blockPayloadSize]...), more...)
+	if !bytes.Equal(readAll(t, openTestBlockChecksumIO(t, fileName, true)), expected) {
+		t.Fatal("data mismatch after append")
+	}
+}
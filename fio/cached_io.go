@@ -0,0 +1,238 @@
+package fio
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"sync"
+)
+
+var ErrTruncateUnsupported = errors.New("io manager does not support truncate")
+
+// 缓存块的大小
+const BlockSize = 4 * 1024
+
+// 缓存块的key：文件id + 块在文件中的起始偏移
+type blockKey struct {
+	fileId      uint32
+	blockOffset int64
+}
+
+// 缓存块
+type cacheBlock struct {
+	key  blockKey
+	data []byte // 块中的数据，文件末尾的块可能不足 BlockSize
+}
+
+// BlockCache 数据文件读取的 LRU 块缓存，所有数据文件共享
+type BlockCache struct {
+	mu       sync.Mutex
+	capacity int                        // 最多缓存的块数量
+	lruList  *list.List                 // 最近使用的块在前面
+	blocks   map[blockKey]*list.Element // 块的索引
+	hits     uint64                     // 命中次数
+	misses   uint64                     // 未命中次数
+}
+
+// NewBlockCache 初始化块缓存，size 为缓存占用的字节数
+func NewBlockCache(size int64) *BlockCache {
+	capacity := int(size / BlockSize)
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &BlockCache{
+		capacity: capacity,
+		lruList:  list.New(),
+		blocks:   make(map[blockKey]*list.Element),
+	}
+}
+
+// 命中次数
+func (bc *BlockCache) Hits() uint64 {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.hits
+}
+
+// 未命中次数
+func (bc *BlockCache) Misses() uint64 {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.misses
+}
+
+// 命中率
+func (bc *BlockCache) HitRatio() float64 {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.hits+bc.misses == 0 {
+		return 0
+	}
+	return float64(bc.hits) / float64(bc.hits+bc.misses)
+}
+
+// 将命中和未命中次数清零，缓存的块保持不变
+func (bc *BlockCache) ResetStats() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.hits = 0
+	bc.misses = 0
+}
+
+func (bc *BlockCache) get(key blockKey) ([]byte, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	elem, ok := bc.blocks[key]
+	if !ok {
+		bc.misses++
+		return nil, false
+	}
+	bc.hits++
+	bc.lruList.MoveToFront(elem)
+	return elem.Value.(*cacheBlock).data, true
+}
+
+func (bc *BlockCache) put(key blockKey, data []byte) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if elem, ok := bc.blocks[key]; ok {
+		elem.Value.(*cacheBlock).data = data
+		bc.lruList.MoveToFront(elem)
+		return
+	}
+	bc.blocks[key] = bc.lruList.PushFront(&cacheBlock{key: key, data: data})
+
+	// 超出容量时淘汰最久未使用的块
+	for bc.lruList.Len() > bc.capacity {
+		oldest := bc.lruList.Back()
+		bc.lruList.Remove(oldest)
+		delete(bc.blocks, oldest.Value.(*cacheBlock).key)
+	}
+}
+
+// 删除文件中 [start, end) 范围内的块
+func (bc *BlockCache) invalidate(fileId uint32, start, end int64) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for blockOffset := start / BlockSize * BlockSize; blockOffset < end; blockOffset += BlockSize {
+		key := blockKey{fileId: fileId, blockOffset: blockOffset}
+		if elem, ok := bc.blocks[key]; ok {
+			bc.lruList.Remove(elem)
+			delete(bc.blocks, key)
+		}
+	}
+}
+
+// 删除文件的所有块
+func (bc *BlockCache) removeFile(fileId uint32) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for key, elem := range bc.blocks {
+		if key.fileId == fileId {
+			bc.lruList.Remove(elem)
+			delete(bc.blocks, key)
+		}
+	}
+}
+
+// CachedIOManager 带块缓存的 IO，读取时优先从缓存中获取
+type CachedIOManager struct {
+	inner  IOManager
+	cache  *BlockCache
+	fileId uint32
+	size   int64 // 文件大小，写入时累加，避免每次写入都获取文件大小
+}
+
+// NewCachedIOManager 使用块缓存包装 IOManager
+func NewCachedIOManager(inner IOManager, cache *BlockCache, fileId uint32) (*CachedIOManager, error) {
+	size, err := inner.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &CachedIOManager{
+		inner:  inner,
+		cache:  cache,
+		fileId: fileId,
+		size:   size,
+	}, nil
+}
+
+func (cio *CachedIOManager) Read(b []byte, offset int64) (int, error) {
+	var n int
+	for n < len(b) {
+		pos := offset + int64(n)
+		blockOffset := pos / BlockSize * BlockSize
+		block, err := cio.readBlock(blockOffset)
+		if err != nil {
+			return n, err
+		}
+
+		// 读取到的块不足时说明已到文件末尾
+		inBlock := int(pos - blockOffset)
+		if inBlock >= len(block) {
+			return n, io.EOF
+		}
+		n += copy(b[n:], block[inBlock:])
+	}
+	return n, nil
+}
+
+// 预读交给底层的文件，缓存中的块不需要预读
+func (cio *CachedIOManager) Prefetch(offset, length int64) {
+	if prefetcher, ok := cio.inner.(Prefetcher); ok {
+		prefetcher.Prefetch(offset, length)
+	}
+}
+
+// 读取一个块，未命中时从文件中读取并放入缓存
+func (cio *CachedIOManager) readBlock(blockOffset int64) ([]byte, error) {
+	key := blockKey{fileId: cio.fileId, blockOffset: blockOffset}
+	if block, ok := cio.cache.get(key); ok {
+		return block, nil
+	}
+
+	block := make([]byte, BlockSize)
+	n, err := cio.inner.Read(block, blockOffset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	block = block[:n]
+	cio.cache.put(key, block)
+	return block, nil
+}
+
+// 写入会改变文件末尾的块，需要让这些块失效
+func (cio *CachedIOManager) Write(b []byte) (int, error) {
+	n, err := cio.inner.Write(b)
+	cio.cache.invalidate(cio.fileId, cio.size, cio.size+int64(len(b)))
+	cio.size += int64(n)
+	return n, err
+}
+
+// 截断文件，并让截断位置之后的块失效
+func (cio *CachedIOManager) Truncate(size int64) error {
+	truncater, ok := cio.inner.(Truncater)
+	if !ok {
+		return ErrTruncateUnsupported
+	}
+	if err := truncater.Truncate(size); err != nil {
+		return err
+	}
+	cio.cache.invalidate(cio.fileId, size, cio.size)
+	cio.size = size
+	return nil
+}
+
+func (cio *CachedIOManager) Sync() error {
+	return cio.inner.Sync()
+}
+
+// 关闭文件时释放它占用的缓存
+func (cio *CachedIOManager) Close() error {
+	cio.cache.removeFile(cio.fileId)
+	return cio.inner.Close()
+}
+
+func (cio *CachedIOManager) Size() (int64, error) {
+	return cio.inner.Size()
+}
@@ -31,6 +31,11 @@ func (fio *FileIO) Write(b []byte) (int, error) {
 	return fio.fd.Write(b)
 }
 
+// 截断文件，以追加方式打开的文件之后的写入从新的末尾开始
+func (fio *FileIO) Truncate(size int64) error {
+	return fio.fd.Truncate(size)
+}
+
 func (fio *FileIO) Sync() error {
 	return fio.fd.Sync()
 }
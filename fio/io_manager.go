@@ -10,6 +10,15 @@ const (
 
 	// 内存文件映射
 	MemoryMap
+
+	// 可写的内存文件映射（仅用于活跃文件）
+	WritableMMap
+
+	// 纯内存文件，不读写磁盘（内存模式）
+	MemoryFIO
+
+	// io_uring 文件IO（仅 Linux，其他平台或者内核不支持时退回标准文件IO）
+	IOUring
 )
 
 // 自定义文件读写接口
@@ -30,6 +39,18 @@ type IOManager interface {
 	Size() (int64, error)
 }
 
+// 支持截断的文件读写接口，按块校验时文件大小是逻辑值，不能直接截断文件
+type Truncater interface {
+	// 将文件截断到指定大小
+	Truncate(int64) error
+}
+
+// 支持预读的文件读写接口
+type Prefetcher interface {
+	// 提示即将顺序读取 [offset, offset+length) 范围内的数据，让操作系统提前读入页缓存；只是提示，失败时忽略
+	Prefetch(offset, length int64)
+}
+
 // 初始化NewIOManager
 func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 	// 根据文件名创建文件管理器
@@ -38,6 +59,12 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case WritableMMap:
+		return NewWritableMMapIOManager(fileName)
+	case MemoryFIO:
+		return NewMemoryIOManager(), nil
+	case IOUring:
+		return NewIOUringManager(fileName)
 	default:
 		panic("unsupported io type")
 	}
@@ -0,0 +1,285 @@
+//go:build linux
+
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+const (
+	// 每个文件未完成的写入数量上限（多个协程并发写入时）
+	ioUringEntries = 256
+
+	// 注册的固定读缓冲区数量和大小，超过大小的读取直接读入调用方的缓冲区
+	ioUringReadBufferNum  = 8
+	ioUringReadBufferSize = 64 * 1024
+)
+
+var errIOUringClosed = errors.New("io_uring file is closed")
+
+// 基于 io_uring 的文件IO（仅 Linux）
+// 写入提交到提交队列后等待自己的完成事件再返回，多个协程并发写入时可以同时有多个未完成的写入；读取、同步和截断之前等待相关的写入完成
+// 读取使用预先注册的固定缓冲区，内核不需要在每次IO时映射用户内存
+// 没有使用 iceber/iouring-go：它通过 linkname 引用标准库的未导出符号，Go 1.23 及以上版本必须加上 -ldflags=-checklinkname=0 才能链接，
+// 只能放在单独的构建标签后面，默认构建中无法使用，因此直接使用系统调用实现（见 io_uring_ring.go）
+type IOUringManager struct {
+	file *os.File
+	fd   int
+	ring *ioUring
+
+	mu       sync.Mutex
+	cond     *sync.Cond // 写入完成时通知等待的读取、同步和关闭
+	offset   int64      // 已提交的写入末尾，也是下一次写入的位置
+	written  int64      // 没有未完成写入时的文件末尾，之前的数据可以直接读取
+	inflight int        // 未完成的写入数量
+	err      error      // 写入失败的错误，文件中可能留下空洞，截断之前之后的写入和同步都返回这个错误
+	closed   bool
+
+	readBuffers [][]byte    // 注册的固定读缓冲区
+	freeBuffers chan uint16 // 空闲的读缓冲区下标
+}
+
+// 创建基于 io_uring 的文件管理器，内核不支持 io_uring 或者无法注册固定缓冲区时退回标准文件IO
+func NewIOUringManager(fileName string) (IOManager, error) {
+	// 写入和读取各自最多 ioUringEntries 个未完成的请求，完成队列的大小是提交队列的两倍
+	ring, err := newIOUring(2 * ioUringEntries)
+	if err != nil {
+		return NewFileIOManager(fileName)
+	}
+	readBuffers := make([][]byte, ioUringReadBufferNum)
+	freeBuffers := make(chan uint16, ioUringReadBufferNum)
+	for i := range readBuffers {
+		readBuffers[i] = make([]byte, ioUringReadBufferSize)
+		freeBuffers <- uint16(i)
+	}
+	if err := ring.registerBuffers(readBuffers); err != nil {
+		_ = ring.close()
+		return NewFileIOManager(fileName)
+	}
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		_ = ring.close()
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = ring.close()
+		_ = file.Close()
+		return nil, err
+	}
+
+	ium := &IOUringManager{
+		file:        file,
+		fd:          int(file.Fd()),
+		ring:        ring,
+		offset:      stat.Size(),
+		written:     stat.Size(),
+		readBuffers: readBuffers,
+		freeBuffers: freeBuffers,
+	}
+	ium.cond = sync.NewCond(&ium.mu)
+	return ium, nil
+}
+
+// 写入完成时记录结果，写入失败或者没有写完时保存并返回错误
+func (ium *IOUringManager) writeDone(buf []byte, res int32) error {
+	var err error
+	if res < 0 {
+		err = syscall.Errno(-res)
+	} else if int(res) != len(buf) {
+		err = io.ErrShortWrite
+	}
+	ium.finishWrite(err)
+	return err
+}
+
+// 结束一次写入（访问此方法前不能持有锁）
+func (ium *IOUringManager) finishWrite(err error) {
+	ium.mu.Lock()
+	defer ium.mu.Unlock()
+	if err != nil && ium.err == nil {
+		ium.err = err
+	}
+	ium.inflight--
+	if ium.inflight == 0 {
+		ium.written = ium.offset
+	}
+	ium.cond.Broadcast()
+}
+
+// 等待所有已提交的写入完成（访问此方法前必须持有锁）
+func (ium *IOUringManager) waitWrites() {
+	for ium.inflight > 0 {
+		ium.cond.Wait()
+	}
+}
+
+// 读取语义和 os.File.ReadAt 一致，读到末尾时返回 io.EOF
+func (ium *IOUringManager) Read(b []byte, offset int64) (int, error) {
+	ium.mu.Lock()
+	if ium.closed {
+		ium.mu.Unlock()
+		return 0, errIOUringClosed
+	}
+	// 读取范围内还有未完成的写入
+	if offset+int64(len(b)) > ium.written {
+		ium.waitWrites()
+	}
+	ium.mu.Unlock()
+
+	var read int
+	for read < len(b) {
+		n, err := ium.pread(b[read:], offset+int64(read))
+		if err != nil {
+			return read, err
+		}
+		if n == 0 {
+			return read, io.EOF
+		}
+		read += n
+	}
+	return read, nil
+}
+
+// 读取一次，不超过固定缓冲区大小时读入空闲的固定缓冲区再复制
+func (ium *IOUringManager) pread(b []byte, offset int64) (int, error) {
+	if len(b) > ioUringReadBufferSize {
+		return ium.submitAndWait(func(sqe *ioUringSQE) {
+			sqe.prepRW(ioringOpRead, ium.fd, b, offset)
+		})
+	}
+	index := <-ium.freeBuffers
+	defer func() { ium.freeBuffers <- index }()
+	buf := ium.readBuffers[index][:len(b)]
+	n, err := ium.submitAndWait(func(sqe *ioUringSQE) {
+		sqe.prepRW(ioringOpReadFixed, ium.fd, buf, offset)
+		sqe.bufIndex = index
+	})
+	if n > 0 {
+		copy(b, buf[:n])
+	}
+	return n, err
+}
+
+// 提交请求并等待完成，返回内核的结果
+func (ium *IOUringManager) submitAndWait(prep func(sqe *ioUringSQE)) (int, error) {
+	result := make(chan int32, 1)
+	if err := ium.ring.submit(prep, func(res int32) { result <- res }); err != nil {
+		return 0, err
+	}
+	res := <-result
+	if res < 0 {
+		return 0, syscall.Errno(-res)
+	}
+	return int(res), nil
+}
+
+// 提交写入并等待完成，返回这次写入的结果；之前的写入失败并且还没有截断时返回之前的错误
+func (ium *IOUringManager) Write(b []byte) (int, error) {
+	ium.mu.Lock()
+	if ium.closed {
+		ium.mu.Unlock()
+		return 0, errIOUringClosed
+	}
+	for ium.inflight >= ioUringEntries && ium.err == nil {
+		ium.cond.Wait()
+	}
+	if ium.err != nil {
+		err := ium.err
+		ium.mu.Unlock()
+		return 0, err
+	}
+	if len(b) == 0 {
+		ium.mu.Unlock()
+		return 0, nil
+	}
+	// 先占用写入位置，提交时不持有锁，否则提交队列已满时收集协程无法在完成回调中获取锁
+	offset := ium.offset
+	ium.offset += int64(len(b))
+	ium.inflight++
+	ium.mu.Unlock()
+
+	// b 由完成回调引用，写入完成之前不会被回收
+	result := make(chan error, 1)
+	err := ium.ring.submit(func(sqe *ioUringSQE) {
+		sqe.prepRW(ioringOpWrite, ium.fd, b, offset)
+	}, func(res int32) {
+		result <- ium.writeDone(b, res)
+	})
+	if err != nil {
+		ium.finishWrite(err)
+		return 0, err
+	}
+	if err := <-result; err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// 等待所有写入完成后持久化
+func (ium *IOUringManager) Sync() error {
+	ium.mu.Lock()
+	if ium.closed {
+		ium.mu.Unlock()
+		return errIOUringClosed
+	}
+	ium.waitWrites()
+	err := ium.err
+	ium.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	_, err = ium.submitAndWait(func(sqe *ioUringSQE) {
+		sqe.opcode = ioringOpFsync
+		sqe.fd = int32(ium.fd)
+	})
+	return err
+}
+
+// 截断文件，之后的写入从新的末尾开始
+func (ium *IOUringManager) Truncate(size int64) error {
+	ium.mu.Lock()
+	defer ium.mu.Unlock()
+	if ium.closed {
+		return errIOUringClosed
+	}
+	ium.waitWrites()
+	if err := ium.file.Truncate(size); err != nil {
+		return err
+	}
+	// 失败的写入已经返回给调用方，截断之后文件中不再有空洞
+	ium.offset = size
+	ium.written = size
+	ium.err = nil
+	return nil
+}
+
+// 等待所有写入完成后关闭，写入的错误已经由各自的 Write 返回
+func (ium *IOUringManager) Close() error {
+	ium.mu.Lock()
+	if ium.closed {
+		ium.mu.Unlock()
+		return nil
+	}
+	ium.waitWrites()
+	ium.closed = true
+	ium.mu.Unlock()
+
+	err := ium.ring.close()
+	if closeErr := ium.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 文件大小包括已提交但未完成的写入
+func (ium *IOUringManager) Size() (int64, error) {
+	ium.mu.Lock()
+	defer ium.mu.Unlock()
+	return ium.offset, nil
+}
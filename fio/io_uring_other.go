@@ -0,0 +1,8 @@
+//go:build !linux
+
+package fio
+
+// NewIOUringManager 当前平台不支持 io_uring，退回标准文件IO
+func NewIOUringManager(fileName string) (IOManager, error) {
+	return NewFileIOManager(fileName)
+}
@@ -0,0 +1,337 @@
+//go:build linux
+
+package fio
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// io_uring 的内核接口常量（include/uapi/linux/io_uring.h）
+const (
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringEnterGetEvents  = 1 << 0
+	ioringRegisterBuffers = 0
+
+	ioringOpNop       = 0
+	ioringOpFsync     = 3
+	ioringOpReadFixed = 4
+	ioringOpRead      = 22
+	ioringOpWrite     = 23
+)
+
+// struct io_uring_params
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        ioSQRingOffsets
+	cqOff        ioCQRingOffsets
+}
+
+// struct io_sqring_offsets
+type ioSQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+// struct io_cqring_offsets
+type ioCQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+// struct io_uring_sqe（64字节）
+type ioUringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	pad         [2]uint64
+}
+
+// struct io_uring_cqe（16字节）
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// 设置读写请求，b 在请求完成之前不能被修改或回收
+func (sqe *ioUringSQE) prepRW(opcode uint8, fd int, b []byte, offset int64) {
+	sqe.opcode = opcode
+	sqe.fd = int32(fd)
+	sqe.addr = uint64(uintptr(unsafe.Pointer(&b[0])))
+	sqe.len = uint32(len(b))
+	sqe.off = uint64(offset)
+}
+
+// 直接使用系统调用的 io_uring 实例
+// 请求提交后立即进入内核，后台协程等待完成事件并调用请求的回调（回调中不能再提交请求并等待完成）
+type ioUring struct {
+	fd     int
+	sqRing []byte // 映射的提交队列、完成队列和提交队列项
+	cqRing []byte
+	sqeMem []byte
+
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray []uint32
+	sqes    []ioUringSQE
+	cqHead  *uint32
+	cqTail  *uint32
+	cqMask  uint32
+	cqes    []ioUringCQE
+
+	mu      sync.Mutex
+	slots   *sync.Cond                 // 请求完成时通知等待空闲位置的提交
+	pending map[uint64]func(res int32) // 未完成请求的回调
+	nextId  uint64
+	closing bool          // 正在关闭，不再接受新的请求，所有请求完成后收集协程退出
+	err     error         // 收集完成事件失败的错误，之后的提交都返回这个错误
+	done    chan struct{} // 收集协程退出
+}
+
+// 创建 io_uring 实例，内核不支持时返回错误（例如 ENOSYS 或者被 seccomp 禁止时的 EPERM）
+func newIOUring(entries uint32) (*ioUring, error) {
+	var params ioUringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	ring := &ioUring{
+		fd:      int(fd),
+		pending: make(map[uint64]func(res int32)),
+		done:    make(chan struct{}),
+	}
+	ring.slots = sync.NewCond(&ring.mu)
+	if err := ring.mmap(&params); err != nil {
+		ring.unmap()
+		_ = unix.Close(ring.fd)
+		return nil, err
+	}
+	go ring.collect()
+	return ring, nil
+}
+
+// 映射提交队列、完成队列和提交队列项
+func (r *ioUring) mmap(params *ioUringParams) error {
+	const prot = unix.PROT_READ | unix.PROT_WRITE
+	const flags = unix.MAP_SHARED | unix.MAP_POPULATE
+	var err error
+	sqSize := params.sqOff.array + params.sqEntries*uint32(unsafe.Sizeof(uint32(0)))
+	if r.sqRing, err = unix.Mmap(r.fd, ioringOffSQRing, int(sqSize), prot, flags); err != nil {
+		return err
+	}
+	cqSize := params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(ioUringCQE{}))
+	if r.cqRing, err = unix.Mmap(r.fd, ioringOffCQRing, int(cqSize), prot, flags); err != nil {
+		return err
+	}
+	sqeSize := params.sqEntries * uint32(unsafe.Sizeof(ioUringSQE{}))
+	if r.sqeMem, err = unix.Mmap(r.fd, ioringOffSQEs, int(sqeSize), prot, flags); err != nil {
+		return err
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.array])), params.sqEntries)
+	r.sqes = unsafe.Slice((*ioUringSQE)(unsafe.Pointer(&r.sqeMem[0])), params.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*ioUringCQE)(unsafe.Pointer(&r.cqRing[params.cqOff.cqes])), params.cqEntries)
+	return nil
+}
+
+func (r *ioUring) unmap() {
+	for _, region := range [][]byte{r.sqRing, r.cqRing, r.sqeMem} {
+		if region != nil {
+			_ = unix.Munmap(region)
+		}
+	}
+}
+
+func (r *ioUring) enter(toSubmit, minComplete, flags uint32) error {
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(toSubmit), uintptr(minComplete), uintptr(flags), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// 注册固定缓冲区，使用 READ_FIXED 读取时内核不需要每次映射用户内存
+func (r *ioUring) registerBuffers(buffers [][]byte) error {
+	iovecs := make([]unix.Iovec, len(buffers))
+	for i, buf := range buffers {
+		iovecs[i].Base = &buf[0]
+		iovecs[i].SetLen(len(buf))
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(r.fd), ioringRegisterBuffers,
+		uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// 提交一个请求，完成时在收集协程中调用 callback，res 为负数时表示 -errno
+// 没有空闲位置时等待其他请求完成，调用方不能持有完成回调中需要的锁
+func (r *ioUring) submit(prep func(sqe *ioUringSQE), callback func(res int32)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for !r.closing && r.err == nil && r.full() {
+		r.slots.Wait()
+	}
+	if r.closing {
+		return errIOUringClosed
+	}
+	return r.submitLocked(prep, callback)
+}
+
+// 提交队列没有空闲的项，或者未完成的请求已经占满完成队列（访问此方法前必须持有锁）
+func (r *ioUring) full() bool {
+	return len(r.pending) >= len(r.cqes) || *r.sqTail-atomic.LoadUint32(r.sqHead) >= uint32(len(r.sqes))
+}
+
+// 提交请求（访问此方法前必须持有锁）
+func (r *ioUring) submitLocked(prep func(sqe *ioUringSQE), callback func(res int32)) error {
+	if r.err != nil {
+		return r.err
+	}
+	// 每个请求都立即提交，提交队列中不会积压请求
+	tail := *r.sqTail
+	index := tail & r.sqMask
+	sqe := &r.sqes[index]
+	*sqe = ioUringSQE{}
+	prep(sqe)
+	r.nextId++
+	sqe.userData = r.nextId
+	r.sqArray[index] = index
+	r.pending[r.nextId] = callback
+	atomic.StoreUint32(r.sqTail, tail+1)
+
+	for {
+		err := r.enter(1, 0, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			// 内核没有取走这个请求，撤回提交
+			atomic.StoreUint32(r.sqTail, tail)
+			delete(r.pending, r.nextId)
+			return err
+		}
+		return nil
+	}
+}
+
+// 等待完成事件并调用请求的回调，关闭之后所有请求完成时退出
+func (r *ioUring) collect() {
+	defer close(r.done)
+	for {
+		if r.reap() {
+			return
+		}
+		err := r.enter(0, 1, ioringEnterGetEvents)
+		if err == nil || err == unix.EINTR || err == unix.EAGAIN || err == unix.EBUSY {
+			continue
+		}
+
+		// 无法再等待完成事件，让所有未完成的请求失败
+		r.mu.Lock()
+		r.err = err
+		pending := r.pending
+		r.pending = make(map[uint64]func(res int32))
+		r.slots.Broadcast()
+		r.mu.Unlock()
+		for _, callback := range pending {
+			callback(-int32(err.(unix.Errno)))
+		}
+		return
+	}
+}
+
+// 处理完成队列中的所有事件，返回收集协程是否应当退出
+func (r *ioUring) reap() bool {
+	// 只有收集协程修改完成队列的头部
+	head := *r.cqHead
+	for tail := atomic.LoadUint32(r.cqTail); head != tail; head++ {
+		cqe := r.cqes[head&r.cqMask]
+		r.mu.Lock()
+		callback := r.pending[cqe.userData]
+		delete(r.pending, cqe.userData)
+		r.slots.Broadcast()
+		r.mu.Unlock()
+		atomic.StoreUint32(r.cqHead, head+1)
+		if callback != nil {
+			callback(cqe.res)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closing && len(r.pending) == 0
+}
+
+// 等待所有请求完成后释放 io_uring 实例
+func (r *ioUring) close() error {
+	r.mu.Lock()
+	if r.closing {
+		r.mu.Unlock()
+		return nil
+	}
+	// 提交一个空请求唤醒收集协程，它在所有请求完成后退出
+	for r.err == nil && r.full() {
+		r.slots.Wait()
+	}
+	err := r.submitLocked(func(sqe *ioUringSQE) { sqe.opcode = ioringOpNop }, func(int32) {})
+	r.closing = true
+	r.slots.Broadcast()
+	// 空请求提交失败并且没有未完成的请求时，收集协程仍然阻塞在等待中，不能解除映射
+	wait := err == nil || len(r.pending) > 0 || r.err != nil
+	r.mu.Unlock()
+
+	if wait {
+		<-r.done
+		r.unmap()
+	}
+	if closeErr := unix.Close(r.fd); err == nil {
+		err = closeErr
+	}
+	return err
+}
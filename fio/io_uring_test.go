@@ -0,0 +1,226 @@
+//go:build linux
+
+package fio
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func openIOUringTest(t testing.TB, fileName string) *IOUringManager {
+	t.Helper()
+	ioManager, err := NewIOUringManager(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ium, ok := ioManager.(*IOUringManager)
+	if !ok {
+		_ = ioManager.Close()
+		t.Skip("io_uring is not supported by the kernel")
+	}
+	return ium
+}
+
+func TestIOUringManager_WriteRead(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.data")
+	ium := openIOUringTest(t, fileName)
+
+	// 写入之后不等待完成直接读取
+	var expected []byte
+	for i := 0; i < 1000; i++ {
+		chunk := bytes.Repeat([]byte{byte(i)}, 100+i)
+		if n, err := ium.Write(chunk); err != nil || n != len(chunk) {
+			t.Fatalf("write = %d, %v", n, err)
+		}
+		expected = append(expected, chunk...)
+
+		buf := make([]byte, len(chunk))
+		if _, err := ium.Read(buf, int64(len(expected)-len(chunk))); err != nil || !bytes.Equal(buf, chunk) {
+			t.Fatalf("read after write %d: %v", i, err)
+		}
+	}
+	if size, _ := ium.Size(); size != int64(len(expected)) {
+		t.Fatalf("size = %d, expected %d", size, len(expected))
+	}
+
+	// 超过固定缓冲区大小的读取
+	buf := make([]byte, len(expected))
+	if n, err := ium.Read(buf, 0); err != nil || n != len(expected) || !bytes.Equal(buf, expected) {
+		t.Fatalf("read all = %d, %v", n, err)
+	}
+	// 读到末尾
+	if n, err := ium.Read(buf[:10], int64(len(expected)-5)); err != io.EOF || n != 5 {
+		t.Fatalf("read past end = %d, %v", n, err)
+	}
+
+	if err := ium.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ium.Truncate(100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ium.Write([]byte("tail")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ium.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, append(expected[:100:100], "tail"...)) {
+		t.Fatalf("unexpected file content after truncate, size %d", len(content))
+	}
+
+	// 重新打开后从文件末尾继续写入
+	ium = openIOUringTest(t, fileName)
+	defer ium.Close()
+	if size, _ := ium.Size(); size != 104 {
+		t.Fatalf("size after reopen = %d", size)
+	}
+	if _, err := ium.Write([]byte("more")); err != nil {
+		t.Fatal(err)
+	}
+	buf = make([]byte, 8)
+	if _, err := ium.Read(buf, 100); err != nil || string(buf) != "tailmore" {
+		t.Fatalf("read after reopen = %q, %v", buf, err)
+	}
+}
+
+func TestIOUringManager_Concurrent(t *testing.T) {
+	ium := openIOUringTest(t, filepath.Join(t.TempDir(), "a.data"))
+	defer ium.Close()
+
+	chunk := bytes.Repeat([]byte("a"), 128)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, len(chunk))
+			for j := 0; j < 500; j++ {
+				if _, err := ium.Write(chunk); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := ium.Read(buf, 0); err != nil || !bytes.Equal(buf, chunk) {
+					t.Errorf("read = %q, %v", buf, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ium.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := ium.Size(); size != 8*500*128 {
+		t.Fatalf("size = %d", size)
+	}
+}
+
+// 写入失败时由这次 Write 返回错误，截断之前之后的写入都失败
+func TestIOUringManager_WriteError(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.data")
+	ium := openIOUringTest(t, fileName)
+	defer ium.Close()
+	if _, err := ium.Write([]byte("head")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 换成只读的文件描述符，内核在完成事件中返回 EBADF
+	readOnly, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	fd := ium.fd
+	ium.fd = int(readOnly.Fd())
+	if n, err := ium.Write([]byte("lost")); err != syscall.EBADF || n != 0 {
+		t.Fatalf("write = %d, %v, expected EBADF", n, err)
+	}
+	ium.fd = fd
+	if _, err := ium.Write([]byte("next")); err != syscall.EBADF {
+		t.Fatalf("write after failure = %v, expected EBADF", err)
+	}
+
+	if err := ium.Truncate(4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ium.Write([]byte("tail")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	if _, err := ium.Read(buf, 0); err != nil || string(buf) != "headtail" {
+		t.Fatalf("read = %q, %v", buf, err)
+	}
+}
+
+// 并发请求超过队列大小时等待空闲位置，而不是返回错误
+func TestIOUring_SubmitWaitsForSlot(t *testing.T) {
+	ring, err := newIOUring(2)
+	if err != nil {
+		t.Skip("io_uring is not supported by the kernel")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				result := make(chan int32, 1)
+				err := ring.submit(func(sqe *ioUringSQE) { sqe.opcode = ioringOpNop }, func(res int32) { result <- res })
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res := <-result; res != 0 {
+					t.Errorf("nop = %d", res)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ring.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 对比标准文件IO与io_uring在多个协程并发写入时的吞吐量
+func benchmarkConcurrentAppend(b *testing.B, ioType FileIOType, size int) {
+	ioManager, err := NewIOManager(filepath.Join(b.TempDir(), "a.data"), ioType)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ioManager.Close()
+
+	buf := bytes.Repeat([]byte("a"), size)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := ioManager.Write(buf); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	if err := ioManager.Sync(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkFileIO_ConcurrentAppend4K(b *testing.B) {
+	benchmarkConcurrentAppend(b, StandardFIO, 4096)
+}
+
+func BenchmarkIOUring_ConcurrentAppend4K(b *testing.B) {
+	benchmarkConcurrentAppend(b, IOUring, 4096)
+}
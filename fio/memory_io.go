@@ -0,0 +1,66 @@
+package fio
+
+import (
+	"io"
+	"sync"
+)
+
+// 内存文件，数据只保存在内存中，不进行任何磁盘IO（用于内存模式）
+type MemoryIO struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+// 创建内存文件管理器，文件名只用于标识，不会在磁盘上创建文件
+func NewMemoryIOManager() *MemoryIO {
+	return &MemoryIO{}
+}
+
+// 读取语义和 os.File.ReadAt 一致，读到末尾时返回 io.EOF
+func (mio *MemoryIO) Read(b []byte, offset int64) (int, error) {
+	mio.mu.RLock()
+	defer mio.mu.RUnlock()
+	if offset >= int64(len(mio.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, mio.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (mio *MemoryIO) Write(b []byte) (int, error) {
+	mio.mu.Lock()
+	defer mio.mu.Unlock()
+	mio.data = append(mio.data, b...)
+	return len(b), nil
+}
+
+// 截断文件，之后的写入从新的末尾开始
+func (mio *MemoryIO) Truncate(size int64) error {
+	mio.mu.Lock()
+	defer mio.mu.Unlock()
+	if size < int64(len(mio.data)) {
+		mio.data = mio.data[:size]
+	}
+	return nil
+}
+
+func (mio *MemoryIO) Sync() error {
+	return nil
+}
+
+// 关闭之后释放数据
+func (mio *MemoryIO) Close() error {
+	mio.mu.Lock()
+	defer mio.mu.Unlock()
+	mio.data = nil
+	return nil
+}
+
+func (mio *MemoryIO) Size() (int64, error) {
+	mio.mu.RLock()
+	defer mio.mu.RUnlock()
+	return int64(len(mio.data)), nil
+}
@@ -0,0 +1,121 @@
+//go:build linux || darwin
+
+package fio
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// 可写 MMap 初始映射的大小，写满后成倍扩容
+const writableMMapInitSize = 4 * 1024 * 1024
+
+// 可写的 MMap IO，用于活跃文件
+// 通过 ftruncate 预先扩展文件，以 PROT_READ|PROT_WRITE、MAP_SHARED 映射，写入时直接拷贝到映射区域，减少系统调用
+type WritableMMapIO struct {
+	fd     *os.File
+	region []byte // 映射的内存区域
+	offset int64  // 实际写入的数据量
+}
+
+// NewWritableMMapIOManager 初始化可写的 MMap IO
+func NewWritableMMapIOManager(fileName string) (*WritableMMapIO, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+
+	wm := &WritableMMapIO{fd: fd, offset: stat.Size()}
+	var capacity int64 = writableMMapInitSize
+	for capacity < wm.offset {
+		capacity *= 2
+	}
+	if err := wm.remap(capacity); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return wm, nil
+}
+
+// 将文件扩展到指定大小并重新映射
+func (wm *WritableMMapIO) remap(capacity int64) error {
+	if wm.region != nil {
+		if err := unix.Munmap(wm.region); err != nil {
+			return err
+		}
+		wm.region = nil
+	}
+	if err := wm.fd.Truncate(capacity); err != nil {
+		return err
+	}
+	region, err := unix.Mmap(int(wm.fd.Fd()), 0, int(capacity), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	wm.region = region
+	return nil
+}
+
+func (wm *WritableMMapIO) Read(b []byte, offset int64) (int, error) {
+	if offset >= wm.offset {
+		return 0, io.EOF
+	}
+	n := copy(b, wm.region[offset:wm.offset])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (wm *WritableMMapIO) Write(b []byte) (int, error) {
+	need := wm.offset + int64(len(b))
+	if need > int64(len(wm.region)) {
+		capacity := int64(len(wm.region)) * 2
+		for capacity < need {
+			capacity *= 2
+		}
+		if err := wm.remap(capacity); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(wm.region[wm.offset:], b)
+	wm.offset += int64(n)
+	return n, nil
+}
+
+// 丢弃 size 之后写入的数据，映射区域中对应的部分清零，关闭时文件截断到写入的大小
+func (wm *WritableMMapIO) Truncate(size int64) error {
+	if size > wm.offset {
+		return io.ErrUnexpectedEOF
+	}
+	clear(wm.region[size:wm.offset])
+	wm.offset = size
+	return nil
+}
+
+func (wm *WritableMMapIO) Sync() error {
+	return unix.Msync(wm.region, unix.MS_SYNC)
+}
+
+// 解除映射，并将文件截断为实际写入的大小
+func (wm *WritableMMapIO) Close() error {
+	if err := unix.Munmap(wm.region); err != nil {
+		return err
+	}
+	wm.region = nil
+	if err := wm.fd.Truncate(wm.offset); err != nil {
+		return err
+	}
+	return wm.fd.Close()
+}
+
+func (wm *WritableMMapIO) Size() (int64, error) {
+	return wm.offset, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package fio
+
+import "errors"
+
+// NewWritableMMapIOManager 当前平台不支持可写的 MMap
+func NewWritableMMapIOManager(fileName string) (IOManager, error) {
+	return nil, errors.New("writable mmap is not supported on this platform")
+}
@@ -0,0 +1,77 @@
+//go:build linux || darwin
+
+package fio
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritableMMapIO_WriteRead(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.data")
+	wm, err := NewWritableMMapIOManager(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 超过初始映射大小，触发扩容
+	chunk := bytes.Repeat([]byte("a"), 1024*1024)
+	for i := 0; i < 5; i++ {
+		if _, err := wm.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size, _ := wm.Size(); size != 5*1024*1024 {
+		t.Fatalf("size = %d", size)
+	}
+
+	buf := make([]byte, 10)
+	if _, err := wm.Read(buf, 5*1024*1024-10); err != nil || !bytes.Equal(buf, chunk[:10]) {
+		t.Fatalf("read = %q, %v", buf, err)
+	}
+
+	// 关闭后文件被截断为实际写入的大小
+	if err := wm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(fileName)
+	if err != nil || stat.Size() != 5*1024*1024 {
+		t.Fatalf("file size after close = %v, %v", stat.Size(), err)
+	}
+}
+
+// 对比标准文件IO与可写MMap的追加写入性能
+func benchmarkAppend(b *testing.B, ioType FileIOType, size int) {
+	ioManager, err := NewIOManager(filepath.Join(b.TempDir(), "a.data"), ioType)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ioManager.Close()
+
+	buf := bytes.Repeat([]byte("a"), size)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ioManager.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFileIO_Append128(b *testing.B) {
+	benchmarkAppend(b, StandardFIO, 128)
+}
+
+func BenchmarkWritableMMap_Append128(b *testing.B) {
+	benchmarkAppend(b, WritableMMap, 128)
+}
+
+func BenchmarkFileIO_Append4K(b *testing.B) {
+	benchmarkAppend(b, StandardFIO, 4096)
+}
+
+func BenchmarkWritableMMap_Append4K(b *testing.B) {
+	benchmarkAppend(b, WritableMMap, 4096)
+}
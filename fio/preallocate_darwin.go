@@ -0,0 +1,26 @@
+//go:build darwin
+
+package fio
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preallocate 为文件预先分配磁盘空间（F_PREALLOCATE），不改变文件大小，文件系统不支持时直接忽略
+func Preallocate(fileName string, size int64) error {
+	fd, err := os.OpenFile(fileName, os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	fstore := &unix.Fstore_t{
+		Flags:   unix.F_ALLOCATEALL,
+		Posmode: unix.F_PEOFPOSMODE,
+		Offset:  0,
+		Length:  size,
+	}
+	return ignoreNotSupported(unix.FcntlFstore(fd.Fd(), unix.F_PREALLOCATE, fstore))
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package fio
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preallocate 为文件预先分配磁盘空间，不改变文件大小（FALLOC_FL_KEEP_SIZE），追加写入的位置不受影响
+// 文件系统不支持 fallocate 时直接忽略
+func Preallocate(fileName string, size int64) error {
+	fd, err := os.OpenFile(fileName, os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return ignoreNotSupported(unix.Fallocate(int(fd.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size))
+}
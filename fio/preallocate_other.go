@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package fio
+
+// Preallocate 当前平台不支持预分配，直接忽略
+func Preallocate(fileName string, size int64) error {
+	return nil
+}
+
+// HasPreallocatedSpace 当前平台不支持预分配，始终返回false
+func HasPreallocatedSpace(fileName string) (bool, error) {
+	return false, nil
+}
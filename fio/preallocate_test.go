@@ -0,0 +1,73 @@
+//go:build linux || darwin
+
+package fio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPreallocate(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.data")
+	if err := os.WriteFile(fileName, []byte("abc"), DataFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := Preallocate(fileName, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	// 预分配不改变文件大小
+	stat, err := os.Stat(fileName)
+	if err != nil || stat.Size() != 3 {
+		t.Fatalf("size after preallocate = %d, %v", stat.Size(), err)
+	}
+}
+
+// 预分配的空间在截断到文件大小之后释放
+func TestHasPreallocatedSpace(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.data")
+	if err := os.WriteFile(fileName, []byte("abc"), DataFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if preallocated, err := HasPreallocatedSpace(fileName); err != nil || preallocated {
+		t.Fatalf("before preallocate = %v, %v", preallocated, err)
+	}
+	if err := Preallocate(fileName, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	preallocated, err := HasPreallocatedSpace(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !preallocated {
+		t.Skip("file system does not support preallocation")
+	}
+	if err := os.Truncate(fileName, 3); err != nil {
+		t.Fatal(err)
+	}
+	if preallocated, err := HasPreallocatedSpace(fileName); err != nil || preallocated {
+		t.Fatalf("after truncate = %v, %v", preallocated, err)
+	}
+}
+
+func TestIgnoreNotSupported(t *testing.T) {
+	if err := ignoreNotSupported(unix.ENOTSUP); err != nil {
+		t.Fatalf("ENOTSUP: %v", err)
+	}
+	if err := ignoreNotSupported(unix.EOPNOTSUPP); err != nil {
+		t.Fatalf("EOPNOTSUPP: %v", err)
+	}
+	if err := ignoreNotSupported(fmt.Errorf("fallocate: %w", unix.EOPNOTSUPP)); err != nil {
+		t.Fatalf("wrapped EOPNOTSUPP: %v", err)
+	}
+	if err := ignoreNotSupported(unix.ENOSPC); !errors.Is(err, unix.ENOSPC) {
+		t.Fatalf("ENOSPC: %v", err)
+	}
+	if err := ignoreNotSupported(nil); err != nil {
+		t.Fatalf("nil: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin
+
+package fio
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// 文件系统不支持预分配（如部分网络文件系统、tmpfs 的旧版本）时视为成功，退化为不预分配
+func ignoreNotSupported(err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return nil
+	}
+	return err
+}
+
+// HasPreallocatedSpace 文件占用的磁盘空间是否超出文件大小（预分配之后没有截断释放的部分）
+func HasPreallocatedSpace(fileName string) (bool, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(fileName, &stat); err != nil {
+		return false, err
+	}
+	// Blocks 以512字节为单位，文件大小按块大小向上取整之后比较
+	blockSize := int64(stat.Blksize)
+	used := (stat.Size + blockSize - 1) / blockSize * blockSize
+	return stat.Blocks*512 > used, nil
+}
@@ -0,0 +1,12 @@
+//go:build linux
+
+package fio
+
+import "golang.org/x/sys/unix"
+
+// Prefetch 通过 fadvise 提示内核按顺序读取文件，并异步地将指定范围读入页缓存
+func (fio *FileIO) Prefetch(offset, length int64) {
+	fd := int(fio.fd.Fd())
+	_ = unix.Fadvise(fd, 0, 0, unix.FADV_SEQUENTIAL)
+	_ = unix.Fadvise(fd, offset, length, unix.FADV_WILLNEED)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package fio
+
+// Prefetch 没有预读提示的平台（如 Windows）上，在后台读取指定范围，和调用方的读取重叠进行，读取的数据进入页缓存后丢弃
+func (fio *FileIO) Prefetch(offset, length int64) {
+	go func() {
+		buf := make([]byte, length)
+		_, _ = fio.fd.ReadAt(buf, offset)
+	}()
+}
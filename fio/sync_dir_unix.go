@@ -0,0 +1,18 @@
+//go:build !windows
+
+package fio
+
+import "os"
+
+// SyncDir 持久化目录本身，使目录中新建、重命名或删除的文件项在崩溃之后仍然有效
+func SyncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		_ = dir.Close()
+		return err
+	}
+	return dir.Close()
+}
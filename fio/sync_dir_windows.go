@@ -0,0 +1,8 @@
+//go:build windows
+
+package fio
+
+// SyncDir Windows 上无法对目录调用 fsync，文件的元数据随文件本身持久化，直接忽略
+func SyncDir(dirPath string) error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+
+	"bitcask-go/data"
+	"bitcask-go/index"
+)
+
+// 清空数据库中的所有key：在新的活跃文件中写入一条清空标识，删除之前所有的数据文件和value log，并重置内存索引、事务序列号和可回收的数据量
+// 比逐个删除key更快，之后也不需要merge；异常退出时没有来得及删除的旧文件，重新打开时根据清空标识忽略其中的记录
+// 订阅者和复制流会收到 KeyEventFlushAll 事件
+func (db *DB) FlushAll() error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// merge 过程中会读取旧的数据文件，不能删除
+	if db.isMerging {
+		return ErrMergeIsProgress
+	}
+
+	// 清空标识写入新的活跃文件，之前的文件（包括当前的活跃文件）都可以整个删除
+	if db.activeFile != nil {
+		db.olderFiles[db.activeFile.FileId] = db.activeFile
+	}
+	if err := db.setActiveFile(); err != nil {
+		return err
+	}
+	logRecord := &data.LogRecord{
+		Key:  logRecordKeyWithSeq(nil, nonTransactionSeqNo),
+		Type: data.LogRecordFlushAll,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	// 删除文件之前清空标识必须落盘
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+
+	if err := db.resetIndex(); err != nil {
+		return err
+	}
+	if err := db.removeFilesBeforeFlush(); err != nil {
+		return err
+	}
+
+	db.seqNo = 0
+	db.versions = make(map[string][]*versionedPos)
+	db.minVersionSeqNo = 0
+	db.versionWrites = 0
+	db.reclaimSize = 0
+	db.options.Logger.Infof("flushed all keys, new active file %d", db.activeFile.FileId)
+	if err := db.writeManifest(); err != nil {
+		return err
+	}
+
+	db.notifyCommit(nil, nil, KeyEventFlushAll, pos)
+	db.auditWrite(AuditFlushAll, nil, nonTransactionSeqNo)
+	return nil
+}
+
+// 清空索引（调用方需持有写锁）
+func (db *DB) resetIndex() error {
+	if db.options.IndexType != BPlusTree {
+		db.index = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites, db.options.BTreeDegree)
+		return nil
+	}
+
+	// B+树索引存储在磁盘上并且可能还有未关闭的迭代器，不能直接删除文件，逐个删除其中的key
+	// 迭代器返回的key只在事务内有效，需要拷贝
+	var keys [][]byte
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, append([]byte(nil), iterator.Key()...))
+	}
+	iterator.Close()
+	for _, key := range keys {
+		if _, ok := db.index.Delete(key); !ok {
+			return ErrIndexUpdateFailed
+		}
+	}
+	return nil
+}
+
+// 删除清空标识之前的所有数据文件、value log以及merge生成的hint文件（调用方需持有写锁）
+// 内存模式下只关闭文件释放内存
+func (db *DB) removeFilesBeforeFlush() error {
+	if !db.options.InMemory {
+		for _, name := range []string{data.HintFileName, data.MergeFinishedFileName} {
+			if err := os.Remove(filepath.Join(db.options.DirPath, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	db.mergedFormat = 0
+
+	for fid, dataFile := range db.olderFiles {
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+		if !db.options.InMemory {
+			if err := os.Remove(db.getDataFileName(db.options.DirPath, fid)); err != nil {
+				return err
+			}
+		}
+		delete(db.olderFiles, fid)
+	}
+
+	if err := db.closeVlogs(); err != nil {
+		return err
+	}
+	if db.activeVlog != nil {
+		db.olderVlogs[db.activeVlog.FileId] = db.activeVlog
+		db.activeVlog = nil
+	}
+	for fid := range db.olderVlogs {
+		if !db.options.InMemory {
+			if err := os.Remove(data.GetValueLogFileName(db.options.DirPath, fid)); err != nil {
+				return err
+			}
+		}
+		delete(db.olderVlogs, fid)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitcask-go/data"
+)
+
+func expectKeyCount(t *testing.T, db *DB, expected int) {
+	t.Helper()
+	count, err := db.CountKeys(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != expected {
+		t.Fatalf("expected %d keys, got %d", expected, count)
+	}
+}
+
+// 写入测试数据，B+树索引的新数据库不能使用批量写入，只写入和删除
+func writeFlushData(t *testing.T, db *DB, round int) {
+	t.Helper()
+	if db.options.IndexType != BPlusTree {
+		writeReplicationData(t, db, round)
+		return
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Put(testKey(i), testValue(round*100+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i += 7 {
+		if err := db.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_FlushAll(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.ValueLogSeparationThreshold = 64 },
+		func(options *Options) { options.StripedLockCount = 16 },
+		func(options *Options) { options.MaxVersionsPerKey = 3 },
+		func(options *Options) { options.IndexType = BPlusTree },
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 8 * 1024
+			if configure != nil {
+				configure(options)
+			}
+		})
+		writeFlushData(t, db, 0)
+		// merge生成的hint文件也需要失效
+		if db.options.IndexType != BPlusTree {
+			if err := db.MergeForce(); err != nil {
+				t.Fatal(err)
+			}
+			db = reopenTestDB(t, db)
+			writeFlushData(t, db, 1)
+		}
+
+		if err := db.FlushAll(); err != nil {
+			t.Fatal(err)
+		}
+		expectKeyCount(t, db, 0)
+		if _, err := db.Get(testKey(5)); err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+		if stat := db.Stat(); stat.ReclaimableSize != 0 || stat.DataFileNum != 1 {
+			t.Fatalf("unexpected stat after flush: %+v", stat)
+		}
+		if db.SeqNo() != 0 {
+			t.Fatalf("expected seq no 0, got %d", db.SeqNo())
+		}
+		// 只剩下写入清空标识的活跃文件
+		entries, err := os.ReadDir(db.options.DirPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), data.ValueLogFileSuffix) || entry.Name() == data.HintFileName {
+				t.Fatalf("unexpected file %s after flush", entry.Name())
+			}
+		}
+		if count := countDataFiles(t, db.options.DirPath); count != 1 {
+			t.Fatalf("expected 1 data file, got %d", count)
+		}
+
+		// 清空之后可以继续写入，重启后只有清空之后写入的数据
+		writeFlushData(t, db, 2)
+		expected := dumpDB(t, db)
+		db = reopenTestDB(t, db)
+		expectContent(t, db, expected)
+	}
+}
+
+// 清空标识写入之后、旧文件删除之前异常退出，重新打开时忽略旧文件中的记录
+func TestDB_FlushAllInterrupted(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+	})
+	writeReplicationData(t, db, 0)
+	// 保存旧文件的内容，模拟删除之前异常退出
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	oldFiles := make(map[string][]byte)
+	entries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			content, err := os.ReadFile(filepath.Join(db.options.DirPath, entry.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			oldFiles[entry.Name()] = content
+		}
+	}
+
+	if err := db.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("after"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 恢复被删除的旧文件
+	for name, content := range oldFiles {
+		if err := os.WriteFile(filepath.Join(db.options.DirPath, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err = Open(db.options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, map[string]string{"after": "v"})
+	if stat := db.Stat(); stat.ReclaimableSize != 0 {
+		t.Fatalf("expected no reclaimable size, got %d", stat.ReclaimableSize)
+	}
+
+	// vacuum保留清空标识，之后重新打开旧文件中的记录仍然被忽略
+	if err := db.Vacuum(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(db.options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	expectContent(t, db, map[string]string{"after": "v"})
+}
+
+// 订阅者、复制流以及审计日志都能收到清空事件
+func TestDB_FlushAllEvents(t *testing.T) {
+	leader := openTestDB(t, func(options *Options) {
+		options.AuditLog = true
+	})
+	follower := openTestDB(t, nil)
+	events, cancelWatch := leader.Watch([]byte("prefix"))
+	defer cancelWatch()
+
+	writeReplicationData(t, leader, 0)
+	if err := leader.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-events:
+		if event.Type != KeyEventFlushAll || len(event.Key) != 0 {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	default:
+		t.Fatal("expected flush event")
+	}
+
+	entries, err := leader.TailAuditLog(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectAuditEntry(t, entries[0], AuditFlushAll, "")
+
+	// 跟随者订阅之前主库已经清空，回放时先收到清空事件；回放之前第二次清空已经删除了文件时，从第二次清空事件开始接收
+	if err := follower.Put([]byte("stale"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	replication, cancel, err := leader.Replication(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	writeReplicationData(t, leader, 1)
+	if err := leader.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	writeReplicationData(t, leader, 2)
+	if err := leader.Put([]byte("stop"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	received := followUntil(t, replication, follower, []byte("stop"))
+	var flushes int
+	for _, event := range received {
+		if event.Type == KeyEventFlushAll {
+			flushes++
+		}
+	}
+	if flushes == 0 || (received[0].Type != KeyEventFlushAll && flushes != 1) {
+		t.Fatalf("unexpected flush events: %d, first %+v", flushes, received[0])
+	}
+	expectSameContent(t, leader, follower)
+}
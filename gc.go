@@ -0,0 +1,138 @@
+package bitcask_go
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// 立即回收无效数据比例超过 DataFileMergeRatio 的旧数据文件（minor compaction，和merge的全量重写互补）
+// 只重写选出的文件：和vacuum一样在临时目录中写入交换文件后原子地替换原文件，并在同一个临界区内更新内存索引，不会打开merge使用的临时实例
+// 被重写的文件由merge生成时，只更新hint文件中指向此文件的位置，hint文件仍然可以用于下次打开
+// 没有文件达到比例时直接返回nil；和merge、vacuum互斥，正在进行时返回 ErrMergeIsProgress
+func (db *DB) GC() error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.options.InMemory {
+		return ErrInMemoryUnsupported
+	}
+	if db.options.IndexType == BPlusTree {
+		return ErrVacuumUnsupported
+	}
+
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	db.isMerging = true
+	fileIds := make([]uint32, 0, len(db.olderFiles))
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	// 选出无效数据比例超过阈值的文件
+	var selected []uint32
+	for _, fid := range fileIds {
+		file, err := db.estimateReclaimable(fid)
+		if err != nil {
+			return err
+		}
+		if file.size > 0 && float32(file.reclaimable)/float32(file.size) > db.options.DataFileMergeRatio {
+			selected = append(selected, fid)
+		}
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+
+	vacuumPath := db.getVacuumPath()
+	if err := os.RemoveAll(vacuumPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(vacuumPath, os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(vacuumPath)
+	}()
+
+	db.options.Logger.Infof("gc started: %d of %d data files", len(selected), len(fileIds))
+	for _, fid := range selected {
+		if err := db.vacuumFile(vacuumPath, fid, fid == fileIds[0], true); err != nil {
+			return err
+		}
+	}
+	db.options.Logger.Infof("gc finished")
+	return nil
+}
+
+// 在临时目录中写入更新后的hint文件以及merge完成标识的副本（调用方需持有数据库的写锁）
+// 指向被重写文件的记录更新为新的位置，记录已被丢弃时删除，其他记录原样复制
+func (db *DB) writeUpdatedHintFile(tmpPath string, fid uint32, newPositions map[int64]*data.LogRecordPos) error {
+	mergeFinished, err := os.ReadFile(filepath.Join(db.options.DirPath, data.MergeFinishedFileName))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmpPath, data.MergeFinishedFileName), mergeFinished, fio.DataFilePerm); err != nil {
+		return err
+	}
+
+	hintFile, err := data.OpenHintFile(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.HintFileName)); os.IsNotExist(err) {
+		return db.finishHintFile(hintFile)
+	}
+	oldHintFile, err := data.OpenHintFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer oldHintFile.Close()
+
+	var offset int64
+	for {
+		logRecord, size, err := oldHintFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		offset += size
+		if logRecord.Type == data.LogRecordHintChecksum {
+			continue
+		}
+
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if pos.Fid == fid {
+			if pos = newPositions[pos.Offset]; pos == nil {
+				continue
+			}
+		}
+		if err := hintFile.WriteHintRecord(logRecord.Key, pos); err != nil {
+			return err
+		}
+	}
+	return db.finishHintFile(hintFile)
+}
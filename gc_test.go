@@ -0,0 +1,168 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitcask-go/data"
+)
+
+// 统计每个旧数据文件的大小
+func olderFileSizes(t *testing.T, db *DB) map[uint32]int64 {
+	t.Helper()
+	sizes := make(map[uint32]int64)
+	for fid := range db.olderFiles {
+		stat, err := os.Stat(db.getDataFileName(db.options.DirPath, fid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[fid] = stat.Size()
+	}
+	return sizes
+}
+
+// 只重写无效数据比例超过阈值的文件
+func TestDB_GC(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+		options.DataFileMergeRatio = 0.5
+	})
+	for i := 0; i < 2000; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 覆盖和删除前面的key，只有前面的文件无效数据比例超过阈值
+	for i := 0; i < 300; i++ {
+		if i%2 == 0 {
+			if err := db.Delete(testKey(i)); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := db.Put(testKey(i), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := dumpDB(t, db)
+	before := olderFileSizes(t, db)
+	reclaimable := db.Stat().ReclaimableSize
+	if err := db.GC(); err != nil {
+		t.Fatal(err)
+	}
+	after := olderFileSizes(t, db)
+	var shrunk, kept int
+	for fid, size := range before {
+		switch {
+		case after[fid] < size:
+			shrunk++
+		case after[fid] == size:
+			kept++
+		default:
+			t.Fatalf("data file %d grew from %d to %d", fid, size, after[fid])
+		}
+	}
+	if shrunk == 0 || kept == 0 {
+		t.Fatalf("expected only part of the files rewritten, shrunk %d, kept %d", shrunk, kept)
+	}
+	if size := db.Stat().ReclaimableSize; size >= reclaimable {
+		t.Fatalf("reclaimable size %d -> %d, want smaller", reclaimable, size)
+	}
+	expectContent(t, db, expected)
+
+	// 再次执行时没有文件达到阈值
+	if err := db.GC(); err != nil {
+		t.Fatal(err)
+	}
+	if sizes := olderFileSizes(t, db); len(sizes) != len(after) {
+		t.Fatalf("unexpected data files after second gc: %v", sizes)
+	}
+	for fid, size := range olderFileSizes(t, db) {
+		if after[fid] != size {
+			t.Fatalf("data file %d rewritten again", fid)
+		}
+	}
+
+	db = reopenTestDB(t, db)
+	expectContent(t, db, expected)
+}
+
+// 重写merge生成的文件时更新hint文件，重新打开后仍然从hint文件加载索引
+func TestDB_GCUpdatesHintFile(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.IndexBuildWorkers = 4 },
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 8 * 1024
+			options.DataFileMergeRatio = 0.5
+			if configure != nil {
+				configure(options)
+			}
+		})
+		for i := 0; i < 2000; i++ {
+			if err := db.Put(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.MergeForce(); err != nil {
+			t.Fatal(err)
+		}
+		db = reopenTestDB(t, db)
+		hintBound, err := db.hintFileIdBound()
+		if err != nil || hintBound == 0 {
+			t.Fatalf("expected merged files, got %d, %v", hintBound, err)
+		}
+
+		// 每三个key覆盖一个、删除一个，重写后剩余记录的位置发生变化
+		for i := 0; i < 600; i += 3 {
+			if err := db.Put(testKey(i), []byte("new")); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Delete(testKey(i + 1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected := dumpDB(t, db)
+		before := olderFileSizes(t, db)
+		if err := db.GC(); err != nil {
+			t.Fatal(err)
+		}
+		var rewritten bool
+		for fid, size := range olderFileSizes(t, db) {
+			rewritten = rewritten || (fid < hintBound && size < before[fid])
+		}
+		if !rewritten {
+			t.Fatal("expected merged data files rewritten")
+		}
+		for _, name := range []string{data.HintFileName, data.MergeFinishedFileName} {
+			if _, err := os.Stat(filepath.Join(db.options.DirPath, name)); err != nil {
+				t.Fatalf("expected %s kept after gc: %v", name, err)
+			}
+		}
+		if bound, err := db.hintFileIdBound(); err != nil || bound != hintBound {
+			t.Fatalf("hint file bound %d -> %d, %v", hintBound, bound, err)
+		}
+		expectContent(t, db, expected)
+
+		// hint文件中的位置指向重写后的文件
+		db = reopenTestDB(t, db)
+		expectContent(t, db, expected)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_GCExclusive(t *testing.T) {
+	db := openTestDB(t, nil)
+	db.mu.Lock()
+	db.isMerging = true
+	db.mu.Unlock()
+	if err := db.GC(); err != ErrMergeIsProgress {
+		t.Fatalf("expected ErrMergeIsProgress, got %v", err)
+	}
+	db.mu.Lock()
+	db.isMerging = false
+	db.mu.Unlock()
+}
@@ -6,14 +6,13 @@ require (
 	github.com/gofrs/flock v0.12.1
 	github.com/google/btree v1.1.3
 	github.com/plar/go-adaptive-radix-tree v1.0.7
+	github.com/tidwall/match v1.1.1
 	github.com/tidwall/redcon v1.6.2
 	go.etcd.io/bbolt v1.4.0
 	golang.org/x/exp v0.0.0-20230321023759-10a507213a29
+	golang.org/x/time v0.5.0
 )
 
-require (
-	github.com/tidwall/btree v1.1.0 // indirect
-	github.com/tidwall/match v1.1.1 // indirect
-)
+require github.com/tidwall/btree v1.1.0 // indirect
 
 require golang.org/x/sys v0.29.0
@@ -0,0 +1,59 @@
+package bitcask_go
+
+import "bitcask-go/data"
+
+// 数据库事件的回调，操作成功并释放锁之后在调用方的协程中同步调用，为nil的回调不调用
+// 回调中可以读写数据库，但会阻塞触发事件的操作返回
+type Hooks struct {
+	// Put 和 PutWithTTL 写入成功后调用，pos为写入的日志记录在数据文件中的位置（可用于复制）
+	OnPut func(key, value []byte, pos *data.LogRecordPos)
+	// Delete 删除了存在的key之后调用，key不存在时不调用
+	OnDelete func(key []byte)
+	// Merge 和 MergeForce 开始重写数据文件时调用，没有达到阈值等原因没有开始时不调用
+	OnMergeStart func()
+	// 调用过 OnMergeStart 的merge结束后调用，err为merge的结果
+	OnMergeEnd func(err error)
+	// 第一次调用 Close 成功关闭数据库并释放文件锁之后调用
+	OnClose func()
+	// 活跃文件写满（或merge开始时）被封存之后调用：文件已经持久化并且之后不会再追加，path为数据文件的路径，size为文件中数据的大小
+	// 和其他回调不同，由后台协程按封存的顺序异步调用，调用时不持有数据库的任何锁；Close 会等待已经封存的文件全部通知完成，因此不能在回调中调用 Close
+	OnFileRotation func(fileId uint32, path string, size int64)
+}
+
+// 写入前的校验，没有配置 BeforeWrite 时总是通过
+func (db *DB) beforeWrite(key, value []byte, isDelete bool) error {
+	if db.options.BeforeWrite == nil {
+		return nil
+	}
+	return db.options.BeforeWrite(key, value, isDelete)
+}
+
+func (db *DB) hookPut(key, value []byte, pos *data.LogRecordPos) {
+	if db.options.Hooks.OnPut != nil {
+		db.options.Hooks.OnPut(key, value, pos)
+	}
+}
+
+func (db *DB) hookDelete(key []byte) {
+	if db.options.Hooks.OnDelete != nil {
+		db.options.Hooks.OnDelete(key)
+	}
+}
+
+func (db *DB) hookMergeStart() {
+	if db.options.Hooks.OnMergeStart != nil {
+		db.options.Hooks.OnMergeStart()
+	}
+}
+
+func (db *DB) hookMergeEnd(err error) {
+	if db.options.Hooks.OnMergeEnd != nil {
+		db.options.Hooks.OnMergeEnd(err)
+	}
+}
+
+func (db *DB) hookClose() {
+	if db.options.Hooks.OnClose != nil {
+		db.options.Hooks.OnClose()
+	}
+}
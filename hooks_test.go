@@ -0,0 +1,308 @@
+package bitcask_go
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"bitcask-go/data"
+)
+
+// 按调用顺序记录回调
+type hookRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *hookRecorder) add(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *hookRecorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func expectHookEvents(t *testing.T, recorder *hookRecorder, expected ...string) {
+	t.Helper()
+	events := recorder.take()
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Fatalf("expected hook events %q, got %q", expected, events)
+	}
+}
+
+func TestDB_Hooks(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.StripedLockCount = 16 },
+		func(options *Options) { options.GroupCommit = true },
+	} {
+		recorder := &hookRecorder{}
+		var db *DB
+		db = openTestDB(t, func(options *Options) {
+			if configure != nil {
+				configure(options)
+			}
+			options.Hooks = Hooks{
+				OnPut: func(key, value []byte, pos *data.LogRecordPos) {
+					// 回调在释放锁之后调用，可以读取数据库
+					stored, err := db.Get(key)
+					if err != nil || string(stored) != string(value) {
+						t.Errorf("get %s in OnPut = %q, %v", key, stored, err)
+					}
+					if indexPos := db.index.Get(key); *indexPos != *pos {
+						t.Errorf("OnPut position %+v, index position %+v", pos, indexPos)
+					}
+					recorder.add("put %s=%s", key, value)
+				},
+				OnDelete: func(key []byte) {
+					if _, err := db.Get(key); err != ErrKeyNotFound {
+						t.Errorf("get %s in OnDelete: %v", key, err)
+					}
+					recorder.add("delete %s", key)
+				},
+				OnMergeStart: func() { recorder.add("merge start") },
+				OnMergeEnd:   func(err error) { recorder.add("merge end %v", err) },
+				OnClose:      func() { recorder.add("close") },
+			}
+		})
+
+		if err := db.Put([]byte("k1"), []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.PutWithTTL([]byte("k2"), []byte("v2"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete([]byte("k1")); err != nil {
+			t.Fatal(err)
+		}
+		// 删除不存在的key不调用回调
+		if err := db.Delete([]byte("missing")); err != nil {
+			t.Fatal(err)
+		}
+		expectHookEvents(t, recorder, "put k1=v1", "put k2=v2", "delete k1")
+
+		if err := db.MergeForce(); err != nil {
+			t.Fatal(err)
+		}
+		expectHookEvents(t, recorder, "merge start", "merge end <nil>")
+
+		// 重复关闭只调用一次回调，merge的临时实例关闭时不调用
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		expectHookEvents(t, recorder, "close")
+	}
+}
+
+// 没有开始的merge不调用回调
+func TestDB_HooksMergeNotStarted(t *testing.T) {
+	recorder := &hookRecorder{}
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileMergeRatio = 0.9
+		options.Hooks = Hooks{
+			OnMergeStart: func() { recorder.add("merge start") },
+			OnMergeEnd:   func(err error) { recorder.add("merge end %v", err) },
+		}
+	})
+	if err := db.Put(testKey(1), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Merge(); err != ErrMergeRatioUnreached {
+		t.Fatalf("expected ErrMergeRatioUnreached, got %v", err)
+	}
+	expectHookEvents(t, recorder)
+
+	db.mu.Lock()
+	db.isMerging = true
+	db.mu.Unlock()
+	if err := db.MergeForce(); err != ErrMergeIsProgress {
+		t.Fatalf("expected ErrMergeIsProgress, got %v", err)
+	}
+	db.mu.Lock()
+	db.isMerging = false
+	db.mu.Unlock()
+	expectHookEvents(t, recorder)
+}
+
+// 写入失败时不调用回调
+func TestDB_HooksOnFullDisk(t *testing.T) {
+	recorder := &hookRecorder{}
+	db := openTestDB(t, func(options *Options) {
+		options.Hooks = Hooks{
+			OnPut:    func(key, value []byte, pos *data.LogRecordPos) { recorder.add("put %s", key) },
+			OnDelete: func(key []byte) { recorder.add("delete %s", key) },
+		}
+	})
+	if err := db.Put(testKey(1), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	expectHookEvents(t, recorder, "put "+string(testKey(1)))
+
+	faulty := &diskFullIO{IOManager: db.activeFile.IOManager, failWrites: true}
+	db.activeFile.IOManager = faulty
+	if err := db.Put(testKey(2), testValue(2)); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected ENOSPC, got %v", err)
+	}
+	if err := db.Delete(testKey(1)); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected ENOSPC, got %v", err)
+	}
+	expectHookEvents(t, recorder)
+
+	faulty.failWrites = false
+	if err := db.Delete(testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	expectHookEvents(t, recorder, "delete "+string(testKey(1)))
+}
+
+// 每次切换活跃文件调用一次，调用时文件已经持久化，之后不会再追加
+func TestDB_HooksOnFileRotation(t *testing.T) {
+	type rotation struct {
+		fileId uint32
+		path   string
+		size   int64
+	}
+	var mu sync.Mutex
+	var rotations []rotation
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+		options.Hooks.OnFileRotation = func(fileId uint32, path string, size int64) {
+			content, err := os.ReadFile(path)
+			if err != nil || int64(len(content)) < size || size == 0 {
+				t.Errorf("sealed file %d: read %d bytes, size %d, %v", fileId, len(content), size, err)
+			}
+			mu.Lock()
+			rotations = append(rotations, rotation{fileId: fileId, path: path, size: size})
+			mu.Unlock()
+		}
+	})
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// merge开始时同样封存活跃文件
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	activeFileId := db.activeFile.FileId
+	dirPath := db.options.DirPath
+	// Close 等待所有通知完成
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rotations) != int(activeFileId) || len(rotations) < 3 {
+		t.Fatalf("expected %d rotations, got %d", activeFileId, len(rotations))
+	}
+	for i, r := range rotations {
+		if r.fileId != uint32(i) {
+			t.Fatalf("rotation %d for file %d, want in order", i, r.fileId)
+		}
+		if r.path != db.getDataFileName(dirPath, r.fileId) {
+			t.Fatalf("unexpected path %s for file %d", r.path, r.fileId)
+		}
+		stat, err := os.Stat(r.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Size() != r.size {
+			t.Fatalf("file %d sealed with size %d, later size %d", r.fileId, r.size, stat.Size())
+		}
+	}
+}
+
+// 拒绝以"_"开头的key：被拒绝的写入返回回调的错误且不生效，其他写入正常进行
+func TestDB_BeforeWrite(t *testing.T) {
+	errReserved := errors.New("reserved key")
+	var calls []string
+	db := openTestDB(t, func(options *Options) {
+		options.BeforeWrite = func(key, value []byte, isDelete bool) error {
+			calls = append(calls, fmt.Sprintf("%s=%s %v", key, value, isDelete))
+			if len(key) > 0 && key[0] == '_' {
+				return errReserved
+			}
+			return nil
+		}
+	})
+
+	if err := db.Put([]byte("_meta"), []byte("v")); err != errReserved {
+		t.Fatalf("expected errReserved, got %v", err)
+	}
+	if err := db.PutWithTTL([]byte("_meta"), []byte("v"), time.Hour); err != errReserved {
+		t.Fatalf("expected errReserved, got %v", err)
+	}
+	if err := db.Put([]byte("user"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete([]byte("_meta")); err != errReserved {
+		t.Fatalf("expected errReserved, got %v", err)
+	}
+	if err := db.Update([]byte("_meta"), func([]byte) ([]byte, error) { return []byte("v"), nil }); err != errReserved {
+		t.Fatalf("expected errReserved, got %v", err)
+	}
+	if err := db.Update([]byte("user"), func([]byte) ([]byte, error) { return []byte("v2"), nil }); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(calls) != "[_meta=v false _meta=v false user=v false _meta= true _meta=v false user=v2 false]" {
+		t.Fatalf("unexpected calls %q", calls)
+	}
+
+	// 批次中任何一个key被拒绝时整个批次都不写入，移除后可以重新提交
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	if err := wb.Put([]byte("batch"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Put([]byte("_batch"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Commit(); err != errReserved {
+		t.Fatalf("expected errReserved, got %v", err)
+	}
+	expectContent(t, db, map[string]string{"user": "v2"})
+	if err := wb.Delete([]byte("_batch")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, map[string]string{"user": "v2", "batch": "v"})
+
+	calls = nil
+	if err := wb.Delete([]byte("user")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(calls) != "[user= true]" {
+		t.Fatalf("unexpected calls %q", calls)
+	}
+	expectContent(t, db, map[string]string{"batch": "v"})
+
+	// 重命名写入的新key同样经过校验
+	if err := db.Rename([]byte("batch"), []byte("_batch")); err != errReserved {
+		t.Fatalf("expected errReserved, got %v", err)
+	}
+	if n, err := db.RenamePrefix([]byte("bat"), []byte("_bat")); err != errReserved || n != 0 {
+		t.Fatalf("expected errReserved, got %d, %v", n, err)
+	}
+	expectContent(t, db, map[string]string{"batch": "v"})
+	if err := db.Rename([]byte("batch"), []byte("renamed")); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, map[string]string{"renamed": "v"})
+}
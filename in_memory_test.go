@@ -0,0 +1,188 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 打开内存模式的数据库，DirPath 指向一个不存在的目录，用于检查没有创建任何文件
+func openInMemoryTestDB(t *testing.T, configure func(options *Options)) *DB {
+	t.Helper()
+	return openTestDB(t, func(options *Options) {
+		options.DirPath = filepath.Join(t.TempDir(), "in-memory")
+		options.InMemory = true
+		if configure != nil {
+			configure(options)
+		}
+	})
+}
+
+func expectNoDataDir(t *testing.T, db *DB) {
+	t.Helper()
+	if _, err := os.Stat(db.options.DirPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no data dir, got %v", err)
+	}
+}
+
+func TestDB_InMemory(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.IndexType = ART },
+		func(options *Options) { options.ValueLogSeparationThreshold = 16 },
+		func(options *Options) { options.StripedLockCount = 16 },
+		func(options *Options) { options.MaxVersionsPerKey = 3 },
+		func(options *Options) { options.DirPath = "" },
+	} {
+		db := openInMemoryTestDB(t, func(options *Options) {
+			// 写入过程中切换活跃文件
+			options.DataFileSize = 4 * 1024
+			if configure != nil {
+				configure(options)
+			}
+		})
+
+		writeReplicationData(t, db, 0)
+		writeReplicationData(t, db, 1)
+		expected := dumpDB(t, db)
+		if len(expected) == 0 {
+			t.Fatal("expected keys after writes")
+		}
+		if stat := db.Stat(); stat.DataFileNum < 2 || stat.DiskSize != 0 {
+			t.Fatalf("unexpected stat: %+v", stat)
+		}
+
+		if err := db.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		value, err := db.Get([]byte("key"))
+		if err != nil || string(value) != "value" {
+			t.Fatalf("unexpected get: %q, %v", value, err)
+		}
+		if err := db.Delete([]byte("key")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Get([]byte("key")); err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+		if err := db.PutWithTTL([]byte("ttl"), []byte("v"), time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+		if _, err := db.Get([]byte("ttl")); err != ErrKeyNotFound {
+			t.Fatalf("expected expired key, got %v", err)
+		}
+
+		// 迭代器按key的顺序读取内存中的value
+		iterator := db.NewIterator(DefaultIteratorOptions)
+		var count int
+		var prev []byte
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			value, err := iterator.Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected[string(iterator.Key())] != string(value) {
+				t.Fatalf("key %s: expected %q, got %q", iterator.Key(), expected[string(iterator.Key())], value)
+			}
+			if prev != nil && bytes.Compare(prev, iterator.Key()) >= 0 {
+				t.Fatalf("keys out of order: %s, %s", prev, iterator.Key())
+			}
+			prev = append(prev[:0], iterator.Key()...)
+			count++
+		}
+		iterator.Close()
+		if count != len(expected) {
+			t.Fatalf("expected %d keys, got %d", len(expected), count)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if db.options.DirPath != "" {
+			expectNoDataDir(t, db)
+		}
+
+		// 重新打开之后是一个空的数据库
+		db = reopenTestDB(t, db)
+		expectKeyCount(t, db, 0)
+	}
+}
+
+// 不支持需要数据目录的操作，清空和写入磁盘的MergeTo仍然可用
+func TestDB_InMemoryDiskOperations(t *testing.T) {
+	db := openInMemoryTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+	})
+	writeReplicationData(t, db, 0)
+
+	if err := db.MergeForce(); err != ErrInMemoryUnsupported {
+		t.Fatalf("expected ErrInMemoryUnsupported from merge, got %v", err)
+	}
+	if _, err := db.MergeN(1); err != ErrInMemoryUnsupported {
+		t.Fatalf("expected ErrInMemoryUnsupported from incremental merge, got %v", err)
+	}
+	if err := db.Vacuum(); err != ErrInMemoryUnsupported {
+		t.Fatalf("expected ErrInMemoryUnsupported from vacuum, got %v", err)
+	}
+	if _, err := db.Truncate(); err != ErrInMemoryUnsupported {
+		t.Fatalf("expected ErrInMemoryUnsupported from truncate, got %v", err)
+	}
+	if err := db.Backup(t.TempDir()); err != ErrInMemoryUnsupported {
+		t.Fatalf("expected ErrInMemoryUnsupported from backup, got %v", err)
+	}
+
+	// 内存中的数据可以通过MergeTo保存到磁盘
+	expected := dumpDB(t, db)
+	targetDir := filepath.Join(t.TempDir(), "target")
+	if err := db.MergeTo(targetDir); err != nil {
+		t.Fatal(err)
+	}
+	options := DefaultOptions
+	options.DirPath = targetDir
+	target, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, target, expected)
+	if err := target.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	expectKeyCount(t, db, 0)
+	if err := db.Put([]byte("after"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, map[string]string{"after": "v"})
+	expectNoDataDir(t, db)
+}
+
+func TestOpen_InMemoryInvalidOptions(t *testing.T) {
+	for _, configure := range []func(*Options){
+		func(options *Options) { options.ReadOnly = true },
+		func(options *Options) { options.IndexType = BPlusTree },
+		func(options *Options) { options.AuditLog = true },
+		func(options *Options) { options.MMapActiveFile = true },
+		func(options *Options) { options.IOUringActiveFile = true },
+		func(options *Options) { options.DataFilePreAllocSize = 1024 },
+		func(options *Options) { options.ChecksumMode = PerBlock },
+	} {
+		options := DefaultOptions
+		options.DirPath = filepath.Join(t.TempDir(), "in-memory")
+		options.InMemory = true
+		configure(&options)
+		db, err := Open(options)
+		if err == nil {
+			_ = db.Close()
+			t.Fatalf("expected error for options %+v", options)
+		}
+		if _, err := os.Stat(options.DirPath); !os.IsNotExist(err) {
+			t.Fatalf("expected no data dir, got %v", err)
+		}
+	}
+}
@@ -13,8 +13,9 @@ import (
 // 自适应基数树索引
 // 主要封装了 https://github.com/plar/go-adaptive-radix-tree 库
 type AdaptiveRadixTree struct {
-	tree goart.Tree
-	lock *sync.RWMutex
+	tree     goart.Tree
+	lock     *sync.RWMutex
+	expiring int // 带有过期时间的key的数量
 }
 
 // 初始化自适应基数树索引
@@ -28,11 +29,26 @@ func NewART() *AdaptiveRadixTree {
 func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
 	oldValue, _ := art.tree.Insert(key, pos)
+	var oldPos *data.LogRecordPos
+	if oldValue != nil {
+		oldPos = oldValue.(*data.LogRecordPos)
+	}
+	art.expiring += expiringDelta(oldPos, pos)
 	art.lock.Unlock()
-	if oldValue == nil {
-		return nil
+	return oldPos
+}
+
+func (art *AdaptiveRadixTree) PutBatch(keys [][]byte, positions []*data.LogRecordPos) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(keys))
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	for i, key := range keys {
+		if oldValue, _ := art.tree.Insert(key, positions[i]); oldValue != nil {
+			oldPositions[i] = oldValue.(*data.LogRecordPos)
+		}
+		art.expiring += expiringDelta(oldPositions[i], positions[i])
 	}
-	return oldValue.(*data.LogRecordPos)
+	return oldPositions
 }
 
 func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
@@ -48,11 +64,14 @@ func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
 func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
 	oldValue, deleted := art.tree.Delete(key)
-	art.lock.Unlock()
 	if oldValue == nil {
+		art.lock.Unlock()
 		return nil, false
 	}
-	return oldValue.(*data.LogRecordPos), deleted
+	oldPos := oldValue.(*data.LogRecordPos)
+	art.expiring += expiringDelta(oldPos, nil)
+	art.lock.Unlock()
+	return oldPos, deleted
 }
 
 // 索引中的数据量
@@ -63,6 +82,13 @@ func (art *AdaptiveRadixTree) Size() int {
 	return size
 }
 
+// 带有过期时间的key的数量
+func (art *AdaptiveRadixTree) ExpiringSize() int {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	return art.expiring
+}
+
 // 获取索引迭代器
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	art.lock.RLock()
@@ -0,0 +1,168 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"os"
+	"path/filepath"
+
+	goart "github.com/plar/go-adaptive-radix-tree"
+
+	"bitcask-go/data"
+)
+
+// 持久化的ART索引文件名
+const ARTIndexFileName = "art-index"
+
+var ErrARTIndexCorrupted = errors.New("ART索引文件已损坏")
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// 持久化的自适应基数树索引，关闭数据库时将整棵树序列化到 art-index 文件中，下次打开时直接加载，不需要重放数据文件
+// 文件格式：元数据长度 | 元数据 | key数量 | (key长度 | key | 位置长度 | 位置)... | crc64，长度和数量均为uvarint
+// 元数据由调用方定义，用于判断文件和数据文件是否一致
+type PersistentART struct {
+	*AdaptiveRadixTree
+	dirPath string
+}
+
+// 初始化持久化的自适应基数树索引，不会读取文件，需要调用 Load 加载
+func NewPersistentART(dirPath string) *PersistentART {
+	return &PersistentART{
+		AdaptiveRadixTree: NewART(),
+		dirPath:           dirPath,
+	}
+}
+
+// 将索引和元数据写入文件，先写入临时文件再重命名，写入过程中异常退出不会破坏之前的文件
+func (pa *PersistentART) Save(meta []byte) error {
+	fileName := filepath.Join(pa.dirPath, ARTIndexFileName)
+	tmpFileName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	hash := crc64.New(crc64Table)
+	writer := bufio.NewWriter(file)
+	buf := make([]byte, binary.MaxVarintLen64)
+	var writeErr error
+	write := func(b []byte) {
+		if writeErr != nil {
+			return
+		}
+		_, _ = hash.Write(b)
+		_, writeErr = writer.Write(b)
+	}
+	writeBytes := func(b []byte) {
+		n := binary.PutUvarint(buf, uint64(len(b)))
+		write(buf[:n])
+		write(b)
+	}
+
+	writeBytes(meta)
+	pa.lock.RLock()
+	n := binary.PutUvarint(buf, uint64(pa.tree.Size()))
+	write(buf[:n])
+	pa.tree.ForEach(func(node goart.Node) bool {
+		writeBytes(node.Key())
+		writeBytes(data.EncodeLogRecordPos(node.Value().(*data.LogRecordPos)))
+		return writeErr == nil
+	})
+	pa.lock.RUnlock()
+
+	if writeErr == nil {
+		binary.LittleEndian.PutUint64(buf[:8], hash.Sum64())
+		_, writeErr = writer.Write(buf[:8])
+	}
+	if writeErr == nil {
+		writeErr = writer.Flush()
+	}
+	if writeErr == nil {
+		writeErr = file.Sync()
+	}
+	if err := file.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		_ = os.Remove(tmpFileName)
+		return writeErr
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// 从文件加载索引，替换当前索引中的数据，返回保存时的元数据
+// 文件不存在时返回的错误满足 os.IsNotExist，文件损坏时返回 ErrARTIndexCorrupted，两种情况下当前索引都不会被修改
+func (pa *PersistentART) Load() ([]byte, error) {
+	buf, err := os.ReadFile(filepath.Join(pa.dirPath, ARTIndexFileName))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 8 {
+		return nil, ErrARTIndexCorrupted
+	}
+	content := buf[:len(buf)-8]
+	if crc64.Checksum(content, crc64Table) != binary.LittleEndian.Uint64(buf[len(buf)-8:]) {
+		return nil, ErrARTIndexCorrupted
+	}
+
+	var index int
+	readBytes := func() ([]byte, bool) {
+		length, n := binary.Uvarint(content[index:])
+		if n <= 0 || length > uint64(len(content)-index-n) {
+			return nil, false
+		}
+		index += n
+		b := content[index : index+int(length)]
+		index += int(length)
+		return b, true
+	}
+
+	meta, ok := readBytes()
+	if !ok {
+		return nil, ErrARTIndexCorrupted
+	}
+	count, n := binary.Uvarint(content[index:])
+	if n <= 0 {
+		return nil, ErrARTIndexCorrupted
+	}
+	index += n
+
+	// 全部解析成功之后才替换当前的树
+	tree := goart.New()
+	var expiring int
+	for i := uint64(0); i < count; i++ {
+		key, ok := readBytes()
+		if !ok {
+			return nil, ErrARTIndexCorrupted
+		}
+		pos, ok := readBytes()
+		if !ok {
+			return nil, ErrARTIndexCorrupted
+		}
+		decoded := data.DecodeLogRecordPos(pos)
+		if decoded.Expire != 0 {
+			expiring++
+		}
+		tree.Insert(append([]byte(nil), key...), decoded)
+	}
+	if index != len(content) {
+		return nil, ErrARTIndexCorrupted
+	}
+
+	pa.lock.Lock()
+	pa.tree = tree
+	pa.expiring = expiring
+	pa.lock.Unlock()
+	return meta, nil
+}
+
+// 删除索引文件，加载之后的写入不会反映在文件中，异常退出时必须重放数据文件
+func RemoveARTIndexFile(dirPath string) error {
+	if err := os.Remove(filepath.Join(dirPath, ARTIndexFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
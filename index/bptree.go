@@ -1,6 +1,8 @@
 package index
 
 import (
+	"bytes"
+	"encoding/binary"
 	"path/filepath"
 
 	"go.etcd.io/bbolt"
@@ -12,6 +14,12 @@ const bptreeIndexFileName = "bptree-index"
 
 var indexBucketName = []byte("bitcask-index")
 
+// 索引的统计信息单独存放，不计入索引bucket的key数量
+var (
+	metaBucketName  = []byte("bitcask-index-meta")
+	expiringKeyName = []byte("expiring")
+)
+
 // BPlusTree B+ 树索引
 // 主要封装了 go.etcd.io/bbolt 库
 type BPlusTree struct {
@@ -30,8 +38,28 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 
 	// 创建对应的bucket，后续操作通过bucket实现，update方法内部实现了事务
 	if err := bptree.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(indexBucketName)
-		return err
+		bucket, err := tx.CreateBucketIfNotExists(indexBucketName)
+		if err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists(metaBucketName)
+		if err != nil {
+			return err
+		}
+		if meta.Get(expiringKeyName) != nil {
+			return nil
+		}
+		// 之前版本创建的索引文件没有记录带有过期时间的key的数量，遍历一次补上
+		var expiring int
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if data.DecodeLogRecordPos(v).Expire != 0 {
+				expiring++
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		return putExpiring(meta, expiring)
 	}); err != nil {
 		panic("failed to create bucket in bptree")
 	}
@@ -40,18 +68,42 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 }
 
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
-	var oldVal []byte
+	var oldPos *data.LogRecordPos
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		oldVal = bucket.Get(key)
-		return bucket.Put(key, data.EncodeLogRecordPos(pos))
+		// bucket返回的value只在事务内有效，需要在事务内解码
+		if oldVal := bucket.Get(key); len(oldVal) != 0 {
+			oldPos = data.DecodeLogRecordPos(oldVal)
+		}
+		if err := bucket.Put(key, data.EncodeLogRecordPos(pos)); err != nil {
+			return err
+		}
+		return addExpiring(tx, expiringDelta(oldPos, pos))
 	}); err != nil {
 		panic("failed to put value in bptree")
 	}
-	if len(oldVal) == 0 {
-		return nil
+	return oldPos
+}
+
+func (bpt *BPlusTree) PutBatch(keys [][]byte, positions []*data.LogRecordPos) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(keys))
+	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		var delta int
+		for i, key := range keys {
+			if oldVal := bucket.Get(key); len(oldVal) != 0 {
+				oldPositions[i] = data.DecodeLogRecordPos(oldVal)
+			}
+			if err := bucket.Put(key, data.EncodeLogRecordPos(positions[i])); err != nil {
+				return err
+			}
+			delta += expiringDelta(oldPositions[i], positions[i])
+		}
+		return addExpiring(tx, delta)
+	}); err != nil {
+		panic("failed to put values in bptree")
 	}
-	return data.DecodeLogRecordPos(oldVal)
+	return oldPositions
 }
 
 func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
@@ -70,20 +122,24 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 }
 
 func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
-	var oldVal []byte
+	var oldPos *data.LogRecordPos
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		if oldVal = bucket.Get(key); len(oldVal) != 0 {
-			return bucket.Delete(key)
+		if oldVal := bucket.Get(key); len(oldVal) != 0 {
+			oldPos = data.DecodeLogRecordPos(oldVal)
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+			return addExpiring(tx, expiringDelta(oldPos, nil))
 		}
 		return nil
 	}); err != nil {
 		panic("failed to delete value in bptree")
 	}
-	if len(oldVal) == 0 {
+	if oldPos == nil {
 		return nil, false
 	}
-	return data.DecodeLogRecordPos(oldVal), true
+	return oldPos, true
 }
 
 func (bpt *BPlusTree) Size() int {
@@ -98,6 +154,34 @@ func (bpt *BPlusTree) Size() int {
 	return size
 }
 
+func (bpt *BPlusTree) ExpiringSize() int {
+	var expiring int
+	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		value, _ := binary.Uvarint(tx.Bucket(metaBucketName).Get(expiringKeyName))
+		expiring = int(value)
+		return nil
+	}); err != nil {
+		panic("failed to get expiring size in bptree")
+	}
+	return expiring
+}
+
+// 在写入索引的事务中更新带有过期时间的key的数量
+func addExpiring(tx *bbolt.Tx, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	meta := tx.Bucket(metaBucketName)
+	value, _ := binary.Uvarint(meta.Get(expiringKeyName))
+	return putExpiring(meta, int(value)+delta)
+}
+
+func putExpiring(meta *bbolt.Bucket, expiring int) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(expiring))
+	return meta.Put(expiringKeyName, buf[:n])
+}
+
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
 	return newBptreeIterator(bpt.tree, reverse)
 }
@@ -139,6 +223,15 @@ func (bpi *bptreeIterator) Rewind() {
 
 func (bpi *bptreeIterator) Seek(key []byte) {
 	bpi.currKey, bpi.currValue = bpi.cursor.Seek(key)
+	if !bpi.reverse {
+		return
+	}
+	// 游标定位到第一个大于等于key的位置，反向遍历时需要第一个小于等于key的位置
+	if bpi.currKey == nil {
+		bpi.currKey, bpi.currValue = bpi.cursor.Last()
+	} else if bytes.Compare(bpi.currKey, key) > 0 {
+		bpi.currKey, bpi.currValue = bpi.cursor.Prev()
+	}
 }
 
 func (bpi *bptreeIterator) Next() {
@@ -15,14 +15,18 @@ type BTree struct {
 	// Google的btree.BTree
 	tree *btree.BTree
 
-	// 读操作并发安全，写操作需要加锁
+	// 读写操作都需要加锁
 	lock *sync.RWMutex
+
+	// 带有过期时间的key的数量
+	expiring int
 }
 
-// 初始化BTree索引
-func NewBtree() *BTree {
+// 初始化BTree索引，degree 为BTree的阶数（每个节点最多 2*degree-1 个元素），必须大于等于2
+// 阶数较大时节点更少、占用的内存更少，阶数较小时插入和删除移动的元素更少
+func NewBtree(degree int) *BTree {
 	return &BTree{
-		tree: btree.New(32),
+		tree: btree.New(degree),
 		lock: new(sync.RWMutex),
 	}
 }
@@ -33,19 +37,36 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	bt.lock.Lock()
 	// 将执行的Item类型插入到Btree中，如果已存在则返回旧值
 	oldItem := bt.tree.ReplaceOrInsert(&it)
+	var oldPos *data.LogRecordPos
+	if oldItem != nil {
+		oldPos = oldItem.(*Item).pos
+	}
+	bt.expiring += expiringDelta(oldPos, pos)
 	// 解锁
 	bt.lock.Unlock()
-	if oldItem == nil {
-		return nil
+	return oldPos
+}
+
+func (bt *BTree) PutBatch(keys [][]byte, positions []*data.LogRecordPos) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(keys))
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	for i, key := range keys {
+		if oldItem := bt.tree.ReplaceOrInsert(&Item{key: key, pos: positions[i]}); oldItem != nil {
+			oldPositions[i] = oldItem.(*Item).pos
+		}
+		bt.expiring += expiringDelta(oldPositions[i], positions[i])
 	}
-	return oldItem.(*Item).pos
+	return oldPositions
 }
 
-// 读操作不用加锁
+// 读操作加读锁，开启分段锁时写入可能和读取并发执行
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
 	// btreeItem为google中的btree.Item，需要转换为自定义的Item类型
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(it)
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -56,17 +77,28 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	it := &Item{key: key}
 	bt.lock.Lock()
 	oldItem := bt.tree.Delete(it)
-	bt.lock.Unlock()
 	if oldItem == nil {
+		bt.lock.Unlock()
 		return nil, false
 	}
-	return oldItem.(*Item).pos, true
+	oldPos := oldItem.(*Item).pos
+	bt.expiring += expiringDelta(oldPos, nil)
+	bt.lock.Unlock()
+	return oldPos, true
 }
 
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
+func (bt *BTree) ExpiringSize() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	return bt.expiring
+}
+
 // 获取索引迭代器
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
@@ -19,13 +19,34 @@ type Indexer interface {
 	// 索引中的数据量
 	Size() int
 
+	// 索引中带有过期时间的key的数量，为0时 Size 中没有可能已经过期的key
+	ExpiringSize() int
+
 	// 获取索引迭代器
 	Iterator(reverse bool) Iterator
 
-	// 关闭索引迭代器（只有B+树索引需要）
+	// 关闭索引（B+树索引关闭bolt数据库，可能失败，错误由 DB.Close 返回；内存索引总是返回nil）
 	Close() error
 }
 
+// 支持批量写入的索引，一次加锁（B+树为一个事务）写入多个key，返回每个key原来的位置（不存在时为nil）
+// 同一个key出现多次时后面的位置覆盖前面的，返回的原位置为前一次写入的位置
+type BatchPutter interface {
+	PutBatch(keys [][]byte, positions []*data.LogRecordPos) []*data.LogRecordPos
+}
+
+// 写入或删除一个key之后带有过期时间的key的数量变化
+func expiringDelta(oldPos, newPos *data.LogRecordPos) int {
+	var delta int
+	if oldPos != nil && oldPos.Expire != 0 {
+		delta--
+	}
+	if newPos != nil && newPos.Expire != 0 {
+		delta++
+	}
+	return delta
+}
+
 type IndexType = int8
 
 const (
@@ -37,17 +58,22 @@ const (
 
 	// BPlusTree B+树索引，将索引存储在磁盘上
 	BPTree
+
+	// 持久化的ART索引，关闭时保存到文件中
+	ARTPersistent
 )
 
-// 初始化索引
-func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
+// 初始化索引，btreeDegree 只用于BTree索引
+func NewIndexer(typ IndexType, dirPath string, sync bool, btreeDegree int) Indexer {
 	switch typ {
 	case Btree:
-		return NewBtree()
+		return NewBtree(btreeDegree)
 	case ART:
 		return NewART()
 	case BPTree:
 		return NewBPlusTree(dirPath, sync)
+	case ARTPersistent:
+		return NewPersistentART(dirPath)
 	default:
 		panic("unsupported index type")
 	}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package bitcask_go
+
+import (
+	"testing"
+
+	"bitcask-go/fio"
+)
+
+// 活跃文件使用 io_uring 写入，轮转之后的旧文件和重启之后都能读到所有数据
+func TestDB_IOUringActiveFile(t *testing.T) {
+	for name, configure := range map[string]func(*Options){
+		"PerRecord": func(options *Options) { options.DataFilePreAllocSize = 64 * 1024 },
+		"PerBlock":  func(options *Options) { options.ChecksumMode = PerBlock },
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(options *Options) {
+				options.IOUringActiveFile = true
+				options.DataFileSize = 16 * 1024
+				configure(options)
+			})
+			if err := db.Put(testKey(0), testValue(0)); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := db.activeFile.IOManager.(*fio.IOUringManager); !ok && db.options.ChecksumMode == PerRecord {
+				t.Skip("io_uring is not supported by the kernel")
+			}
+
+			expected := make(map[string]string)
+			for i := 0; i < 2000; i++ {
+				if err := db.Put(testKey(i), testValue(i)); err != nil {
+					t.Fatal(err)
+				}
+				expected[string(testKey(i))] = string(testValue(i))
+			}
+			for i := 0; i < 2000; i += 3 {
+				if err := db.Delete(testKey(i)); err != nil {
+					t.Fatal(err)
+				}
+				delete(expected, string(testKey(i)))
+			}
+			if len(db.olderFiles) == 0 {
+				t.Fatal("expected the active file to rotate")
+			}
+			if err := db.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			expectContent(t, db, expected)
+
+			db = reopenTestDB(t, db)
+			expectContent(t, db, expected)
+		})
+	}
+}
@@ -2,7 +2,9 @@ package bitcask_go
 
 import (
 	"bytes"
+	"time"
 
+	"bitcask-go/data"
 	"bitcask-go/index"
 )
 
@@ -11,16 +13,22 @@ type Iterator struct {
 	indexIter index.Iterator // 索引迭代器
 	db        *DB
 	options   IteratorOptions
+	readAhead *readAhead // 开启 SequentialReadHint 时检测顺序读取
+	exhausted bool       // 已经沿遍历方向越过了前缀的范围，之后不会再有匹配的key
 }
 
 // 初始化迭代器
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
 	indexIter := db.index.Iterator(opts.Reverse)
-	return &Iterator{
+	it := &Iterator{
 		db:        db,
 		indexIter: indexIter,
 		options:   opts,
 	}
+	if opts.SequentialReadHint {
+		it.readAhead = newReadAhead()
+	}
+	return it
 }
 
 // 重新回到迭代器的起点，第一个数据
@@ -35,6 +43,32 @@ func (it *Iterator) Seek(key []byte) {
 	it.skipToNext()
 }
 
+// 切换遍历的前缀，并跳转到第一个匹配前缀的key（反向遍历时为最后一个），同一个迭代器可以依次遍历多个前缀
+func (it *Iterator) SeekPrefix(prefix []byte) {
+	it.options.Prefix = prefix
+	if !it.options.Reverse {
+		it.indexIter.Seek(prefix)
+	} else if upper := prefixUpperBound(prefix); upper != nil {
+		// 反向遍历时从第一个小于等于上界的key开始，上界本身不匹配前缀，会被跳过
+		it.indexIter.Seek(upper)
+	} else {
+		it.indexIter.Rewind()
+	}
+	it.skipToNext()
+}
+
+// 返回大于所有以prefix为前缀的key的最小值，prefix为空或全为0xff时没有上界，返回nil
+func prefixUpperBound(prefix []byte) []byte {
+	upper := append([]byte(nil), prefix...)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xff {
+			upper[i]++
+			return upper[:i+1]
+		}
+	}
+	return nil
+}
+
 // 跳转到下一个key
 func (it *Iterator) Next() {
 	it.indexIter.Next()
@@ -43,7 +77,7 @@ func (it *Iterator) Next() {
 
 // 是否已经遍历完所有的key，用于退出遍历
 func (it *Iterator) Valid() bool {
-	return it.indexIter.Valid()
+	return !it.exhausted && it.indexIter.Valid()
 }
 
 // 当前遍历位置的key数据
@@ -54,10 +88,15 @@ func (it *Iterator) Key() []byte {
 // 当前遍历位置的value数据
 func (it *Iterator) Value() ([]byte, error) {
 	logRecordPos := it.indexIter.Value()
-	it.db.mu.Lock()
+	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
 	// 去文件中读取
-	return it.db.getValueByPosition(logRecordPos)
+	return it.db.readValue(logRecordPos, it.readAhead)
+}
+
+// 当前遍历位置的数据在数据文件中的位置
+func (it *Iterator) Pos() *data.LogRecordPos {
+	return it.indexIter.Value()
 }
 
 // 关闭迭代器，释放相应资源
@@ -65,19 +104,26 @@ func (it *Iterator) Close() {
 	it.indexIter.Close()
 }
 
-// 跳过不符合前缀的key
+// 跳过不符合前缀以及已过期的key，索引迭代器的 Next 按创建时的方向移动，反向遍历时跳向更小的key
+// 匹配前缀的key是连续的一段：沿遍历方向还没到这一段时继续跳过，越过这一段之后（正向遍历时key大于前缀，反向遍历时key小于前缀）直接结束遍历
 func (it *Iterator) skipToNext() {
-	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
-	}
+	it.exhausted = false
+	prefix := it.options.Prefix
+	now := time.Now().UnixNano()
 
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
 		// 迭代器当前遍历到的key
 		key := it.indexIter.Key()
 
 		// 判断key的前缀是否匹配
-		if prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
+		if len(prefix) > 0 && !bytes.HasPrefix(key, prefix) {
+			if afterPrefix := bytes.Compare(key, prefix) > 0; afterPrefix != it.options.Reverse {
+				it.exhausted = true
+				return
+			}
+			continue
+		}
+		if !it.indexIter.Value().IsExpired(now) {
 			break
 		}
 	}
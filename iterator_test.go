@@ -0,0 +1,138 @@
+package bitcask_go
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 依次遍历多个前缀，返回遍历到的key
+func walkPrefixes(it *Iterator, prefixes ...string) []string {
+	var keys []string
+	for _, prefix := range prefixes {
+		for it.SeekPrefix([]byte(prefix)); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Key()))
+		}
+	}
+	return keys
+}
+
+func TestIterator_SeekPrefix(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+		})
+		for _, key := range []string{"a", "a:1", "a:2", "a:3", "a;", "b:1", "b:2", "c:1", "\xff:1", "\xff\xff"} {
+			if err := db.Put([]byte(key), []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		check := func(reverse bool, expected []string, prefixes ...string) {
+			t.Helper()
+			it := db.NewIterator(IteratorOptions{Reverse: reverse})
+			defer it.Close()
+			keys := walkPrefixes(it, prefixes...)
+			if fmt.Sprint(keys) != fmt.Sprint(expected) {
+				t.Fatalf("index %d reverse=%v prefixes %q: got %q, want %q", indexType, reverse, prefixes, keys, expected)
+			}
+		}
+		check(false, []string{"a:1", "a:2", "a:3", "b:1", "b:2"}, "a:", "b:")
+		check(true, []string{"a:3", "a:2", "a:1", "b:2", "b:1"}, "a:", "b:")
+		// 先遍历排在后面的前缀再回到前面的前缀
+		check(false, []string{"c:1", "a:1", "a:2", "a:3"}, "c:", "a:")
+		// 上界不存在的前缀
+		check(true, []string{"\xff\xff", "\xff:1"}, "\xff")
+		check(false, nil, "d:")
+	}
+}
+
+// 反向遍历时 Seek 跳转到第一个小于等于key的位置，之后按前缀过滤并跳过已过期的key，方向和 Next 相同
+func TestIterator_SeekReversePrefix(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree, PersistentART} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+		})
+		for _, key := range []string{"a", "a:1", "a:3", "a:5", "b:1", "b:2"} {
+			if err := db.Put([]byte(key), []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		putExpired(t, db, []byte("a:4"), []byte("value"))
+
+		check := func(reverse bool, seek string, expected ...string) {
+			t.Helper()
+			it := db.NewIterator(IteratorOptions{Prefix: []byte("a:"), Reverse: reverse})
+			defer it.Close()
+			var keys []string
+			for it.Seek([]byte(seek)); it.Valid(); it.Next() {
+				keys = append(keys, string(it.Key()))
+			}
+			if fmt.Sprint(keys) != fmt.Sprint(expected) {
+				t.Fatalf("index %d reverse=%v seek %q: got %q, want %q", indexType, reverse, seek, keys, expected)
+			}
+		}
+		check(true, "a:3", "a:3", "a:1")
+		// 已过期的key被跳过
+		check(true, "a:4", "a:3", "a:1")
+		check(true, "a:9", "a:5", "a:3", "a:1")
+		// 跳过前缀之后的key
+		check(true, "b:1", "a:5", "a:3", "a:1")
+		check(true, "\xff", "a:5", "a:3", "a:1")
+		// 前缀之前的key不匹配
+		check(true, "a:0")
+		check(true, "a")
+		check(false, "a:2", "a:3", "a:5")
+		check(false, "a:4", "a:5")
+		check(false, "a:6")
+		check(false, "", "a:1", "a:3", "a:5")
+	}
+}
+
+// 反向遍历带前缀的迭代器只返回匹配的key，按从大到小的顺序，越过前缀的范围之后结束
+func TestIterator_ReversePrefix(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree, PersistentART} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+		})
+		for _, key := range []string{"a", "ab", "b", "b:", "b:1", "b:2", "b:2:x", "b;", "c", "c:1"} {
+			if err := db.Put([]byte(key), []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		putExpired(t, db, []byte("b:3"), []byte("value"))
+
+		for _, reverse := range []bool{false, true} {
+			it := db.NewIterator(IteratorOptions{Prefix: []byte("b:"), Reverse: reverse})
+			var keys []string
+			for it.Rewind(); it.Valid(); it.Next() {
+				keys = append(keys, string(it.Key()))
+			}
+			// 越过前缀的范围之后保持结束状态
+			it.Next()
+			if it.Valid() {
+				t.Fatalf("index %d reverse=%v: iterator is valid after the prefix range", indexType, reverse)
+			}
+			it.Close()
+
+			expected := []string{"b:", "b:1", "b:2", "b:2:x"}
+			if reverse {
+				expected = []string{"b:2:x", "b:2", "b:1", "b:"}
+			}
+			if fmt.Sprint(keys) != fmt.Sprint(expected) {
+				t.Fatalf("index %d reverse=%v: got %q, want %q", indexType, reverse, keys, expected)
+			}
+		}
+
+		// 越过范围之后可以重新 Seek 回到范围内
+		it := db.NewIterator(IteratorOptions{Prefix: []byte("b:"), Reverse: true})
+		it.Seek([]byte("b"))
+		if it.Valid() {
+			t.Fatalf("index %d: expected no key before the prefix range, got %q", indexType, it.Key())
+		}
+		it.Seek([]byte("b:1"))
+		if !it.Valid() || string(it.Key()) != "b:1" {
+			t.Fatalf("index %d: expected b:1 after seeking back", indexType)
+		}
+		it.Close()
+	}
+}
@@ -0,0 +1,62 @@
+package lock
+
+import "sync"
+
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// 分段锁，按key的哈希值选择其中一把读写锁
+// 同一个key总是对应同一把锁，不同的key大概率落在不同的锁上，可以并发执行
+type Striped struct {
+	locks []sync.RWMutex
+}
+
+// 初始化分段锁，count为锁的数量
+func NewStriped(count int) *Striped {
+	if count <= 0 {
+		count = 1
+	}
+	return &Striped{locks: make([]sync.RWMutex, count)}
+}
+
+// 获取key对应的锁
+func (s *Striped) For(key []byte) *sync.RWMutex {
+	return &s.locks[fnv32(key)%uint32(len(s.locks))]
+}
+
+// 对key对应的锁加写锁
+func (s *Striped) Lock(key []byte) {
+	s.For(key).Lock()
+}
+
+// 释放key对应的写锁
+func (s *Striped) Unlock(key []byte) {
+	s.For(key).Unlock()
+}
+
+// 对key对应的锁加读锁
+func (s *Striped) RLock(key []byte) {
+	s.For(key).RLock()
+}
+
+// 释放key对应的读锁
+func (s *Striped) RUnlock(key []byte) {
+	s.For(key).RUnlock()
+}
+
+// 锁的数量
+func (s *Striped) Count() int {
+	return len(s.locks)
+}
+
+// FNV-1a 哈希，避免使用 hash/fnv 时每次调用的内存分配
+func fnv32(key []byte) uint32 {
+	hash := uint32(fnvOffset32)
+	for _, b := range key {
+		hash ^= uint32(b)
+		hash *= fnvPrime32
+	}
+	return hash
+}
@@ -0,0 +1,66 @@
+package lock
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"testing"
+)
+
+func TestFnv32(t *testing.T) {
+	for _, key := range []string{"", "a", "key-00001", "foobar"} {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		if got := fnv32([]byte(key)); got != h.Sum32() {
+			t.Fatalf("fnv32(%q) = %d, want %d", key, got, h.Sum32())
+		}
+	}
+}
+
+func TestStriped_SameKeySameLock(t *testing.T) {
+	s := NewStriped(16)
+	if s.For([]byte("a")) != s.For([]byte("a")) {
+		t.Fatal("expected the same lock for the same key")
+	}
+
+	// key应分散到多个锁上
+	used := make(map[*sync.RWMutex]struct{})
+	for i := 0; i < 256; i++ {
+		used[s.For([]byte(fmt.Sprintf("key-%05d", i)))] = struct{}{}
+	}
+	if len(used) < s.Count()/2 {
+		t.Fatalf("keys only spread over %d of %d locks", len(used), s.Count())
+	}
+
+	if NewStriped(0).Count() != 1 {
+		t.Fatal("expected at least one lock")
+	}
+}
+
+func TestStriped_MutualExclusion(t *testing.T) {
+	s := NewStriped(8)
+	keys := []string{"a", "b", "c", "d"}
+	counters := make([]int, len(keys))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := i % len(keys)
+				s.Lock([]byte(keys[k]))
+				counters[k]++
+				s.Unlock([]byte(keys[k]))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 不同的key可能落在同一把锁上，这里只检查同一个key的更新没有丢失
+	for k, key := range keys {
+		if counters[k] != 8*1000/len(keys) {
+			t.Fatalf("counter %s = %d", key, counters[k])
+		}
+	}
+}
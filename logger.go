@@ -0,0 +1,21 @@
+package bitcask_go
+
+// Logger 记录引擎内部事件的日志接口，包括活跃文件切换、merge、启动恢复时丢弃的损坏数据以及获取文件锁失败等
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// 不输出任何内容的日志，没有配置 Logger 时使用
+type nopLogger struct{}
+
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// 使用指定的日志打开存储引擎实例
+func OpenWithLogger(options Options, logger Logger) (*DB, error) {
+	options.Logger = logger
+	return Open(options)
+}
@@ -0,0 +1,85 @@
+package bitcask_go
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 记录所有日志的Logger
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) logf(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *captureLogger) Infof(format string, args ...interface{}) {
+	l.logf("INFO", format, args...)
+}
+
+func (l *captureLogger) Warnf(format string, args ...interface{}) {
+	l.logf("WARN", format, args...)
+}
+
+func (l *captureLogger) Errorf(format string, args ...interface{}) {
+	l.logf("ERROR", format, args...)
+}
+
+// 返回包含指定内容的日志数量
+func (l *captureLogger) count(substr string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var n int
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDB_LoggerRotateAndMerge(t *testing.T) {
+	logger := &captureLogger{}
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+		options.Logger = logger
+	})
+	for i := 0; i < 200; i++ {
+		if err := db.Put(testKey(i%50), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if logger.count("INFO data file") == 0 {
+		t.Fatalf("expected a rotation log, got %q", logger.lines)
+	}
+
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	if logger.count("INFO merge started") != 1 || logger.count("INFO merge finished: rewrote 50 records") != 1 {
+		t.Fatalf("expected merge logs, got %q", logger.lines)
+	}
+
+	// 重新打开时应用merge的结果
+	reopenTestDB(t, db)
+	if logger.count("INFO applied merge results") != 1 {
+		t.Fatalf("expected a recovery log, got %q", logger.lines)
+	}
+}
+
+func TestOpenWithLogger_LockFailure(t *testing.T) {
+	db := openTestDB(t, nil)
+	logger := &captureLogger{}
+	if _, err := OpenWithLogger(db.options, logger); err != ErrDatabaseIsUsing {
+		t.Fatalf("expected ErrDatabaseIsUsing, got %v", err)
+	}
+	if logger.count("ERROR failed to acquire file lock") != 1 {
+		t.Fatalf("expected a lock failure log, got %q", logger.lines)
+	}
+}
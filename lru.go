@@ -0,0 +1,101 @@
+package bitcask_go
+
+import (
+	"sort"
+	"time"
+
+	"bitcask-go/data"
+)
+
+// 访问时间的key：记录在数据文件中的位置，key被覆盖、merge或vacuum重写之后对应新的位置，之前的访问时间不再生效
+type accessKey struct {
+	fid    uint32
+	offset int64
+}
+
+// 记录位置的访问时间
+func (db *DB) recordAccess(pos *data.LogRecordPos) {
+	db.accessTimes.Store(accessKey{fid: pos.Fid, offset: pos.Offset}, time.Now().UnixNano())
+}
+
+// 更新key的最近访问时间，不读取value也不写入磁盘，key不存在或已过期时返回 ErrKeyNotFound
+// 访问时间只保存在内存中，重新打开数据库之后所有key的访问时间都从0开始
+func (db *DB) Touch(key []byte) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+		return ErrKeyNotFound
+	}
+	db.recordAccess(pos)
+	return nil
+}
+
+// 删除最久未访问的n个key，返回实际删除的数量；从未访问过的key访问时间为0，最先被删除，访问时间相同时按key的顺序
+// 遍历索引和删除在同一个写锁内完成，同时清理已经失效的位置的访问时间
+func (db *DB) EvictLRU(n int) (int, error) {
+	if db.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+	if db.options.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	type candidate struct {
+		key        []byte
+		access     accessKey
+		accessTime int64
+	}
+	candidates := make([]candidate, 0, db.index.Size())
+	live := make(map[accessKey]struct{}, db.index.Size())
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		access := accessKey{fid: pos.Fid, offset: pos.Offset}
+		c := candidate{key: append([]byte(nil), iterator.Key()...), access: access}
+		if accessTime, ok := db.accessTimes.Load(access); ok {
+			c.accessTime = accessTime.(int64)
+		}
+		candidates = append(candidates, c)
+		live[access] = struct{}{}
+	}
+	iterator.Close()
+
+	// 清理被覆盖、删除或重写的位置
+	db.accessTimes.Range(func(key, _ any) bool {
+		if _, ok := live[key.(accessKey)]; !ok {
+			db.accessTimes.Delete(key)
+		}
+		return true
+	})
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].accessTime < candidates[j].accessTime
+	})
+	var evicted int
+	for _, c := range candidates {
+		if evicted == n {
+			break
+		}
+		db.recordLockedWrite(len(c.key))
+		if _, err := db.deleteLocked(c.key); err != nil {
+			return evicted, err
+		}
+		db.accessTimes.Delete(c.access)
+		evicted++
+	}
+	return evicted, nil
+}
@@ -0,0 +1,91 @@
+package bitcask_go
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_TouchEvictLRU(t *testing.T) {
+	db := openTestDB(t, nil)
+	for i := 0; i < 5; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, i := range []int{3, 1, 4} {
+		if err := db.Touch(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := db.Touch([]byte("missing")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	// 没有开启 EnableLRUTracking 时读取不更新访问时间
+	if _, err := db.Get(testKey(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 从未访问过的key最先删除，之后按访问时间从早到晚
+	evicted, err := db.EvictLRU(3)
+	if err != nil || evicted != 3 {
+		t.Fatalf("EvictLRU = %d, %v", evicted, err)
+	}
+	expectContent(t, db, map[string]string{
+		string(testKey(1)): string(testValue(1)),
+		string(testKey(4)): string(testValue(4)),
+	})
+
+	// 要求删除的数量超过key的数量时删除全部
+	if evicted, err := db.EvictLRU(10); err != nil || evicted != 2 {
+		t.Fatalf("EvictLRU = %d, %v", evicted, err)
+	}
+	expectKeyCount(t, db, 0)
+	if length := syncMapLen(&db.accessTimes); length != 0 {
+		t.Fatalf("expected access times cleaned up, got %d", length)
+	}
+}
+
+func TestDB_LRUTracking(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.EnableLRUTracking = true
+	})
+	for i := 0; i < 4; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// 读取和覆盖都会更新访问时间
+	if _, err := db.Get(testKey(0)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := db.Put(testKey(1), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if evicted, err := db.EvictLRU(2); err != nil || evicted != 2 {
+		t.Fatalf("EvictLRU = %d, %v", evicted, err)
+	}
+	expectContent(t, db, map[string]string{
+		string(testKey(0)): string(testValue(0)),
+		string(testKey(1)): "new",
+	})
+
+	// 访问时间不持久化，重新打开后按key的顺序删除
+	db = reopenTestDB(t, db)
+	if evicted, err := db.EvictLRU(1); err != nil || evicted != 1 {
+		t.Fatalf("EvictLRU = %d, %v", evicted, err)
+	}
+	expectContent(t, db, map[string]string{string(testKey(1)): "new"})
+}
+
+func syncMapLen(m *sync.Map) int {
+	var length int
+	m.Range(func(_, _ any) bool {
+		length++
+		return true
+	})
+	return length
+}
@@ -0,0 +1,200 @@
+package bitcask_go
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+)
+
+const (
+	manifestFileName = "manifest.json"
+
+	// 清单中只校验每个文件最后一块数据的crc，避免打开时读取所有数据文件
+	manifestBlockSize = 4 * 1024
+)
+
+var manifestCRCTable = crc64.MakeTable(crc64.ECMA)
+
+// 数据文件清单，记录数据目录中每个数据文件的大小和最后一块数据的crc64，打开时用于发现被删除、替换或截断的数据文件
+// 记录中的crc只能发现意外的修改，不能防止有意的篡改
+type manifest struct {
+	Files []manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	FileId           uint32 `json:"fileId"`
+	Size             int64  `json:"size"`
+	CRC64OfLastBlock uint64 `json:"crc64OfLastBlock"`
+}
+
+// 根据数据目录中的数据文件生成清单，先写入临时文件再重命名（开启 Manifest 时，只读和内存模式下不写入）
+// 在打开数据库、切换活跃文件、重写或删除旧的数据文件以及关闭数据库之后调用
+func (db *DB) writeManifest() error {
+	if !db.options.Manifest || db.options.ReadOnly || db.options.InMemory {
+		return nil
+	}
+	db.manifestMu.Lock()
+	defer db.manifestMu.Unlock()
+
+	fileIds, err := db.listDataFileIds()
+	if err != nil {
+		return err
+	}
+	m := manifest{Files: make([]manifestEntry, 0, len(fileIds))}
+	for _, fid := range fileIds {
+		size, crc, err := lastBlockCRC(db.getDataFileName(db.options.DirPath, uint32(fid)))
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, manifestEntry{FileId: uint32(fid), Size: size, CRC64OfLastBlock: crc})
+	}
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fileName := filepath.Join(db.options.DirPath, manifestFileName)
+	tmpFileName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, writeErr := file.Write(content)
+	if writeErr == nil {
+		writeErr = file.Sync()
+	}
+	if err := file.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		_ = os.Remove(tmpFileName)
+		return writeErr
+	}
+	if err := os.Rename(tmpFileName, fileName); err != nil {
+		return err
+	}
+	return db.syncDir()
+}
+
+// 返回文件的大小和最后 manifestBlockSize 字节的crc64
+func lastBlockCRC(fileName string) (int64, uint64, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := stat.Size()
+	block := make([]byte, min(size, manifestBlockSize))
+	if _, err := file.ReadAt(block, size-int64(len(block))); err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	return size, crc64.Checksum(block, manifestCRCTable), nil
+}
+
+// 打开数据库之前用清单校验数据目录中的数据文件（调用方需持有文件锁）
+// 清单中的文件必须都存在，最后一个文件（上次的活跃文件，之后可能继续追加）的大小不能小于记录的大小，其余文件的大小和最后一块的crc必须一致；
+// 除了比清单中所有文件都新的文件（切换活跃文件之后、更新清单之前退出时留下的），不能有清单之外的数据文件
+// 清单不存在或者无法解析时跳过校验，打开之后重新生成；只读模式下不一致时只记录警告
+func (db *DB) checkManifest() error {
+	content, err := os.ReadFile(filepath.Join(db.options.DirPath, manifestFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var m manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		db.options.Logger.Warnf("ignored corrupted manifest, it will be rebuilt: %v", err)
+		return nil
+	}
+
+	err = db.compareManifest(&m)
+	if err != nil && db.options.ReadOnly {
+		db.options.Logger.Warnf("%v", err)
+		return nil
+	}
+	return err
+}
+
+func (db *DB) compareManifest(m *manifest) error {
+	listed := make(map[uint32]struct{}, len(m.Files))
+	var lastFileId uint32
+	for _, entry := range m.Files {
+		listed[entry.FileId] = struct{}{}
+		lastFileId = max(lastFileId, entry.FileId)
+	}
+	for _, entry := range m.Files {
+		size, crc, err := lastBlockCRC(db.getDataFileName(db.options.DirPath, entry.FileId))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: data file %d is missing", ErrManifestMismatch, entry.FileId)
+		}
+		if err != nil {
+			return err
+		}
+		if entry.FileId == lastFileId {
+			if size < entry.Size {
+				return fmt.Errorf("%w: data file %d shrank from %d to %d bytes", ErrManifestMismatch, entry.FileId, entry.Size, size)
+			}
+			continue
+		}
+		if size != entry.Size || crc != entry.CRC64OfLastBlock {
+			return fmt.Errorf("%w: data file %d was modified", ErrManifestMismatch, entry.FileId)
+		}
+	}
+
+	fileIds, err := db.listDataFileIds()
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIds {
+		if _, ok := listed[uint32(fid)]; ok {
+			continue
+		}
+		// 比清单中所有文件都新的文件是之后新建的活跃文件
+		if len(listed) == 0 || uint32(fid) > lastFileId {
+			continue
+		}
+		return fmt.Errorf("%w: unexpected data file %d", ErrManifestMismatch, fid)
+	}
+	return nil
+}
+
+// 以数据目录中当前的数据文件重新生成清单，用于确认数据文件的变化是预期的之后（例如从备份中恢复了数据文件），
+// 解决打开时返回的 ErrManifestMismatch；不检查数据文件的内容，可以在之后打开数据库并调用 Check 检查
+// 数据库不能处于打开状态
+func Repair(options Options) error {
+	if options.InMemory {
+		return ErrInMemoryUnsupported
+	}
+	if options.ReadOnly {
+		return ErrReadOnly
+	}
+	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
+	hold, err := tryLockWithTimeout(fileLock, options.LockTimeout)
+	if err != nil {
+		return err
+	}
+	if !hold {
+		return ErrDatabaseIsUsing
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	options.Manifest = true
+	if options.Logger == nil {
+		options.Logger = nopLogger{}
+	}
+	db := &DB{options: options}
+	return db.writeManifest()
+}
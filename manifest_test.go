@@ -0,0 +1,240 @@
+package bitcask_go
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readTestManifest(t *testing.T, dirPath string) manifest {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dirPath, manifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// 清单中的文件和数据目录中的数据文件一一对应
+func expectManifestFiles(t *testing.T, db *DB) {
+	t.Helper()
+	fileIds, err := db.listDataFileIds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := readTestManifest(t, db.options.DirPath)
+	if len(m.Files) != len(fileIds) {
+		t.Fatalf("manifest lists %d files, data directory has %d", len(m.Files), len(fileIds))
+	}
+	for i, entry := range m.Files {
+		if entry.FileId != uint32(fileIds[i]) {
+			t.Fatalf("manifest entry %d is file %d, want %d", i, entry.FileId, fileIds[i])
+		}
+	}
+}
+
+func writeManifestData(t *testing.T, db *DB, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_Manifest(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+		options.Manifest = true
+	})
+	writeManifestData(t, db, 0, 2000)
+	if db.activeFile.FileId < 2 {
+		t.Fatalf("expected several data files, active file %d", db.activeFile.FileId)
+	}
+	// 切换活跃文件时更新清单，之前的文件都已经列出
+	m := readTestManifest(t, db.options.DirPath)
+	if last := m.Files[len(m.Files)-1].FileId; last != db.activeFile.FileId {
+		t.Fatalf("manifest ends at file %d, active file %d", last, db.activeFile.FileId)
+	}
+
+	// 关闭时记录活跃文件最终的大小
+	activeFileId := db.activeFile.FileId
+	db = reopenTestDB(t, db)
+	expectManifestFiles(t, db)
+	m = readTestManifest(t, db.options.DirPath)
+	stat, err := os.Stat(db.getDataFileName(db.options.DirPath, activeFileId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := m.Files[len(m.Files)-1]; last.FileId != activeFileId || last.Size != stat.Size() {
+		t.Fatalf("unexpected last entry %+v, active file size %d", last, stat.Size())
+	}
+
+	// merge删除旧的数据文件之后重新打开仍然一致
+	for i := 0; i < 1900; i++ {
+		if err := db.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	expectManifestFiles(t, db)
+	expectKeyCount(t, db, 100)
+
+	// 增量merge替换数据文件之后立即更新清单
+	writeManifestData(t, db, 2000, 4000)
+	for i := 2000; i < 3900; i++ {
+		if err := db.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.MergeN(2); err != nil {
+		t.Fatal(err)
+	}
+	expectManifestFiles(t, db)
+	if err := db.checkManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 清空之后同样一致
+	if err := db.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	expectManifestFiles(t, db)
+	db = reopenTestDB(t, db)
+	expectManifestFiles(t, db)
+	expectKeyCount(t, db, 0)
+}
+
+func TestDB_ManifestMismatch(t *testing.T) {
+	for name, damage := range map[string]func(t *testing.T, dirPath string){
+		"missing": func(t *testing.T, dirPath string) {
+			if err := os.Remove(filepath.Join(dirPath, "000000001.data")); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"truncated": func(t *testing.T, dirPath string) {
+			if err := os.Truncate(filepath.Join(dirPath, "000000001.data"), 100); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"replaced": func(t *testing.T, dirPath string) {
+			content, err := os.ReadFile(filepath.Join(dirPath, "000000000.data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dirPath, "000000001.data"), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"unexpected": func(t *testing.T, dirPath string) {
+			// 清单中间不能出现没有列出的文件
+			m := readTestManifest(t, dirPath)
+			m.Files = append(m.Files[:1], m.Files[2:]...)
+			content, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dirPath, manifestFileName), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(options *Options) {
+				options.DataFileSize = 8 * 1024
+				options.Manifest = true
+			})
+			writeManifestData(t, db, 0, 2000)
+			options := db.options
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			damage(t, options.DirPath)
+
+			if _, err := Open(options); !errors.Is(err, ErrManifestMismatch) {
+				t.Fatalf("expected ErrManifestMismatch, got %v", err)
+			}
+
+			// 只读模式下只记录警告
+			readOnlyOptions := options
+			readOnlyOptions.ReadOnly = true
+			readOnlyDB, err := Open(readOnlyOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := readOnlyDB.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// 重新生成清单之后可以打开
+			if err := Repair(readOnlyOptions); err != ErrReadOnly {
+				t.Fatalf("expected ErrReadOnly, got %v", err)
+			}
+			if err := Repair(options); err != nil {
+				t.Fatal(err)
+			}
+			db, err = Open(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectManifestFiles(t, db)
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// 清单之外更新的文件和损坏的清单都不影响打开，打开之后重新生成
+func TestDB_ManifestTolerated(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+		options.Manifest = true
+	})
+	writeManifestData(t, db, 0, 2000)
+	options := db.options
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟切换活跃文件之后、更新清单之前退出
+	m := readTestManifest(t, options.DirPath)
+	m.Files = m.Files[:len(m.Files)-1]
+	content, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(options.DirPath, manifestFileName)
+	if err := os.WriteFile(manifestPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectManifestFiles(t, db)
+	expectKeyCount(t, db, 2000)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(manifestPath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	expectManifestFiles(t, db)
+	expectKeyCount(t, db, 2000)
+}
@@ -7,22 +7,52 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"bitcask-go/data"
+	"bitcask-go/index"
 	"bitcask-go/utils"
 )
 
 const (
 	mergeDirName     = "-merge"
 	mergeFinishedKey = "merge.finished"
+	mergeVlogKey     = "merge.vlog"
+	mergeFormatKey   = "merge.format"
+	mergeStartKey    = "merge.start"
 )
 
-// 清理无效数据，生成Hint文件
+// 清理无效数据，生成Hint文件（可回收的数据量未达到 DataFileMergeRatio 时返回 ErrMergeRatioUnreached）
+// CompactionStrategy 为 SizeTiered 时只原地回收一层数据文件，不生成Hint文件
 func (db *DB) Merge() error {
+	return db.merge(false)
+}
+
+// 强制清理无效数据，忽略 DataFileMergeRatio 阈值，但仍会检查磁盘空间以及是否正在merge
+func (db *DB) MergeForce() error {
+	return db.merge(true)
+}
+
+// 清理无效数据，生成Hint文件，force 为 true 时跳过 merge 比率的检查
+func (db *DB) merge(force bool) (err error) {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.options.InMemory {
+		return ErrInMemoryUnsupported
+	}
 	// 如果数据库为空，则直接返回
 	if db.activeFile == nil {
 		return nil
 	}
+	// 按大小分层时只原地回收一层数据文件，B+树索引不支持原地重写，仍然合并所有文件
+	if db.options.CompactionStrategy == SizeTiered && db.options.IndexType != BPlusTree {
+		return db.mergeSizeTiered(force)
+	}
 
 	db.mu.Lock()
 
@@ -38,7 +68,7 @@ func (db *DB) Merge() error {
 		db.mu.Unlock()
 		return err
 	}
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+	if !force && float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
 		return ErrMergeRatioUnreached
 	}
@@ -50,15 +80,24 @@ func (db *DB) Merge() error {
 		return err
 	}
 	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
+		db.mu.Unlock()
 		return ErrNoEnoughSpaceForMerge
 	}
 
 	db.isMerging = true
+	var started bool
 	defer func() {
 		db.isMerging = false
+		if started {
+			db.hookMergeEnd(err)
+		}
 	}()
 
-	// 持久化当前活跃文件
+	// 持久化当前活跃文件（指针指向的value log数据需要先落盘）
+	if err := db.syncVlog(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
 	if err := db.activeFile.Sync(); err != nil {
 		db.mu.Unlock()
 		return err
@@ -66,22 +105,50 @@ func (db *DB) Merge() error {
 
 	// 将当前活跃文件转换为旧的数据文件
 	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	db.notifyFileSealed(db.activeFile)
 	// 打开新的活跃文件
 	if err := db.setActiveFile(); err != nil {
 		db.mu.Unlock()
-		return nil
+		return err
+	}
+	if err := db.writeManifest(); err != nil {
+		db.mu.Unlock()
+		return err
 	}
 
 	// 记录没有参与 merge 的文件 id
 	nonMergeFileId := db.activeFile.FileId
 
+	// 同样切换新的活跃 value log 文件，之后写入的数据不会再引用参与 merge 的 value log
+	var nonMergeVlogId uint32
+	var vlogSize int64
+	if db.activeVlog != nil {
+		db.olderVlogs[db.activeVlog.FileId] = db.activeVlog
+		if err := db.setActiveVlog(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		nonMergeVlogId = db.activeVlog.FileId
+		for _, vlogFile := range db.olderVlogs {
+			size, err := vlogFile.IOManager.Size()
+			if err != nil {
+				db.mu.Unlock()
+				return err
+			}
+			vlogSize += size
+		}
+	}
+
 	// 取出所有需要 merge 的文件（旧DB中的olderFiles所有文件）
 	var mergeFiles []*data.DataFile
 	for _, file := range db.olderFiles {
 		mergeFiles = append(mergeFiles, file)
 	}
+	db.options.Logger.Infof("merge started: %d data files, %d reclaimable bytes", len(mergeFiles), db.reclaimSize)
 
 	db.mu.Unlock()
+	started = true
+	db.hookMergeStart()
 
 	// 将merge的文件根据FileId从小到大进行排序，依次merge
 	sort.Slice(mergeFiles, func(i, j int) bool {
@@ -102,13 +169,14 @@ func (db *DB) Merge() error {
 	}
 
 	// 在merge目录中，打开一个新的临时bitcask实例
-	mergeOptions := db.options
-	mergeOptions.DirPath = mergePath
-	mergeOptions.SyncWrites = false
+	mergeOptions := db.mergeOptions(mergePath)
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = mergeDB.Close()
+	}()
 
 	// 打开hint文件，存储索引
 	hintFile, err := data.OpenHintFile(mergePath)
@@ -116,53 +184,76 @@ func (db *DB) Merge() error {
 		return err
 	}
 
-	// 遍历处理每个数据文件
-	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
-		// 依次读取每个文件中的每条记录
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
+	// value log中的无效数据较少时只重写数据文件，指针记录原样保留，不删除任何value log
+	now := time.Now().UnixNano()
+	rewriteVlog := true
+	if vlogSize > 0 && db.options.ValueLogMergeRatio > 0 {
+		liveSize, err := db.liveValueLogSize(mergeFiles, now)
+		if err != nil {
+			return err
+		}
+		if float32(vlogSize-liveSize)/float32(vlogSize) < db.options.ValueLogMergeRatio {
+			rewriteVlog = false
+			nonMergeVlogId = 0
+		}
+	}
+
+	// 将有效的记录重写到merge引擎中，并将位置索引写到Hint文件中
+	var rewritten int
+	rewrite := func(realKey []byte, logRecord *data.LogRecord) error {
+		var err error
+		// value 存储在 value log 中时，将其重写到新的 value log，参与 merge 的 value log 在 merge 生效后删除
+		if logRecord.Type == data.LogRecordValuePointer && rewriteVlog {
+			if logRecord, err = db.rewriteValueLog(logRecord); err != nil {
 				return err
 			}
+		}
+		// 由于内存中的记录一定有效，所以此记录也有效，可以清除文件中数据的事务序列号标记
+		logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+		if err := db.writeLimiter.wait(len(realKey) + len(logRecord.Value)); err != nil {
+			return err
+		}
+		// 重写入merge引擎中的文件中
+		pos, err := mergeDB.appendLogRecord(logRecord)
+		if err != nil {
+			return err
+		}
+		// 将重写后的位置索引写到Hint文件中
+		if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+			return err
+		}
+		rewritten++
+		return nil
+	}
 
-			// 解析拿到实际的key
-			realKey, _ := parseLogRecordKey(logRecord.Key)
-			// 根据实际key去内存寻找
-			logRecordPos := db.index.Get(realKey)
-
-			// 将文件数据和内存索引比较
-			if logRecordPos != nil &&
-				logRecordPos.Fid == dataFile.FileId &&
-				logRecordPos.Offset == offset { // 如果有效则重写
-				// 由于内存中的记录一定有效，所以此记录也有效，可以清除文件中数据的事务序列号标记
-				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
-				// 重写入merge引擎中的文件中
-				_, err := mergeDB.appendLogRecord(logRecord)
-				if err != nil {
-					return err
-				}
-
-				// 将当前位置索引写到Hint文件中
-				if err = hintFile.WriteHintRecord(realKey, logRecordPos); err != nil {
-					return err
-				}
+	// 遍历处理每个数据文件
+	if db.options.MergeConcurrency > 1 {
+		err = db.scanMergeFilesParallel(mergeFiles, now, rewrite)
+	} else {
+		for _, dataFile := range mergeFiles {
+			if err = db.scanLiveRecords(dataFile, now, rewrite); err != nil {
+				break
 			}
-			// 增加 offset
-			offset += size
 		}
 	}
+	if err != nil {
+		return err
+	}
 
 	// sync 保证持久化
-	if err := hintFile.Sync(); err != nil {
+	if err := db.finishHintFile(hintFile); err != nil {
 		return err
 	}
 	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
+	// 重写到 value log 中的数据需要先于 merge 完成的标识落盘
+	db.mu.Lock()
+	err = db.syncVlog()
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
 
 	// 打开标识merge完成的文件
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
@@ -179,15 +270,323 @@ func (db *DB) Merge() error {
 	if err := mergeFinishedFile.Write(encRecord); err != nil {
 		return err
 	}
+	// 未参与merge的value log文件id，id更小的value log在merge生效后删除
+	mergeVlogRecord := &data.LogRecord{
+		Key:   []byte(mergeVlogKey),
+		Value: []byte(strconv.Itoa(int(nonMergeVlogId))),
+	}
+	encRecord, _ = data.EncodeLogRecord(mergeVlogRecord)
+	if err := mergeFinishedFile.Write(encRecord); err != nil {
+		return err
+	}
+	// 重写的记录全部使用同一个Header格式版本（按块校验时记录中没有crc，不记录）
+	if db.options.ChecksumMode == PerRecord {
+		mergeFormatRecord := &data.LogRecord{
+			Key:   []byte(mergeFormatKey),
+			Value: []byte(strconv.Itoa(int(data.LogRecordFormatOf(mergeOptions.ChecksumAlgorithm)))),
+		}
+		encRecord, _ = data.EncodeLogRecord(mergeFormatRecord)
+		if err := mergeFinishedFile.Write(encRecord); err != nil {
+			return err
+		}
+	}
 
 	// 将标识merge完成的文件持久化
 	if err := mergeFinishedFile.Sync(); err != nil {
 		return err
 	}
 
+	db.options.Logger.Infof("merge finished: rewrote %d records from %d data files", rewritten, len(mergeFiles))
+	return nil
+}
+
+// 依次读取数据文件中的每条记录，将文件数据和内存索引比较，有效并且没有过期的记录交给 fn 处理
+func (db *DB) scanLiveRecords(dataFile *data.DataFile, now int64, fn func(realKey []byte, logRecord *data.LogRecord) error) error {
+	var offset int64 = 0
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		// 解析拿到实际的key，根据实际key去内存寻找
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		logRecordPos := db.index.Get(realKey)
+		if logRecordPos != nil &&
+			logRecordPos.Fid == dataFile.FileId &&
+			logRecordPos.Offset == offset &&
+			!logRecordPos.IsExpired(now) {
+			if err := fn(realKey, logRecord); err != nil {
+				return err
+			}
+		}
+		offset += size
+	}
+}
+
+// 一个数据文件中的有效记录
+type mergeRecord struct {
+	realKey   []byte
+	logRecord *data.LogRecord
+}
+
+// 并发扫描参与merge的数据文件：最多 MergeConcurrency 个文件同时读取，每个文件中的有效记录暂存在内存中，
+// 再由调用方的协程按FileId顺序交给 rewrite 写入，merge生成的数据文件和hint文件与顺序merge完全相同
+func (db *DB) scanMergeFilesParallel(mergeFiles []*data.DataFile, now int64, rewrite func(realKey []byte, logRecord *data.LogRecord) error) error {
+	type scanResult struct {
+		records []mergeRecord
+		err     error
+	}
+	results := make([]chan scanResult, len(mergeFiles))
+	for i := range results {
+		results[i] = make(chan scanResult, 1)
+	}
+	// 扫描中和等待写入的文件占用一个位置，写入完成后释放，限制暂存在内存中的文件数量
+	slots := make(chan struct{}, db.options.MergeConcurrency)
+	// 写入出错时通知还没有完成的扫描提前退出
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, dataFile := range mergeFiles {
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			wg.Add(1)
+			go func(i int, dataFile *data.DataFile) {
+				defer wg.Done()
+				var records []mergeRecord
+				err := db.scanLiveRecords(dataFile, now, func(realKey []byte, logRecord *data.LogRecord) error {
+					select {
+					case <-stop:
+						return errMergeScanStopped
+					default:
+					}
+					records = append(records, mergeRecord{realKey: realKey, logRecord: logRecord})
+					return nil
+				})
+				results[i] <- scanResult{records: records, err: err}
+			}(i, dataFile)
+		}
+	}()
+
+	for i := range mergeFiles {
+		result := <-results[i]
+		if result.err != nil {
+			return result.err
+		}
+		for _, record := range result.records {
+			if err := rewrite(record.realKey, record.logRecord); err != nil {
+				return err
+			}
+		}
+		<-slots
+	}
+	return nil
+}
+
+// 统计参与merge的数据文件中有效的指针记录引用的value log数据量
+// 只顺序读取数据文件（开启键值分离时数据文件较小），不读取value log
+func (db *DB) liveValueLogSize(mergeFiles []*data.DataFile, now int64) (int64, error) {
+	var liveSize int64
+	for _, dataFile := range mergeFiles {
+		var offset int64
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			if logRecord.Type == data.LogRecordValuePointer {
+				realKey, _ := parseLogRecordKey(logRecord.Key)
+				pos := db.index.Get(realKey)
+				if pos != nil && pos.Fid == dataFile.FileId && pos.Offset == offset && !pos.IsExpired(now) {
+					liveSize += int64(data.DecodeLogRecordPos(logRecord.Value).Size)
+				}
+			}
+			offset += size
+		}
+	}
+	return liveSize, nil
+}
+
+// 按大小分层的边界，依次为：小于64MB、64MB到256MB、大于等于256MB
+var sizeTierBounds = []int64{64 * 1024 * 1024, 256 * 1024 * 1024}
+
+// 参与分层的一个旧数据文件
+type tieredFile struct {
+	fid         uint32
+	size        int64
+	reclaimable int64
+}
+
+// SizeTiered 策略的merge：将旧的数据文件按大小分层，选出无效数据比例最高的一层，逐个文件原地回收其中的无效数据
+// 最近写入的大文件通常所在的层无效数据比例较低，不会被重写，减少写放大
+// force 为 false 时，选出的层的无效数据比例需要达到 DataFileMergeRatio
+func (db *DB) mergeSizeTiered(force bool) (err error) {
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	db.isMerging = true
+	fileIds := make([]uint32, 0, len(db.olderFiles))
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	db.mu.Unlock()
+	var started bool
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+		if started {
+			db.hookMergeEnd(err)
+		}
+	}()
+
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	// 统计每个文件的大小和无效数据量，按大小放入对应的层
+	tiers := make([][]*tieredFile, len(sizeTierBounds)+1)
+	for _, fid := range fileIds {
+		file, err := db.estimateReclaimable(fid)
+		if err != nil {
+			return err
+		}
+		tier := sort.Search(len(sizeTierBounds), func(i int) bool {
+			return file.size < sizeTierBounds[i]
+		})
+		tiers[tier] = append(tiers[tier], file)
+	}
+
+	// 选出无效数据比例最高的一层
+	var selected []*tieredFile
+	var selectedRatio float32
+	for _, files := range tiers {
+		var size, reclaimable int64
+		for _, file := range files {
+			size += file.size
+			reclaimable += file.reclaimable
+		}
+		if size == 0 {
+			continue
+		}
+		if ratio := float32(reclaimable) / float32(size); ratio > selectedRatio {
+			selected, selectedRatio = files, ratio
+		}
+	}
+	if !force && selectedRatio < db.options.DataFileMergeRatio {
+		return ErrMergeRatioUnreached
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+
+	// 和vacuum一样，在临时目录中重写文件后原子地替换
+	vacuumPath := db.getVacuumPath()
+	if err := os.RemoveAll(vacuumPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(vacuumPath, os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(vacuumPath)
+	}()
+
+	db.options.Logger.Infof("size tiered merge started: %d of %d data files, reclaimable ratio %.2f", len(selected), len(fileIds), selectedRatio)
+	started = true
+	db.hookMergeStart()
+	for _, file := range selected {
+		if err := db.vacuumFile(vacuumPath, file.fid, file.fid == fileIds[0], false); err != nil {
+			return err
+		}
+	}
+	db.options.Logger.Infof("size tiered merge finished")
 	return nil
 }
 
+// 估算一个旧数据文件中的无效数据量：内存索引没有指向的数据记录以及删除记录
+func (db *DB) estimateReclaimable(fid uint32) (*tieredFile, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	file := &tieredFile{fid: fid}
+	dataFile := db.olderFiles[fid]
+	if dataFile == nil {
+		return file, nil
+	}
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(file.size)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset := file.size
+		file.size += size
+
+		switch logRecord.Type {
+		case data.LogRecordTxnFinished, data.LogRecordFlushAll:
+		case data.LogRecordDeleted:
+			file.reclaimable += size
+		default:
+			realKey, _ := parseLogRecordKey(logRecord.Key)
+			if pos := db.index.Get(realKey); pos == nil || pos.Fid != fid || pos.Offset != offset {
+				file.reclaimable += size
+			}
+		}
+	}
+	return file, nil
+}
+
+// merge目录中临时实例的配置
+func (db *DB) mergeOptions(mergePath string) Options {
+	mergeOptions := db.options
+	mergeOptions.DirPath = mergePath
+	mergeOptions.SyncWrites = false
+	// 临时实例中不能生成 value log 文件，否则移动时会覆盖原有的文件，有效的 value 由 rewriteValueLog 写入当前实例的 value log
+	mergeOptions.ValueLogSeparationThreshold = 0
+	mergeOptions.Logger = nopLogger{}
+	// merge 写入使用当前实例的写入限速
+	mergeOptions.WriteRateLimit = 0
+	// 临时实例不记录历史版本，关闭时不会生成历史版本文件
+	mergeOptions.MaxVersionsPerKey = 1
+	// 临时实例不写审计日志，否则移动时会覆盖原有的审计日志
+	mergeOptions.AuditLog = false
+	// 临时实例的写入和关闭不是用户的操作，不调用回调
+	mergeOptions.Hooks = Hooks{}
+	// 临时实例不生成清单，否则移动时会覆盖原有的清单，应用merge结果之后由当前实例重新生成
+	mergeOptions.Manifest = false
+	// 临时实例写入的是重写的有效数据，不受数据目录大小的限额限制
+	mergeOptions.MaxDiskSize = 0
+	mergeOptions.CompactOnDiskQuota = false
+	// 临时实例不启动管理接口，否则会和当前实例监听相同的地址
+	mergeOptions.AdminAddr = ""
+	// 开启格式升级时，重写的记录统一编码为最新的Header格式
+	if db.options.MergeUpgradeFormat && db.options.ChecksumMode == PerRecord {
+		mergeOptions.ChecksumAlgorithm = data.FormatChecksumAlgorithm(data.LatestLogRecordFormat)
+	}
+	return mergeOptions
+}
+
 // 获取merge目录名
 // 原目录：tmp/bitcask
 // 对应的merge目录：tmp/bitcask-merge
@@ -204,10 +603,30 @@ func (db *DB) getMergePath() string {
 
 // 加载merge数据目录
 func (db *DB) loadMergeFiles() error {
+	nonMergeFileId, applied, err := db.applyMergeFiles()
+	if err != nil || !applied {
+		return err
+	}
+	// 移入的merge结果和删除的旧文件在目录持久化之后才能保证崩溃后不会回退
+	if err := db.syncDir(); err != nil {
+		return err
+	}
+	db.mergedFileId = nonMergeFileId
+	// 持久化的ART索引指向merge之前的文件，已经失效
+	if err := index.RemoveARTIndexFile(db.options.DirPath); err != nil {
+		return err
+	}
+	db.options.Logger.Infof("applied merge results, removed data files before %d", nonMergeFileId)
+	return nil
+}
+
+// 将merge目录中已完成的结果移动到数据目录中：删除参与merge的数据文件和value log，再移动merge目录中的文件
+// 返回未参与merge的最小文件id，以及是否有已完成的merge结果
+func (db *DB) applyMergeFiles() (uint32, bool, error) {
 	mergePath := db.getMergePath()
 	// 如果目录不存在则直接返回
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
-		return nil
+		return 0, false, nil
 	}
 	defer func() {
 		_ = os.RemoveAll(mergePath)
@@ -215,7 +634,7 @@ func (db *DB) loadMergeFiles() error {
 
 	dirEntries, err := os.ReadDir(mergePath)
 	if err != nil {
-		return err
+		return 0, false, err
 	}
 
 	// 查找标识merge完成的文件
@@ -228,8 +647,8 @@ func (db *DB) loadMergeFiles() error {
 			// 如果merge完成过
 			mergeFinished = true
 		}
-		// 如果是记录最新事务序列号的文件，则跳过不需要移动
-		if entry.Name() == data.SeqNoFileName {
+		// 如果是记录最新事务序列号或历史版本的文件，则跳过不需要移动
+		if entry.Name() == data.SeqNoFileName || entry.Name() == data.VersionFileName {
 			continue
 		}
 		// 如果是文件锁文件，则跳过不需要移动
@@ -243,22 +662,37 @@ func (db *DB) loadMergeFiles() error {
 
 	// 如果merge还未完成
 	if !mergeFinished {
-		return nil
+		return 0, false, nil
 	}
 
 	// 获取最小的未参与merge的文件id
-	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
+	nonMergeFileId, nonMergeVlogId, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
-		return nil
+		return 0, false, err
+	}
+	// 增量merge只重写了 [startFileId, nonMergeFileId) 范围内的文件，更早的文件保持不变
+	startFileId, err := readMergeFinishedValue(mergePath, mergeStartKey)
+	if err != nil {
+		return 0, false, err
 	}
 
 	// 在旧的DB中，根据最小的未参与merge的文件id，在DB目录下将所有参与过merge的文件删除
-	var fileId uint32 = 0
+	fileId := uint32(startFileId)
 	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
+		fileName := db.getDataFileName(db.options.DirPath, fileId)
 		if _, err := os.Stat(fileName); err == nil {
 			if err := os.Remove(fileName); err != nil {
-				return err
+				return 0, false, err
+			}
+		}
+	}
+
+	// 参与merge的value log中有效的数据已经重写到新的value log，同样删除
+	for fileId = 0; fileId < nonMergeVlogId; fileId++ {
+		fileName := data.GetValueLogFileName(db.options.DirPath, fileId)
+		if _, err := os.Stat(fileName); err == nil {
+			if err := os.Remove(fileName); err != nil {
+				return 0, false, err
 			}
 		}
 	}
@@ -269,48 +703,132 @@ func (db *DB) loadMergeFiles() error {
 		newPath := filepath.Join(db.options.DirPath, fileName)
 		// 将文件从旧路径移动到新路径
 		if err := os.Rename(oldPath, newPath); err != nil {
-			return err
+			return 0, false, err
 		}
 	}
-	return nil
+	return nonMergeFileId, true, nil
 }
 
-// 获取最小的未参与merge的文件id
-func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
+// 获取最小的未参与merge的数据文件id和value log文件id
+func (db *DB) getNonMergeFileId(dirPath string) (uint32, uint32, error) {
 	// 打开标识merge完成的文件
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
+	defer mergeFinishedFile.Close()
 
 	// 读取标识merge完成的文件
-	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	record, size, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
 	// 将标识merge完成的文件的读取到的记录转换为数字
 	nonMergeFileId, err := strconv.Atoi(string(record.Value))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// 没有value log文件id的记录时，没有value log参与merge
+	record, _, err = mergeFinishedFile.ReadLogRecord(size)
+	if err == io.EOF {
+		return uint32(nonMergeFileId), 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	nonMergeVlogId, err := strconv.Atoi(string(record.Value))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint32(nonMergeFileId), uint32(nonMergeVlogId), nil
+}
+
+// 读取数据目录中merge完成的标识记录的Header格式版本，没有merge或旧版本的标识中没有记录时返回0
+func (db *DB) loadMergedFormat() (LogRecordFormat, error) {
+	format, err := readMergeFinishedValue(db.options.DirPath, mergeFormatKey)
+	return LogRecordFormat(format), err
+}
+
+// 读取目录中merge完成的标识里指定key的数值，没有标识文件或其中没有此key时返回0
+func readMergeFinishedValue(dirPath string, key string) (int, error) {
+	mergeFinFileName := filepath.Join(dirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); os.IsNotExist(err) {
+		return 0, nil
+	}
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
 		return 0, err
 	}
+	defer mergeFinishedFile.Close()
 
-	return uint32(nonMergeFileId), nil
+	var offset int64
+	for {
+		record, size, err := mergeFinishedFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+		if string(record.Key) != key {
+			continue
+		}
+		return strconv.Atoi(string(record.Value))
+	}
+}
+
+// 写完hint文件中的记录之后持久化，开启了 HintChecksum 时先在末尾写入校验和
+func (db *DB) finishHintFile(hintFile *data.DataFile) error {
+	if db.options.HintChecksum {
+		if err := hintFile.WriteHintChecksum(); err != nil {
+			return err
+		}
+	}
+	return hintFile.Sync()
 }
 
 // 从 hint 文件中加载索引
-func (db *DB) loadIndexFromHintFile() error {
+// 开启了 HintChecksum 并且hint文件校验失败时不加载任何索引，返回 false，调用方需要从所有数据文件（包括merge过的文件）重新加载
+func (db *DB) loadIndexFromHintFile() (bool, error) {
 	// 查看hint索引文件是否存在
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
 	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
-		return nil
+		return true, nil
+	}
+
+	// 先读入整个文件校验，通过之后再构建索引
+	if db.options.HintChecksum {
+		buf, err := os.ReadFile(hintFileName)
+		if err != nil {
+			return false, err
+		}
+		records, err := data.VerifyHintChecksum(buf)
+		if err != nil {
+			db.options.Logger.Warnf("%v, rebuilding index from all data files", err)
+			return false, nil
+		}
+		return true, db.loadIndexFromHintBuffer(records)
+	}
+
+	// 配置了多个worker时，并发构建索引
+	if db.options.IndexBuildWorkers > 1 {
+		buf, err := os.ReadFile(hintFileName)
+		if err != nil {
+			return false, err
+		}
+		return true, db.loadIndexFromHintBuffer(buf)
 	}
 
 	//打开hint索引文件
 	hintFile, err := data.OpenHintFile(db.options.DirPath)
 	if err != nil {
-		return err
+		return false, err
 	}
+	defer hintFile.Close()
 
 	// 读取文件中的索引
 	var offset int64 = 0
@@ -320,14 +838,160 @@ func (db *DB) loadIndexFromHintFile() error {
 			if err == io.EOF {
 				break
 			}
-			return err
+			return false, err
 		}
 
-		// 解码拿到实际的位置索引
-		pos := data.DecodeLogRecordPos(logRecord.Value)
-		// 将索引放入内存
-		db.index.Put(logRecord.Key, pos)
+		db.putHintRecord(logRecord)
 		offset += size
 	}
+	return true, nil
+}
+
+// 从读入内存的 hint 文件内容中加载索引
+// 按字节偏移切分为 IndexBuildWorkers 个大小相近的分片（分片边界对齐到记录的起始位置），
+// 每个 worker 负责解码自己分片中的记录、校验crc并写入索引（hint 文件中每个key只出现一次，写入顺序不影响结果）
+func (db *DB) loadIndexFromHintBuffer(buf []byte) error {
+	// 只解析Header跳过记录，找到每个分片的起始位置
+	workers := max(db.options.IndexBuildWorkers, 1)
+	chunkSize := int64(len(buf)) / int64(workers)
+	bounds := []int64{0}
+	var offset int64 = 0
+	for offset < int64(len(buf)) {
+		size, err := data.LogRecordSize(buf[offset:])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		offset += size
+		if offset >= chunkSize*int64(len(bounds)) && len(bounds) < workers {
+			bounds = append(bounds, offset)
+		}
+	}
+	bounds = append(bounds, offset)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(bounds)-1)
+	for i := 0; i < len(bounds)-1; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			chunk := buf[bounds[i]:bounds[i+1]]
+			for len(chunk) > 0 {
+				logRecord, size, err := data.DecodeLogRecord(chunk)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				db.putHintRecord(logRecord)
+				chunk = chunk[size:]
+			}
+		}(i)
+	}
+
+	// 等待所有 worker 写完索引
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// 将 hint 文件中的一条记录放入内存索引
+func (db *DB) putHintRecord(logRecord *data.LogRecord) {
+	// 末尾的校验和不是索引记录（关闭 HintChecksum 之后仍然可能存在）
+	if logRecord.Type == data.LogRecordHintChecksum {
+		return
+	}
+	// 解码拿到实际的位置索引
+	pos := data.DecodeLogRecordPos(logRecord.Value)
+	// 指向的数据文件可能已被 Truncate 删除，此时索引已失效，直接跳过
+	if _, ok := db.olderFiles[pos.Fid]; !ok && (db.activeFile == nil || db.activeFile.FileId != pos.Fid) {
+		return
+	}
+	// 将索引放入内存
+	db.index.Put(logRecord.Key, pos)
+}
+
+// 删除所有记录均已失效的旧数据文件，返回回收的字节数（merge的轻量版本，不重写任何数据）
+func (db *DB) Truncate() (int64, error) {
+	if db.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+	if db.options.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	if db.options.InMemory {
+		return 0, ErrInMemoryUnsupported
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 如果数据库为空，则直接返回
+	if db.activeFile == nil {
+		return 0, nil
+	}
+
+	// merge 过程中会读取旧的数据文件，不能删除
+	if db.isMerging {
+		return 0, ErrMergeIsProgress
+	}
+	return db.truncateFiles()
+}
+
+// 删除从最小id开始连续的失效旧数据文件，返回回收的字节数（调用方需持有数据库的写锁）
+func (db *DB) truncateFiles() (int64, error) {
+	// 统计内存索引中仍被引用的文件id
+	referenced := make(map[uint32]struct{})
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		referenced[iterator.Value().Fid] = struct{}{}
+	}
+	iterator.Close()
+
+	// 将旧的数据文件根据FileId从小到大进行排序（活跃文件不在其中，永远不会被删除）
+	fileIds := make([]uint32, 0, len(db.olderFiles))
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	// 只删除从最小id开始连续的失效文件
+	// 如果跳过某个仍被引用的文件继续删除，后续文件中的删除记录会丢失，重启时被删除的key会重新出现
+	var reclaimed int64
+	removed := make(map[uint32]struct{})
+	for _, fid := range fileIds {
+		if _, ok := referenced[fid]; ok {
+			break
+		}
+
+		dataFile := db.olderFiles[fid]
+		size, err := dataFile.IOManager.Size()
+		if err != nil {
+			return reclaimed, err
+		}
+		if err := dataFile.Close(); err != nil {
+			return reclaimed, err
+		}
+		if err := os.Remove(db.getDataFileName(db.options.DirPath, fid)); err != nil {
+			return reclaimed, err
+		}
+		delete(db.olderFiles, fid)
+		removed[fid] = struct{}{}
+		reclaimed += size
+	}
+	db.dropVersionsInFiles(removed)
+
+	// 被删除文件中的数据均已计入可回收大小，需要扣除
+	db.reclaimSize -= reclaimed
+	if db.reclaimSize < 0 {
+		db.reclaimSize = 0
+	}
+
+	return reclaimed, db.writeManifest()
+}
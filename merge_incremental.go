@@ -0,0 +1,404 @@
+package bitcask_go
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// 增量merge中重写的一条记录
+type incrementalMergeRecord struct {
+	realKey []byte
+	oldPos  *data.LogRecordPos
+	newPos  *data.LogRecordPos // 没有重写时为nil
+	indexed bool               // 内存索引指向此记录
+	expired bool               // 记录已过期，生效时从内存索引中删除
+}
+
+// 增量merge：每次最多重写最早的 maxFiles 个还没有merge过的旧数据文件，返回是否已经没有需要merge的文件
+// 调用方可以循环调用，把一次完整merge的停顿和磁盘占用分摊到多次调用中；活跃文件不参与merge
+// 和 Merge 不同，结果在本次调用中直接生效：hint文件和merge完成的标识随每次调用更新，重写的文件替换原来的文件并更新内存索引
+// 已经merge过的文件中之后失效的数据不会被再次回收，需要通过 Merge 或 Vacuum 回收
+// B+树索引持久化在磁盘上，无法和数据文件原子地一起更新，不支持增量merge
+// Merge 完成之后结果要在重新打开时才生效，在此之前调用返回 ErrMergeIsProgress
+func (db *DB) MergeN(maxFiles int) (done bool, err error) {
+	if db.closed.Load() {
+		return false, ErrDatabaseClosed
+	}
+	if maxFiles <= 0 {
+		return false, ErrInvalidMergeFileCount
+	}
+	if db.options.ReadOnly {
+		return false, ErrReadOnly
+	}
+	if db.options.InMemory {
+		return false, ErrInMemoryUnsupported
+	}
+	if db.options.IndexType == BPlusTree {
+		return false, ErrIncrementalMergeUnsupported
+	}
+
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return false, ErrMergeIsProgress
+	}
+	db.isMerging = true
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+	return db.mergeIncremental(maxFiles)
+}
+
+// 增量merge最早的 maxFiles 个还没有merge过的旧数据文件（调用方需已设置 isMerging）
+func (db *DB) mergeIncremental(maxFiles int) (bool, error) {
+	// merge目录中已完成的 Merge 结果在下次打开时生效，不能删除，重新打开之前不能增量merge
+	mergePath := db.getMergePath()
+	if _, err := os.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); err == nil {
+		return false, ErrMergeIsProgress
+	}
+
+	db.mu.Lock()
+	// 更早的文件已经merge过，索引保存在hint文件中
+	startFileId, err := db.hintFileIdBound()
+	if err != nil {
+		db.mu.Unlock()
+		return false, err
+	}
+	var fileIds []uint32
+	for fid := range db.olderFiles {
+		if fid >= startFileId {
+			fileIds = append(fileIds, fid)
+		}
+	}
+	if len(fileIds) == 0 {
+		db.mu.Unlock()
+		return true, nil
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	// 未参与本次merge的最小文件id
+	nonMergeFileId := db.activeFile.FileId
+	if len(fileIds) > maxFiles {
+		nonMergeFileId = fileIds[maxFiles]
+		fileIds = fileIds[:maxFiles]
+	}
+	mergeFiles := make([]*data.DataFile, len(fileIds))
+	for i, fid := range fileIds {
+		mergeFiles[i] = db.olderFiles[fid]
+	}
+	// 历史版本引用的旧记录同样需要保留
+	versionRefs := make(map[data.LogRecordPos]struct{})
+	for _, versions := range db.versions {
+		for _, v := range versions {
+			if v.pos != nil && v.pos.Fid >= startFileId && v.pos.Fid < nonMergeFileId {
+				versionRefs[data.LogRecordPos{Fid: v.pos.Fid, Offset: v.pos.Offset}] = struct{}{}
+			}
+		}
+	}
+	db.mu.Unlock()
+
+	// 之前没有完成的merge留下的目录可以直接删除
+	if err := os.RemoveAll(mergePath); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
+		return false, err
+	}
+
+	records, mergedSize, err := db.writeIncrementalMerge(mergePath, mergeFiles, startFileId, nonMergeFileId, versionRefs)
+	if err != nil {
+		return false, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.applyIncrementalMerge(fileIds, records, mergedSize); err != nil {
+		return false, err
+	}
+	db.options.Logger.Infof("incremental merge finished: data files [%d, %d), %d bytes rewritten to %d records", startFileId, nonMergeFileId, mergedSize, len(records))
+
+	for fid := range db.olderFiles {
+		if fid >= nonMergeFileId {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// 在merge目录中重写参与merge的文件，写入新的hint文件和merge完成的标识，返回重写的记录以及参与merge的文件的总大小
+// 重写后的文件id从 startFileId 开始，不会超过 nonMergeFileId（有效数据不会比原来的文件更多）
+func (db *DB) writeIncrementalMerge(mergePath string, mergeFiles []*data.DataFile, startFileId, nonMergeFileId uint32,
+	versionRefs map[data.LogRecordPos]struct{}) ([]*incrementalMergeRecord, int64, error) {
+	mergeOptions := db.mergeOptions(mergePath)
+	mergeDB, err := Open(mergeOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	hintFile, err := data.OpenHintFile(mergePath)
+	if err != nil {
+		_ = mergeDB.Close()
+		return nil, 0, err
+	}
+	// 移动之前需要关闭merge目录中的文件
+	records, mergedSize, outputFiles, err := db.rewriteIncrementalMerge(mergeDB, hintFile, mergeFiles, startFileId, versionRefs)
+	if closeErr := hintFile.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := mergeDB.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 将重写后的文件按新的文件id重命名，从大到小重命名不会覆盖还没有重命名的文件
+	for i := int(outputFiles) - 1; i >= 0 && startFileId > 0; i-- {
+		if err := os.Rename(db.getDataFileName(mergePath, uint32(i)), db.getDataFileName(mergePath, startFileId+uint32(i))); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// 最后写入merge完成的标识，之前异常退出时下次打开会丢弃merge目录
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer mergeFinishedFile.Close()
+	markers := []*data.LogRecord{
+		{Key: []byte(mergeFinishedKey), Value: []byte(strconv.Itoa(int(nonMergeFileId)))},
+		// value log没有重写，不删除任何value log
+		{Key: []byte(mergeVlogKey), Value: []byte("0")},
+		{Key: []byte(mergeStartKey), Value: []byte(strconv.Itoa(int(startFileId)))},
+	}
+	// 之前merge的文件使用相同的格式时才记录格式版本
+	format := data.LogRecordFormatOf(mergeOptions.ChecksumAlgorithm)
+	if db.options.ChecksumMode == PerRecord && (startFileId == 0 || db.mergedFormat == format) {
+		markers = append(markers, &data.LogRecord{Key: []byte(mergeFormatKey), Value: []byte(strconv.Itoa(int(format)))})
+	}
+	for _, marker := range markers {
+		encRecord, _ := data.EncodeLogRecord(marker)
+		if err := mergeFinishedFile.Write(encRecord); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := mergeFinishedFile.Sync(); err != nil {
+		return nil, 0, err
+	}
+	return records, mergedSize, nil
+}
+
+// 将参与merge的文件中需要保留的记录写入merge目录中的临时实例，有效的记录同时写入hint文件
+// 返回重写的记录、参与merge的文件的总大小以及临时实例中生成的文件数量
+func (db *DB) rewriteIncrementalMerge(mergeDB *DB, hintFile *data.DataFile, mergeFiles []*data.DataFile, startFileId uint32,
+	versionRefs map[data.LogRecordPos]struct{}) ([]*incrementalMergeRecord, int64, uint32, error) {
+	// 之前merge的文件中仍然有效的索引保留到新的hint文件中
+	if err := db.copyValidHintRecords(hintFile); err != nil {
+		return nil, 0, 0, err
+	}
+
+	// 之前还有其他数据文件或者保留了历史版本时，旧的记录可能在hint文件失效后重新被加载，删除记录需要保留
+	keepDeletes := startFileId > 0 || len(versionRefs) > 0
+	now := time.Now().UnixNano()
+	var records []*incrementalMergeRecord
+	var mergedSize int64
+	for _, dataFile := range mergeFiles {
+		var offset int64
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err == io.EOF {
+				mergedSize += offset
+				break
+			}
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			oldPos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: offset, Size: uint32(size)}
+			offset += size
+
+			realKey, _ := parseLogRecordKey(logRecord.Key)
+			record := &incrementalMergeRecord{realKey: realKey, oldPos: oldPos}
+			switch logRecord.Type {
+			case data.LogRecordTxnFinished, data.LogRecordFlushAll:
+				continue
+			case data.LogRecordDeleted:
+				if !keepDeletes || db.index.Get(realKey) != nil {
+					continue
+				}
+			default:
+				pos := db.index.Get(realKey)
+				record.indexed = pos != nil && pos.Fid == oldPos.Fid && pos.Offset == oldPos.Offset
+				record.expired = record.indexed && pos.IsExpired(now)
+				_, versioned := versionRefs[data.LogRecordPos{Fid: oldPos.Fid, Offset: oldPos.Offset}]
+				if record.expired && !versioned {
+					// 已过期的key不再重写
+					records = append(records, record)
+					continue
+				}
+				if !versioned && !record.indexed {
+					continue
+				}
+			}
+
+			// 记录已经提交，清除事务序列号；value log中的数据不重写，继续使用原来的指针
+			logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+			if err := db.writeLimiter.wait(len(realKey) + len(logRecord.Value)); err != nil {
+				return nil, 0, 0, err
+			}
+			pos, err := mergeDB.appendLogRecord(logRecord)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			pos.Fid += startFileId
+			record.newPos = pos
+			if record.indexed && !record.expired {
+				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+					return nil, 0, 0, err
+				}
+			}
+			records = append(records, record)
+		}
+	}
+	if err := db.finishHintFile(hintFile); err != nil {
+		return nil, 0, 0, err
+	}
+	if err := mergeDB.Sync(); err != nil {
+		return nil, 0, 0, err
+	}
+	var outputFiles uint32
+	if mergeDB.activeFile != nil {
+		outputFiles = mergeDB.activeFile.FileId + 1
+	}
+	return records, mergedSize, outputFiles, nil
+}
+
+// 将数据目录中hint文件里仍然有效的索引写入新的hint文件
+func (db *DB) copyValidHintRecords(hintFile *data.DataFile) error {
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.HintFileName)); os.IsNotExist(err) {
+		return nil
+	}
+	oldHintFile, err := data.OpenHintFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer oldHintFile.Close()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var offset int64
+	for {
+		logRecord, size, err := oldHintFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset += size
+		if logRecord.Type == data.LogRecordHintChecksum {
+			continue
+		}
+
+		hintPos := data.DecodeLogRecordPos(logRecord.Value)
+		pos := db.index.Get(logRecord.Key)
+		if pos == nil || pos.Fid != hintPos.Fid || pos.Offset != hintPos.Offset {
+			continue
+		}
+		if err := hintFile.WriteHintRecord(logRecord.Key, pos); err != nil {
+			return err
+		}
+	}
+}
+
+// 关闭参与merge的文件，移动merge目录中的结果，打开重写后的文件并更新内存索引和历史版本中的位置（调用方需持有写锁）
+func (db *DB) applyIncrementalMerge(fileIds []uint32, records []*incrementalMergeRecord, mergedSize int64) error {
+	// 先关闭参与merge的文件才能删除，确认merge结果已经生效之后再从 olderFiles 中移除
+	for _, fid := range fileIds {
+		if err := db.olderFiles[fid].Close(); err != nil {
+			return err
+		}
+	}
+	_, applied, err := db.applyMergeFiles()
+	if err == nil && !applied {
+		err = errMergeNotApplied
+	}
+	if err != nil {
+		// 重新打开原来的文件，内存索引仍然指向这些文件
+		for _, fid := range fileIds {
+			dataFile, openErr := db.openDataFile(fid, fio.StandardFIO, false)
+			if openErr != nil {
+				return errors.Join(err, openErr)
+			}
+			db.olderFiles[fid] = dataFile
+		}
+		return err
+	}
+	for _, fid := range fileIds {
+		delete(db.olderFiles, fid)
+	}
+
+	var rewrittenSize int64
+	newPositions := make(map[data.LogRecordPos]*data.LogRecordPos, len(records))
+	for _, record := range records {
+		// merge过程中没有被覆盖的key指向新的位置，已过期的key从索引中删除
+		if record.indexed {
+			if pos := db.index.Get(record.realKey); pos != nil && pos.Fid == record.oldPos.Fid && pos.Offset == record.oldPos.Offset {
+				if record.expired {
+					db.index.Delete(record.realKey)
+				} else {
+					db.index.Put(record.realKey, record.newPos)
+				}
+			}
+		}
+		if record.newPos == nil {
+			continue
+		}
+		if _, ok := db.olderFiles[record.newPos.Fid]; !ok {
+			dataFile, err := db.openDataFile(record.newPos.Fid, fio.StandardFIO, false)
+			if err != nil {
+				return err
+			}
+			db.olderFiles[record.newPos.Fid] = dataFile
+		}
+		rewrittenSize += int64(record.newPos.Size)
+		newPositions[data.LogRecordPos{Fid: record.oldPos.Fid, Offset: record.oldPos.Offset}] = record.newPos
+	}
+	for _, versions := range db.versions {
+		for _, v := range versions {
+			if v.pos == nil {
+				continue
+			}
+			if newPos, ok := newPositions[data.LogRecordPos{Fid: v.pos.Fid, Offset: v.pos.Offset}]; ok {
+				v.pos = newPos
+			}
+		}
+	}
+
+	if db.mergedFormat, err = db.loadMergedFormat(); err != nil {
+		return err
+	}
+	if db.reclaimSize -= mergedSize - rewrittenSize; db.reclaimSize < 0 {
+		db.reclaimSize = 0
+	}
+	return db.writeManifest()
+}
+
+// 数据目录中hint文件覆盖的文件id上界（merge完成的标识中未参与merge的文件id），没有merge完成的标识时返回0
+func (db *DB) hintFileIdBound() (uint32, error) {
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.MergeFinishedFileName)); os.IsNotExist(err) {
+		return 0, nil
+	}
+	fid, _, err := db.getNonMergeFileId(db.options.DirPath)
+	return fid, err
+}
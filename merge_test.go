@@ -0,0 +1,907 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bitcask-go/data"
+)
+
+// 统计目录中的数据文件数量
+func countDataFiles(t *testing.T, dirPath string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			count++
+		}
+	}
+	return count
+}
+
+// 统计目录中数据文件的总大小
+func dataFilesSize(t *testing.T, dirPath string) int64 {
+	t.Helper()
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			info, err := entry.Info()
+			if err != nil {
+				t.Fatal(err)
+			}
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func TestDB_TruncateAfterOverwrites(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+	})
+
+	// 写入数据后全部覆盖，前面的数据文件全部失效
+	for i := 0; i < 200; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if err := db.Put(testKey(i), []byte("new-"+string(testValue(i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := countDataFiles(t, db.options.DirPath)
+	reclaimed, err := db.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed <= 0 {
+		t.Fatalf("reclaimed %d bytes, want > 0", reclaimed)
+	}
+	if after := countDataFiles(t, db.options.DirPath); after >= before {
+		t.Fatalf("data files %d -> %d, want fewer", before, after)
+	}
+
+	// 删除文件后所有key仍然可以读取到最新的值，重启后同样如此
+	check := func(db *DB) {
+		for i := 0; i < 200; i++ {
+			value, err := db.Get(testKey(i))
+			if err != nil {
+				t.Fatalf("get %s: %v", testKey(i), err)
+			}
+			if !bytes.Equal(value, []byte("new-"+string(testValue(i)))) {
+				t.Fatalf("get %s = %q", testKey(i), value)
+			}
+		}
+	}
+	check(db)
+	check(reopenTestDB(t, db))
+}
+
+func TestDB_TruncateKeepsDeleteRecords(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+	})
+
+	// 第一个文件中的key被删除，删除记录所在文件之前仍有被引用的文件
+	for i := 0; i < 100; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put([]byte("pinned"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte("filler"), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后被删除的key不能重新出现
+	db = reopenTestDB(t, db)
+	for i := 0; i < 100; i++ {
+		if _, err := db.Get(testKey(i)); err != ErrKeyNotFound {
+			t.Fatalf("get deleted key %s: %v", testKey(i), err)
+		}
+	}
+	if value, err := db.Get([]byte("pinned")); err != nil || string(value) != "value" {
+		t.Fatalf("get pinned = %q, %v", value, err)
+	}
+}
+
+func TestDB_TruncateEmpty(t *testing.T) {
+	db := openTestDB(t, nil)
+	reclaimed, err := db.Truncate()
+	if err != nil || reclaimed != 0 {
+		t.Fatalf("Truncate = %d, %v", reclaimed, err)
+	}
+}
+
+// 写入数据并merge生成hint文件，重启一次将merge目录中的文件移入数据目录，关闭后返回配置项
+func prepareHintFile(tb testing.TB, dirPath string, keys int) Options {
+	tb.Helper()
+	options := DefaultOptions
+	options.DirPath = dirPath
+	options.DataFileSize = 8 * 1024 * 1024
+	options.DataFileMergeRatio = 0
+	db, err := Open(options)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < keys; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := db.Merge(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	if db, err = Open(options); err != nil {
+		tb.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dirPath, data.HintFileName)); err != nil {
+		tb.Fatal(err)
+	}
+	return options
+}
+
+func TestDB_LoadIndexFromHintFileParallel(t *testing.T) {
+	options := prepareHintFile(t, t.TempDir(), 20000)
+
+	for _, workers := range []int{1, 2, 3, 8} {
+		options.IndexBuildWorkers = workers
+		db, err := Open(options)
+		if err != nil {
+			t.Fatalf("open with %d workers: %v", workers, err)
+		}
+		if size := db.index.Size(); size != 20000 {
+			t.Fatalf("index size with %d workers = %d", workers, size)
+		}
+		for i := 0; i < 20000; i += 997 {
+			value, err := db.Get(testKey(i))
+			if err != nil || !bytes.Equal(value, testValue(i)) {
+				t.Fatalf("get %s with %d workers = %q, %v", testKey(i), workers, value, err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_LoadIndexFromHintFileParallelCorrupted(t *testing.T) {
+	options := prepareHintFile(t, t.TempDir(), 1000)
+
+	// 修改 hint 文件中间的一个字节，worker 解码时能发现 crc 错误
+	hintFileName := filepath.Join(options.DirPath, data.HintFileName)
+	buf, err := os.ReadFile(hintFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[len(buf)/2] ^= 0xff
+	if err := os.WriteFile(hintFileName, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	options.IndexBuildWorkers = 4
+	db, err := Open(options)
+	if err == nil {
+		_ = db.Close()
+		t.Fatal("open with corrupted hint file succeeded")
+	}
+}
+
+// 写入数据并merge生成带校验和的hint文件，之后的数据写入未merge的文件，返回关闭之后的配置
+func prepareHintChecksum(t *testing.T) Options {
+	t.Helper()
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+		options.HintChecksum = true
+	})
+	writeManifestData(t, db, 0, 1000)
+	for i := 0; i < 500; i++ {
+		if err := db.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	writeManifestData(t, db, 1000, 1100)
+	if err := db.Delete(testKey(999)); err != nil {
+		t.Fatal(err)
+	}
+	options := db.options
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return options
+}
+
+// hint文件之外的数据文件中的记录和删除都能正确加载
+func expectHintChecksumData(t *testing.T, db *DB) {
+	t.Helper()
+	expectKeyCount(t, db, 599)
+	for i := 0; i < 1100; i++ {
+		value, err := db.Get(testKey(i))
+		switch {
+		case i < 500 || i == 999:
+			if err != ErrKeyNotFound {
+				t.Fatalf("expected %s to be deleted, got %v", testKey(i), err)
+			}
+		case err != nil || !bytes.Equal(value, testValue(i)):
+			t.Fatalf("get %s = %q, %v", testKey(i), value, err)
+		}
+	}
+}
+
+func TestDB_HintChecksum(t *testing.T) {
+	options := prepareHintChecksum(t)
+	hintFileName := filepath.Join(options.DirPath, data.HintFileName)
+	buf, err := os.ReadFile(hintFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := data.VerifyHintChecksum(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 校验通过时使用hint文件；关闭选项之后末尾的校验和不影响加载
+	for _, hintChecksum := range []bool{true, false} {
+		for _, workers := range []int{1, 4} {
+			logger := &captureLogger{}
+			options.HintChecksum = hintChecksum
+			options.IndexBuildWorkers = workers
+			options.Logger = logger
+			db, err := Open(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectHintChecksumData(t, db)
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if n := logger.count(data.ErrHintChecksumMismatch.Error()); n != 0 {
+				t.Fatalf("unexpected checksum warning %q", logger.lines)
+			}
+		}
+	}
+}
+
+// hint文件损坏时从所有数据文件重新加载索引
+func TestDB_HintChecksumMismatch(t *testing.T) {
+	for name, damage := range map[string]func(buf []byte) []byte{
+		"flipped": func(buf []byte) []byte {
+			buf[len(buf)/2] ^= 0xff
+			return buf
+		},
+		"truncated": func(buf []byte) []byte {
+			return buf[:len(buf)-1]
+		},
+		"missing checksum": func(buf []byte) []byte {
+			records, err := data.VerifyHintChecksum(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return records
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			options := prepareHintChecksum(t)
+			hintFileName := filepath.Join(options.DirPath, data.HintFileName)
+			buf, err := os.ReadFile(hintFileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(hintFileName, damage(buf), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			logger := &captureLogger{}
+			options.Logger = logger
+			db, err := Open(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if logger.count(data.ErrHintChecksumMismatch.Error()) != 1 {
+				t.Fatalf("expected a checksum warning, got %q", logger.lines)
+			}
+			expectHintChecksumData(t, db)
+		})
+	}
+}
+
+func BenchmarkDB_LoadIndexFromHintFile(b *testing.B) {
+	options := prepareHintFile(b, b.TempDir(), 1000000)
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			options.IndexBuildWorkers = workers
+			for i := 0; i < b.N; i++ {
+				db, err := Open(options)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := db.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDB_MergeForce(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 32 * 1024
+		options.DataFileMergeRatio = 0.9
+	})
+	for i := 0; i < 2000; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 只覆盖少量数据，可回收的比例达不到阈值
+	for i := 0; i < 100; i++ {
+		if err := db.Put(testKey(i), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Merge(); err != ErrMergeRatioUnreached {
+		t.Fatalf("Merge = %v, want ErrMergeRatioUnreached", err)
+	}
+	if db.Stat().ReclaimableSize == 0 {
+		t.Fatal("no reclaimable data before merge")
+	}
+	sizeBefore := dataFilesSize(t, db.options.DirPath)
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后merge生效，失效数据被清理
+	db = reopenTestDB(t, db)
+	if size := db.Stat().ReclaimableSize; size != 0 {
+		t.Fatalf("reclaimable size after merge = %d", size)
+	}
+	if sizeAfter := dataFilesSize(t, db.options.DirPath); sizeAfter >= sizeBefore {
+		t.Fatalf("data files size %d -> %d, want smaller", sizeBefore, sizeAfter)
+	}
+	for i := 0; i < 2000; i++ {
+		want := testValue(i)
+		if i < 100 {
+			want = []byte("new")
+		}
+		value, err := db.Get(testKey(i))
+		if err != nil || !bytes.Equal(value, want) {
+			t.Fatalf("get %s = %q, %v", testKey(i), value, err)
+		}
+	}
+}
+
+// 统计include选中的数据文件中各个Header格式版本的记录数量
+func countRecordFormats(t *testing.T, dirPath string, include func(fid uint32) bool) map[LogRecordFormat]int {
+	t.Helper()
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formats := make(map[LogRecordFormat]int)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		var fid uint32
+		if _, err := fmt.Sscanf(entry.Name(), "%d", &fid); err != nil {
+			t.Fatal(err)
+		}
+		if !include(fid) {
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for offset := int64(0); offset < int64(len(buf)); {
+			size, err := data.LogRecordSize(buf[offset:])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			formats[data.DecodeLogRecordFormat(buf[offset:])]++
+			offset += size
+		}
+	}
+	return formats
+}
+
+func TestDB_MergeUpgradeFormat(t *testing.T) {
+	for _, upgrade := range []bool{true, false} {
+		// 使用旧格式（IEEE crc）写入数据
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 4 * 1024
+			options.ChecksumAlgorithm = CRC32IEEE
+		})
+		for i := 0; i < 300; i++ {
+			if err := db.Put(testKey(i%100), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		all := func(uint32) bool { return true }
+		if formats := countRecordFormats(t, db.options.DirPath, all); formats[LogRecordFormatV2] != 0 {
+			t.Fatalf("unexpected record formats before merge: %v", formats)
+		}
+
+		db.options.MergeUpgradeFormat = upgrade
+		if err := db.MergeForce(); err != nil {
+			t.Fatal(err)
+		}
+		db = reopenTestDB(t, db)
+
+		// merge重写的文件中只有一种格式，并记录在merge完成的标识中
+		expected := LogRecordFormatLegacy
+		if upgrade {
+			expected = LatestLogRecordFormat
+		}
+		if format := db.Stat().MergedFormat; format != expected {
+			t.Fatalf("merged format = %d, want %d", format, expected)
+		}
+		merged := countRecordFormats(t, db.options.DirPath, func(fid uint32) bool { return fid < db.mergedFileId })
+		if len(merged) != 1 || merged[expected] != 100 {
+			t.Fatalf("unexpected record formats after merge: %v", merged)
+		}
+		for i := 200; i < 300; i++ {
+			value, err := db.Get(testKey(i % 100))
+			if err != nil || !bytes.Equal(value, testValue(i)) {
+				t.Fatalf("get %s = %q, %v", testKey(i%100), value, err)
+			}
+		}
+	}
+}
+
+// 没有merge或按块校验时不记录格式版本
+func TestDB_MergedFormatUnknown(t *testing.T) {
+	db := openTestDB(t, nil)
+	if format := db.Stat().MergedFormat; format != 0 {
+		t.Fatalf("merged format without merge = %d", format)
+	}
+
+	db = openTestDB(t, func(options *Options) {
+		options.ChecksumMode = PerBlock
+	})
+	for i := 0; i < 100; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if format := db.Stat().MergedFormat; format != 0 {
+		t.Fatalf("merged format with per block checksum = %d", format)
+	}
+	if _, err := db.Get(testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 读取目录中所有数据文件的内容
+func readDataFiles(t *testing.T, dirPath string) map[string][]byte {
+	t.Helper()
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			content, err := os.ReadFile(filepath.Join(dirPath, entry.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[entry.Name()] = content
+		}
+	}
+	return files
+}
+
+// SizeTiered 策略只回收无效数据比例最高的一层，其他层的文件保持不变
+func TestDB_MergeSizeTiered(t *testing.T) {
+	bounds := sizeTierBounds
+	sizeTierBounds = []int64{2048}
+	defer func() { sizeTierBounds = bounds }()
+
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+		options.CompactionStrategy = SizeTiered
+	})
+	// 大文件中只有有效数据
+	expected := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		expected[string(testKey(i))] = string(testValue(i))
+	}
+	if err := db.Merge(); err != ErrMergeRatioUnreached {
+		t.Fatalf("expected ErrMergeRatioUnreached, got %v", err)
+	}
+
+	// 小文件中反复覆盖同一批key
+	db.options.DataFileSize = 1024
+	db = reopenTestDB(t, db)
+	for round := 0; round < 5; round++ {
+		for i := 1000; i < 1020; i++ {
+			value := fmt.Sprintf("round-%d-%s", round, testValue(i))
+			if err := db.Put(testKey(i), []byte(value)); err != nil {
+				t.Fatal(err)
+			}
+			expected[string(testKey(i))] = value
+		}
+	}
+
+	before := readDataFiles(t, db.options.DirPath)
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	after := readDataFiles(t, db.options.DirPath)
+	if len(after) != len(before) {
+		t.Fatalf("data files %d -> %d", len(before), len(after))
+	}
+	var rewritten int
+	for name, content := range before {
+		if bytes.Equal(after[name], content) {
+			continue
+		}
+		if len(content) >= 2048 {
+			t.Fatalf("large data file %s should not be rewritten", name)
+		}
+		rewritten++
+	}
+	if rewritten == 0 {
+		t.Fatal("expected small data files to be rewritten")
+	}
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.HintFileName)); !os.IsNotExist(err) {
+		t.Fatalf("size tiered merge should not write a hint file: %v", err)
+	}
+
+	expectContent(t, db, expected)
+	db = reopenTestDB(t, db)
+	expectContent(t, db, expected)
+}
+
+// 每次merge两个文件，循环直到完成，期间继续写入
+func TestDB_MergeN(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.MaxVersionsPerKey = 3 },
+		func(options *Options) { options.ValueLogSeparationThreshold = 16 },
+		func(options *Options) { options.IndexType = ART },
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 1024
+			if configure != nil {
+				configure(options)
+			}
+		})
+		expected := writeVacuumData(t, db)
+		files := countDataFiles(t, db.options.DirPath)
+		if files < 6 {
+			t.Fatalf("expected at least 6 data files, got %d", files)
+		}
+
+		var calls int
+		for {
+			done, err := db.MergeN(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			calls++
+			expectContent(t, db, expected)
+			if done {
+				break
+			}
+			if calls > files {
+				t.Fatalf("merge not done after %d calls", calls)
+			}
+			// merge之间的写入和删除
+			key := fmt.Sprintf("between-%d", calls)
+			if err := db.Put([]byte(key), []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+			expected[key] = "v"
+			if err := db.Delete(testKey(calls)); err != nil {
+				t.Fatal(err)
+			}
+			delete(expected, string(testKey(calls)))
+		}
+		if calls < 2 {
+			t.Fatalf("expected several calls, got %d", calls)
+		}
+		// 保留历史版本时旧的记录不会被回收
+		if after := countDataFiles(t, db.options.DirPath); after >= files && db.options.MaxVersionsPerKey <= 1 {
+			t.Fatalf("data files %d -> %d, want fewer", files, after)
+		}
+		if done, err := db.MergeN(2); err != nil || !done {
+			t.Fatalf("expected nothing to merge, got %v, %v", done, err)
+		}
+
+		// 重新打开时从hint文件加载已经merge的文件
+		db = reopenTestDB(t, db)
+		expectContent(t, db, expected)
+
+		// hint文件被vacuum删除之后，从数据文件加载时被删除的key不会重新出现
+		for i := 200; i < 300; i++ {
+			if err := db.Put([]byte("filler"), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected["filler"] = string(testValue(299))
+		if err := db.Vacuum(); err != nil {
+			t.Fatal(err)
+		}
+		db = reopenTestDB(t, db)
+		expectContent(t, db, expected)
+	}
+}
+
+// merge目录中的结果不完整或无法读取时返回错误，参与merge的文件重新打开，数据仍然可以读取
+func TestDB_MergeNApplyFailure(t *testing.T) {
+	for name, prepare := range map[string]func(mergePath string) error{
+		"not finished": func(string) error { return nil },
+		"corrupted finished file": func(mergePath string) error {
+			mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+			if err != nil {
+				return err
+			}
+			defer mergeFinishedFile.Close()
+			encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte(mergeFinishedKey), Value: []byte("bad")})
+			return mergeFinishedFile.Write(encRecord)
+		},
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 1024
+		})
+		expected := writeVacuumData(t, db)
+		fileIds := []uint32{0, 1}
+
+		mergePath := db.getMergePath()
+		if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := prepare(mergePath); err != nil {
+			t.Fatal(err)
+		}
+		db.mu.Lock()
+		err := db.applyIncrementalMerge(fileIds, nil, 0)
+		db.mu.Unlock()
+		var numErr *strconv.NumError
+		if err == nil || (err != errMergeNotApplied && !errors.As(err, &numErr)) {
+			t.Fatalf("%s: unexpected error %v", name, err)
+		}
+
+		for _, fid := range fileIds {
+			if _, ok := db.olderFiles[fid]; !ok {
+				t.Fatalf("%s: data file %d dropped", name, fid)
+			}
+		}
+		expectContent(t, db, expected)
+		db = reopenTestDB(t, db)
+		expectContent(t, db, expected)
+	}
+}
+
+// Merge 完成但还没有在重新打开时生效的结果不会被 MergeN 删除
+func TestDB_MergeNAfterMerge(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 1024
+	})
+	expected := writeVacuumData(t, db)
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MergeN(1); err != ErrMergeIsProgress {
+		t.Fatalf("expected ErrMergeIsProgress, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(db.getMergePath(), data.MergeFinishedFileName)); err != nil {
+		t.Fatalf("merge result was removed: %v", err)
+	}
+
+	db = reopenTestDB(t, db)
+	if db.mergedFileId == 0 {
+		t.Fatal("expected merge results applied")
+	}
+	expectContent(t, db, expected)
+	if _, err := db.MergeN(1); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, expected)
+}
+
+func TestDB_MergeNInvalid(t *testing.T) {
+	db := openTestDB(t, nil)
+	if _, err := db.MergeN(0); err != ErrInvalidMergeFileCount {
+		t.Fatalf("expected ErrInvalidMergeFileCount, got %v", err)
+	}
+	db = openTestDB(t, func(options *Options) {
+		options.IndexType = BPlusTree
+	})
+	if _, err := db.MergeN(1); err != ErrIncrementalMergeUnsupported {
+		t.Fatalf("expected ErrIncrementalMergeUnsupported, got %v", err)
+	}
+}
+
+// 从hint文件加载的位置包含记录大小，merge之后覆盖写入时可回收空间按旧记录的大小增加
+func TestDB_MergeHintKeepsRecordSize(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 32 * 1024
+	})
+	for i := 0; i < 2000; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.HintFileName)); err != nil {
+		t.Fatalf("expected hint file after merge: %v", err)
+	}
+	if size := db.Stat().ReclaimableSize; size != 0 {
+		t.Fatalf("reclaimable size after merge = %d", size)
+	}
+
+	for _, i := range []int{0, 999, 1999} {
+		oldPos := db.index.Get(testKey(i))
+		if oldPos == nil {
+			t.Fatalf("key %d not found", i)
+		}
+		_, recordSize, err := db.olderFiles[oldPos.Fid].ReadLogRecord(oldPos.Offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(oldPos.Size) != recordSize {
+			t.Fatalf("key %d: position size %d, record size %d", i, oldPos.Size, recordSize)
+		}
+
+		reclaimable := db.Stat().ReclaimableSize
+		if err := db.Put(testKey(i), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+		if delta := db.Stat().ReclaimableSize - reclaimable; delta != recordSize {
+			t.Fatalf("key %d: reclaimable size increased by %d, want %d", i, delta, recordSize)
+		}
+	}
+}
+
+// 写入数据并覆盖、删除一部分，使每个数据文件中都有无效数据
+func writeMergeData(tb testing.TB, db *DB, keys int) {
+	tb.Helper()
+	for i := 0; i < keys; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	for i := 0; i < keys; i += 3 {
+		if err := db.Put(testKey(i), []byte("new")); err != nil {
+			tb.Fatal(err)
+		}
+		if err := db.Delete(testKey(i + 1)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// 并发扫描数据文件时，merge生成的数据文件和hint文件与顺序merge完全相同
+func TestDB_MergeConcurrency(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.ValueLogSeparationThreshold = 8 },
+	} {
+		var mergeFiles []map[string][]byte
+		for _, concurrency := range []int{1, 4} {
+			db := openTestDB(t, func(options *Options) {
+				options.DataFileSize = 8 * 1024
+				options.MergeConcurrency = concurrency
+				if configure != nil {
+					configure(options)
+				}
+			})
+			writeMergeData(t, db, 2000)
+			expected := dumpDB(t, db)
+			if err := db.MergeForce(); err != nil {
+				t.Fatal(err)
+			}
+
+			mergePath := db.getMergePath()
+			files := readDataFiles(t, mergePath)
+			if len(files) < 2 {
+				t.Fatalf("expected several merged data files, got %d", len(files))
+			}
+			hint, err := os.ReadFile(filepath.Join(mergePath, data.HintFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[data.HintFileName] = hint
+			mergeFiles = append(mergeFiles, files)
+
+			db = reopenTestDB(t, db)
+			expectContent(t, db, expected)
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		sequential, concurrent := mergeFiles[0], mergeFiles[1]
+		if len(sequential) != len(concurrent) {
+			t.Fatalf("merged files %d -> %d", len(sequential), len(concurrent))
+		}
+		for name, content := range sequential {
+			if !bytes.Equal(content, concurrent[name]) {
+				t.Fatalf("merged file %s differs from sequential merge", name)
+			}
+		}
+	}
+}
+
+func BenchmarkDB_MergeConcurrency(b *testing.B) {
+	for _, concurrency := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			options := DefaultOptions
+			options.DirPath = b.TempDir()
+			options.DataFileSize = 4 * 1024 * 1024
+			options.MergeConcurrency = concurrency
+			db, err := Open(options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			writeMergeData(b, db, 300000)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.MergeForce(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
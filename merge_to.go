@@ -0,0 +1,169 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"bitcask-go/data"
+)
+
+// 将当前所有有效的数据重写到targetDir中，生成一组新的数据文件和hint文件，不修改当前实例的数据目录
+// targetDir必须不存在或者为空，生成的目录可以直接作为独立的数据库打开（使用相同的 ChecksumMode 和 DataFileNamer）
+// 开始时在读锁下记录所有有效key的位置，之后的写入不会出现在结果中；复制期间和merge、vacuum互斥，但不阻塞读写
+func (db *DB) MergeTo(targetDir string) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if filepath.Clean(targetDir) == filepath.Clean(db.options.DirPath) {
+		return ErrMergeTargetNotEmpty
+	}
+	entries, err := os.ReadDir(targetDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return ErrMergeTargetNotEmpty
+	}
+
+	db.mu.Lock()
+	// merge和vacuum会删除或重写旧的数据文件，记录的位置会失效
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	db.isMerging = true
+	snapshot := db.snapshotLivePositions()
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+
+	db.options.Logger.Infof("merge to %s started: %d keys", targetDir, len(snapshot))
+	if err := db.writeMergeTarget(targetDir, snapshot); err != nil {
+		return err
+	}
+	db.options.Logger.Infof("merge to %s finished", targetDir)
+	return nil
+}
+
+// 一个有效key在数据文件中的位置
+type livePosition struct {
+	key []byte
+	pos *data.LogRecordPos
+}
+
+// 记录所有未过期的key的位置（调用方需持有锁）
+// B+树索引迭代器返回的key只在事务内有效，需要拷贝
+func (db *DB) snapshotLivePositions() []livePosition {
+	now := time.Now().UnixNano()
+	positions := make([]livePosition, 0, db.index.Size())
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.IsExpired(now) {
+			continue
+		}
+		positions = append(positions, livePosition{key: append([]byte(nil), iterator.Key()...), pos: pos})
+	}
+	iterator.Close()
+	return positions
+}
+
+// 在targetDir中打开新的实例，依次写入每个key的value，并生成hint文件和merge完成的标识
+func (db *DB) writeMergeTarget(targetDir string, positions []livePosition) error {
+	if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
+		return err
+	}
+	// 目标实例是独立的数据库，较大的value写入它自己的value log
+	targetOptions := db.mergeOptions(targetDir)
+	targetOptions.ValueLogSeparationThreshold = db.options.ValueLogSeparationThreshold
+	targetOptions.ReadOnly = false
+	// 内存模式的实例可以通过MergeTo将数据保存到磁盘
+	targetOptions.InMemory = false
+	targetDB, err := Open(targetOptions)
+	if err != nil {
+		return err
+	}
+	hintFile, err := data.OpenHintFile(targetDir)
+	if err != nil {
+		_ = targetDB.Close()
+		return err
+	}
+
+	nonMergeFileId, err := db.rewriteMergeTarget(targetDB, hintFile, positions)
+	if closeErr := hintFile.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := targetDB.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// 最后写入merge完成的标识，打开时从hint文件加载 nonMergeFileId 之前的文件的索引
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(targetDir)
+	if err != nil {
+		return err
+	}
+	defer mergeFinishedFile.Close()
+	markers := []*data.LogRecord{
+		{Key: []byte(mergeFinishedKey), Value: []byte(strconv.Itoa(int(nonMergeFileId)))},
+		{Key: []byte(mergeVlogKey), Value: []byte("0")},
+	}
+	if db.options.ChecksumMode == PerRecord {
+		format := data.LogRecordFormatOf(targetOptions.ChecksumAlgorithm)
+		markers = append(markers, &data.LogRecord{Key: []byte(mergeFormatKey), Value: []byte(strconv.Itoa(int(format)))})
+	}
+	for _, marker := range markers {
+		encRecord, _ := data.EncodeLogRecord(marker)
+		if err := mergeFinishedFile.Write(encRecord); err != nil {
+			return err
+		}
+	}
+	return mergeFinishedFile.Sync()
+}
+
+// 将每个key的value写入目标实例并记录到hint文件，返回目标实例中第一个不在hint文件中的数据文件id
+func (db *DB) rewriteMergeTarget(targetDB *DB, hintFile *data.DataFile, positions []livePosition) (uint32, error) {
+	for _, live := range positions {
+		value, err := db.getValueByPosition(live.pos)
+		if err != nil {
+			return 0, err
+		}
+		if err := db.writeLimiter.wait(len(live.key) + len(value)); err != nil {
+			return 0, err
+		}
+		pos, err := targetDB.appendLogRecord(&data.LogRecord{
+			Key:    logRecordKeyWithSeq(live.key, nonTransactionSeqNo),
+			Value:  value,
+			Type:   data.LogRecordNormal,
+			Expire: live.pos.Expire,
+		})
+		if err != nil {
+			return 0, err
+		}
+		// B+树索引打开时不扫描数据文件，需要直接写入目标实例的索引
+		targetDB.index.Put(live.key, pos)
+		if err := hintFile.WriteHintRecord(live.key, pos); err != nil {
+			return 0, err
+		}
+	}
+
+	// 切换到新的空活跃文件，之后写入目标实例的数据不在hint文件覆盖的范围内
+	if targetDB.activeFile == nil {
+		if err := targetDB.setActiveFile(); err != nil {
+			return 0, err
+		}
+	} else if err := targetDB.rotateActiveFile(); err != nil {
+		return 0, err
+	}
+	if err := db.finishHintFile(hintFile); err != nil {
+		return 0, err
+	}
+	return targetDB.activeFile.FileId, nil
+}
@@ -0,0 +1,88 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bitcask-go/data"
+)
+
+func TestDB_MergeTo(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.ValueLogSeparationThreshold = 64 },
+		func(options *Options) { options.IndexType = ART },
+		func(options *Options) { options.IndexType = BPlusTree },
+		func(options *Options) { options.ChecksumMode = PerBlock },
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 8 * 1024
+			if configure != nil {
+				configure(options)
+			}
+		})
+		for round := 0; round < 5; round++ {
+			writeFlushData(t, db, round)
+		}
+		if err := db.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.PutWithTTL([]byte("expired"), []byte("v"), time.Nanosecond); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+		expected := dumpDB(t, db)
+		sourceFiles := countDataFiles(t, db.options.DirPath)
+		sourceSize := dataFilesSize(t, db.options.DirPath)
+
+		targetDir := filepath.Join(t.TempDir(), "compacted")
+		if err := db.MergeTo(targetDir); err != nil {
+			t.Fatal(err)
+		}
+		// 源数据库不受影响
+		expectContent(t, db, expected)
+		if count := countDataFiles(t, db.options.DirPath); count != sourceFiles {
+			t.Fatalf("expected %d source data files, got %d", sourceFiles, count)
+		}
+		if _, err := os.Stat(filepath.Join(targetDir, data.HintFileName)); err != nil {
+			t.Fatalf("expected hint file: %v", err)
+		}
+		if size := dataFilesSize(t, targetDir); size >= sourceSize {
+			t.Fatalf("expected less than %d bytes of data files, got %d", sourceSize, size)
+		}
+
+		// 目标目录可以作为独立的数据库打开，只包含有效的key
+		targetOptions := db.options
+		targetOptions.DirPath = targetDir
+		target, err := Open(targetOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectContent(t, target, expected)
+		if pos := target.index.Get([]byte("ttl")); pos == nil || pos.Expire == 0 {
+			t.Fatalf("expected expiration to be kept, got %+v", pos)
+		}
+		if stat := target.Stat(); stat.ReclaimableSize != 0 {
+			t.Fatalf("expected no reclaimable size, got %d", stat.ReclaimableSize)
+		}
+
+		// 之后写入的数据在重新打开后仍然存在
+		if err := target.Put([]byte("after"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		expected["after"] = "v"
+		target = reopenTestDB(t, target)
+		expectContent(t, target, expected)
+		_ = target.Close()
+
+		if err := db.MergeTo(targetDir); err != ErrMergeTargetNotEmpty {
+			t.Fatalf("expected ErrMergeTargetNotEmpty, got %v", err)
+		}
+		if err := db.MergeTo(db.options.DirPath); err != ErrMergeTargetNotEmpty {
+			t.Fatalf("expected ErrMergeTargetNotEmpty, got %v", err)
+		}
+		_ = db.Close()
+	}
+}
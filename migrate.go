@@ -0,0 +1,46 @@
+package bitcask_go
+
+// MigrateChecksumMode 将 options 对应的数据库中的有效数据重写到 destDir 目录下，
+// 新数据库使用 mode 指定的校验方式（例如将 PerRecord 格式的数据库迁移为 PerBlock 格式）
+func MigrateChecksumMode(options Options, destDir string, mode ChecksumMode) error {
+	// 迁移会把读取到的数据重写为新的有效记录，读取时必须校验crc
+	options.SkipReadCRC = false
+	srcDB, err := Open(options)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = srcDB.Close()
+	}()
+
+	destOptions := options
+	destOptions.DirPath = destDir
+	destOptions.AdminAddr = ""
+	destOptions.ChecksumMode = mode
+	destDB, err := Open(destOptions)
+	if err != nil {
+		return err
+	}
+
+	// 遍历源数据库中的所有有效数据，写入新数据库
+	var putErr error
+	if err := srcDB.Fold(func(key []byte, value []byte) bool {
+		if putErr = destDB.Put(key, value); putErr != nil {
+			return false
+		}
+		return true
+	}); err != nil {
+		_ = destDB.Close()
+		return err
+	}
+	if putErr != nil {
+		_ = destDB.Close()
+		return putErr
+	}
+
+	if err := destDB.Sync(); err != nil {
+		_ = destDB.Close()
+		return err
+	}
+	return destDB.Close()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	bitcask "bitcask-go"
+)
+
+// 将已有数据库迁移为指定校验方式的新数据库
+// 用法：go run ./migrate -src /path/to/old -dest /path/to/new -mode block
+func main() {
+	src := flag.String("src", "", "源数据库目录")
+	dest := flag.String("dest", "", "新数据库目录")
+	mode := flag.String("mode", "block", "新数据库的校验方式：record 或 block")
+	srcMode := flag.String("src-mode", "record", "源数据库的校验方式：record 或 block")
+	flag.Parse()
+
+	if *src == "" || *dest == "" {
+		log.Fatal("src and dest dir must be specified")
+	}
+
+	options := bitcask.DefaultOptions
+	options.DirPath = *src
+	options.ChecksumMode = parseChecksumMode(*srcMode)
+
+	if err := bitcask.MigrateChecksumMode(options, *dest, parseChecksumMode(*mode)); err != nil {
+		log.Fatalf("failed to migrate database: %v", err)
+	}
+	log.Printf("migrate %s to %s finished", *src, *dest)
+}
+
+func parseChecksumMode(mode string) bitcask.ChecksumMode {
+	switch mode {
+	case "record":
+		return bitcask.PerRecord
+	case "block":
+		return bitcask.PerBlock
+	default:
+		log.Fatalf("unknown checksum mode: %s", mode)
+	}
+	return bitcask.PerRecord
+}
@@ -0,0 +1,67 @@
+package bitcask_go
+
+import (
+	"sort"
+	"time"
+
+	"bitcask-go/data"
+)
+
+// 批量读取多个key，整个过程只获取一次读锁，所有value来自同一时刻的数据
+// 返回的map以key为索引保存读取成功的value；errs和keys一一对应，读取成功的位置为nil，
+// key不存在或已过期时为 ErrKeyNotFound（map中没有此key，查找得到nil），key为空时为 ErrKeyIsEmpty
+// 读取前按数据文件中的位置 (Fid, Offset) 排序，同一个文件中的读取按偏移递增进行，可以触发预读
+func (db *DB) MultiGet(keys [][]byte) (map[string][]byte, []error) {
+	values := make(map[string][]byte, len(keys))
+	errs := make([]error, len(keys))
+	if db.closed.Load() {
+		for i := range errs {
+			errs[i] = ErrDatabaseClosed
+		}
+		return values, errs
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 先从内存索引中查找所有key的位置
+	type lookup struct {
+		index int
+		pos   *data.LogRecordPos
+	}
+	lookups := make([]lookup, 0, len(keys))
+	now := time.Now().UnixNano()
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		pos := db.index.Get(key)
+		if pos == nil || pos.IsExpired(now) {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		lookups = append(lookups, lookup{index: i, pos: pos})
+	}
+
+	sort.Slice(lookups, func(i, j int) bool {
+		if lookups[i].pos.Fid != lookups[j].pos.Fid {
+			return lookups[i].pos.Fid < lookups[j].pos.Fid
+		}
+		return lookups[i].pos.Offset < lookups[j].pos.Offset
+	})
+
+	ra := newReadAhead()
+	for _, l := range lookups {
+		value, err := db.readValue(l.pos, ra)
+		if err != nil {
+			errs[l.index] = err
+			continue
+		}
+		values[string(keys[l.index])] = value
+		if db.options.EnableLRUTracking {
+			db.recordAccess(l.pos)
+		}
+	}
+	return values, errs
+}
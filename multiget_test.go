@@ -0,0 +1,94 @@
+package bitcask_go
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDB_MultiGet(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, BPlusTree} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+			options.DataFileSize = 8 * 1024
+		})
+		// 写入顺序和读取顺序不同，value分布在多个数据文件中
+		for i := 499; i >= 0; i-- {
+			if err := db.Put(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.Put([]byte("empty"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete(testKey(7)); err != nil {
+			t.Fatal(err)
+		}
+		putExpired(t, db, []byte("expired"), []byte("value"))
+
+		keys := [][]byte{testKey(3), []byte("missing"), testKey(450), testKey(7), nil, []byte("empty"), []byte("expired"), testKey(3), testKey(0)}
+		values, errs := db.MultiGet(keys)
+		expectedErrs := []error{nil, ErrKeyNotFound, nil, ErrKeyNotFound, ErrKeyIsEmpty, nil, ErrKeyNotFound, nil, nil}
+		if fmt.Sprint(errs) != fmt.Sprint(expectedErrs) {
+			t.Fatalf("expected errors %v, got %v", expectedErrs, errs)
+		}
+		expected := map[string]string{
+			string(testKey(3)):   string(testValue(3)),
+			string(testKey(450)): string(testValue(450)),
+			string(testKey(0)):   string(testValue(0)),
+			"empty":              "",
+		}
+		if len(values) != len(expected) {
+			t.Fatalf("expected %d values, got %d", len(expected), len(values))
+		}
+		for key, value := range expected {
+			if got, ok := values[key]; !ok || string(got) != value {
+				t.Fatalf("value of %s = %q, %v", key, got, ok)
+			}
+		}
+		if values["empty"] == nil {
+			t.Fatal("expected non-nil empty value")
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		values, errs = db.MultiGet(keys[:2])
+		if len(values) != 0 || errs[0] != ErrDatabaseClosed || errs[1] != ErrDatabaseClosed {
+			t.Fatalf("MultiGet after close = %v, %v", values, errs)
+		}
+	}
+}
+
+func BenchmarkDB_MultiGet(b *testing.B) {
+	options := DefaultOptions
+	options.DirPath = b.TempDir()
+	db, err := Open(options)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 10000; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	keys := make([][]byte, 100)
+	for i := range keys {
+		keys[i] = testKey((i * 7919) % 10000)
+	}
+
+	b.Run("Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				if _, err := db.Get(key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("MultiGet", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db.MultiGet(keys)
+		}
+	})
+}
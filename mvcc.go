@@ -0,0 +1,382 @@
+package bitcask_go
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+
+	"bitcask-go/data"
+)
+
+// 历史版本文件中第一条记录的key，记录中保存事务序列号
+const versionHeaderKey = "versions"
+
+// key的某个历史版本
+type versionedPos struct {
+	seqNo uint64             // 写入时的事务序列号
+	pos   *data.LogRecordPos // 数据位置，为nil表示此版本中key已被删除
+}
+
+// 记录key的一个新版本（调用方需持有写锁）
+// oldPos 为写入前索引中的位置，key没有历史版本时作为基础版本，用于读取此次写入之前的值
+func (db *DB) addVersion(key []byte, seqNo uint64, oldPos, pos *data.LogRecordPos) {
+	if db.options.MaxVersionsPerKey <= 1 {
+		return
+	}
+
+	versions, ok := db.versions[string(key)]
+	if !ok {
+		versions = []*versionedPos{{seqNo: db.minVersionSeqNo, pos: oldPos}}
+	}
+	versions = append(versions, &versionedPos{seqNo: seqNo, pos: pos})
+	if len(versions) > db.options.MaxVersionsPerKey {
+		// 拷贝到新的切片，避免旧版本一直被底层数组引用
+		versions = append([]*versionedPos(nil), versions[len(versions)-db.options.MaxVersionsPerKey:]...)
+	}
+	db.versions[string(key)] = versions
+
+	// 每经过一个保留窗口清理一次所有key，没有再被修改的key也能及时释放
+	db.versionWrites++
+	if db.versionWrites >= db.options.VersionRetention {
+		db.versionWrites = 0
+		db.trimVersions()
+	}
+}
+
+// 记录非事务写入的新版本，为此次写入分配新的事务序列号并返回（调用方需持有写锁）
+// 未开启多版本时不分配序列号，返回 nonTransactionSeqNo
+func (db *DB) addNonTxnVersion(key []byte, oldPos, pos *data.LogRecordPos) uint64 {
+	if db.options.MaxVersionsPerKey <= 1 {
+		return nonTransactionSeqNo
+	}
+	db.seqNo++
+	db.addVersion(key, db.seqNo, oldPos, pos)
+	return db.seqNo
+}
+
+// 可以读取历史版本的最小事务序列号：打开数据库之前的版本没有记录，只保留最近 VersionRetention 个序列号内的版本
+func (db *DB) versionFloor() uint64 {
+	floor := db.minVersionSeqNo
+	if db.seqNo > db.options.VersionRetention && db.seqNo-db.options.VersionRetention > floor {
+		floor = db.seqNo - db.options.VersionRetention
+	}
+	return floor
+}
+
+// 丢弃在保留窗口之前就已被覆盖的版本，只剩下最新版本的key和索引中的数据一致，直接删除（调用方需持有写锁）
+func (db *DB) trimVersions() {
+	floor := db.versionFloor()
+	for key, versions := range db.versions {
+		// 版本i在 [versions[i].seqNo, versions[i+1].seqNo) 范围内可见
+		i := 0
+		for i+1 < len(versions) && versions[i+1].seqNo <= floor {
+			i++
+		}
+		if i == len(versions)-1 {
+			delete(db.versions, key)
+		} else if i > 0 {
+			db.versions[key] = append([]*versionedPos(nil), versions[i:]...)
+		}
+	}
+}
+
+// 删除位于指定数据文件中的历史版本（数据文件被删除后这些版本已无法读取）
+// 比被删除版本更早的版本也一并丢弃，避免读取时越过无法读取的版本返回更早的值
+func (db *DB) dropVersionsInFiles(fileIds map[uint32]struct{}) {
+	if len(fileIds) == 0 {
+		return
+	}
+	for key, versions := range db.versions {
+		dropped := -1
+		for i, v := range versions {
+			if v.pos == nil {
+				continue
+			}
+			if _, ok := fileIds[v.pos.Fid]; ok {
+				dropped = i
+			}
+		}
+		if dropped < 0 {
+			continue
+		}
+		if dropped == len(versions)-1 {
+			delete(db.versions, key)
+		} else {
+			db.versions[key] = append([]*versionedPos(nil), versions[dropped+1:]...)
+		}
+	}
+}
+
+// 获取当前最新的事务序列号，可用于之后通过 GetAtSeqNo 读取此时刻的数据
+func (db *DB) SeqNo() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.seqNo
+}
+
+// 读取key在指定事务序列号时的值
+// 开启多版本后每次写入（非事务写入或一次事务提交）都会分配新的事务序列号
+// 只能读取打开数据库之后、最近 VersionRetention 个序列号内的数据，每个key最多保留 MaxVersionsPerKey 个版本，
+// 超出范围时返回 ErrVersionNotFound
+func (db *DB) GetAtSeqNo(key []byte, seqNo uint64) ([]byte, error) {
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if db.options.MaxVersionsPerKey <= 1 {
+		return nil, ErrMultiVersionDisabled
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pos, err := db.posAtSeqNo(key, seqNo)
+	if err != nil {
+		return nil, err
+	}
+	return db.getValueByPosition(pos)
+}
+
+// 获取key在指定事务序列号时的数据位置，此版本中key不存在时返回 ErrKeyNotFound（调用方需持有读锁）
+func (db *DB) posAtSeqNo(key []byte, seqNo uint64) (*data.LogRecordPos, error) {
+	if seqNo < db.versionFloor() {
+		return nil, ErrVersionNotFound
+	}
+
+	// 没有历史版本的key在保留窗口内没有被修改过，直接返回当前的位置
+	versions, ok := db.versions[string(key)]
+	if !ok {
+		pos := db.index.Get(key)
+		if pos == nil {
+			return nil, ErrKeyNotFound
+		}
+		return pos, nil
+	}
+
+	// 从最新的版本开始，找到第一个不晚于seqNo的版本
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].seqNo > seqNo {
+			continue
+		}
+		if versions[i].pos == nil {
+			return nil, ErrKeyNotFound
+		}
+		return versions[i].pos, nil
+	}
+	return nil, ErrVersionNotFound
+}
+
+// 读取key的第n个最近的版本，n为0时为当前的值，n为1时为上一次写入之前的值，以此类推
+// 最多可以读取 MaxVersionsPerKey-1 个历史版本，
+// 版本不存在或已被清理时返回 ErrVersionNotFound，该版本中key已被删除或还未写入时返回 ErrKeyNotFound
+func (db *DB) GetVersion(key []byte, n int) ([]byte, error) {
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if db.options.MaxVersionsPerKey <= 1 {
+		return nil, ErrMultiVersionDisabled
+	}
+	if n < 0 {
+		return nil, ErrVersionNotFound
+	}
+	if n == 0 {
+		return db.Get(key)
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 最后一个版本是当前的值
+	versions, ok := db.versions[string(key)]
+	if !ok || n >= len(versions) {
+		return nil, ErrVersionNotFound
+	}
+	version := versions[len(versions)-1-n]
+	if version.pos == nil {
+		return nil, ErrKeyNotFound
+	}
+	return db.getValueByPosition(version.pos)
+}
+
+// 关闭数据库时将历史版本保存到文件中，下次打开时继续使用（调用方需持有写锁）
+// 第一条记录保存事务序列号，之后每条记录保存一个key的所有版本
+func (db *DB) saveVersions() error {
+	fileName := filepath.Join(db.options.DirPath, data.VersionFileName)
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if db.options.MaxVersionsPerKey <= 1 || len(db.versions) == 0 {
+		return nil
+	}
+
+	versionFile, err := data.OpenVersionFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer versionFile.Close()
+
+	header := binary.AppendUvarint(nil, db.seqNo)
+	header = binary.AppendUvarint(header, db.minVersionSeqNo)
+	header = binary.AppendUvarint(header, db.versionWrites)
+	records := [][]byte{encodeVersionRecord([]byte(versionHeaderKey), header)}
+	for key, versions := range db.versions {
+		records = append(records, encodeVersionRecord([]byte(key), encodeVersions(versions)))
+	}
+	for _, record := range records {
+		if err := versionFile.Write(record); err != nil {
+			return err
+		}
+	}
+	return versionFile.Sync()
+}
+
+// 加载上次关闭时保存的历史版本，加载后删除文件，避免异常退出后重新打开时使用过期的版本
+// 指向已被merge重写的数据文件的版本无法再读取，连同更早的版本一起丢弃
+func (db *DB) loadVersions() error {
+	fileName := filepath.Join(db.options.DirPath, data.VersionFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	if db.options.MaxVersionsPerKey > 1 {
+		if err := db.readVersionFile(); err != nil {
+			return err
+		}
+	}
+	if db.options.ReadOnly {
+		return nil
+	}
+	return os.Remove(fileName)
+}
+
+func (db *DB) readVersionFile() error {
+	versionFile, err := data.OpenVersionFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer versionFile.Close()
+
+	header, size, err := versionFile.ReadLogRecord(0)
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	seqNo, n := binary.Uvarint(header.Value)
+	minVersionSeqNo, m := binary.Uvarint(header.Value[n:])
+	versionWrites, _ := binary.Uvarint(header.Value[n+m:])
+
+	var dropped bool
+	offset := size
+	for {
+		logRecord, size, err := versionFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		offset += size
+
+		versions, err := decodeVersions(logRecord.Value)
+		if err != nil {
+			return err
+		}
+		// 从最新的版本向前查找第一个无法读取的版本
+		valid := len(versions)
+		for i := len(versions) - 1; i >= 0; i-- {
+			if pos := versions[i].pos; pos != nil && !db.versionPosReadable(pos) {
+				break
+			}
+			valid = i
+		}
+		if valid > 0 {
+			dropped = true
+		}
+		if len(versions)-valid > db.options.MaxVersionsPerKey {
+			valid = len(versions) - db.options.MaxVersionsPerKey
+		}
+		if valid < len(versions)-1 {
+			db.versions[string(logRecord.Key)] = versions[valid:]
+		}
+	}
+
+	if seqNo > db.seqNo {
+		db.seqNo = seqNo
+	}
+	db.versionWrites = versionWrites
+	// 丢弃了版本时，更早的序列号已无法完整读取，从当前序列号开始
+	if dropped {
+		db.minVersionSeqNo = db.seqNo
+	} else {
+		db.minVersionSeqNo = minVersionSeqNo
+	}
+	return nil
+}
+
+// 版本所在的数据文件是否仍然存在，并且没有在本次打开时被merge重写
+func (db *DB) versionPosReadable(pos *data.LogRecordPos) bool {
+	if pos.Fid < db.mergedFileId {
+		return false
+	}
+	if db.activeFile != nil && db.activeFile.FileId == pos.Fid {
+		return true
+	}
+	_, ok := db.olderFiles[pos.Fid]
+	return ok
+}
+
+func encodeVersionRecord(key, value []byte) []byte {
+	record, _ := data.EncodeLogRecord(&data.LogRecord{Key: key, Value: value})
+	return record
+}
+
+// 编码一个key的所有版本：版本数量，以及每个版本的序列号和位置（位置长度为0表示此版本中key已被删除）
+func encodeVersions(versions []*versionedPos) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(versions)))
+	for _, v := range versions {
+		buf = binary.AppendUvarint(buf, v.seqNo)
+		if v.pos == nil {
+			buf = binary.AppendUvarint(buf, 0)
+			continue
+		}
+		pos := data.EncodeLogRecordPos(v.pos)
+		buf = binary.AppendUvarint(buf, uint64(len(pos)))
+		buf = append(buf, pos...)
+	}
+	return buf
+}
+
+func decodeVersions(buf []byte) ([]*versionedPos, error) {
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	buf = buf[n:]
+	versions := make([]*versionedPos, 0, count)
+	for i := uint64(0); i < count; i++ {
+		seqNo, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		buf = buf[n:]
+		size, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < size {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		buf = buf[n:]
+		v := &versionedPos{seqNo: seqNo}
+		if size > 0 {
+			v.pos = data.DecodeLogRecordPos(buf[:size])
+		}
+		buf = buf[size:]
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
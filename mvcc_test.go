@@ -0,0 +1,321 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"bitcask-go/data"
+)
+
+func TestDB_GetAtSeqNo(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.MaxVersionsPerKey = 10
+	})
+	key := []byte("a")
+
+	before := db.SeqNo()
+	if err := db.Put(key, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	s1 := db.SeqNo()
+	if err := db.Put(key, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	s2 := db.SeqNo()
+	if err := db.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	s3 := db.SeqNo()
+
+	// 事务写入也是一个版本
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	if err := wb.Put(key, []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	s4 := db.SeqNo()
+
+	if !(before < s1 && s1 < s2 && s2 < s3 && s3 < s4) {
+		t.Fatalf("seqNo not increasing: %d %d %d %d %d", before, s1, s2, s3, s4)
+	}
+	if _, err := db.GetAtSeqNo(key, before); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound before first put, got %v", err)
+	}
+	for seqNo, expected := range map[uint64]string{s1: "1", s2: "2", s4: "3"} {
+		value, err := db.GetAtSeqNo(key, seqNo)
+		if err != nil || string(value) != expected {
+			t.Fatalf("GetAtSeqNo(%d) = %s, %v, want %s", seqNo, value, err, expected)
+		}
+	}
+	if _, err := db.GetAtSeqNo(key, s3); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound after delete, got %v", err)
+	}
+}
+
+// 打开数据库之前写入的key没有历史版本，第一次修改后仍然可以读取修改之前的值
+func TestDB_GetAtSeqNoBaseVersion(t *testing.T) {
+	db := openTestDB(t, nil)
+	key := []byte("a")
+	if err := db.Put(key, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	db.options.MaxVersionsPerKey = 10
+	db = reopenTestDB(t, db)
+
+	snapshot := db.SeqNo()
+	if err := db.Put(key, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	value, err := db.GetAtSeqNo(key, snapshot)
+	if err != nil || string(value) != "old" {
+		t.Fatalf("GetAtSeqNo = %s, %v, want old", value, err)
+	}
+	if snapshot > 0 {
+		if _, err := db.GetAtSeqNo(key, snapshot-1); err != ErrVersionNotFound {
+			t.Fatalf("expected ErrVersionNotFound, got %v", err)
+		}
+	}
+}
+
+// 保留窗口之外的版本会被清理，不再被修改的key也会从版本集合中删除
+func TestDB_VersionRetention(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.MaxVersionsPerKey = 10
+		options.VersionRetention = 100
+	})
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.mu.RLock()
+	remaining := len(db.versions)
+	db.mu.RUnlock()
+	if remaining > 2*int(db.options.VersionRetention) {
+		t.Fatalf("expected at most %d keys with versions, got %d", 2*db.options.VersionRetention, remaining)
+	}
+
+	seqNo := db.SeqNo()
+	if _, err := db.GetAtSeqNo(testKey(0), seqNo-db.options.VersionRetention-1); err != ErrVersionNotFound {
+		t.Fatalf("expected ErrVersionNotFound outside the retention, got %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		value, err := db.GetAtSeqNo(testKey(i), seqNo)
+		if err != nil || !bytes.Equal(value, testValue(i)) {
+			t.Fatalf("GetAtSeqNo(%s) = %s, %v", testKey(i), value, err)
+		}
+	}
+}
+
+// 并发写入同一个key时，版本的顺序和索引一致，最新版本就是索引中的值
+func TestDB_GetAtSeqNoConcurrentPut(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.MaxVersionsPerKey = 1000
+	})
+	key := []byte("a")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if err := db.Put(key, testValue(g*100+i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	current, err := db.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := db.GetAtSeqNo(key, db.SeqNo())
+	if err != nil || !bytes.Equal(value, current) {
+		t.Fatalf("GetAtSeqNo = %s, %v, want %s", value, err, current)
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	versions := db.versions[string(key)]
+	for i := 1; i < len(versions); i++ {
+		if versions[i].seqNo <= versions[i-1].seqNo {
+			t.Fatalf("versions out of order at %d", i)
+		}
+	}
+}
+
+func TestDB_GetVersion(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.MaxVersionsPerKey = 3
+	})
+	key := []byte("a")
+	for _, value := range []string{"1", "2", "3", "4"} {
+		if err := db.Put(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 最多保留3个版本，包括当前版本
+	for n, expected := range []string{"4", "3", "2"} {
+		value, err := db.GetVersion(key, n)
+		if err != nil || string(value) != expected {
+			t.Fatalf("GetVersion(%d) = %s, %v, want %s", n, value, err, expected)
+		}
+	}
+	for _, n := range []int{3, 10, -1} {
+		if _, err := db.GetVersion(key, n); err != ErrVersionNotFound {
+			t.Fatalf("GetVersion(%d): expected ErrVersionNotFound, got %v", n, err)
+		}
+	}
+
+	// 删除也是一个版本
+	if err := db.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetVersion(key, 0); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if value, err := db.GetVersion(key, 1); err != nil || string(value) != "4" {
+		t.Fatalf("GetVersion(1) = %s, %v", value, err)
+	}
+
+	// 第一次写入之前key不存在
+	if err := db.Put([]byte("b"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetVersion([]byte("b"), 1); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := db.GetVersion([]byte("c"), 1); err != ErrVersionNotFound {
+		t.Fatalf("expected ErrVersionNotFound, got %v", err)
+	}
+}
+
+func TestDB_GetVersionDisabled(t *testing.T) {
+	// 默认值1只保留当前版本，和0一样不开启多版本，非事务写入不分配序列号
+	for _, maxVersions := range []int{DefaultOptions.MaxVersionsPerKey, 0} {
+		db := openTestDB(t, func(options *Options) {
+			options.MaxVersionsPerKey = maxVersions
+		})
+		if err := db.Put([]byte("a"), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.GetVersion([]byte("a"), 1); err != ErrMultiVersionDisabled {
+			t.Fatalf("MaxVersionsPerKey=%d: expected ErrMultiVersionDisabled, got %v", maxVersions, err)
+		}
+		if _, err := db.GetAtSeqNo([]byte("a"), 0); err != ErrMultiVersionDisabled {
+			t.Fatalf("MaxVersionsPerKey=%d: expected ErrMultiVersionDisabled, got %v", maxVersions, err)
+		}
+		if seqNo := db.SeqNo(); seqNo != 0 {
+			t.Fatalf("MaxVersionsPerKey=%d: expected seq no 0, got %d", maxVersions, seqNo)
+		}
+	}
+
+	// 大于1时开启多版本，为2时保留一个历史版本
+	db := openTestDB(t, func(options *Options) {
+		options.MaxVersionsPerKey = 2
+	})
+	for _, value := range []string{"1", "2", "3"} {
+		if err := db.Put([]byte("a"), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, err := db.GetVersion([]byte("a"), 1); err != nil || string(value) != "2" {
+		t.Fatalf("GetVersion(1) = %s, %v", value, err)
+	}
+	if _, err := db.GetVersion([]byte("a"), 2); err != ErrVersionNotFound {
+		t.Fatalf("expected ErrVersionNotFound, got %v", err)
+	}
+}
+
+// 历史版本在关闭时保存，重新打开后仍然可以读取
+func TestDB_VersionsSurviveRestart(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.MaxVersionsPerKey = 5
+	})
+	key := []byte("a")
+	if err := db.Put(key, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	s1 := db.SeqNo()
+	if err := db.Put(key, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	s2 := db.SeqNo()
+
+	db = reopenTestDB(t, db)
+	if db.SeqNo() != s2 {
+		t.Fatalf("expected seqNo %d after reopen, got %d", s2, db.SeqNo())
+	}
+	if value, err := db.GetVersion(key, 1); err != nil || string(value) != "1" {
+		t.Fatalf("GetVersion(1) = %s, %v", value, err)
+	}
+	if value, err := db.GetAtSeqNo(key, s1); err != nil || string(value) != "1" {
+		t.Fatalf("GetAtSeqNo(%d) = %s, %v", s1, value, err)
+	}
+
+	// 重新打开后继续写入，新的版本接在之前的版本之后
+	if err := db.Put(key, []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if db.SeqNo() <= s2 {
+		t.Fatalf("seqNo did not advance: %d", db.SeqNo())
+	}
+	for n, expected := range []string{"3", "2", "1"} {
+		if value, err := db.GetVersion(key, n); err != nil || string(value) != expected {
+			t.Fatalf("GetVersion(%d) = %s, %v, want %s", n, value, err, expected)
+		}
+	}
+
+	// 版本文件加载后被删除，异常退出后不会读到过期的版本
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.VersionFileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected version file removed after open, got %v", err)
+	}
+}
+
+// merge生效后，位于被重写的数据文件中的版本被丢弃，之后写入的版本仍然保留
+func TestDB_VersionsAfterMerge(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.MaxVersionsPerKey = 5
+		options.DataFileSize = 4 * 1024
+	})
+	for i := 0; i < 200; i++ {
+		if err := db.Put(testKey(i%10), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	// merge之后写入的版本在未参与merge的文件中
+	for i := 0; i < 3; i++ {
+		if err := db.Put([]byte("after"), []byte{byte('a' + i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db = reopenTestDB(t, db)
+	for i := 0; i < 10; i++ {
+		if value, err := db.GetVersion(testKey(i), 0); err != nil || !bytes.Equal(value, testValue(190+i)) {
+			t.Fatalf("GetVersion(%s, 0) = %s, %v", testKey(i), value, err)
+		}
+		if _, err := db.GetVersion(testKey(i), 1); err != ErrVersionNotFound {
+			t.Fatalf("expected ErrVersionNotFound for merged versions, got %v", err)
+		}
+	}
+	for n, expected := range []string{"c", "b", "a"} {
+		if value, err := db.GetVersion([]byte("after"), n); err != nil || string(value) != expected {
+			t.Fatalf("GetVersion(after, %d) = %s, %v, want %s", n, value, err, expected)
+		}
+	}
+}
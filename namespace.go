@@ -0,0 +1,91 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// 命名空间，同一个数据库实例中不同命名空间的key相互隔离
+// 写入时在key前加上命名空间前缀（2字节的名称长度 + 名称），返回给调用方的key会去掉前缀
+type Namespace struct {
+	db     *DB
+	name   string
+	prefix []byte
+}
+
+// 获取指定名称的命名空间，名称长度不能超过 65535 字节，否则返回 ErrNamespaceNameTooLong
+func (db *DB) Namespace(name string) (*Namespace, error) {
+	if len(name) > math.MaxUint16 {
+		return nil, ErrNamespaceNameTooLong
+	}
+	prefix := make([]byte, 2+len(name))
+	binary.BigEndian.PutUint16(prefix, uint16(len(name)))
+	copy(prefix[2:], name)
+	return &Namespace{db: db, name: name, prefix: prefix}, nil
+}
+
+// 命名空间的名称
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// 加上命名空间前缀
+func (ns *Namespace) key(key []byte) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+	nsKey := make([]byte, len(ns.prefix)+len(key))
+	copy(nsKey, ns.prefix)
+	copy(nsKey[len(ns.prefix):], key)
+	return nsKey
+}
+
+// 写入命名空间中的key
+func (ns *Namespace) Put(key []byte, value []byte) error {
+	return ns.db.Put(ns.key(key), value)
+}
+
+// 读取命名空间中的key
+func (ns *Namespace) Get(key []byte) ([]byte, error) {
+	return ns.db.Get(ns.key(key))
+}
+
+// 删除命名空间中的key，不影响其他命名空间中的同名key
+func (ns *Namespace) Delete(key []byte) error {
+	return ns.db.Delete(ns.key(key))
+}
+
+// 判断命名空间中的key是否存在
+func (ns *Namespace) Exists(key []byte) (bool, error) {
+	return ns.db.Exists(ns.key(key))
+}
+
+// 获取命名空间中所有的key（去掉前缀）
+func (ns *Namespace) ListKeys() [][]byte {
+	iterator := ns.db.NewIterator(DefaultIteratorOptions)
+	defer iterator.Close()
+
+	var keys [][]byte
+	for iterator.SeekPrefix(ns.prefix); iterator.Valid() && bytes.HasPrefix(iterator.Key(), ns.prefix); iterator.Next() {
+		keys = append(keys, iterator.Key()[len(ns.prefix):])
+	}
+	return keys
+}
+
+// 按key从小到大遍历命名空间中的数据，fn返回false时停止遍历
+func (ns *Namespace) Fold(fn func(key []byte, value []byte) bool) error {
+	iterator := ns.db.NewIterator(DefaultIteratorOptions)
+	defer iterator.Close()
+
+	for iterator.SeekPrefix(ns.prefix); iterator.Valid() && bytes.HasPrefix(iterator.Key(), ns.prefix); iterator.Next() {
+		value, err := iterator.Value()
+		if err != nil {
+			return err
+		}
+		if !fn(iterator.Key()[len(ns.prefix):], value) {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// 获取命名空间，出错时终止测试
+func openTestNamespace(t *testing.T, db *DB, name string) *Namespace {
+	t.Helper()
+	ns, err := db.Namespace(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ns
+}
+
+func TestNamespace_Isolation(t *testing.T) {
+	db := openTestDB(t, nil)
+	users := openTestNamespace(t, db, "users")
+	orders := openTestNamespace(t, db, "orders")
+
+	// 同名key在不同命名空间中相互独立
+	if err := users.Put([]byte("1"), []byte("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.Put([]byte("1"), []byte("order-1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("1"), []byte("plain")); err != nil {
+		t.Fatal(err)
+	}
+	for ns, expected := range map[*Namespace]string{users: "alice", orders: "order-1"} {
+		if value, err := ns.Get([]byte("1")); err != nil || string(value) != expected {
+			t.Fatalf("%s: Get = %q, %v", ns.Name(), value, err)
+		}
+	}
+	if value, err := db.Get([]byte("1")); err != nil || string(value) != "plain" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	// 只删除本命名空间中的key
+	if err := users.Delete([]byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Get([]byte("1")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if ok, err := orders.Exists([]byte("1")); err != nil || !ok {
+		t.Fatalf("Exists = %v, %v", ok, err)
+	}
+	if _, err := db.Get([]byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := users.Put(nil, []byte("v")); err != ErrKeyIsEmpty {
+		t.Fatalf("expected ErrKeyIsEmpty, got %v", err)
+	}
+}
+
+func TestNamespace_ListKeysAndFold(t *testing.T) {
+	db := openTestDB(t, nil)
+	// 名称互为前缀的命名空间也不会看到对方的key
+	a := openTestNamespace(t, db, "a")
+	ab := openTestNamespace(t, db, "ab")
+	for i := 0; i < 5; i++ {
+		if err := a.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ab.Put(testKey(i+100), testValue(i+100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put([]byte("zzz"), []byte("plain")); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := a.ListKeys()
+	if len(keys) != 5 {
+		t.Fatalf("expected 5 keys, got %d", len(keys))
+	}
+	for i, key := range keys {
+		if !bytes.Equal(key, testKey(i)) {
+			t.Fatalf("key %d: expected %s, got %s", i, testKey(i), key)
+		}
+	}
+
+	var visited int
+	err := ab.Fold(func(key []byte, value []byte) bool {
+		if !bytes.Equal(key, testKey(visited+100)) || !bytes.Equal(value, testValue(visited+100)) {
+			t.Fatalf("unexpected pair %s=%s", key, value)
+		}
+		visited++
+		return visited < 3
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visited != 3 {
+		t.Fatalf("expected fold to stop after 3 keys, got %d", visited)
+	}
+
+	if keys := openTestNamespace(t, db, "empty").ListKeys(); len(keys) != 0 {
+		t.Fatalf("expected no keys, got %q", keys)
+	}
+}
+
+func TestNamespace_NameTooLong(t *testing.T) {
+	db := openTestDB(t, nil)
+	if ns, err := db.Namespace(strings.Repeat("n", 65536)); err != ErrNamespaceNameTooLong || ns != nil {
+		t.Fatalf("expected ErrNamespaceNameTooLong, got %v, %v", ns, err)
+	}
+	ns := openTestNamespace(t, db, strings.Repeat("n", 65535))
+	if err := ns.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := ns.Get([]byte("k")); err != nil || string(value) != "v" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+}
@@ -1,16 +1,72 @@
 package bitcask_go
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
 // 配置项结构体（封装需要用户自定义的参数）
 type Options struct {
-	DirPath            string    // 数据库数据文件目录名
-	DataFileSize       int64     // 数据文件的大小（阈值）
-	SyncWrites         bool      // 每次写数据是否持久化
-	BytesPerSync       uint      // 自动持久化的阈值（写入数据大于此阈值则持久化）
-	IndexType          IndexType // 索引类型
-	MMapAtStartup      bool      // 启动时是否使用 MMap 加载数据
-	DataFileMergeRatio float32   // 数据文件merge合并的阈值（无效数据/总数据），超过此阈值才会merge
+	DirPath            string             // 数据库数据文件目录名
+	DataFileSize       int64              // 数据文件的大小（阈值）
+	SyncWrites         bool               // 每次写数据是否持久化
+	BytesPerSync       uint               // 自动持久化的阈值（写入数据大于此阈值则持久化）
+	IndexType          IndexType          // 索引类型
+	BTreeDegree        int                // BTree索引的阶数，使用BTree索引时必须大于等于2，默认32
+	MMapAtStartup      bool               // 启动时是否使用 MMap 加载数据
+	MMapActiveFile     bool               // 新建的活跃文件是否使用可写的 MMap 写入（仅支持 Linux/macOS，不支持B+树索引）
+	IOUringActiveFile  bool               // 新建的活跃文件是否使用 io_uring 写入（仅支持 Linux，其他平台或者内核不支持时使用标准文件IO），写入等待完成后返回，并发的写入可以同时提交；不能和 MMapActiveFile 同时开启
+	DataFileMergeRatio float32            // 数据文件merge合并的阈值（无效数据/总数据），超过此阈值才会merge
+	IndexBuildWorkers  int                // 启动时从hint文件构建索引的并发数，小于等于1时顺序加载
+	MergeConcurrency   int                // merge时并发读取数据文件的数量（每个文件的有效记录暂存在内存中），小于等于1时逐个文件顺序处理
+	GroupCommit        bool               // 是否开启组提交，将并发的Put合并为一次写入和持久化
+	ChecksumMode       ChecksumMode       // 数据文件的校验方式，打开已有数据库时必须与写入时一致
+	ChecksumAlgorithm  ChecksumAlgorithm  // 日志记录crc的算法（仅PerRecord方式），记录中带有算法标记，切换之后已有的数据仍然可以校验
+	MaxVersionsPerKey  int                // 每个key保留的版本数，包括当前版本（用于GetVersion和GetAtSeqNo，关闭数据库时保存到文件），大于1时开启多版本，默认为1表示不保留历史版本
+	VersionRetention   uint64             // 开启多版本时，保留最近多少个事务序列号内的历史版本，更早的版本会被清理
+	ReadOnly           bool               // 是否以只读模式打开，只读模式不获取文件锁、不修改数据目录，可以在其他实例运行时查看数据
+	SkipReadCRC        bool               // Get和迭代器读取数据时是否跳过日志记录的crc校验（写入时仍然计算），加载索引、merge和迁移时始终校验
+	Logger             Logger             // 引擎内部事件的日志，为nil时不输出
+	WriteRateLimit     float64            // 写入磁盘的速度限制（MB/s，普通写入和merge共用），为0表示不限速
+	StripedLockCount   int                // Put和Delete使用的分段锁数量（例如256），不同分段的写入可以并发，为0表示使用全局锁，不支持多版本
+	AuditLog           bool               // 是否将每次写入和删除记录到审计日志文件（audit.log），审计日志只追加写入，不参与merge，写入失败时只记录错误日志
+	MergeUpgradeFormat bool               // merge时是否将重写的记录统一编码为最新的Header格式（LatestLogRecordFormat，仅PerRecord方式），之后的写入仍使用ChecksumAlgorithm
+	CompactionStrategy CompactionStrategy // Merge清理无效数据的策略，默认CompactAll合并所有数据文件
+	LockTimeout        time.Duration      // 文件锁被其他进程持有时，Open按退避间隔重试获取的最长时间，为0表示立即返回 ErrDatabaseIsUsing
+	InMemory           bool               // 是否只在内存中保存数据（用于测试和临时缓存），不创建数据目录和任何文件，关闭后数据丢失；不支持merge、vacuum和备份
+	StatsInterval      time.Duration      // 后台采样统计信息（Stat）的间隔，保留最近的快照供 StatsHistory 查询，为0表示不采样
+	EnableLRUTracking  bool               // Get 和 Put 成功时是否记录key的访问时间（和 Touch 相同，只保存在内存中），供 EvictLRU 淘汰最久未访问的key
+	AdminAddr          string             // 管理接口（AdminHandler）的HTTP监听地址，例如 "127.0.0.1:6380"，Open 时启动、Close 时关闭，为空表示不启动
+
+	ValueLogSeparationThreshold int64   // 键值分离的阈值，value大于等于此值时写入单独的value log文件，为0表示不分离
+	ValueLogMergeRatio          float32 // merge时value log中无效数据的比例达到此阈值才重写value log，否则只重写数据文件、保留原有的指针；为0表示每次merge都重写
+	BlockCacheSize              int64   // 数据文件读取的块缓存大小，字节为单位，为0表示不使用缓存
+	DataFilePreAllocSize        int64   // 新建活跃文件时预分配的磁盘空间大小（仅支持 Linux/macOS），为0表示不预分配
+	SyncDirOnRollover           bool    // 新建数据文件、value log文件以及打开时移入merge结果之后，是否持久化数据目录（Windows上忽略）
+	Manifest                    bool    // 是否在数据目录中维护数据文件清单（manifest.json），打开时发现数据文件被删除、替换或截断时返回 ErrManifestMismatch
+	MaxDiskSize                 int64   // 数据目录大小的上限，字节为单位，写入之后会超过上限时 Put、Update、BulkLoad 和 WriteBatch.Commit 返回 ErrDiskQuotaExceeded（删除不受限制）；为0表示不限制，内存模式下忽略
+	CompactOnDiskQuota          bool    // 超过 MaxDiskSize 时是否先调用一次 Compact 回收无效数据再重新检查（Update 和 BulkLoad 持有写锁，不会尝试），不支持B+树索引
+	HintChecksum                bool    // merge生成的hint文件末尾是否写入整个文件的校验和，打开时校验，缺少校验和或者校验失败时不使用hint文件，从所有数据文件重新加载索引
+
+	// 写入时value的压缩算法，压缩之后没有变小的value不压缩；记录中带有压缩标记，修改之后已有的数据仍然可以读取
+	Compression CompressionType
+
+	// value加密（AES-GCM）的密钥，长度必须为16、24或32字节，为空表示不加密
+	// 压缩在加密之前进行；读取加密的记录时必须配置写入时的密钥，没有配置时返回 ErrUnsupportedRecordFlags，密钥不一致时返回 ErrDecryptionFailed
+	EncryptionKey []byte
+
+	// 自定义数据文件的路径（例如加上分片id），为nil时使用默认的 %09d.data
+	// 相同的 (dirPath, fileId) 必须始终返回相同的路径；文件必须直接位于dirPath目录下，文件名只由fileId决定，并以十进制包含fileId（打开时根据文件名中的数字查找数据文件）
+	// 打开已有数据库时必须与写入时一致，value log等其他文件的命名不受影响
+	DataFileNamer func(dirPath string, fileId uint32) string
+
+	// 数据库事件的回调（写入、删除、merge和关闭），操作成功并释放锁之后同步调用
+	Hooks Hooks
+
+	// 写入前的校验回调（例如限制value大小、禁止某些key前缀），为nil时不校验
+	// Put、PutWithTTL、Delete、Update、BulkLoad 以及 WriteBatch.Commit 在写入文件之前对每个key调用，返回错误时不写入并将此错误返回给调用方
+	// 删除时value为nil、isDelete为true；Update 和 BulkLoad 在持有写锁时调用，因此回调中不能读写数据库
+	BeforeWrite func(key, value []byte, isDelete bool) error
 }
 
 // 索引迭代器配置项（供用户调用）
@@ -19,6 +75,10 @@ type IteratorOptions struct {
 	Prefix []byte
 	// 是否反向遍历，默认false是正向
 	Reverse bool
+	// ForEach每遍历多少个key释放并重新获取一次读锁，避免长时间阻塞写入，为0时使用默认值
+	ForEachBatchSize int
+	// 读取value时检测到同一个数据文件中的偏移连续递增（例如按写入顺序批量导入的数据）时，提示操作系统预读之后的数据，默认false
+	SequentialReadHint bool
 }
 
 // 批量写配置
@@ -26,6 +86,12 @@ type WriteBatchOptions struct {
 	// 一个批次当中的最大数据量
 	MaxBatchNum uint
 
+	// 是否检测和其他未提交的批次暂存了相同的key，检测到时 Commit 返回 ErrPotentialDeadlock
+	DetectConflicts bool
+
+	// 提交的超时时间，超时前没有写入事务完成标识时 Commit 返回 ErrCommitTimeout，已写入的记录不会生效；为0表示不超时
+	CommitTimeout time.Duration
+
 	// 提交时是否sync持久化
 	syncWrites bool
 }
@@ -41,6 +107,63 @@ const (
 
 	// BPlusTree B+树索引，将索引存储在磁盘上
 	BPlusTree
+
+	// PersistentART 持久化的ART索引，关闭时将索引保存到 art-index 文件中，下次打开时文件有效则不需要重放数据文件
+	PersistentART
+)
+
+type ChecksumMode = byte
+
+const (
+	// PerRecord 每条日志记录单独计算crc
+	PerRecord ChecksumMode = iota
+
+	// PerBlock 数据文件按4KB分块，每个块末尾带有块中数据的crc，日志记录不再单独计算crc
+	PerBlock
+)
+
+type ChecksumAlgorithm = byte
+
+const (
+	// CRC32IEEE 使用IEEE多项式计算crc
+	CRC32IEEE ChecksumAlgorithm = iota
+
+	// CRC32C 使用Castagnoli多项式计算crc，支持SSE4.2或ARMv8 CRC指令的CPU上计算更快
+	CRC32C
+)
+
+type CompactionStrategy = byte
+
+const (
+	// CompactAll 将所有旧的数据文件合并到新的文件中，并生成hint文件
+	CompactAll CompactionStrategy = iota
+
+	// SizeTiered 按大小将旧的数据文件分层，只原地回收无效数据比例最高的一层，不改动其他文件（不支持B+树索引，B+树索引仍然合并所有文件）
+	SizeTiered
+)
+
+type CompressionType = byte
+
+const (
+	// NoCompression 不压缩
+	NoCompression CompressionType = iota
+
+	// Flate 使用 compress/flate（BestSpeed）压缩value
+	Flate
+)
+
+// 日志记录Header的格式版本（仅PerRecord方式），merge完成的标识中记录重写的数据文件使用的格式
+type LogRecordFormat = byte
+
+const (
+	// LogRecordFormatLegacy 最初的格式：IEEE crc，type字节中没有标记
+	LogRecordFormatLegacy LogRecordFormat = iota + 1
+
+	// LogRecordFormatV2 type字节的最高位标记crc算法，使用CRC32C
+	LogRecordFormatV2
+
+	// LatestLogRecordFormat 最新的格式版本
+	LatestLogRecordFormat = LogRecordFormatV2
 )
 
 // 默认配置
@@ -50,13 +173,38 @@ var DefaultOptions = Options{
 	SyncWrites:         false,
 	BytesPerSync:       0,
 	IndexType:          Btree,
+	BTreeDegree:        32,
 	MMapAtStartup:      true,
+	MMapActiveFile:     false,
+	IOUringActiveFile:  false,
 	DataFileMergeRatio: 0.5,
+	IndexBuildWorkers:  1,
+	MergeConcurrency:   1,
+	GroupCommit:        false,
+	ChecksumMode:       PerRecord,
+	ChecksumAlgorithm:  CRC32IEEE,
+	MaxVersionsPerKey:  1,
+	VersionRetention:   100000,
+	ReadOnly:           false,
+	SkipReadCRC:        false,
+	Logger:             nil,
+	WriteRateLimit:     0,
+	StripedLockCount:   0,
+	AuditLog:           false,
+	MergeUpgradeFormat: false,
+	CompactionStrategy: CompactAll,
+	Compression:        NoCompression,
+
+	ValueLogSeparationThreshold: 0,
+	BlockCacheSize:              0,
+	DataFilePreAllocSize:        0,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
-	nil,
-	false,
+	Prefix:             nil,
+	Reverse:            false,
+	ForEachBatchSize:   1000,
+	SequentialReadHint: false,
 }
 
 var DefaultWriteBatchOptions = WriteBatchOptions{
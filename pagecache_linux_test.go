@@ -0,0 +1,29 @@
+//go:build linux
+
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// 将目录中文件的页缓存写回并丢弃，模拟冷缓存
+func evictPageCache(tb testing.TB, dirPath string) {
+	tb.Helper()
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, entry := range entries {
+		file, err := os.Open(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		_ = unix.Fdatasync(int(file.Fd()))
+		_ = unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_DONTNEED)
+		_ = file.Close()
+	}
+}
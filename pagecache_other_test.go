@@ -0,0 +1,8 @@
+//go:build !linux
+
+package bitcask_go
+
+import "testing"
+
+// 当前平台无法丢弃页缓存，基准测试在热缓存下运行
+func evictPageCache(tb testing.TB, dirPath string) {}
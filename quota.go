@@ -0,0 +1,84 @@
+package bitcask_go
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"bitcask-go/utils"
+)
+
+// 估算写入量时每条记录额外计入的大小：记录Header和key中编码的序列号的保守估计，估计偏大时只会提前重新统计目录大小
+const diskQuotaRecordOverhead = 32
+
+// 数据目录大小的限额（配置了 MaxDiskSize 时使用）
+// 打开时统计一次数据目录的大小，之后每次写入只累加估算的写入量，估算值超过上限时才重新遍历目录统计，
+// merge、Compact 等操作删除的文件在重新统计时计入
+type diskQuota struct {
+	dirPath string
+	maxSize int64
+	used    atomic.Int64 // 最近一次统计的目录大小加上之后估算的写入量
+	mu      sync.Mutex   // 保证同一时刻只有一个协程重新统计目录大小
+}
+
+func newDiskQuota(dirPath string, maxSize int64) (*diskQuota, error) {
+	size, err := utils.DirSize(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	q := &diskQuota{dirPath: dirPath, maxSize: maxSize}
+	q.used.Store(size)
+	return q, nil
+}
+
+// 预留size字节的写入量，写入之后目录大小会超过上限时返回 ErrDiskQuotaExceeded
+func (q *diskQuota) reserve(size int64) error {
+	if q.used.Add(size) <= q.maxSize {
+		return nil
+	}
+
+	// 估算值超过上限时重新统计实际的目录大小
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dirSize, err := utils.DirSize(q.dirPath)
+	if err != nil {
+		q.used.Add(-size)
+		return err
+	}
+	if dirSize+size > q.maxSize {
+		q.used.Store(dirSize)
+		return ErrDiskQuotaExceeded
+	}
+	q.used.Store(dirSize + size)
+	return nil
+}
+
+// 估算一条记录写入数据文件的大小
+func quotaRecordSize(key, value []byte) int64 {
+	return int64(len(key) + len(value) + diskQuotaRecordOverhead)
+}
+
+// 写入之前检查数据目录大小的限额（不持有锁时调用），未配置 MaxDiskSize 时直接返回
+// 超过限额并且开启了 CompactOnDiskQuota 时，先调用一次 Compact 回收无效数据再重新检查
+func (db *DB) checkDiskQuota(size int64) error {
+	if db.diskQuota == nil || size == 0 {
+		return nil
+	}
+	err := db.diskQuota.reserve(size)
+	if err != ErrDiskQuotaExceeded || !db.options.CompactOnDiskQuota {
+		return err
+	}
+	// 其他协程正在回收时不等待，直接返回超过限额
+	if _, compactErr := db.Compact(); compactErr != nil {
+		db.options.Logger.Warnf("failed to compact after exceeding disk quota: %v", compactErr)
+		return err
+	}
+	return db.diskQuota.reserve(size)
+}
+
+// 持有写锁时检查数据目录大小的限额，不会尝试 Compact
+func (db *DB) checkDiskQuotaLocked(size int64) error {
+	if db.diskQuota == nil || size == 0 {
+		return nil
+	}
+	return db.diskQuota.reserve(size)
+}
@@ -0,0 +1,125 @@
+package bitcask_go
+
+import (
+	"testing"
+
+	"bitcask-go/utils"
+)
+
+// 反复覆盖写入同一批key，直到超过数据目录大小的限额
+func fillDiskQuota(t *testing.T, db *DB) int {
+	t.Helper()
+	for i := 0; i < 100000; i++ {
+		err := db.Put(testKey(i%100), testValue(i))
+		if err == ErrDiskQuotaExceeded {
+			return i
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Fatal("disk quota is never exceeded")
+	return 0
+}
+
+func TestDB_MaxDiskSize(t *testing.T) {
+	const maxDiskSize = 64 * 1024
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+		options.CompactionStrategy = SizeTiered
+		options.MaxDiskSize = maxDiskSize
+	})
+	written := fillDiskQuota(t, db)
+	dirSize, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirSize > maxDiskSize {
+		t.Fatalf("data directory grew to %d bytes, quota %d", dirSize, maxDiskSize)
+	}
+
+	// 超过限额之后的写入都失败，删除不受限制
+	if err := db.Put(testKey(0), testValue(0)); err != ErrDiskQuotaExceeded {
+		t.Fatalf("expected ErrDiskQuotaExceeded, got %v", err)
+	}
+	if err := db.Update(testKey(0), func([]byte) ([]byte, error) { return testValue(0), nil }); err != ErrDiskQuotaExceeded {
+		t.Fatalf("expected ErrDiskQuotaExceeded, got %v", err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	if err := wb.Put(testKey(0), testValue(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Commit(); err != ErrDiskQuotaExceeded {
+		t.Fatalf("expected ErrDiskQuotaExceeded, got %v", err)
+	}
+	if err := db.Rename(testKey(0), []byte("renamed")); err != ErrDiskQuotaExceeded {
+		t.Fatalf("expected ErrDiskQuotaExceeded, got %v", err)
+	}
+	if n, err := db.RenamePrefix(testKey(0), []byte("renamed")); err != ErrDiskQuotaExceeded || n != 0 {
+		t.Fatalf("expected ErrDiskQuotaExceeded, got %d, %v", n, err)
+	}
+	if err := db.Delete(testKey(99)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 关闭之后重新打开时重新统计，仍然超过限额
+	db = reopenTestDB(t, db)
+	if err := db.Put(testKey(0), testValue(0)); err != ErrDiskQuotaExceeded {
+		t.Fatalf("expected ErrDiskQuotaExceeded after reopen, got %v", err)
+	}
+
+	// merge回收无效数据之后可以继续写入
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(testKey(0), testValue(written)); err != nil {
+		t.Fatal(err)
+	}
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	if err := wb.Put(testKey(1), testValue(written)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expectKeyCount(t, db, 99)
+}
+
+// 超过限额时自动 Compact，只有有效数据超过限额时才拒绝写入
+func TestDB_CompactOnDiskQuota(t *testing.T) {
+	const maxDiskSize = 64 * 1024
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+		options.MaxDiskSize = maxDiskSize
+		options.CompactOnDiskQuota = true
+	})
+	for i := 0; i < 10000; i++ {
+		if err := db.Put(testKey(i%100), testValue(i)); err != nil {
+			t.Fatalf("put %d: %v", i, err)
+		}
+	}
+	expectKeyCount(t, db, 100)
+
+	// 有效数据超过限额时 Compact 也无法回收，写入失败且不生效
+	var err error
+	i := 100
+	for ; err == nil; i++ {
+		err = db.Put(testKey(i), testValue(i))
+	}
+	if err != ErrDiskQuotaExceeded {
+		t.Fatalf("expected ErrDiskQuotaExceeded, got %v", err)
+	}
+	if _, err := db.Get(testKey(i - 1)); err != ErrKeyNotFound {
+		t.Fatalf("expected rejected key to be missing, got %v", err)
+	}
+	expectKeyCount(t, db, i-1)
+
+	options := DefaultOptions
+	options.DirPath = t.TempDir()
+	options.IndexType = BPlusTree
+	options.MaxDiskSize = maxDiskSize
+	options.CompactOnDiskQuota = true
+	if _, err := Open(options); err == nil {
+		t.Fatal("expected b+ tree index to reject CompactOnDiskQuota")
+	}
+}
@@ -0,0 +1,104 @@
+package bitcask_go
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	bytesPerMB           = 1024 * 1024
+	writeRateWindow      = time.Second // 统计写入速度的时间窗口
+	minWriteLimiterBurst = 64 * 1024   // 令牌桶的最小容量，字节
+)
+
+// 写入限速：使用令牌桶限制写入磁盘的速度（普通写入和merge共用），同时统计最近的写入速度
+type writeLimiter struct {
+	limiter *rate.Limiter // 为nil表示不限速
+
+	mu          sync.Mutex
+	windowStart time.Time // 当前统计窗口的开始时间
+	windowBytes int64     // 当前统计窗口内写入的数据量
+	lastRate    float64   // 上一个统计窗口的写入速度，MB/s
+}
+
+// 初始化写入限速，mbPerSec 为0时不限速，只统计写入速度
+func newWriteLimiter(mbPerSec float64) *writeLimiter {
+	wl := &writeLimiter{windowStart: time.Now()}
+	if mbPerSec > 0 {
+		bytesPerSec := mbPerSec * bytesPerMB
+		// 令牌桶容量为 100ms 的写入量，避免空闲之后的突发写入远超限制
+		burst := int(bytesPerSec / 10)
+		if burst < minWriteLimiterBurst {
+			burst = minWriteLimiterBurst
+		}
+		wl.limiter = rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+	}
+	return wl
+}
+
+// 写入 n 个字节之前调用，等待获取到足够的令牌（不能在持有 db.mu 时调用，避免阻塞其他读写）
+func (wl *writeLimiter) wait(n int) error {
+	wl.record(int64(n))
+	if wl.limiter == nil {
+		return nil
+	}
+	// 超过令牌桶容量的写入分多次等待
+	burst := wl.limiter.Burst()
+	for n > 0 {
+		size := n
+		if size > burst {
+			size = burst
+		}
+		if err := wl.limiter.WaitN(context.Background(), size); err != nil {
+			return err
+		}
+		n -= size
+	}
+	return nil
+}
+
+// 统计写入的数据量
+func (wl *writeLimiter) record(n int64) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	wl.roll(time.Now())
+	wl.windowBytes += n
+}
+
+// 当前的写入速度，MB/s
+func (wl *writeLimiter) rate() float64 {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	now := time.Now()
+	wl.roll(now)
+	// 当前窗口刚开始时数据太少，使用上一个窗口的速度
+	elapsed := now.Sub(wl.windowStart)
+	if elapsed < writeRateWindow/10 {
+		return wl.lastRate
+	}
+	return float64(wl.windowBytes) / bytesPerMB / elapsed.Seconds()
+}
+
+// 当前窗口结束时开始新的窗口（调用方需持有锁）
+func (wl *writeLimiter) roll(now time.Time) {
+	elapsed := now.Sub(wl.windowStart)
+	if elapsed < writeRateWindow {
+		return
+	}
+	wl.lastRate = float64(wl.windowBytes) / bytesPerMB / elapsed.Seconds()
+	// 超过两个窗口没有写入时，上一个窗口的速度已经过期
+	if elapsed >= 2*writeRateWindow {
+		wl.lastRate = 0
+	}
+	wl.windowStart = now
+	wl.windowBytes = 0
+}
+
+// 持有写锁之后才知道写入量的操作（Update、Rename、淘汰等）不等待写入限速，写入的数据量只计入统计：
+// 持有写锁时等待会阻塞其他所有读写；获取锁之前就知道写入量的操作应该在获取锁之前调用 wait
+func (db *DB) recordLockedWrite(n int) {
+	db.writeLimiter.record(int64(n))
+}
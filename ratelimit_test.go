@@ -0,0 +1,90 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDB_WriteRateLimit(t *testing.T) {
+	const limit = 2.0 // MB/s
+	db := openTestDB(t, func(options *Options) {
+		options.WriteRateLimit = limit
+	})
+
+	value := bytes.Repeat([]byte("v"), 4096)
+	var written int
+	start := time.Now()
+	for i := 0; written < bytesPerMB; i++ {
+		if err := db.Put(testKey(i), value); err != nil {
+			t.Fatal(err)
+		}
+		written += len(testKey(i)) + len(value)
+	}
+	elapsed := time.Since(start)
+
+	// 除去令牌桶初始的容量，写入速度不超过限制
+	burst := db.writeLimiter.limiter.Burst()
+	allowed := limit*bytesPerMB*elapsed.Seconds() + float64(burst)
+	if float64(written) > allowed*1.05 {
+		t.Fatalf("wrote %d bytes in %v, exceeds %.1f MB/s", written, elapsed, limit)
+	}
+
+	rate := db.Stat().CurrentWriteRateMBs
+	if rate <= 0 || rate > limit*1.5 {
+		t.Fatalf("unexpected current write rate %.2f MB/s", rate)
+	}
+}
+
+// 单次写入超过令牌桶容量时分多次等待，不会返回错误
+func TestDB_WriteRateLimitLargeValue(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.WriteRateLimit = 1
+	})
+	value := bytes.Repeat([]byte("v"), 2*db.writeLimiter.limiter.Burst())
+	if err := db.Put([]byte("large"), value); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.Get([]byte("large"))
+	if err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("Get = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestOpen_RejectsNegativeWriteRateLimit(t *testing.T) {
+	options := DefaultOptions
+	options.DirPath = t.TempDir()
+	options.WriteRateLimit = -1
+	if _, err := Open(options); err == nil {
+		t.Fatal("expected error for negative write rate limit")
+	}
+}
+
+// 写入前知道数据量的写入路径同样等待写入限速：超过令牌桶容量的部分至少需要等待相应的时间
+func TestDB_WriteRateLimitWaits(t *testing.T) {
+	for name, write := range map[string]func(db *DB, value []byte) error{
+		"PutIfAbsent": func(db *DB, value []byte) error {
+			_, err := db.PutIfAbsent([]byte("key"), value)
+			return err
+		},
+		"BulkLoad": func(db *DB, value []byte) error {
+			return db.BulkLoad(func(yield func([]byte, []byte) bool) {
+				yield([]byte("key"), value)
+			})
+		},
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.WriteRateLimit = 1
+		})
+		burst := db.writeLimiter.limiter.Burst()
+		value := bytes.Repeat([]byte("v"), 2*burst)
+		start := time.Now()
+		if err := write(db, value); err != nil {
+			t.Fatal(err)
+		}
+		minimum := time.Duration(float64(len(value)-burst) / bytesPerMB * float64(time.Second))
+		if elapsed := time.Since(start); elapsed < minimum*8/10 {
+			t.Fatalf("%s: wrote %d bytes in %v, expected at least %v", name, len(value), elapsed, minimum)
+		}
+	}
+}
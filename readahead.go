@@ -0,0 +1,46 @@
+package bitcask_go
+
+import "bitcask-go/data"
+
+const (
+	// 每次预读的数据量
+	readAheadWindow = 1024 * 1024
+	// 同一个文件中的偏移连续递增多少次之后认为是顺序读取
+	readAheadTrigger = 2
+)
+
+// 迭代器读取value时的预读状态
+// 按key顺序遍历时，如果数据按key的顺序写入，value在数据文件中也是顺序的，此时每次读取都是随机IO，
+// 检测到顺序读取后提前让操作系统读入之后的数据，后续的读取可以直接命中页缓存
+type readAhead struct {
+	fid        uint32
+	lastOffset int64
+	sequential int   // 偏移连续递增的次数
+	prefetched int64 // 已经预读到的位置
+}
+
+func newReadAhead() *readAhead {
+	return &readAhead{lastOffset: -1}
+}
+
+// 记录一次读取，检测到顺序读取并且读取位置接近已经预读的末尾时，预读下一段数据
+func (ra *readAhead) observe(dataFile *data.DataFile, offset int64) {
+	if dataFile.FileId != ra.fid || offset <= ra.lastOffset {
+		ra.fid = dataFile.FileId
+		ra.sequential = 0
+		ra.prefetched = 0
+	} else {
+		ra.sequential++
+	}
+	ra.lastOffset = offset
+
+	if ra.sequential < readAheadTrigger || offset+readAheadWindow/2 < ra.prefetched {
+		return
+	}
+	start := offset
+	if ra.prefetched > start {
+		start = ra.prefetched
+	}
+	dataFile.Prefetch(start, readAheadWindow)
+	ra.prefetched = start + readAheadWindow
+}
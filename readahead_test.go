@@ -0,0 +1,147 @@
+package bitcask_go
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// 记录预读请求的文件IO
+type prefetchRecorder struct {
+	fio.IOManager
+	ranges [][2]int64
+}
+
+func (r *prefetchRecorder) Prefetch(offset, length int64) {
+	r.ranges = append(r.ranges, [2]int64{offset, length})
+}
+
+func TestReadAhead_Observe(t *testing.T) {
+	recorder := &prefetchRecorder{}
+	dataFile := &data.DataFile{FileId: 1, IOManager: recorder}
+	other := &data.DataFile{FileId: 2, IOManager: recorder}
+	ra := newReadAhead()
+
+	// 连续递增的读取达到阈值后开始预读
+	for _, offset := range []int64{0, 100, 200} {
+		ra.observe(dataFile, offset)
+	}
+	if len(recorder.ranges) != 1 || recorder.ranges[0] != [2]int64{200, readAheadWindow} {
+		t.Fatalf("unexpected prefetch %v", recorder.ranges)
+	}
+	// 已经预读的范围内不重复预读，接近末尾时预读下一段
+	ra.observe(dataFile, 300)
+	ra.observe(dataFile, 200+readAheadWindow/2)
+	if len(recorder.ranges) != 2 || recorder.ranges[1] != [2]int64{200 + readAheadWindow, readAheadWindow} {
+		t.Fatalf("unexpected prefetch %v", recorder.ranges)
+	}
+
+	// 换了文件或偏移回退时重新检测
+	recorder.ranges = nil
+	ra.observe(other, 1000)
+	ra.observe(other, 500)
+	ra.observe(other, 600)
+	if len(recorder.ranges) != 0 {
+		t.Fatalf("unexpected prefetch %v", recorder.ranges)
+	}
+	ra.observe(other, 700)
+	if len(recorder.ranges) != 1 || recorder.ranges[0][0] != 700 {
+		t.Fatalf("unexpected prefetch %v", recorder.ranges)
+	}
+}
+
+// 开启预读提示后遍历的结果不变
+func TestIterator_SequentialReadHint(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.ChecksumMode = PerBlock },
+		func(options *Options) { options.BlockCacheSize = 64 * 1024 },
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 64 * 1024
+			if configure != nil {
+				configure(options)
+			}
+		})
+		for i := 0; i < 2000; i++ {
+			if err := db.Put(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db = reopenTestDB(t, db)
+
+		opts := DefaultIteratorOptions
+		opts.SequentialReadHint = true
+		iterator := db.NewIterator(opts)
+		var i int
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			value, err := iterator.Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(value) != string(testValue(i)) {
+				t.Fatalf("key %s: got %q", iterator.Key(), value)
+			}
+			i++
+		}
+		iterator.Close()
+		if i != 2000 {
+			t.Fatalf("expected 2000 keys, got %d", i)
+		}
+
+		var count int
+		if err := db.ForEachWithOptions(context.Background(), opts, func(key []byte, value []byte) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if count != 2000 {
+			t.Fatalf("expected 2000 keys, got %d", count)
+		}
+	}
+}
+
+// 冷缓存下按写入顺序遍历，对比开启和关闭预读提示的吞吐
+func BenchmarkIterator_SequentialScan(b *testing.B) {
+	options := DefaultOptions
+	options.DirPath = b.TempDir()
+	options.DataFileSize = 64 * 1024 * 1024
+	options.MMapAtStartup = false
+	db, err := Open(options)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	value := make([]byte, 1024)
+	const keys = 50000
+	for i := 0; i < keys; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%08d", i)), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := db.Sync(); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, hint := range []bool{false, true} {
+		b.Run(fmt.Sprintf("hint=%v", hint), func(b *testing.B) {
+			opts := DefaultIteratorOptions
+			opts.SequentialReadHint = hint
+			b.SetBytes(keys * int64(len(value)))
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				evictPageCache(b, options.DirPath)
+				b.StartTimer()
+				if err := db.ForEachWithOptions(context.Background(), opts, func(key []byte, value []byte) error {
+					return nil
+				}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -3,7 +3,12 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/tidwall/redcon"
 
@@ -19,39 +24,151 @@ func newWrongNumberOfArgsError(cmd string) error {
 type cmdHandler func(cli *BitcaskClient, args [][]byte) (interface{}, error)
 
 var supportedCommands = map[string]cmdHandler{
-	"set":   set,
-	"get":   get,
-	"hset":  hset,
-	"sadd":  sadd,
-	"lpush": lpush,
-	"zadd":  zadd,
+	"set":     set,
+	"get":     get,
+	"hset":    hset,
+	"hsetnx":  hsetnx,
+	"hmset":   hmset,
+	"hmget":   hmget,
+	"hget":    hget,
+	"hgetall": hgetall,
+	"sadd":    sadd,
+	"lpush":   lpush,
+	"zadd":    zadd,
+	"zscore":  zscore,
+	"hello":   hello,
+	"auth":    auth,
+	"publish": publish,
+	"object":  object,
+	"config":  config,
+	"client":  client,
+	"slowlog": slowlog,
 }
 
 type BitcaskClient struct {
-	server *BitcaskServer
-	db     *bitcask_redis.RedisDataStructure
+	server   *BitcaskServer
+	db       *bitcask_redis.RedisDataStructure
+	dbIndex  int  // 当前使用的数据库编号
+	protocol int  // 连接协商的 RESP 协议版本（2 或 3）
+	authed   bool // 是否已通过密码认证（服务端未配置密码时始终为 true）
+	detached bool // 是否已订阅并被发布订阅服务接管
+
+	id          uint64      // 连接的id，由服务端按连接的顺序分配
+	conn        redcon.Conn // CLIENT KILL 时用于断开连接
+	connectedAt time.Time
+
+	mu          sync.Mutex // 保护命令统计，CLIENT LIST 会在其他连接中读取
+	commands    int64      // 已执行的命令数量
+	lastCommand string     // 最近执行的命令
 }
 
-func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
-	command := strings.ToLower(string(cmd.Args[0]))
-	cmdFunc, ok := supportedCommands[command]
-	if !ok {
-		conn.WriteError("Err unsupported command: '" + command + "' ")
+// 键值对形式的回复，RESP3 下编码为 map 类型，RESP2 下编码为扁平数组
+type mapReply struct {
+	protocol int
+	pairs    []interface{} // key 和 value 交替存放，保证输出顺序
+}
+
+func (m mapReply) MarshalRESP() []byte {
+	var b []byte
+	n := len(m.pairs) / 2
+	if m.protocol == 3 {
+		b = append(b, '%')
+		b = strconv.AppendInt(b, int64(n), 10)
+		b = append(b, '\r', '\n')
+	} else {
+		b = redcon.AppendArray(b, n*2)
+	}
+	for _, v := range m.pairs {
+		b = redcon.AppendAny(b, v)
+	}
+	return b
+}
+
+// 浮点数回复，RESP3 下编码为 double 类型，RESP2 下和 Redis 一样编码为字符串
+type doubleReply struct {
+	protocol int
+	value    float64
+}
+
+func (d doubleReply) MarshalRESP() []byte {
+	var s string
+	switch {
+	case math.IsInf(d.value, 1):
+		s = "inf"
+	case math.IsInf(d.value, -1):
+		s = "-inf"
+	default:
+		s = strconv.FormatFloat(d.value, 'g', -1, 64)
+	}
+	if d.protocol == 3 {
+		return append([]byte{','}, s+"\r\n"...)
+	}
+	return redcon.AppendBulkString(nil, s)
+}
+
+// RESP3 下回复 null 类型，RESP2 下回复空的 bulk string
+func writeNull(conn redcon.Conn, protocol int) {
+	if protocol == 3 {
+		conn.WriteRaw([]byte("_\r\n"))
 		return
 	}
+	conn.WriteNull()
+}
+
+func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
+	command := strings.ToLower(string(cmd.Args[0]))
 
 	// 从上下文中获取出client
 	client, _ := conn.Context().(*BitcaskClient)
+	client.recordCommand(command)
+	// 未通过认证时，只允许执行 AUTH 和 HELLO 命令
+	if !client.authed && command != "auth" && command != "hello" {
+		conn.WriteError("NOAUTH Authentication required.")
+		return
+	}
+
 	switch command {
 	case "quit":
 		_ = conn.Close()
 	case "ping":
 		conn.WriteString("PONG")
+	case "subscribe", "psubscribe":
+		// 订阅后连接会被发布订阅服务接管，后续的 UNSUBSCRIBE 等命令以及断开连接时的清理都由其处理
+		if len(cmd.Args) < 2 {
+			conn.WriteError(newWrongNumberOfArgsError(command).Error())
+			return
+		}
+		client.detached = true
+		client.server.pubsub.subscribe(conn, client.protocol, command == "psubscribe", cmd.Args[1:])
+	case "unsubscribe", "punsubscribe":
+		// 未订阅任何频道的连接，按 Redis 的格式回复订阅数量为 0
+		channels := cmd.Args[1:]
+		if len(channels) == 0 {
+			channels = [][]byte{nil}
+		}
+		for _, channel := range channels {
+			writePushHeader(conn, client.protocol, 3)
+			conn.WriteBulkString(command)
+			if channel == nil {
+				conn.WriteNull()
+			} else {
+				conn.WriteBulk(channel)
+			}
+			conn.WriteInt(0)
+		}
 	default:
+		cmdFunc, ok := supportedCommands[command]
+		if !ok {
+			conn.WriteError("Err unsupported command: '" + command + "' ")
+			return
+		}
+
+		start := time.Now()
 		res, err := cmdFunc(client, cmd.Args[1:])
+		client.server.slowlog.record(start, time.Since(start), cmd.Args, conn.RemoteAddr())
 		if err != nil {
 			if errors.Is(err, bitcask.ErrKeyNotFound) {
-				conn.WriteNull()
+				writeNull(conn, client.protocol)
 			} else {
 				conn.WriteError(err.Error())
 			}
@@ -105,6 +222,82 @@ func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	return redcon.SimpleInt(ok), nil
 }
 
+func hsetnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("hsetnx")
+	}
+
+	var ok = 0
+	res, err := cli.db.HSetNX(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func hmset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, newWrongNumberOfArgsError("hmset")
+	}
+
+	if err := cli.db.HMSet(args[0], args[1:]...); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func hmget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("hmget")
+	}
+
+	values, err := cli.db.HMGet(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 不存在的field回复为nil
+	res := make([]interface{}, len(values))
+	for i, value := range values {
+		if value != nil {
+			res[i] = value
+		}
+	}
+	return res, nil
+}
+
+func hget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("hget")
+	}
+
+	value, err := cli.db.HGet(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// RESP3 下回复 map 类型，RESP2 下回复 field 和 value 交替的数组
+func hgetall(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("hgetall")
+	}
+
+	fieldValues, err := cli.db.HGetAll(args[0])
+	if err != nil {
+		return nil, err
+	}
+	pairs := make([]interface{}, len(fieldValues))
+	for i, v := range fieldValues {
+		pairs[i] = v
+	}
+	return mapReply{protocol: cli.protocol, pairs: pairs}, nil
+}
+
 func sadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 2 {
 		return nil, newWrongNumberOfArgsError("sadd")
@@ -156,3 +349,230 @@ func zadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 
 	return redcon.SimpleInt(ok), nil
 }
+
+// RESP3 下回复 double 类型，key 或 member 不存在时回复 null
+func zscore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("zscore")
+	}
+
+	score, err := cli.db.ZScore(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return doubleReply{protocol: cli.protocol, value: score}, nil
+}
+
+func hello(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	protocol := cli.protocol
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return nil, errors.New("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != 2 && ver != 3 {
+			return nil, errors.New("NOPROTO unsupported protocol version")
+		}
+		protocol = ver
+
+		// 解析可选参数
+		for i := 1; i < len(args); i++ {
+			switch strings.ToLower(string(args[i])) {
+			case "auth":
+				if i+2 >= len(args) {
+					return nil, newWrongNumberOfArgsError("hello")
+				}
+				// 未配置密码时忽略 AUTH 参数
+				if cli.server.options.RequirePass != "" {
+					if err := cli.checkPassword(args[i+2]); err != nil {
+						return nil, err
+					}
+				}
+				i += 2
+			case "setname":
+				if i+1 >= len(args) {
+					return nil, newWrongNumberOfArgsError("hello")
+				}
+				i++
+			default:
+				return nil, fmt.Errorf("ERR syntax error in HELLO option '%s'", args[i])
+			}
+		}
+	}
+	if !cli.authed {
+		return nil, errors.New("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	cli.protocol = protocol
+
+	return mapReply{
+		protocol: protocol,
+		pairs: []interface{}{
+			"server", "bitcask-go",
+			"version", serverVersion,
+			"proto", redcon.SimpleInt(protocol),
+			"mode", "standalone",
+			"role", "master",
+			"modules", []interface{}{},
+		},
+	}, nil
+}
+
+func auth(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	// 支持 AUTH <password> 和 AUTH <username> <password> 两种形式，用户名不做校验
+	if len(args) != 1 && len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("auth")
+	}
+	if cli.server.options.RequirePass == "" {
+		return nil, errors.New("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+
+	if err := cli.checkPassword(args[len(args)-1]); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+// 记录连接执行的命令
+func (cli *BitcaskClient) recordCommand(command string) {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	cli.commands++
+	cli.lastCommand = command
+}
+
+// CLIENT LIST 中一个连接的信息
+func (cli *BitcaskClient) info(now time.Time) string {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	return fmt.Sprintf("id=%d addr=%s db=%d age=%d cmd=%s tot-cmds=%d\n",
+		cli.id, cli.conn.RemoteAddr(), cli.dbIndex, int64(now.Sub(cli.connectedAt).Seconds()), cli.lastCommand, cli.commands)
+}
+
+// 校验密码，校验通过后标记连接为已认证
+func (cli *BitcaskClient) checkPassword(password []byte) error {
+	if string(password) != cli.server.options.RequirePass {
+		return errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	cli.authed = true
+	return nil
+}
+
+func publish(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("publish")
+	}
+
+	// 消息不做持久化，只发送给当前在线的订阅者，返回接收到消息的订阅者数量
+	channel, message := args[0], args[1]
+	return redcon.SimpleInt(cli.server.pubsub.publish(channel, message)), nil
+}
+
+func object(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("object")
+	}
+
+	switch strings.ToLower(string(args[0])) {
+	case "encoding":
+		if len(args) != 2 {
+			return nil, newWrongNumberOfArgsError("object|encoding")
+		}
+		// 没有Redis的内部编码，返回key在磁盘上占用的字节数
+		size, err := cli.db.SizeOf(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return strconv.FormatInt(size, 10), nil
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%s'. Try OBJECT HELP.", args[0])
+	}
+}
+
+// CONFIG 支持的配置项名称
+const configStatsInterval = "stats-interval"
+
+// CONFIG GET/SET，目前只支持 stats-interval（存储引擎统计采样的间隔，毫秒，为0表示不采样）
+func config(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("config")
+	}
+
+	switch strings.ToLower(string(args[0])) {
+	case "get":
+		if len(args) < 2 {
+			return nil, newWrongNumberOfArgsError("config|get")
+		}
+		// 和 Redis 一样按glob模式匹配配置项名称，可以同时查询多个模式
+		var pairs []interface{}
+		for _, pattern := range args[1:] {
+			if matched, _ := path.Match(strings.ToLower(string(pattern)), configStatsInterval); matched {
+				pairs = append(pairs, configStatsInterval, strconv.FormatInt(cli.db.StatsInterval().Milliseconds(), 10))
+				break
+			}
+		}
+		return mapReply{protocol: cli.protocol, pairs: pairs}, nil
+	case "set":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return nil, newWrongNumberOfArgsError("config|set")
+		}
+		// 先校验所有配置项，全部合法时才修改
+		var interval time.Duration
+		for i := 1; i < len(args); i += 2 {
+			name := strings.ToLower(string(args[i]))
+			if name != configStatsInterval {
+				return nil, fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i])
+			}
+			ms, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || ms < 0 {
+				return nil, fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - argument must be a non-negative integer", args[i])
+			}
+			interval = time.Duration(ms) * time.Millisecond
+		}
+		if err := cli.db.SetStatsInterval(interval); err != nil {
+			return nil, err
+		}
+		return redcon.SimpleString("OK"), nil
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[0])
+	}
+}
+
+// CLIENT ID/LIST/KILL，KILL 只支持 CLIENT KILL ID <id> 的形式
+func client(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("client")
+	}
+
+	switch strings.ToLower(string(args[0])) {
+	case "id":
+		return redcon.SimpleInt(cli.id), nil
+	case "list":
+		if len(args) != 1 {
+			return nil, newWrongNumberOfArgsError("client|list")
+		}
+		// 每行一个连接，按id排序
+		now := time.Now()
+		var b strings.Builder
+		for _, c := range cli.server.listClients() {
+			b.WriteString(c.info(now))
+		}
+		return b.String(), nil
+	case "kill":
+		if len(args) != 3 {
+			return nil, newWrongNumberOfArgsError("client|kill")
+		}
+		if strings.ToLower(string(args[1])) != "id" {
+			return nil, fmt.Errorf("ERR syntax error in CLIENT KILL filter '%s'", args[1])
+		}
+		id, err := strconv.ParseUint(string(args[2]), 10, 64)
+		if err != nil {
+			return nil, errors.New("ERR client-id should be greater than 0")
+		}
+		if !cli.server.killClient(id) {
+			return nil, errors.New("ERR No such client")
+		}
+		return redcon.SimpleString("OK"), nil
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%s'. Try CLIENT HELP.", args[0])
+	}
+}
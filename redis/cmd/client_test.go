@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 将 HELLO 回复中的键值对转换为 map
+func helloFields(t *testing.T, reply interface{}, kind byte) map[string]interface{} {
+	t.Helper()
+	agg, ok := reply.(respAggregate)
+	if !ok || agg.kind != kind || len(agg.items)%2 != 0 {
+		t.Fatalf("HELLO reply = %#v, want type %q", reply, kind)
+	}
+	fields := make(map[string]interface{})
+	for i := 0; i < len(agg.items); i += 2 {
+		fields[agg.items[i].(string)] = agg.items[i+1]
+	}
+	return fields
+}
+
+func TestHello_RESP2(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{}))
+	fields := helloFields(t, conn.do("HELLO", "2"), '*')
+	if fields["proto"] != int64(2) || fields["server"] != "bitcask-go" {
+		t.Fatalf("HELLO 2 fields = %v", fields)
+	}
+	// 不带参数时返回当前协议版本
+	fields = helloFields(t, conn.do("HELLO"), '*')
+	if fields["proto"] != int64(2) {
+		t.Fatalf("HELLO fields = %v", fields)
+	}
+}
+
+func TestHello_RESP3(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{}))
+	fields := helloFields(t, conn.do("HELLO", "3"), '%')
+	if fields["proto"] != int64(3) || fields["mode"] != "standalone" {
+		t.Fatalf("HELLO 3 fields = %v", fields)
+	}
+	// 切换回 RESP2
+	fields = helloFields(t, conn.do("HELLO", "2"), '*')
+	if fields["proto"] != int64(2) {
+		t.Fatalf("HELLO 2 fields = %v", fields)
+	}
+}
+
+func TestHello_UnsupportedProtocol(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{}))
+	if reply, _ := conn.do("HELLO", "4").(string); !strings.HasPrefix(reply, "-NOPROTO") {
+		t.Fatalf("HELLO 4 = %v", reply)
+	}
+	if reply, _ := conn.do("HELLO", "x").(string); !strings.HasPrefix(reply, "-ERR") {
+		t.Fatalf("HELLO x = %v", reply)
+	}
+}
+
+func TestAuth_RejectsCommandsBeforeAuth(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{RequirePass: "secret"}))
+
+	// 认证前拒绝普通命令
+	for _, args := range [][]string{{"SET", "k", "v"}, {"GET", "k"}, {"HSET", "h", "f", "v"}} {
+		if reply, _ := conn.do(args...).(string); !strings.HasPrefix(reply, "-NOAUTH") {
+			t.Fatalf("%v before AUTH = %v", args, reply)
+		}
+	}
+	if reply, _ := conn.do("HELLO", "3").(string); !strings.HasPrefix(reply, "-NOAUTH") {
+		t.Fatalf("HELLO before AUTH = %v", reply)
+	}
+
+	// 密码错误
+	if reply, _ := conn.do("AUTH", "wrong").(string); !strings.HasPrefix(reply, "-WRONGPASS") {
+		t.Fatalf("AUTH wrong = %v", reply)
+	}
+	if reply, _ := conn.do("GET", "k").(string); !strings.HasPrefix(reply, "-NOAUTH") {
+		t.Fatalf("GET after wrong AUTH = %v", reply)
+	}
+
+	// 认证通过后可以正常执行命令
+	if reply := conn.do("AUTH", "secret"); reply != "+OK" {
+		t.Fatalf("AUTH = %v", reply)
+	}
+	if reply := conn.do("SET", "k", "v"); reply != "+OK" {
+		t.Fatalf("SET after AUTH = %v", reply)
+	}
+	if reply := conn.do("GET", "k"); reply != "v" {
+		t.Fatalf("GET after AUTH = %v", reply)
+	}
+}
+
+func TestAuth_HelloWithAuth(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{RequirePass: "secret"}))
+	if reply, _ := conn.do("HELLO", "3", "AUTH", "default", "wrong").(string); !strings.HasPrefix(reply, "-WRONGPASS") {
+		t.Fatalf("HELLO AUTH wrong = %v", reply)
+	}
+	fields := helloFields(t, conn.do("HELLO", "3", "AUTH", "default", "secret"), '%')
+	if fields["proto"] != int64(3) {
+		t.Fatalf("HELLO AUTH fields = %v", fields)
+	}
+	if reply := conn.do("SET", "k", "v"); reply != "+OK" {
+		t.Fatalf("SET after HELLO AUTH = %v", reply)
+	}
+}
+
+func TestAuth_WithoutPasswordConfigured(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{}))
+	if reply, _ := conn.do("AUTH", "secret").(string); !strings.HasPrefix(reply, "-ERR") {
+		t.Fatalf("AUTH without password = %v", reply)
+	}
+	if reply := conn.do("SET", "k", "v"); reply != "+OK" {
+		t.Fatalf("SET = %v", reply)
+	}
+}
+
+// 切换到 RESP3 之后，HGETALL 回复 map 类型，ZSCORE 回复 double 类型，不存在时回复 null
+func TestHello_RESP3TypedReplies(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{}))
+	for _, args := range [][]string{
+		{"HSET", "h", "f2", "v2"},
+		{"HSET", "h", "f1", "v1"},
+		{"ZADD", "z", "1.5", "m"},
+	} {
+		if reply := conn.do(args...); reply != int64(1) {
+			t.Fatalf("%v = %v", args, reply)
+		}
+	}
+
+	// RESP2 下为扁平数组和字符串
+	if agg, ok := conn.do("HGETALL", "h").(respAggregate); !ok || agg.kind != '*' || fmt.Sprint(agg.items) != "[f1 v1 f2 v2]" {
+		t.Fatalf("HGETALL in RESP2 = %#v", agg)
+	}
+	if reply := conn.do("ZSCORE", "z", "m"); reply != "1.5" {
+		t.Fatalf("ZSCORE in RESP2 = %#v", reply)
+	}
+
+	helloFields(t, conn.do("HELLO", "3"), '%')
+	if agg, ok := conn.do("HGETALL", "h").(respAggregate); !ok || agg.kind != '%' || fmt.Sprint(agg.items) != "[f1 v1 f2 v2]" {
+		t.Fatalf("HGETALL in RESP3 = %#v", agg)
+	}
+	if agg, ok := conn.do("HGETALL", "missing").(respAggregate); !ok || agg.kind != '%' || len(agg.items) != 0 {
+		t.Fatalf("HGETALL missing in RESP3 = %#v", agg)
+	}
+	if reply := conn.do("ZSCORE", "z", "m"); reply != 1.5 {
+		t.Fatalf("ZSCORE in RESP3 = %#v", reply)
+	}
+	if reply := conn.do("HGET", "h", "f1"); reply != "v1" {
+		t.Fatalf("HGET = %#v", reply)
+	}
+	for _, args := range [][]string{{"ZSCORE", "z", "missing"}, {"ZSCORE", "missing", "m"}, {"HGET", "h", "missing"}} {
+		if reply := conn.do(args...); reply != nil {
+			t.Fatalf("%v in RESP3 = %#v", args, reply)
+		}
+	}
+}
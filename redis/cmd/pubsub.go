@@ -0,0 +1,338 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/match"
+	"github.com/tidwall/redcon"
+)
+
+const (
+	// 每个订阅者等待发送的回复和消息数量上限，超过时断开订阅者（类似 Redis 的 client-output-buffer-limit pubsub），不阻塞发布者
+	pubSubQueueSize = 1024
+	// 向订阅者写入的超时时间，超时后断开订阅者
+	pubSubWriteTimeout = 10 * time.Second
+)
+
+// 发布订阅服务（消息只在内存中转发，不做持久化）
+// 连接订阅后从服务器中分离出来，由单独的协程读取后续命令；推送给订阅者的消息按连接协商的协议编码，
+// RESP2 下为普通数组，RESP3 下为 push 类型（'>'），客户端可以据此区分推送消息和命令回复
+// 发往订阅者的回复和消息先放入订阅者的队列，由订阅者自己的协程写入连接，持有 mu 时不会阻塞在网络写入上
+type pubSub struct {
+	mu          sync.RWMutex
+	channels    map[string]map[*subscriber]struct{} // 频道 -> 订阅者
+	patterns    map[string]map[*subscriber]struct{} // 模式 -> 订阅者
+	subscribers map[redcon.Conn]*subscriber
+	onClose     func(conn redcon.Conn) // 订阅中的连接断开之后调用，为nil时不调用
+}
+
+// 已订阅的连接，channels 和 patterns 由 pubSub.mu 保护
+type subscriber struct {
+	conn     redcon.Conn
+	dconn    redcon.DetachedConn
+	protocol int                 // 订阅时连接协商的 RESP 协议版本
+	channels map[string]struct{} // 订阅的频道
+	patterns map[string]struct{} // 订阅的模式
+
+	queue     chan []byte   // 等待写入连接的回复和消息
+	closing   chan struct{} // 连接断开或者被断开时关闭
+	closeOnce sync.Once
+	written   chan struct{} // 写入协程退出时关闭
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		channels:    make(map[string]map[*subscriber]struct{}),
+		patterns:    make(map[string]map[*subscriber]struct{}),
+		subscribers: make(map[redcon.Conn]*subscriber),
+	}
+}
+
+// 追加推送消息的头部，RESP3 下为 push 类型，RESP2 下为数组
+func appendPushHeader(b []byte, protocol int, n int) []byte {
+	if protocol == 3 {
+		b = append(b, '>')
+		b = strconv.AppendInt(b, int64(n), 10)
+		return append(b, '\r', '\n')
+	}
+	return redcon.AppendArray(b, n)
+}
+
+// 写入推送消息的头部
+func writePushHeader(conn interface{ WriteRaw([]byte) }, protocol int, n int) {
+	conn.WriteRaw(appendPushHeader(nil, protocol, n))
+}
+
+// 订阅频道或模式，首次订阅时将连接分离出来并启动后台协程
+func (ps *pubSub) subscribe(conn redcon.Conn, protocol int, pattern bool, channels [][]byte) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	sub, ok := ps.subscribers[conn]
+	if !ok {
+		sub = &subscriber{
+			conn:     conn,
+			dconn:    conn.Detach(),
+			protocol: protocol,
+			channels: make(map[string]struct{}),
+			patterns: make(map[string]struct{}),
+			queue:    make(chan []byte, pubSubQueueSize),
+			closing:  make(chan struct{}),
+			written:  make(chan struct{}),
+		}
+		ps.subscribers[conn] = sub
+	}
+
+	kind, subs, index := "subscribe", sub.channels, ps.channels
+	if pattern {
+		kind, subs, index = "psubscribe", sub.patterns, ps.patterns
+	}
+	var reply []byte
+	for _, channel := range channels {
+		name := string(channel)
+		subs[name] = struct{}{}
+		if index[name] == nil {
+			index[name] = make(map[*subscriber]struct{})
+		}
+		index[name][sub] = struct{}{}
+
+		// 回复订阅结果，包含当前订阅的频道和模式总数
+		reply = appendPushHeader(reply, sub.protocol, 3)
+		reply = redcon.AppendBulkString(reply, kind)
+		reply = redcon.AppendBulk(reply, channel)
+		reply = redcon.AppendInt(reply, int64(len(sub.channels)+len(sub.patterns)))
+	}
+	sub.send(reply)
+
+	if !ok {
+		go sub.writeLoop()
+		go ps.serve(sub)
+	}
+}
+
+// 取消订阅，channels 为空时取消所有频道（或模式）
+func (ps *pubSub) unsubscribe(sub *subscriber, pattern bool, channels [][]byte) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	kind, subs, index := "unsubscribe", sub.channels, ps.channels
+	if pattern {
+		kind, subs, index = "punsubscribe", sub.patterns, ps.patterns
+	}
+	if len(channels) == 0 {
+		for name := range subs {
+			channels = append(channels, []byte(name))
+		}
+	}
+
+	// 没有订阅任何频道时，回复的频道为 nil
+	var reply []byte
+	if len(channels) == 0 {
+		reply = appendPushHeader(reply, sub.protocol, 3)
+		reply = redcon.AppendBulkString(reply, kind)
+		reply = redcon.AppendNull(reply)
+		reply = redcon.AppendInt(reply, int64(len(sub.channels)+len(sub.patterns)))
+	}
+	for _, channel := range channels {
+		name := string(channel)
+		delete(subs, name)
+		if index[name] != nil {
+			delete(index[name], sub)
+			if len(index[name]) == 0 {
+				delete(index, name)
+			}
+		}
+
+		reply = appendPushHeader(reply, sub.protocol, 3)
+		reply = redcon.AppendBulkString(reply, kind)
+		reply = redcon.AppendBulk(reply, channel)
+		reply = redcon.AppendInt(reply, int64(len(sub.channels)+len(sub.patterns)))
+	}
+	sub.send(reply)
+}
+
+// 接收消息的订阅者，pattern 不为空时为模式订阅
+type delivery struct {
+	sub     *subscriber
+	pattern []byte
+}
+
+// 发布消息，返回接收到消息的订阅者数量
+// 持有读锁时只复制订阅者列表，释放锁之后再放入各个订阅者的队列
+func (ps *pubSub) publish(channel, message []byte) int {
+	ps.mu.RLock()
+	var deliveries []delivery
+	for sub := range ps.channels[string(channel)] {
+		deliveries = append(deliveries, delivery{sub: sub})
+	}
+	for pattern, subs := range ps.patterns {
+		if !match.Match(string(channel), pattern) {
+			continue
+		}
+		for sub := range subs {
+			deliveries = append(deliveries, delivery{sub: sub, pattern: []byte(pattern)})
+		}
+	}
+	ps.mu.RUnlock()
+
+	for _, d := range deliveries {
+		d.sub.send(appendMessage(nil, d.sub.protocol, d.pattern, channel, message))
+	}
+	return len(deliveries)
+}
+
+// 追加一条推送消息，pattern 不为空时为模式订阅收到的消息
+func appendMessage(b []byte, protocol int, pattern, channel, message []byte) []byte {
+	if pattern != nil {
+		b = appendPushHeader(b, protocol, 4)
+		b = redcon.AppendBulkString(b, "pmessage")
+		b = redcon.AppendBulk(b, pattern)
+	} else {
+		b = appendPushHeader(b, protocol, 3)
+		b = redcon.AppendBulkString(b, "message")
+	}
+	b = redcon.AppendBulk(b, channel)
+	return redcon.AppendBulk(b, message)
+}
+
+// 将回复或消息放入队列，不会阻塞；队列已满说明订阅者读取太慢，断开订阅者
+func (sub *subscriber) send(b []byte) {
+	select {
+	case <-sub.closing:
+	case sub.queue <- b:
+	default:
+		sub.drop()
+	}
+}
+
+// 断开订阅者：直接关闭底层连接，正在阻塞的写入和读取都会返回错误，读取协程随后清理订阅
+func (sub *subscriber) drop() {
+	sub.closeOnce.Do(func() {
+		close(sub.closing)
+		_ = sub.dconn.NetConn().Close()
+	})
+}
+
+// 按顺序将队列中的回复和消息写入连接，写入超时或失败时断开订阅者
+// 订阅者正常断开时写完队列中剩余的数据再退出（QUIT 的回复）
+func (sub *subscriber) writeLoop() {
+	defer close(sub.written)
+	for {
+		select {
+		case b := <-sub.queue:
+			sub.dconn.WriteRaw(b)
+		case <-sub.closing:
+			for n := len(sub.queue); n > 0; n-- {
+				sub.dconn.WriteRaw(<-sub.queue)
+			}
+			_ = sub.flush()
+			return
+		}
+		// 队列中已有的数据合并写入
+		for n := len(sub.queue); n > 0; n-- {
+			sub.dconn.WriteRaw(<-sub.queue)
+		}
+		if err := sub.flush(); err != nil {
+			sub.drop()
+			return
+		}
+	}
+}
+
+func (sub *subscriber) flush() error {
+	if err := sub.dconn.NetConn().SetWriteDeadline(time.Now().Add(pubSubWriteTimeout)); err != nil {
+		return err
+	}
+	return sub.dconn.Flush()
+}
+
+// 在后台读取已订阅连接的命令，连接断开时清理所有订阅
+func (ps *pubSub) serve(sub *subscriber) {
+	defer func() {
+		ps.mu.Lock()
+		for name := range sub.channels {
+			delete(ps.channels[name], sub)
+			if len(ps.channels[name]) == 0 {
+				delete(ps.channels, name)
+			}
+		}
+		for name := range sub.patterns {
+			delete(ps.patterns[name], sub)
+			if len(ps.patterns[name]) == 0 {
+				delete(ps.patterns, name)
+			}
+		}
+		delete(ps.subscribers, sub.conn)
+		ps.mu.Unlock()
+
+		// 等待写入协程写完剩余的数据之后再关闭连接
+		sub.closeOnce.Do(func() {
+			close(sub.closing)
+		})
+		<-sub.written
+		_ = sub.dconn.Close()
+		if ps.onClose != nil {
+			ps.onClose(sub.conn)
+		}
+	}()
+
+	for {
+		cmd, err := sub.dconn.ReadCommand()
+		if err != nil {
+			return
+		}
+		if len(cmd.Args) == 0 {
+			continue
+		}
+		command := strings.ToLower(string(cmd.Args[0]))
+		switch command {
+		case "subscribe", "psubscribe":
+			if len(cmd.Args) < 2 {
+				sub.writeError(newWrongNumberOfArgsError(command).Error())
+				continue
+			}
+			ps.subscribe(sub.conn, sub.protocol, command == "psubscribe", cmd.Args[1:])
+		case "unsubscribe", "punsubscribe":
+			ps.unsubscribe(sub, command == "punsubscribe", cmd.Args[1:])
+		case "ping":
+			if len(cmd.Args) > 2 {
+				sub.writeError(newWrongNumberOfArgsError(command).Error())
+				continue
+			}
+			sub.pong(cmd.Args[1:])
+		case "quit":
+			sub.send(redcon.AppendOK(nil))
+			return
+		default:
+			sub.writeError("ERR Can't execute '" + command + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+		}
+	}
+}
+
+func (sub *subscriber) writeError(msg string) {
+	sub.send(redcon.AppendError(nil, msg))
+}
+
+// 订阅状态下的 PING，RESP2 下按 Redis 的格式回复数组，RESP3 下回复普通的 PONG
+func (sub *subscriber) pong(args [][]byte) {
+	var reply []byte
+	if sub.protocol == 3 {
+		if len(args) > 0 {
+			reply = redcon.AppendBulk(reply, args[0])
+		} else {
+			reply = redcon.AppendString(reply, "PONG")
+		}
+	} else {
+		reply = redcon.AppendArray(reply, 2)
+		reply = redcon.AppendBulkString(reply, "pong")
+		if len(args) > 0 {
+			reply = redcon.AppendBulk(reply, args[0])
+		} else {
+			reply = redcon.AppendBulkString(reply, "")
+		}
+	}
+	sub.send(reply)
+}
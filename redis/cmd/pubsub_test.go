@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPubSub_RESP2(t *testing.T) {
+	address := startTestServer(t, ServerOptions{})
+	subscriber := dialTestServer(t, address)
+	publisher := dialTestServer(t, address)
+
+	want := respAggregate{kind: '*', items: []interface{}{"subscribe", "news", int64(1)}}
+	if reply := subscriber.do("SUBSCRIBE", "news"); !reflect.DeepEqual(reply, want) {
+		t.Fatalf("SUBSCRIBE = %#v", reply)
+	}
+	if reply := publisher.do("PUBLISH", "news", "hello"); reply != int64(1) {
+		t.Fatalf("PUBLISH = %v", reply)
+	}
+	want = respAggregate{kind: '*', items: []interface{}{"message", "news", "hello"}}
+	if reply := subscriber.read(); !reflect.DeepEqual(reply, want) {
+		t.Fatalf("message = %#v", reply)
+	}
+}
+
+func TestPubSub_RESP3Push(t *testing.T) {
+	address := startTestServer(t, ServerOptions{})
+	subscriber := dialTestServer(t, address)
+	publisher := dialTestServer(t, address)
+
+	helloFields(t, subscriber.do("HELLO", "3"), '%')
+	want := respAggregate{kind: '>', items: []interface{}{"subscribe", "news", int64(1)}}
+	if reply := subscriber.do("SUBSCRIBE", "news"); !reflect.DeepEqual(reply, want) {
+		t.Fatalf("SUBSCRIBE = %#v", reply)
+	}
+	want = respAggregate{kind: '>', items: []interface{}{"psubscribe", "n*", int64(2)}}
+	if reply := subscriber.do("PSUBSCRIBE", "n*"); !reflect.DeepEqual(reply, want) {
+		t.Fatalf("PSUBSCRIBE = %#v", reply)
+	}
+
+	// 频道订阅和模式订阅各收到一条 push 消息
+	if reply := publisher.do("PUBLISH", "news", "hello"); reply != int64(2) {
+		t.Fatalf("PUBLISH = %v", reply)
+	}
+	messages := []interface{}{subscriber.read(), subscriber.read()}
+	wantMessages := []respAggregate{
+		{kind: '>', items: []interface{}{"message", "news", "hello"}},
+		{kind: '>', items: []interface{}{"pmessage", "n*", "news", "hello"}},
+	}
+	for _, want := range wantMessages {
+		if !reflect.DeepEqual(messages[0], want) && !reflect.DeepEqual(messages[1], want) {
+			t.Fatalf("messages = %#v, missing %#v", messages, want)
+		}
+	}
+
+	// 订阅状态下 PING 的回复不是推送消息
+	if reply := subscriber.do("PING"); reply != "+PONG" {
+		t.Fatalf("PING = %#v", reply)
+	}
+
+	// 不匹配的频道没有订阅者
+	if reply := publisher.do("PUBLISH", "other", "x"); reply != int64(0) {
+		t.Fatalf("PUBLISH other = %v", reply)
+	}
+
+	want = respAggregate{kind: '>', items: []interface{}{"unsubscribe", "news", int64(1)}}
+	if reply := subscriber.do("UNSUBSCRIBE"); !reflect.DeepEqual(reply, want) {
+		t.Fatalf("UNSUBSCRIBE = %#v", reply)
+	}
+	if reply := publisher.do("PUBLISH", "news", "again"); reply != int64(1) {
+		t.Fatalf("PUBLISH after UNSUBSCRIBE = %v", reply)
+	}
+}
+
+func TestPubSub_DisconnectRemovesSubscriber(t *testing.T) {
+	address := startTestServer(t, ServerOptions{})
+	subscriber := dialTestServer(t, address)
+	publisher := dialTestServer(t, address)
+
+	subscriber.do("SUBSCRIBE", "news")
+	_ = subscriber.conn.Close()
+
+	// 订阅者断开后不再计入接收数量
+	for i := 0; i < 100; i++ {
+		if publisher.do("PUBLISH", "news", "hello") == int64(0) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("subscriber was not removed after disconnect")
+}
+
+// 不读取消息的订阅者不会阻塞发布者和其他订阅者，队列满了之后被断开
+func TestPubSub_SlowSubscriberDropped(t *testing.T) {
+	address := startTestServer(t, ServerOptions{})
+	slow := dialTestServer(t, address)
+	publisher := dialTestServer(t, address)
+
+	slow.do("SUBSCRIBE", "news")
+	message := strings.Repeat("m", 16*1024)
+	for i := 0; ; i++ {
+		if i > 10*pubSubQueueSize {
+			t.Fatal("slow subscriber was not dropped")
+		}
+		if publisher.do("PUBLISH", "news", message) == int64(0) {
+			break
+		}
+	}
+
+	// 其他订阅者正常收到消息
+	subscriber := dialTestServer(t, address)
+	subscriber.do("SUBSCRIBE", "news")
+	if reply := publisher.do("PUBLISH", "news", "hello"); reply != int64(1) {
+		t.Fatalf("PUBLISH = %v", reply)
+	}
+	want := respAggregate{kind: '*', items: []interface{}{"message", "news", "hello"}}
+	if reply := subscriber.read(); !reflect.DeepEqual(reply, want) {
+		t.Fatalf("message = %#v", reply)
+	}
+}
+
+// 订阅状态下的 QUIT 回复之后断开连接
+func TestPubSub_Quit(t *testing.T) {
+	address := startTestServer(t, ServerOptions{})
+	subscriber := dialTestServer(t, address)
+	subscriber.do("SUBSCRIBE", "news")
+	if reply := subscriber.do("QUIT"); reply != "+OK" {
+		t.Fatalf("QUIT = %#v", reply)
+	}
+	if _, err := readReply(subscriber.rd); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
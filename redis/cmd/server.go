@@ -1,9 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/tidwall/redcon"
 
@@ -13,31 +17,79 @@ import (
 
 // 兼容Redis协议，使用第三方库
 
-const addr = "127.0.0.1:6380"
+const (
+	addr          = "127.0.0.1:6380"
+	serverVersion = "1.0.0"
+)
+
+// 服务端的配置项
+type ServerOptions struct {
+	RequirePass      string        // 连接密码，为空表示无需认证
+	MaxConnections   int           // 最大连接数（包括订阅中的连接），超过时拒绝新的连接，为0表示不限制
+	SlowLogThreshold time.Duration // 执行时间超过此值的命令记入慢查询日志，为0时记录所有命令，小于0时不记录
+	SlowLogMaxLen    int           // 慢查询日志最多保留的条数，超过时丢弃最早的记录
+}
+
+var DefaultServerOptions = ServerOptions{
+	SlowLogThreshold: 10 * time.Millisecond,
+	SlowLogMaxLen:    128,
+}
 
 type BitcaskServer struct {
-	dbs    map[int]*bitcask_redis.RedisDataStructure
-	server *redcon.Server
-	mu     sync.RWMutex
+	dbs     map[int]*bitcask_redis.RedisDataStructure
+	server  *redcon.Server
+	mu      sync.RWMutex
+	options ServerOptions
+	pubsub  *pubSub // 发布订阅服务（消息只在内存中转发，不做持久化）
+	slowlog *slowLog
+
+	connections  atomic.Int64              // 当前的连接数
+	nextClientId atomic.Uint64             // 分配给新连接的id
+	clients      map[uint64]*BitcaskClient // 当前的连接，由 mu 保护
 }
 
 func main() {
-	redisDataStructure, err := bitcask_redis.NewRedisDataStructure(bitcask.DefaultOptions)
+	requirePass := flag.String("requirepass", "", "password required for clients to authenticate")
+	maxClients := flag.Int("maxclients", 0, "max number of connected clients, 0 means unlimited")
+	slowlogThreshold := flag.Duration("slowlog-threshold", DefaultServerOptions.SlowLogThreshold, "log commands slower than this, negative disables the slow log")
+	slowlogMaxLen := flag.Int("slowlog-max-len", DefaultServerOptions.SlowLogMaxLen, "max number of entries kept in the slow log")
+	flag.Parse()
+
+	serverOptions := DefaultServerOptions
+	serverOptions.RequirePass = *requirePass
+	serverOptions.MaxConnections = *maxClients
+	serverOptions.SlowLogThreshold = *slowlogThreshold
+	serverOptions.SlowLogMaxLen = *slowlogMaxLen
+	bitcaskServer, err := newBitcaskServer(addr, bitcask.DefaultOptions, serverOptions)
 	if err != nil {
 		fmt.Println(err)
 		panic(err)
 	}
+	bitcaskServer.listen()
+	bitcaskServer.closeDBs()
+}
+
+// 初始化BitcaskServer，打开0号数据库
+func newBitcaskServer(address string, options bitcask.Options, serverOptions ServerOptions) (*BitcaskServer, error) {
+	redisDataStructure, err := bitcask_redis.NewRedisDataStructure(options)
+	if err != nil {
+		return nil, err
+	}
 
-	// 初始化BitcaskServer
 	bitcaskServer := &BitcaskServer{
-		dbs: make(map[int]*bitcask_redis.RedisDataStructure),
+		dbs:     make(map[int]*bitcask_redis.RedisDataStructure),
+		options: serverOptions,
+		pubsub:  newPubSub(),
+		slowlog: newSlowLog(serverOptions.SlowLogThreshold, serverOptions.SlowLogMaxLen),
+		clients: make(map[uint64]*BitcaskClient),
 	}
 	bitcaskServer.dbs[0] = redisDataStructure
+	// 订阅中的连接从服务器中分离出来，真正断开时由发布订阅服务通知
+	bitcaskServer.pubsub.onClose = bitcaskServer.removeClient
 
 	// 初始化Redis服务器
-	bitcaskServer.server = redcon.NewServer(addr, nil, bitcaskServer.accept, bitcaskServer.close)
-	bitcaskServer.listen()
-
+	bitcaskServer.server = redcon.NewServer(address, execClientCommand, bitcaskServer.accept, bitcaskServer.close)
+	return bitcaskServer, nil
 }
 
 func (svr *BitcaskServer) listen() {
@@ -46,20 +98,82 @@ func (svr *BitcaskServer) listen() {
 }
 
 func (svr *BitcaskServer) accept(conn redcon.Conn) bool {
-	cli := new(BitcaskClient)
+	// 超过最大连接数时回复错误并关闭连接
+	if n := svr.connections.Add(1); svr.options.MaxConnections > 0 && n > int64(svr.options.MaxConnections) {
+		svr.connections.Add(-1)
+		conn.WriteError("ERR max clients exceeded")
+		return false
+	}
+
+	cli := &BitcaskClient{
+		id:          svr.nextClientId.Add(1),
+		conn:        conn,
+		connectedAt: time.Now(),
+	}
 	svr.mu.Lock()
 	defer svr.mu.Unlock()
 	cli.server = svr
 	cli.db = svr.dbs[0]
+	cli.protocol = 2
+	cli.authed = svr.options.RequirePass == ""
+	svr.clients[cli.id] = cli
 	// 放入上下文
 	conn.SetContext(cli)
 	return true
 }
 
+// 连接断开时的回调，数据库由服务退出时统一关闭
+// 连接订阅之后从服务器中分离出来时也会调用，此时连接仍然存在，不做处理
 func (svr *BitcaskServer) close(conn redcon.Conn, err error) {
+	if cli, ok := conn.Context().(*BitcaskClient); ok && !cli.detached {
+		svr.removeClient(conn)
+	}
+}
+
+// 移除已断开的连接
+func (svr *BitcaskServer) removeClient(conn redcon.Conn) {
+	cli, ok := conn.Context().(*BitcaskClient)
+	if !ok {
+		return
+	}
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	if _, ok := svr.clients[cli.id]; ok {
+		delete(svr.clients, cli.id)
+		svr.connections.Add(-1)
+	}
+}
+
+// 服务退出时关闭所有数据库
+func (svr *BitcaskServer) closeDBs() {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
 	for _, db := range svr.dbs {
 		_ = db.Close()
 	}
+}
+
+// 当前所有的连接，按id排序
+func (svr *BitcaskServer) listClients() []*BitcaskClient {
+	svr.mu.RLock()
+	clients := make([]*BitcaskClient, 0, len(svr.clients))
+	for _, cli := range svr.clients {
+		clients = append(clients, cli)
+	}
+	svr.mu.RUnlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
 
-	_ = svr.server.Close()
+// 断开指定id的连接，连接不存在时返回false
+// 直接关闭底层的网络连接，连接所在的协程读取失败后退出，并通过 close 回调移除连接
+func (svr *BitcaskServer) killClient(id uint64) bool {
+	svr.mu.RLock()
+	cli, ok := svr.clients[id]
+	svr.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	_ = cli.conn.NetConn().Close()
+	return true
 }
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	bitcask "bitcask-go"
+)
+
+// 在随机端口上启动服务，测试结束时关闭
+func startTestServer(t *testing.T, serverOptions ServerOptions) string {
+	t.Helper()
+	options := bitcask.DefaultOptions
+	options.DirPath = t.TempDir()
+	svr, err := newBitcaskServer("127.0.0.1:0", options, serverOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signal := make(chan error, 1)
+	go func() {
+		_ = svr.server.ListenServeAndSignal(signal)
+	}()
+	if err := <-signal; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = svr.server.Close()
+		svr.closeDBs()
+	})
+	return svr.server.Addr().String()
+}
+
+// 测试用的 RESP 客户端
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func dialTestServer(t *testing.T, address string) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return &testConn{t: t, conn: conn, rd: bufio.NewReader(conn)}
+}
+
+// 发送命令并读取一条回复
+func (c *testConn) do(args ...string) interface{} {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+func (c *testConn) send(args ...string) {
+	c.t.Helper()
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"+arg+"\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// 读取一条回复，简单字符串和错误分别以 "+" 和 "-" 开头返回，
+// double 返回 float64，聚合类型返回 respAggregate，null 返回 nil
+func (c *testConn) read() interface{} {
+	c.t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := readReply(c.rd)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return reply
+}
+
+// 聚合类型的回复，kind 为类型前缀（'*' 数组，'%' map，'>' push）
+type respAggregate struct {
+	kind  byte
+	items []interface{}
+}
+
+func readReply(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("invalid reply line %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+', '-':
+		return string(kind) + body, nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case ',':
+		return strconv.ParseFloat(body, 64)
+	case '_':
+		return nil, nil
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*', '%', '>':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if kind == '%' {
+			n *= 2
+		}
+		agg := respAggregate{kind: kind}
+		for i := 0; i < n; i++ {
+			item, err := readReply(rd)
+			if err != nil {
+				return nil, err
+			}
+			agg.items = append(agg.items, item)
+		}
+		return agg, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", line)
+	}
+}
+
+func TestServer_SetGet(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{}))
+	if reply := conn.do("SET", "name", "bitcask"); reply != "+OK" {
+		t.Fatalf("SET = %v", reply)
+	}
+	if reply := conn.do("GET", "name"); reply != "bitcask" {
+		t.Fatalf("GET = %v", reply)
+	}
+	if reply := conn.do("GET", "missing"); reply != nil {
+		t.Fatalf("GET missing = %v", reply)
+	}
+}
+
+func TestServer_DisconnectKeepsDBOpen(t *testing.T) {
+	address := startTestServer(t, ServerOptions{})
+	first := dialTestServer(t, address)
+	if reply := first.do("SET", "k", "v"); reply != "+OK" {
+		t.Fatalf("SET = %v", reply)
+	}
+	_ = first.conn.Close()
+
+	// 其他连接断开后，数据库仍然可以正常读写
+	second := dialTestServer(t, address)
+	for i := 0; i < 10; i++ {
+		if reply := second.do("GET", "k"); reply != "v" {
+			t.Fatalf("GET after disconnect = %v", reply)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_ObjectEncoding(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{}))
+	if reply := conn.do("SET", "name", "bitcask"); reply != "+OK" {
+		t.Fatalf("SET = %v", reply)
+	}
+	reply, ok := conn.do("OBJECT", "ENCODING", "name").(string)
+	if size, err := strconv.Atoi(reply); !ok || err != nil || size <= len("bitcask") {
+		t.Fatalf("OBJECT ENCODING = %v", reply)
+	}
+	if reply := conn.do("OBJECT", "ENCODING", "missing"); reply != nil {
+		t.Fatalf("OBJECT ENCODING missing = %v", reply)
+	}
+	if reply, _ := conn.do("OBJECT", "FREQ", "name").(string); !strings.HasPrefix(reply, "-ERR") {
+		t.Fatalf("OBJECT FREQ = %v", reply)
+	}
+}
+
+func TestServer_ConfigStatsInterval(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{}))
+	if reply := conn.do("CONFIG", "SET", "stats-interval", "250"); reply != "+OK" {
+		t.Fatalf("CONFIG SET = %v", reply)
+	}
+	agg, ok := conn.do("CONFIG", "GET", "stats-*").(respAggregate)
+	if !ok || len(agg.items) != 2 || agg.items[0] != "stats-interval" || agg.items[1] != "250" {
+		t.Fatalf("CONFIG GET = %#v", agg)
+	}
+	if agg, ok := conn.do("CONFIG", "GET", "maxmemory").(respAggregate); !ok || len(agg.items) != 0 {
+		t.Fatalf("CONFIG GET unknown = %#v", agg)
+	}
+
+	for _, args := range [][]string{
+		{"CONFIG", "SET", "stats-interval", "-1"},
+		{"CONFIG", "SET", "stats-interval", "x"},
+		{"CONFIG", "SET", "maxmemory", "100"},
+		{"CONFIG", "SET", "stats-interval"},
+		{"CONFIG", "RESETSTAT"},
+	} {
+		if reply, _ := conn.do(args...).(string); !strings.HasPrefix(reply, "-ERR") {
+			t.Fatalf("%v = %v", args, reply)
+		}
+	}
+
+	// 设置为0时停止采样
+	if reply := conn.do("CONFIG", "SET", "STATS-INTERVAL", "0"); reply != "+OK" {
+		t.Fatalf("CONFIG SET 0 = %v", reply)
+	}
+	if agg, ok := conn.do("CONFIG", "GET", "stats-interval").(respAggregate); !ok || len(agg.items) != 2 || agg.items[1] != "0" {
+		t.Fatalf("CONFIG GET = %#v", agg)
+	}
+}
+
+// 超过最大连接数的连接被拒绝，连接断开（包括订阅中的连接）之后可以重新连接
+func TestServer_MaxConnections(t *testing.T) {
+	address := startTestServer(t, ServerOptions{MaxConnections: 2})
+	first := dialTestServer(t, address)
+	second := dialTestServer(t, address)
+	for _, conn := range []*testConn{first, second} {
+		if reply := conn.do("PING"); reply != "+PONG" {
+			t.Fatalf("PING = %v", reply)
+		}
+	}
+	if reply := dialTestServer(t, address).read(); reply != "-ERR max clients exceeded" {
+		t.Fatalf("expected connection to be rejected, got %v", reply)
+	}
+
+	if reply, _ := second.do("SUBSCRIBE", "news").(respAggregate); len(reply.items) != 3 {
+		t.Fatalf("SUBSCRIBE = %v", reply)
+	}
+	if reply := dialTestServer(t, address).read(); reply != "-ERR max clients exceeded" {
+		t.Fatalf("expected subscribed connection to be counted, got %v", reply)
+	}
+	_ = second.conn.Close()
+	expectAccepted(t, address)
+}
+
+// 等待连接数释放之后可以建立新的连接
+func expectAccepted(t *testing.T, address string) *testConn {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		conn := dialTestServer(t, address)
+		if reply := conn.do("PING"); reply == "+PONG" {
+			return conn
+		}
+		_ = conn.conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("connection slot is never released")
+	return nil
+}
+
+func TestServer_ClientListKill(t *testing.T) {
+	address := startTestServer(t, ServerOptions{MaxConnections: 2})
+	first := dialTestServer(t, address)
+	second := dialTestServer(t, address)
+	firstId, _ := first.do("CLIENT", "ID").(int64)
+	secondId, _ := second.do("CLIENT", "ID").(int64)
+	if firstId == 0 || secondId <= firstId {
+		t.Fatalf("CLIENT ID = %d, %d", firstId, secondId)
+	}
+	first.do("SET", "k", "v")
+	first.do("GET", "k")
+
+	list, _ := second.do("CLIENT", "LIST").(string)
+	lines := strings.Split(strings.TrimSuffix(list, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("CLIENT LIST = %q", list)
+	}
+	wantFirst := fmt.Sprintf("id=%d addr=%s db=0 ", firstId, first.conn.LocalAddr())
+	if !strings.HasPrefix(lines[0], wantFirst) || !strings.HasSuffix(lines[0], " cmd=get tot-cmds=3") {
+		t.Fatalf("unexpected client info %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], fmt.Sprintf("id=%d ", secondId)) || !strings.HasSuffix(lines[1], " cmd=client tot-cmds=2") {
+		t.Fatalf("unexpected client info %q", lines[1])
+	}
+
+	for _, args := range [][]string{
+		{"CLIENT", "KILL", "ID", "999"},
+		{"CLIENT", "KILL", "ID", "x"},
+		{"CLIENT", "KILL", "ADDR", "127.0.0.1:1"},
+		{"CLIENT", "KILL"},
+		{"CLIENT", "PAUSE"},
+	} {
+		if reply, _ := second.do(args...).(string); !strings.HasPrefix(reply, "-ERR") {
+			t.Fatalf("%v = %v", args, reply)
+		}
+	}
+
+	// 被断开的连接读取失败，释放的连接数可以被新的连接使用
+	if reply := second.do("CLIENT", "KILL", "ID", strconv.FormatInt(firstId, 10)); reply != "+OK" {
+		t.Fatalf("CLIENT KILL = %v", reply)
+	}
+	_ = first.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := first.rd.ReadByte(); err == nil {
+		t.Fatal("expected killed connection to be closed")
+	}
+	third := expectAccepted(t, address)
+	list, _ = third.do("CLIENT", "LIST").(string)
+	if strings.Contains(list, fmt.Sprintf("id=%d ", firstId)) || strings.Count(list, "\n") != 2 {
+		t.Fatalf("CLIENT LIST after kill = %q", list)
+	}
+}
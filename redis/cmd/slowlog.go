@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+const (
+	slowLogMaxArgs      = 32  // 每条记录最多保存的参数个数，和 Redis 一致
+	slowLogMaxArgLen    = 128 // 每个参数最多保存的字节数
+	slowLogDefaultCount = 10  // SLOWLOG GET 默认返回的条数
+)
+
+// 慢查询日志中的一条记录
+type slowLogEntry struct {
+	id        int64
+	timestamp time.Time     // 命令开始执行的时间
+	duration  time.Duration // 命令的执行时间
+	command   string
+	args      []string // 超过上限的参数个数和长度被截断
+	addr      string   // 执行命令的连接的地址
+}
+
+// 慢查询日志，保存最近的 maxLen 条记录的环形缓冲区
+type slowLog struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	maxLen    int
+	entries   []slowLogEntry
+	next      int   // 下一条记录在 entries 中的位置
+	nextId    int64 // 下一条记录的id，RESET 之后不重置
+}
+
+func newSlowLog(threshold time.Duration, maxLen int) *slowLog {
+	return &slowLog{threshold: threshold, maxLen: maxLen}
+}
+
+// 记录执行时间超过阈值的命令，args 包括命令名称
+// 命令的参数引用连接的读缓冲区，保存之前先复制
+func (s *slowLog) record(start time.Time, duration time.Duration, args [][]byte, addr string) {
+	if s.threshold < 0 || duration < s.threshold || s.maxLen <= 0 {
+		return
+	}
+	entry := slowLogEntry{
+		timestamp: start,
+		duration:  duration,
+		command:   string(args[0]),
+		addr:      addr,
+	}
+	for i, arg := range args[1:] {
+		if i == slowLogMaxArgs-1 && len(args)-1 > slowLogMaxArgs {
+			entry.args = append(entry.args, fmt.Sprintf("... (%d more arguments)", len(args)-1-i))
+			break
+		}
+		if len(arg) > slowLogMaxArgLen {
+			entry.args = append(entry.args, fmt.Sprintf("%s... (%d more bytes)", arg[:slowLogMaxArgLen], len(arg)-slowLogMaxArgLen))
+			continue
+		}
+		entry.args = append(entry.args, string(arg))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.id = s.nextId
+	s.nextId++
+	if len(s.entries) < s.maxLen {
+		s.entries = append(s.entries, entry)
+	} else {
+		s.entries[s.next] = entry
+	}
+	s.next = (s.next + 1) % s.maxLen
+}
+
+// 最近的 count 条记录，按从新到旧的顺序返回，count 小于0时返回所有记录
+func (s *slowLog) get(count int) []slowLogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if count < 0 || count > len(s.entries) {
+		count = len(s.entries)
+	}
+	entries := make([]slowLogEntry, count)
+	for i := range entries {
+		entries[i] = s.entries[(s.next-1-i+len(s.entries))%len(s.entries)]
+	}
+	return entries
+}
+
+func (s *slowLog) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func (s *slowLog) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	s.next = 0
+}
+
+// 按 Redis 的格式回复：id、开始时间（秒）、执行时间（微秒）、命令和参数、客户端地址、客户端名称
+func (e slowLogEntry) reply() []interface{} {
+	args := make([]interface{}, 0, len(e.args)+1)
+	args = append(args, e.command)
+	for _, arg := range e.args {
+		args = append(args, arg)
+	}
+	return []interface{}{
+		redcon.SimpleInt(e.id),
+		redcon.SimpleInt(e.timestamp.Unix()),
+		redcon.SimpleInt(e.duration.Microseconds()),
+		args,
+		e.addr,
+		"",
+	}
+}
+
+// SLOWLOG GET [count]/LEN/RESET
+func slowlog(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("slowlog")
+	}
+
+	switch strings.ToLower(string(args[0])) {
+	case "get":
+		if len(args) > 2 {
+			return nil, newWrongNumberOfArgsError("slowlog|get")
+		}
+		count := slowLogDefaultCount
+		if len(args) == 2 {
+			n, err := strconv.Atoi(string(args[1]))
+			if err != nil || n < -1 {
+				return nil, errors.New("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		entries := cli.server.slowlog.get(count)
+		reply := make([]interface{}, len(entries))
+		for i, entry := range entries {
+			reply[i] = entry.reply()
+		}
+		return reply, nil
+	case "len":
+		if len(args) != 1 {
+			return nil, newWrongNumberOfArgsError("slowlog|len")
+		}
+		return redcon.SimpleInt(cli.server.slowlog.len()), nil
+	case "reset":
+		if len(args) != 1 {
+			return nil, newWrongNumberOfArgsError("slowlog|reset")
+		}
+		cli.server.slowlog.reset()
+		return redcon.SimpleString("OK"), nil
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", args[0])
+	}
+}
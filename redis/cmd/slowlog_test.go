@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowLog_Ring(t *testing.T) {
+	s := newSlowLog(10*time.Millisecond, 3)
+	start := time.Now()
+	s.record(start, 9*time.Millisecond, [][]byte{[]byte("get"), []byte("fast")}, "addr")
+	if n := s.len(); n != 0 {
+		t.Fatalf("expected commands under the threshold to be skipped, got %d entries", n)
+	}
+	for i := 0; i < 5; i++ {
+		s.record(start, 10*time.Millisecond, [][]byte{[]byte("get"), []byte(strconv.Itoa(i))}, "addr")
+	}
+
+	// 只保留最近的3条，从新到旧返回
+	entries := s.get(-1)
+	if len(entries) != 3 || s.len() != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, entry := range entries {
+		if want := int64(4 - i); entry.id != want || entry.args[0] != strconv.Itoa(int(want)) {
+			t.Fatalf("entry %d = %+v, want id %d", i, entry, want)
+		}
+	}
+	if entries := s.get(1); len(entries) != 1 || entries[0].id != 4 {
+		t.Fatalf("get(1) = %+v", entries)
+	}
+
+	// 参数的个数和长度被截断
+	args := [][]byte{[]byte("mset")}
+	for i := 0; i < 40; i++ {
+		args = append(args, []byte(strings.Repeat("x", 200)))
+	}
+	s.record(start, time.Second, args, "addr")
+	entry := s.get(1)[0]
+	if len(entry.args) != slowLogMaxArgs || entry.args[slowLogMaxArgs-1] != "... (9 more arguments)" {
+		t.Fatalf("unexpected truncated args %q", entry.args)
+	}
+	if entry.args[0] != strings.Repeat("x", 128)+"... (72 more bytes)" {
+		t.Fatalf("unexpected truncated arg %q", entry.args[0])
+	}
+
+	// 清空之后id继续递增
+	s.reset()
+	if entries := s.get(-1); len(entries) != 0 {
+		t.Fatalf("expected empty slow log after reset, got %+v", entries)
+	}
+	s.record(start, time.Second, [][]byte{[]byte("get")}, "addr")
+	if entries := s.get(-1); len(entries) != 1 || entries[0].id != 6 {
+		t.Fatalf("unexpected entries after reset %+v", entries)
+	}
+
+	disabled := newSlowLog(-1, 3)
+	disabled.record(start, time.Second, [][]byte{[]byte("get")}, "addr")
+	if n := disabled.len(); n != 0 {
+		t.Fatalf("expected disabled slow log to stay empty, got %d entries", n)
+	}
+}
+
+func TestServer_SlowLog(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, ServerOptions{SlowLogThreshold: 0, SlowLogMaxLen: 2}))
+	if reply := conn.do("SET", "k", "v"); reply != "+OK" {
+		t.Fatalf("SET = %v", reply)
+	}
+	if reply := conn.do("GET", "k"); reply != "v" {
+		t.Fatalf("GET = %v", reply)
+	}
+
+	// 阈值为0时记录所有命令，最多保留2条
+	reply, ok := conn.do("SLOWLOG", "GET").(respAggregate)
+	if !ok || len(reply.items) != 2 {
+		t.Fatalf("SLOWLOG GET = %#v", reply)
+	}
+	entry, _ := reply.items[0].(respAggregate)
+	if len(entry.items) != 6 {
+		t.Fatalf("unexpected entry %#v", entry)
+	}
+	if id, _ := entry.items[0].(int64); id != 1 {
+		t.Fatalf("expected newest entry id 1, got %v", entry.items[0])
+	}
+	if ts, _ := entry.items[1].(int64); time.Since(time.Unix(ts, 0)) > time.Minute {
+		t.Fatalf("unexpected timestamp %v", entry.items[1])
+	}
+	if duration, _ := entry.items[2].(int64); duration < 0 {
+		t.Fatalf("unexpected duration %v", entry.items[2])
+	}
+	want := respAggregate{kind: '*', items: []interface{}{"GET", "k"}}
+	if !reflect.DeepEqual(entry.items[3], want) {
+		t.Fatalf("unexpected command %#v", entry.items[3])
+	}
+	if entry.items[4] != conn.conn.LocalAddr().String() {
+		t.Fatalf("unexpected client addr %v", entry.items[4])
+	}
+
+	if reply, ok := conn.do("SLOWLOG", "GET", "1").(respAggregate); !ok || len(reply.items) != 1 {
+		t.Fatalf("SLOWLOG GET 1 = %#v", reply)
+	}
+	if reply := conn.do("SLOWLOG", "LEN"); reply != int64(2) {
+		t.Fatalf("SLOWLOG LEN = %v", reply)
+	}
+	if reply := conn.do("SLOWLOG", "RESET"); reply != "+OK" {
+		t.Fatalf("SLOWLOG RESET = %v", reply)
+	}
+	// RESET 本身在清空之后记录
+	if reply := conn.do("SLOWLOG", "LEN"); reply != int64(1) {
+		t.Fatalf("SLOWLOG LEN after reset = %v", reply)
+	}
+
+	for _, args := range [][]string{
+		{"SLOWLOG"},
+		{"SLOWLOG", "GET", "x"},
+		{"SLOWLOG", "GET", "-2"},
+		{"SLOWLOG", "LEN", "1"},
+		{"SLOWLOG", "HELP"},
+	} {
+		if reply, _ := conn.do(args...).(string); !strings.HasPrefix(reply, "-ERR") {
+			t.Fatalf("%v = %v", args, reply)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package redis
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 // 通用命令
 
@@ -9,6 +12,21 @@ func (rds *RedisDataStructure) Del(key []byte) error {
 	return rds.db.Delete(key)
 }
 
+// 获取key在磁盘上占用的大小
+func (rds *RedisDataStructure) SizeOf(key []byte) (int64, error) {
+	return rds.db.SizeOf(key)
+}
+
+// 修改存储引擎统计采样的间隔，为0时停止采样
+func (rds *RedisDataStructure) SetStatsInterval(interval time.Duration) error {
+	return rds.db.SetStatsInterval(interval)
+}
+
+// 存储引擎当前的统计采样间隔
+func (rds *RedisDataStructure) StatsInterval() time.Duration {
+	return rds.db.StatsInterval()
+}
+
 // 获取value类型
 func (rds *RedisDataStructure) Type(key []byte) (redisDataType, error) {
 	encValue, err := rds.db.Get(key)
@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	lockInitialBackoff = time.Millisecond       // LockWithRetry 第一次重试前等待的时间
+	lockMaxBackoff     = 100 * time.Millisecond // LockWithRetry 重试间隔的上限
+)
+
+// 锁已被其他token持有（只在 Lock 内部使用，不返回给调用方）
+var errLockHeld = errors.New("lock is held")
+
+// ==============基于租约的分布式锁==============
+// 锁以String类型保存，value为持有者的token，过期时间为租约的时长，持有者崩溃时租约到期后锁自动释放
+// 持有者需要在租约到期之前完成操作，超过租约之后锁可能已被其他token获取
+
+// 获取锁，相当于 SET key token NX EX ttl：key不存在（或已过期）时写入token并返回true，key已存在时不修改并返回false
+// 检查和写入在存储引擎的同一次写锁中完成，并发获取同一个锁时只有一个返回true
+func (rds *RedisDataStructure) Lock(key, token []byte, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, ErrInvalidLockTTL
+	}
+	err := rds.db.Update(key, func(oldValue []byte) ([]byte, error) {
+		// 其他类型的key和未过期的String都视为已存在
+		if oldValue != nil {
+			if _, value, err := decodeStringValue(oldValue); err != nil || value != nil {
+				return nil, errLockHeld
+			}
+		}
+		return encodeStringValue(stringExpire(ttl), token), nil
+	})
+	if errors.Is(err, errLockHeld) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 释放锁：只有锁当前由token持有时才删除，否则返回 ErrLockNotHeld（包括锁不存在或者租约已经到期）
+// 比较和删除在存储引擎的同一次写锁中完成，不会删除租约到期后被其他token获取的锁
+func (rds *RedisDataStructure) Unlock(key, token []byte) error {
+	return rds.db.Update(key, func(oldValue []byte) ([]byte, error) {
+		if oldValue == nil {
+			return nil, ErrLockNotHeld
+		}
+		if _, value, err := decodeStringValue(oldValue); err != nil || value == nil || !bytes.Equal(value, token) {
+			return nil, ErrLockNotHeld
+		}
+		// 返回nil删除key
+		return nil, nil
+	})
+}
+
+// 获取锁，锁已被持有时按指数退避（加上随机抖动）重试，直到获取成功或者超过timeout，超时返回 ErrLockTimeout
+func (rds *RedisDataStructure) LockWithRetry(key, token []byte, ttl, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := lockInitialBackoff
+	for {
+		acquired, err := rds.Lock(key, token, ttl)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ErrLockTimeout
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		time.Sleep(min(wait, remaining))
+		backoff = min(backoff*2, lockMaxBackoff)
+	}
+}
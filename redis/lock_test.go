@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bitcask "bitcask-go"
+)
+
+func TestRedisDataStructure_Lock(t *testing.T) {
+	rds := openTestRDS(t)
+	key := []byte("lock")
+	if acquired, err := rds.Lock(key, []byte("a"), time.Minute); err != nil || !acquired {
+		t.Fatalf("Lock = %v, %v", acquired, err)
+	}
+	if acquired, err := rds.Lock(key, []byte("b"), time.Minute); err != nil || acquired {
+		t.Fatalf("Lock held by another token = %v, %v", acquired, err)
+	}
+	// 锁以String类型保存token
+	if value, err := rds.Get(key); err != nil || string(value) != "a" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	// 只有持有者可以释放
+	if err := rds.Unlock(key, []byte("b")); err != ErrLockNotHeld {
+		t.Fatalf("expected ErrLockNotHeld, got %v", err)
+	}
+	if err := rds.Unlock(key, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.Unlock(key, []byte("a")); err != ErrLockNotHeld {
+		t.Fatalf("expected ErrLockNotHeld after unlock, got %v", err)
+	}
+
+	// 租约到期之后可以被其他token获取，原来的持有者不能再释放
+	if acquired, err := rds.Lock(key, []byte("a"), 20*time.Millisecond); err != nil || !acquired {
+		t.Fatalf("Lock = %v, %v", acquired, err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if acquired, err := rds.Lock(key, []byte("b"), time.Minute); err != nil || !acquired {
+		t.Fatalf("Lock after lease expired = %v, %v", acquired, err)
+	}
+	if err := rds.Unlock(key, []byte("a")); err != ErrLockNotHeld {
+		t.Fatalf("expected ErrLockNotHeld for expired lease, got %v", err)
+	}
+
+	// 其他类型的key视为已存在
+	if _, err := rds.HSet([]byte("h"), []byte("f"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, err := rds.Lock([]byte("h"), []byte("a"), time.Minute); err != nil || acquired {
+		t.Fatalf("Lock on hash = %v, %v", acquired, err)
+	}
+	if err := rds.Unlock([]byte("h"), []byte("a")); err != ErrLockNotHeld {
+		t.Fatalf("expected ErrLockNotHeld for hash, got %v", err)
+	}
+	if _, err := rds.Lock(key, []byte("a"), 0); err != ErrInvalidLockTTL {
+		t.Fatalf("expected ErrInvalidLockTTL, got %v", err)
+	}
+}
+
+func TestRedisDataStructure_LockWithRetry(t *testing.T) {
+	rds := openTestRDS(t)
+	key := []byte("lock")
+	if acquired, err := rds.Lock(key, []byte("a"), time.Minute); err != nil || !acquired {
+		t.Fatalf("Lock = %v, %v", acquired, err)
+	}
+	start := time.Now()
+	if err := rds.LockWithRetry(key, []byte("b"), time.Minute, 50*time.Millisecond); err != ErrLockTimeout {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("LockWithRetry returned after %v", elapsed)
+	}
+
+	// 持有者释放之后，等待中的调用获取到锁
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = rds.Unlock(key, []byte("a"))
+	}()
+	if err := rds.LockWithRetry(key, []byte("b"), time.Minute, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := rds.Get(key); err != nil || string(value) != "b" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+}
+
+// 多个协程在锁的保护下读取并递增同一个计数器：持有锁期间读到的值不会被其他协程修改，也没有丢失的更新
+func TestRedisDataStructure_LockNoPhantomReads(t *testing.T) {
+	rds := openTestRDS(t)
+	lockKey, counterKey := []byte("lock"), []byte("counter")
+	const workers, rounds = 8, 50
+
+	var wg sync.WaitGroup
+	var holders atomic.Int32
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				token := []byte(fmt.Sprintf("worker-%d-%d", w, r))
+				if err := rds.LockWithRetry(lockKey, token, time.Minute, 30*time.Second); err != nil {
+					t.Error(err)
+					return
+				}
+				if n := holders.Add(1); n != 1 {
+					t.Errorf("%d holders inside the lock", n)
+				}
+
+				first, err := rds.Get(counterKey)
+				if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+					t.Error(err)
+				}
+				time.Sleep(100 * time.Microsecond)
+				second, _ := rds.Get(counterKey)
+				if string(first) != string(second) {
+					t.Errorf("counter changed while holding the lock: %q -> %q", first, second)
+				}
+				n, _ := strconv.Atoi(string(first))
+				if err := rds.Set(counterKey, 0, []byte(strconv.Itoa(n+1))); err != nil {
+					t.Error(err)
+				}
+
+				holders.Add(-1)
+				if err := rds.Unlock(lockKey, token); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	value, err := rds.Get(counterKey)
+	if err != nil || string(value) != strconv.Itoa(workers*rounds) {
+		t.Fatalf("counter = %q, %v, want %d", value, err, workers*rounds)
+	}
+}
@@ -55,14 +55,16 @@ func decodeMetadata(buf []byte) *metadata {
 	index += n
 	version, n := binary.Varint(buf[index:])
 	index += n
-	size, _ := binary.Varint(buf[index:])
+	size, n := binary.Varint(buf[index:])
 	index += n
 
+	// head和tail和编码时一样使用有符号的变长编码
 	var head, tail uint64
 	if dataType == List {
-		head, n = binary.Uvarint(buf[index:])
+		encHead, n := binary.Varint(buf[index:])
 		index += n
-		tail, _ = binary.Uvarint(buf[index:])
+		encTail, _ := binary.Varint(buf[index:])
+		head, tail = uint64(encHead), uint64(encTail)
 	}
 
 	return &metadata{
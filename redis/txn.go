@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	bitcask "bitcask-go"
+)
+
+// 读取数据的来源，数据库或事务
+type reader interface {
+	Get(key []byte) ([]byte, error)
+}
+
+// 数据结构操作读写数据的目标：单个命令使用 batchStore，每个命令一个批次；RedisTxn 使用存储引擎的事务，多个命令一起提交
+type store interface {
+	reader
+	Put(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// 从数据库读取，写入暂存到批次中，由调用方提交
+type batchStore struct {
+	*bitcask.WriteBatch
+	db *bitcask.DB
+}
+
+func (s batchStore) Get(key []byte) ([]byte, error) {
+	return s.db.Get(key)
+}
+
+// 为单个命令创建批量写入
+func (rds *RedisDataStructure) newBatch() batchStore {
+	return batchStore{
+		WriteBatch: rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions),
+		db:         rds.db,
+	}
+}
+
+// 跨数据类型的事务：多个命令的写入暂存在同一个存储引擎事务中，Commit 时一起生效，Rollback 或提交失败时全部不生效
+// 事务中的读取可以看到之前命令暂存的写入；读取过的key（包括元数据）在提交前被其他写入修改时，Commit 返回 bitcask.ErrConflict
+// 不能在多个协程中同时使用同一个事务
+type RedisTxn struct {
+	txn *bitcask.Txn
+}
+
+// 开启事务
+func (rds *RedisDataStructure) Begin() *RedisTxn {
+	return &RedisTxn{txn: rds.db.Txn()}
+}
+
+// 提交事务，无论成功与否事务都会结束
+func (rt *RedisTxn) Commit(ctx context.Context) error {
+	return rt.txn.Commit(ctx)
+}
+
+// 丢弃事务中暂存的所有写入
+func (rt *RedisTxn) Rollback() error {
+	return rt.txn.Rollback()
+}
+
+func (rt *RedisTxn) Set(key []byte, ttl time.Duration, value []byte) error {
+	if value == nil {
+		return nil
+	}
+	return rt.txn.Put(key, encodeStringValue(stringExpire(ttl), value))
+}
+
+func (rt *RedisTxn) Del(key []byte) error {
+	return rt.txn.Delete(key)
+}
+
+func (rt *RedisTxn) HSet(key, field, value []byte) (bool, error) {
+	return hset(rt.txn, key, field, value)
+}
+
+func (rt *RedisTxn) HDel(key, field []byte) (bool, error) {
+	return hdel(rt.txn, key, field)
+}
+
+func (rt *RedisTxn) SAdd(key, member []byte) (bool, error) {
+	return sadd(rt.txn, key, member)
+}
+
+func (rt *RedisTxn) SRem(key, member []byte) (bool, error) {
+	return srem(rt.txn, key, member)
+}
+
+func (rt *RedisTxn) LPush(key, element []byte) (uint32, error) {
+	return push(rt.txn, key, element, true)
+}
+
+func (rt *RedisTxn) RPush(key, element []byte) (uint32, error) {
+	return push(rt.txn, key, element, false)
+}
+
+func (rt *RedisTxn) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	return zadd(rt.txn, key, score, member)
+}
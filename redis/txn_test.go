@@ -0,0 +1,126 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	bitcask "bitcask-go"
+)
+
+func expectHashAndSet(t *testing.T, rds *RedisDataStructure, fields map[string]string, members ...string) {
+	t.Helper()
+	meta, err := findMetadata(rds.db, []byte("h"), Hash)
+	if err != nil || meta.size != uint32(len(fields)) {
+		t.Fatalf("hash size = %+v, %v", meta, err)
+	}
+	for field, value := range fields {
+		if got, err := rds.HGet([]byte("h"), []byte(field)); err != nil || string(got) != value {
+			t.Fatalf("HGet %s = %q, %v", field, got, err)
+		}
+	}
+	meta, err = findMetadata(rds.db, []byte("s"), Set)
+	if err != nil || meta.size != uint32(len(members)) {
+		t.Fatalf("set size = %+v, %v", meta, err)
+	}
+	for _, member := range members {
+		if ok, err := rds.SIsMember([]byte("s"), []byte(member)); err != nil || !ok {
+			t.Fatalf("SIsMember %s = %v, %v", member, ok, err)
+		}
+	}
+}
+
+func TestRedisTxn(t *testing.T) {
+	errFailed := errors.New("simulated failure")
+	options := bitcask.DefaultOptions
+	options.DirPath = t.TempDir()
+	// 写入包含 fail 的key时失败，模拟提交过程中出错
+	options.BeforeWrite = func(key, value []byte, isDelete bool) error {
+		if bytes.Contains(key, []byte("fail")) {
+			return errFailed
+		}
+		return nil
+	}
+	rds, err := NewRedisDataStructure(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rds.Close()
+
+	// 同一个事务中修改Hash和Set，事务中可以读到之前暂存的写入
+	txn := rds.Begin()
+	if created, err := txn.HSet([]byte("h"), []byte("f1"), []byte("v1")); err != nil || !created {
+		t.Fatalf("HSet = %v, %v", created, err)
+	}
+	if created, err := txn.HSet([]byte("h"), []byte("f1"), []byte("v2")); err != nil || created {
+		t.Fatalf("HSet = %v, %v", created, err)
+	}
+	if added, err := txn.SAdd([]byte("s"), []byte("m1")); err != nil || !added {
+		t.Fatalf("SAdd = %v, %v", added, err)
+	}
+	// 提交之前不可见
+	expectHashAndSet(t, rds, nil)
+	if err := txn.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectHashAndSet(t, rds, map[string]string{"f1": "v2"}, "m1")
+
+	// 提交失败时两个数据结构都不修改
+	txn = rds.Begin()
+	if _, err := txn.HSet([]byte("h"), []byte("f2"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.HDel([]byte("h"), []byte("f1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.SAdd([]byte("s"), []byte("fail")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(context.Background()); err != errFailed {
+		t.Fatalf("expected errFailed, got %v", err)
+	}
+	expectHashAndSet(t, rds, map[string]string{"f1": "v2"}, "m1")
+	if ok, err := rds.SIsMember([]byte("s"), []byte("fail")); err != nil || ok {
+		t.Fatalf("SIsMember = %v, %v", ok, err)
+	}
+
+	// 读取之后元数据被其他写入修改，提交返回冲突
+	txn = rds.Begin()
+	if _, err := txn.HSet([]byte("h"), []byte("f3"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.SRem([]byte("s"), []byte("m1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rds.HSet([]byte("h"), []byte("f4"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(context.Background()); err != bitcask.ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	expectHashAndSet(t, rds, map[string]string{"f1": "v2", "f4": "v"}, "m1")
+
+	// 回滚丢弃所有写入
+	txn = rds.Begin()
+	if _, err := txn.RPush([]byte("l"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.ZAdd([]byte("z"), 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set([]byte("str"), 0, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.SAdd([]byte("s"), []byte("m2")); err != bitcask.ErrTxnFinished {
+		t.Fatalf("expected ErrTxnFinished, got %v", err)
+	}
+	for _, key := range []string{"l", "z", "str"} {
+		if _, err := rds.db.Get([]byte(key)); err != bitcask.ErrKeyNotFound {
+			t.Fatalf("expected %s to be absent, got %v", key, err)
+		}
+	}
+}
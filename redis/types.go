@@ -3,6 +3,7 @@ package redis
 import (
 	"encoding/binary"
 	"errors"
+	"math/bits"
 	"time"
 
 	bitcask "bitcask-go"
@@ -11,6 +12,20 @@ import (
 
 var (
 	ErrWrongTypeOperation = errors.New("wrong Operation against a key holding the wrong kind of value")
+	ErrOffsetOutOfRange   = errors.New("offset is out of range")
+	ErrInvalidFieldValues = errors.New("field and value must appear in pairs")
+	ErrStringTooLong      = errors.New("string exceeds maximum allowed size (512MB)")
+	ErrInvalidLockTTL     = errors.New("lock ttl must be positive")
+	ErrLockNotHeld        = errors.New("lock is not held by this token")
+	ErrLockTimeout        = errors.New("timed out waiting for lock")
+)
+
+const (
+	// String类型value的最大长度，与 Redis 的 proto-max-bulk-len 默认值一致
+	maxStringSize = 512 * 1024 * 1024
+
+	// 位操作的最大偏移（不包含），与 Redis 一致限制为 2^32 位
+	maxBitOffset = 1 << 32
 )
 
 type redisDataType = byte
@@ -48,40 +63,181 @@ func (rds *RedisDataStructure) Set(key []byte, ttl time.Duration, value []byte)
 	if value == nil {
 		return nil
 	}
+	// 写入数据
+	return rds.db.Put(key, encodeStringValue(stringExpire(ttl), value))
+}
 
-	// 新的value：type(数据类型) + expire(过期时间) + payload(原始value)
-	buf := make([]byte, binary.MaxVarintLen64+1)
+// ttl对应的过期时间，为0表示不过期
+func stringExpire(ttl time.Duration) int64 {
+	if ttl == 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
 
-	// 设置数据类型为String
-	buf[0] = String
+func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
+	_, value, err := rds.getStringValue(key)
+	return value, err
+}
 
-	var index = 1
-	var expire int64 = 0
-	if ttl != 0 {
-		expire = time.Now().Add(ttl).UnixNano()
+// 获取value中指定范围的子串，start和end均包含在内，支持负数下标（-1表示最后一个字节）
+func (rds *RedisDataStructure) GetRange(key []byte, start, end int64) ([]byte, error) {
+	_, value, err := rds.getStringValue(key)
+	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return nil, err
 	}
-	// 编码过期时间
-	index += binary.PutVarint(buf[index:], expire)
 
-	// 编码value
-	encValue := make([]byte, index+len(value))
-	copy(encValue[:index], buf[:index])
-	copy(encValue[index:], value)
+	start, end, ok := normalizeRange(start, end, int64(len(value)))
+	if !ok {
+		return []byte{}, nil
+	}
+	return value[start : end+1], nil
+}
 
-	// 写入数据
-	return rds.db.Put(key, encValue)
+// 从offset开始覆盖写入data，offset超出value长度时用0填充，返回写入后value的长度
+// 写入后的长度超过512MB时返回 ErrStringTooLong
+func (rds *RedisDataStructure) SetRange(key []byte, offset int64, data []byte) (int, error) {
+	if offset < 0 {
+		return 0, ErrOffsetOutOfRange
+	}
+
+	expire, value, err := rds.getStringValue(key)
+	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return 0, err
+	}
+
+	// key不存在并且没有要写入的数据，则不创建key
+	if len(data) == 0 {
+		return len(value), nil
+	}
+
+	// 扩展value的长度，新增部分默认为0
+	newLen := offset + int64(len(data))
+	if newLen > maxStringSize {
+		return 0, ErrStringTooLong
+	}
+	if newLen > int64(len(value)) {
+		newValue := make([]byte, newLen)
+		copy(newValue, value)
+		value = newValue
+	}
+	copy(value[offset:], data)
+
+	// 保留原有的过期时间
+	if err = rds.db.Put(key, encodeStringValue(expire, value)); err != nil {
+		return 0, err
+	}
+	return len(value), nil
 }
 
-func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
+// 设置value中指定位的值，返回此位原来的值，offset超出value长度时用0扩展value
+// 位的顺序与 Redis 一致：offset 0 为第一个字节的最高位，offset 需要在 [0, 2^32) 范围内
+func (rds *RedisDataStructure) SetBit(key []byte, offset int64, value bool) (bool, error) {
+	if offset < 0 || offset >= maxBitOffset {
+		return false, ErrOffsetOutOfRange
+	}
+
+	expire, payload, err := rds.getStringValue(key)
+	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return false, err
+	}
+
+	// 扩展value的长度，新增部分默认为0
+	byteIndex := offset / 8
+	if byteIndex >= int64(len(payload)) {
+		newPayload := make([]byte, byteIndex+1)
+		copy(newPayload, payload)
+		payload = newPayload
+	}
+
+	mask := byte(1) << (7 - uint(offset%8))
+	old := payload[byteIndex]&mask != 0
+	if value {
+		payload[byteIndex] |= mask
+	} else {
+		payload[byteIndex] &^= mask
+	}
+
+	// 保留原有的过期时间
+	if err = rds.db.Put(key, encodeStringValue(expire, payload)); err != nil {
+		return false, err
+	}
+	return old, nil
+}
+
+// 获取value中指定位的值，超出value长度的位视为0
+func (rds *RedisDataStructure) GetBit(key []byte, offset int64) (bool, error) {
+	if offset < 0 || offset >= maxBitOffset {
+		return false, ErrOffsetOutOfRange
+	}
+
+	_, payload, err := rds.getStringValue(key)
+	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return false, err
+	}
+
+	byteIndex := offset / 8
+	if byteIndex >= int64(len(payload)) {
+		return false, nil
+	}
+	return payload[byteIndex]&(byte(1)<<(7-uint(offset%8))) != 0, nil
+}
+
+// 统计value中字节范围 [start, end] 内值为1的位数，支持负数下标
+func (rds *RedisDataStructure) BitCount(key []byte, start, end int64) (int64, error) {
+	_, payload, err := rds.getStringValue(key)
+	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return 0, err
+	}
+
+	start, end, ok := normalizeRange(start, end, int64(len(payload)))
+	if !ok {
+		return 0, nil
+	}
+
+	var count int64
+	for _, b := range payload[start : end+1] {
+		count += int64(bits.OnesCount8(b))
+	}
+	return count, nil
+}
+
+// 将 Redis 风格的下标范围（包含两端，支持负数下标）转换为 [0, n) 内的下标，范围为空时返回false
+func normalizeRange(start, end, n int64) (int64, int64, bool) {
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if n == 0 || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// 读取String类型的数据，返回过期时间和实际value
+// key已过期时返回的过期时间为0，value为nil
+func (rds *RedisDataStructure) getStringValue(key []byte) (int64, []byte, error) {
 	encValue, err := rds.db.Get(key)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
+	return decodeStringValue(encValue)
+}
 
+// 解码String类型的value，已过期时返回的过期时间为0，value为nil
+func decodeStringValue(encValue []byte) (int64, []byte, error) {
 	// 解码
 	dataType := encValue[0]
 	if dataType != String {
-		return nil, ErrWrongTypeOperation
+		return 0, nil, ErrWrongTypeOperation
 	}
 
 	// 解码过期时间
@@ -91,17 +247,50 @@ func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
 
 	// 判断是否过期
 	if expire > 0 && expire <= time.Now().UnixNano() {
-		return nil, nil
+		return 0, nil, nil
 	}
 
 	// 返回实际value
-	return encValue[index:], nil
+	return expire, encValue[index:], nil
+}
+
+// 编码String类型的value：type(数据类型) + expire(过期时间) + payload(原始value)
+func encodeStringValue(expire int64, value []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+1)
+
+	// 设置数据类型为String
+	buf[0] = String
+
+	// 编码过期时间
+	var index = 1
+	index += binary.PutVarint(buf[index:], expire)
+
+	// 编码value
+	encValue := make([]byte, index+len(value))
+	copy(encValue[:index], buf[:index])
+	copy(encValue[index:], value)
+	return encValue
 }
 
 // ==============Hash数据结构==============
 func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
+	// 初始化原子写，开启事务
+	wb := rds.newBatch()
+	created, err := hset(wb, key, field, value)
+	if err != nil {
+		return false, err
+	}
+	// 提交事务
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// 在s中写入Hash的field，新增了field返回true
+func hset(s store, key, field, value []byte) (bool, error) {
 	// 查找元数据是否存在
-	meta, err := rds.findMetadata(key, Hash)
+	meta, err := findMetadata(s, key, Hash)
 	if err != nil {
 		return false, err
 	}
@@ -116,26 +305,22 @@ func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
 
 	// 查找数据部分的key是否存在（key+field）
 	var exist = true
-	if _, err = rds.db.Get(encKey); errors.Is(err, bitcask.ErrKeyNotFound) {
+	if _, err = s.Get(encKey); errors.Is(err, bitcask.ErrKeyNotFound) {
 		exist = false
 	}
 
-	// 初始化原子写，开启事务
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-
 	// 如果数据部分的key不存在，代表此次操作是新增操作，需要增加size
 	if !exist {
 		// 增加size
 		meta.size++
 		// 存入实际key和元数据（如果元数据已经写入则更新，如果未写入则新增）
-		_ = wb.Put(key, meta.encode())
+		if err = s.Put(key, meta.encode()); err != nil {
+			return false, err
+		}
 	}
 
 	// 写入数据部分的key和实际value
-	_ = wb.Put(encKey, value)
-
-	// 提交事务
-	if err = wb.Commit(); err != nil {
+	if err = s.Put(encKey, value); err != nil {
 		return false, err
 	}
 
@@ -144,9 +329,43 @@ func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
 	return !exist, nil
 }
 
+// 只有field不存在时才设置，新增了field返回true，field已存在时不修改并返回false
+func (rds *RedisDataStructure) HSetNX(key, field, value []byte) (bool, error) {
+	// 查找元数据是否存在
+	meta, err := findMetadata(rds.db, key, Hash)
+	if err != nil {
+		return false, err
+	}
+
+	// 构造Hash数据部分的key
+	hk := &hashInternalKey{
+		key:     key,
+		version: meta.version,
+		filed:   field,
+	}
+	encKey := hk.encode()
+
+	// 数据部分的key已存在，不做修改
+	if _, err = rds.db.Get(encKey); err == nil {
+		return false, nil
+	} else if !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return false, err
+	}
+
+	// 新增field，在同一个事务中更新元数据的size和数据部分
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	meta.size++
+	_ = wb.Put(key, meta.encode())
+	_ = wb.Put(encKey, value)
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
 	// 查找元数据是否存在
-	meta, err := rds.findMetadata(key, Hash)
+	meta, err := findMetadata(rds.db, key, Hash)
 	if err != nil {
 		return nil, err
 	}
@@ -167,9 +386,124 @@ func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
 	return rds.db.Get(hk.encode())
 }
 
+// 批量设置多个field，参数为交替出现的field和value，所有field在同一个事务中写入
+func (rds *RedisDataStructure) HMSet(key []byte, fieldValues ...[]byte) error {
+	if len(fieldValues) == 0 || len(fieldValues)%2 != 0 {
+		return ErrInvalidFieldValues
+	}
+
+	// 查找元数据是否存在
+	meta, err := findMetadata(rds.db, key, Hash)
+	if err != nil {
+		return err
+	}
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+
+	// 记录此次新增的field，同一个field出现多次时只计算一次
+	created := make(map[string]struct{})
+	for i := 0; i < len(fieldValues); i += 2 {
+		field, value := fieldValues[i], fieldValues[i+1]
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			filed:   field,
+		}
+		encKey := hk.encode()
+
+		// 数据部分的key不存在，代表是新增的field，需要增加size
+		if _, ok := created[string(field)]; !ok {
+			if _, err = rds.db.Get(encKey); errors.Is(err, bitcask.ErrKeyNotFound) {
+				created[string(field)] = struct{}{}
+				meta.size++
+			} else if err != nil {
+				return err
+			}
+		}
+
+		_ = wb.Put(encKey, value)
+	}
+
+	// 有新增的field时，更新元数据
+	if len(created) > 0 {
+		_ = wb.Put(key, meta.encode())
+	}
+
+	return wb.Commit()
+}
+
+// 批量获取多个field的值，按参数顺序返回，不存在的field对应的值为nil
+func (rds *RedisDataStructure) HMGet(key []byte, fields ...[]byte) ([][]byte, error) {
+	// 查找元数据是否存在
+	meta, err := findMetadata(rds.db, key, Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(fields))
+	if meta.size == 0 {
+		// 元数据不存在，所有field都不存在
+		return values, nil
+	}
+
+	for i, field := range fields {
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			filed:   field,
+		}
+		value, err := rds.db.Get(hk.encode())
+		if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// 获取Hash中所有的field和value，按field排序，field和value交替存放；key不存在时返回空
+func (rds *RedisDataStructure) HGetAll(key []byte) ([][]byte, error) {
+	// 查找元数据是否存在
+	meta, err := findMetadata(rds.db, key, Hash)
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 {
+		return nil, nil
+	}
+
+	// 数据部分的key以 key+version 开头，之后是field
+	prefix := (&hashInternalKey{key: key, version: meta.version}).encode()
+	iterator := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: prefix})
+	defer iterator.Close()
+	fieldValues := make([][]byte, 0, meta.size*2)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := iterator.Value()
+		if err != nil {
+			return nil, err
+		}
+		fieldValues = append(fieldValues, iterator.Key()[len(prefix):], value)
+	}
+	return fieldValues, nil
+}
+
 func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
+	wb := rds.newBatch()
+	exist, err := hdel(wb, key, field)
+	if err != nil {
+		return false, err
+	}
+	// 提交事务
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return exist, nil
+}
+
+// 在s中删除Hash的field，field不存在时返回false
+func hdel(s store, key, field []byte) (bool, error) {
 	// 查找元数据是否存在
-	meta, err := rds.findMetadata(key, Hash)
+	meta, err := findMetadata(s, key, Hash)
 	if err != nil {
 		return false, err
 	}
@@ -188,37 +522,88 @@ func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
 	encKey := hk.encode()
 
 	// 查找数据部分的key是否存在
-	var exist = true
-	if _, err = rds.db.Get(encKey); errors.Is(err, bitcask.ErrKeyNotFound) {
-		exist = false
+	if _, err = s.Get(encKey); errors.Is(err, bitcask.ErrKeyNotFound) {
+		// 删除key中的filed，如果filed不存在，返回false
+		return false, nil
 	}
 
-	// 如果数据部分的key存在
-	if exist {
-		// 开启事务
-		wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	// 因为要删除，所以更新元数据的size
+	meta.size--
+	if err = s.Put(key, meta.encode()); err != nil {
+		return false, err
+	}
 
-		// 因为要删除，所以更新元数据的size
-		meta.size--
-		_ = wb.Put(key, meta.encode())
+	// 删除数据部分的key
+	if err = s.Delete(encKey); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-		// 删除数据部分的key
-		_ = wb.Delete(encKey)
+// ==============Set数据结构==============
 
-		// 提交事务
-		if err = wb.Commit(); err != nil {
-			return false, nil
+// 添加member期间Set被其他写入创建或重新创建（只在 SAdd 内部使用，不返回给调用方）
+var errSetVersionChanged = errors.New("set version changed")
+
+// 数据部分的key通过 PutIfAbsent 写入，并发添加同一个member时只有一个返回true；之后再原子地增加元数据中的size
+// 并发创建同一个Set时各自生成不同的version，只有第一个写入的元数据生效，其他的在生效的version下重新添加member
+func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
+	for {
+		// 查找元数据
+		meta, err := findMetadata(rds.db, key, Set)
+		if err != nil {
+			return false, err
 		}
-	}
 
-	// 删除key中的filed，如果filed不存在，返回false
-	return exist, nil
+		// 构造数据部分的key
+		sk := &setInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+		encKey := sk.encode()
+
+		// member已存在时不修改，返回false
+		added, err := rds.db.PutIfAbsent(encKey, nil)
+		if err != nil || !added {
+			return false, err
+		}
+
+		// 在最新的元数据上增加size；Set在此期间被其他写入创建或重新创建时不覆盖它的元数据
+		err = rds.db.Update(key, func(oldValue []byte) ([]byte, error) {
+			current := meta
+			if oldValue != nil {
+				old := decodeMetadata(oldValue)
+				if old.dataType != Set {
+					return nil, ErrWrongTypeOperation
+				}
+				if old.version != meta.version && (old.expire == 0 || old.expire > time.Now().UnixNano()) {
+					return nil, errSetVersionChanged
+				}
+				if old.version == meta.version {
+					current = old
+				}
+			}
+			current.size++
+			return current.encode(), nil
+		})
+		if err == nil {
+			return true, nil
+		}
+		// 撤回写入旧version下的member，在当前生效的version下重新添加
+		if deleteErr := rds.db.Delete(encKey); deleteErr != nil {
+			return false, errors.Join(err, deleteErr)
+		}
+		if !errors.Is(err, errSetVersionChanged) {
+			return false, err
+		}
+	}
 }
 
-// ==============Set数据结构==============
-func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
+// 在s中向Set添加member，member已存在时返回false
+func sadd(s store, key, member []byte) (bool, error) {
 	// 查找元数据
-	meta, err := rds.findMetadata(key, Set)
+	meta, err := findMetadata(s, key, Set)
 	if err != nil {
 		return false, err
 	}
@@ -230,30 +615,28 @@ func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
 		member:  member,
 	}
 
-	var ok bool
-	if _, err = rds.db.Get(sk.encode()); errors.Is(err, bitcask.ErrKeyNotFound) {
-		// 如果key不存在，则新增
-		wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-		meta.size++
-		// 更新元数据
-		_ = wb.Put(key, meta.encode())
-		// 更新数据部分
-		_ = wb.Put(sk.encode(), nil)
-		if err = wb.Commit(); err != nil {
-			return false, err
-		}
-
-		ok = true
+	// 只有key不存在才可以SAdd，如果key已存在，则返回false
+	if _, err = s.Get(sk.encode()); !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return false, nil
 	}
 
-	// 只有key不存在才可以SAdd，如果key已存在，则返回false
-	return ok, nil
+	// 如果key不存在，则新增
+	meta.size++
+	// 更新元数据
+	if err = s.Put(key, meta.encode()); err != nil {
+		return false, err
+	}
+	// 更新数据部分
+	if err = s.Put(sk.encode(), nil); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // 查找key下的member是否存在
 func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
 	// 查找元数据
-	meta, err := rds.findMetadata(key, Set)
+	meta, err := findMetadata(rds.db, key, Set)
 	if err != nil {
 		return false, err
 	}
@@ -284,15 +667,28 @@ func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
 
 // 删除key下的member
 func (rds *RedisDataStructure) SRem(key, member []byte) (bool, error) {
+	wb := rds.newBatch()
+	ok, err := srem(wb, key, member)
+	if err != nil {
+		return false, err
+	}
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// 在s中删除Set的member，member不存在时返回false
+func srem(s store, key, member []byte) (bool, error) {
 	// 查找元数据
-	meta, err := rds.findMetadata(key, Set)
+	meta, err := findMetadata(s, key, Set)
 	if err != nil {
 		return false, err
 	}
 
 	if meta.size == 0 {
 		// 如果元数据原本不存在，刚被初始化
-		return false, err
+		return false, nil
 	}
 
 	// 构造数据部分的key
@@ -302,17 +698,17 @@ func (rds *RedisDataStructure) SRem(key, member []byte) (bool, error) {
 		member:  member,
 	}
 
-	if _, err = rds.db.Get(sk.encode()); errors.Is(err, bitcask.ErrKeyNotFound) {
+	if _, err = s.Get(sk.encode()); errors.Is(err, bitcask.ErrKeyNotFound) {
 		return false, nil
 	}
 
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
 	meta.size--
 	// 更新元数据
-	_ = wb.Put(key, meta.encode())
+	if err = s.Put(key, meta.encode()); err != nil {
+		return false, err
+	}
 	// 删除数据部分的member
-	_ = wb.Delete(sk.encode())
-	if err = wb.Commit(); err != nil {
+	if err = s.Delete(sk.encode()); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -329,8 +725,21 @@ func (rds *RedisDataStructure) RPush(key, element []byte) (uint32, error) {
 
 // 插入数据，返回key下数据的数量
 func (rds *RedisDataStructure) pushInner(key, element []byte, isLeft bool) (uint32, error) {
+	wb := rds.newBatch()
+	size, err := push(wb, key, element, isLeft)
+	if err != nil {
+		return 0, err
+	}
+	if err = wb.Commit(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// 在s中向List的左边或右边插入元素，返回插入后List的长度
+func push(s store, key, element []byte, isLeft bool) (uint32, error) {
 	// 查找元数据
-	meta, err := rds.findMetadata(key, List)
+	meta, err := findMetadata(s, key, List)
 	if err != nil {
 		return 0, err
 	}
@@ -349,7 +758,6 @@ func (rds *RedisDataStructure) pushInner(key, element []byte, isLeft bool) (uint
 		lk.index = meta.tail
 	}
 
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
 	meta.size++
 	if isLeft {
 		meta.head--
@@ -357,13 +765,13 @@ func (rds *RedisDataStructure) pushInner(key, element []byte, isLeft bool) (uint
 		meta.tail++
 	}
 	// 更新元数据
-	_ = wb.Put(key, meta.encode())
+	if err = s.Put(key, meta.encode()); err != nil {
+		return 0, err
+	}
 	// 更新数据部分
-	_ = wb.Put(lk.encode(), element)
-	if err = wb.Commit(); err != nil {
+	if err = s.Put(lk.encode(), element); err != nil {
 		return 0, err
 	}
-
 	return meta.size, nil
 }
 
@@ -378,7 +786,7 @@ func (rds *RedisDataStructure) RPop(key []byte) ([]byte, error) {
 // 删除数据，返回被删除的数据和错误
 func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error) {
 	// 查找元数据
-	meta, err := rds.findMetadata(key, List)
+	meta, err := findMetadata(rds.db, key, List)
 	if err != nil {
 		return nil, err
 	}
@@ -420,9 +828,71 @@ func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error)
 	return element, nil
 }
 
+// 只保留List中 [start, stop] 范围内的元素（包含两端，支持负数下标），删除其余元素
+// 删除元素和更新元数据在同一个批量写入中完成，范围为空时删除整个List
+func (rds *RedisDataStructure) LTrim(key []byte, start, stop int64) error {
+	meta, err := findMetadata(rds.db, key, List)
+	if err != nil {
+		return err
+	}
+
+	first, last, ok := normalizeRange(start, stop, int64(meta.size))
+
+	// 批量写入的数量上限需要容纳所有被删除的元素以及元数据
+	opts := bitcask.DefaultWriteBatchOptions
+	if uint(meta.size)+1 > opts.MaxBatchNum {
+		opts.MaxBatchNum = uint(meta.size) + 1
+	}
+	wb := rds.db.NewWriteBatch(opts)
+
+	// 删除范围之外的元素
+	lk := &listInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	for i := int64(0); i < int64(meta.size); i++ {
+		if ok && i >= first && i <= last {
+			continue
+		}
+		lk.index = meta.head + uint64(i)
+		if err := wb.Delete(lk.encode()); err != nil {
+			return err
+		}
+	}
+
+	if !ok {
+		// 范围为空，删除元数据
+		if err := wb.Delete(key); err != nil {
+			return err
+		}
+	} else {
+		// 更新元数据
+		meta.tail = meta.head + uint64(last) + 1
+		meta.head += uint64(first)
+		meta.size = uint32(last - first + 1)
+		if err := wb.Put(key, meta.encode()); err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
 // ==============ZSet数据结构==============
 func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, ZSet)
+	wb := rds.newBatch()
+	created, err := zadd(wb, key, score, member)
+	if err != nil {
+		return false, err
+	}
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// 在s中向ZSet添加member或更新member的score，新增了member返回true
+func zadd(s store, key []byte, score float64, member []byte) (bool, error) {
+	meta, err := findMetadata(s, key, ZSet)
 	if err != nil {
 		return false, err
 	}
@@ -439,7 +909,7 @@ func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (b
 	var exist = true
 
 	// 先根据member key寻找score
-	oldScore, err := rds.db.Get(zk.encodeWithMember())
+	oldScore, err := s.Get(zk.encodeWithMember())
 	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
 		return false, err
 	}
@@ -453,19 +923,6 @@ func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (b
 		if score == utils.Float64FromBytes(oldScore) {
 			return false, nil
 		}
-	}
-
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-
-	// 如果member key不存在（1.此key的元数据不存在，2.元数据存在，但是此member不存在）
-	if !exist {
-		// 更新元数据（不存在则新增，存在则更新）
-		meta.size++
-		_ = wb.Put(key, meta.encode())
-	}
-
-	// 如果member key存在
-	if exist {
 		// 删除旧的score key
 		oldKey := &zsetInternalKey{
 			key:     key,
@@ -473,28 +930,37 @@ func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (b
 			member:  member,
 			score:   utils.Float64FromBytes(oldScore),
 		}
-		_ = wb.Delete(oldKey.encodeWithScore())
+		if err = s.Delete(oldKey.encodeWithScore()); err != nil {
+			return false, err
+		}
+	} else {
+		// 如果member key不存在（1.此key的元数据不存在，2.元数据存在，但是此member不存在）
+		// 更新元数据（不存在则新增，存在则更新）
+		meta.size++
+		if err = s.Put(key, meta.encode()); err != nil {
+			return false, err
+		}
 	}
 
 	// 更新或插入member key 和 score key
-	_ = wb.Put(zk.encodeWithMember(), utils.Float64ToBytes(score))
-	_ = wb.Put(zk.encodeWithScore(), nil)
-
-	if err = wb.Commit(); err != nil {
+	if err = s.Put(zk.encodeWithMember(), utils.Float64ToBytes(score)); err != nil {
+		return false, err
+	}
+	if err = s.Put(zk.encodeWithScore(), nil); err != nil {
 		return false, err
 	}
-
 	return !exist, nil
 }
 
 func (rds *RedisDataStructure) ZScore(key, member []byte) (float64, error) {
 	// 查找元数据
-	meta, err := rds.findMetadata(key, ZSet)
+	meta, err := findMetadata(rds.db, key, ZSet)
 	if err != nil {
 		return -1, err
 	}
+	// key不存在时和member不存在一样返回 ErrKeyNotFound
 	if meta.size == 0 {
-		return -1, nil
+		return -1, bitcask.ErrKeyNotFound
 	}
 
 	// 构造数据部分的key
@@ -515,8 +981,8 @@ func (rds *RedisDataStructure) ZScore(key, member []byte) (float64, error) {
 
 // 查找元数据（根据key和type）
 // 如果元数据存在则返回，如果不存在则初始化一个元数据（未写入存储引擎）
-func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType) (*metadata, error) {
-	metaBuf, err := rds.db.Get(key)
+func findMetadata(r reader, key []byte, dataType redisDataType) (*metadata, error) {
+	metaBuf, err := r.Get(key)
 	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
 		// 如果出现错误，并且错误不是key没有找到，则直接返回（key没有找到的错误需要单独处理）
 		return nil, err
@@ -552,12 +1018,12 @@ func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType)
 			version:  time.Now().UnixNano(),
 			size:     0,
 		}
-	}
 
-	if dataType == List {
-		// 如果是List类型，初始化head和tail
-		meta.head = initialListMark
-		meta.tail = initialListMark
+		if dataType == List {
+			// 如果是List类型，初始化head和tail（已存在的List使用元数据中保存的head和tail）
+			meta.head = initialListMark
+			meta.tail = initialListMark
+		}
 	}
 
 	return meta, nil
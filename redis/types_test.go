@@ -0,0 +1,509 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	bitcask "bitcask-go"
+	"bitcask-go/data"
+)
+
+// 在临时目录中打开Redis数据结构服务，测试结束时自动关闭
+func openTestRDS(t *testing.T) *RedisDataStructure {
+	t.Helper()
+	options := bitcask.DefaultOptions
+	options.DirPath = t.TempDir()
+	rds, err := NewRedisDataStructure(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = rds.Close()
+	})
+	return rds
+}
+
+func TestRedisDataStructure_SetRange(t *testing.T) {
+	rds := openTestRDS(t)
+	if err := rds.Set([]byte("k"), 0, []byte("Hello World")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 覆盖中间的部分
+	n, err := rds.SetRange([]byte("k"), 6, []byte("Redis"))
+	if err != nil || n != 11 {
+		t.Fatalf("SetRange = %d, %v", n, err)
+	}
+	if value, _ := rds.Get([]byte("k")); string(value) != "Hello Redis" {
+		t.Fatalf("value = %q", value)
+	}
+
+	// offset超出value长度时用0填充
+	n, err = rds.SetRange([]byte("padded"), 3, []byte("ab"))
+	if err != nil || n != 5 {
+		t.Fatalf("SetRange padded = %d, %v", n, err)
+	}
+	if value, _ := rds.Get([]byte("padded")); !bytes.Equal(value, []byte{0, 0, 0, 'a', 'b'}) {
+		t.Fatalf("padded value = %q", value)
+	}
+
+	// 空数据不创建key
+	n, err = rds.SetRange([]byte("missing"), 10, nil)
+	if err != nil || n != 0 {
+		t.Fatalf("SetRange empty = %d, %v", n, err)
+	}
+	if _, err := rds.Get([]byte("missing")); !errors.Is(err, bitcask.ErrKeyNotFound) {
+		t.Fatalf("get missing: %v", err)
+	}
+}
+
+func TestRedisDataStructure_SetRangeOutOfRange(t *testing.T) {
+	rds := openTestRDS(t)
+	if _, err := rds.SetRange([]byte("k"), -1, []byte("a")); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Fatalf("negative offset: %v", err)
+	}
+	if _, err := rds.SetRange([]byte("k"), maxStringSize, []byte("a")); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("offset at max size: %v", err)
+	}
+	if _, err := rds.SetRange([]byte("k"), maxStringSize-1, []byte("ab")); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("data crossing max size: %v", err)
+	}
+	if _, err := rds.Get([]byte("k")); !errors.Is(err, bitcask.ErrKeyNotFound) {
+		t.Fatalf("key created by rejected SetRange: %v", err)
+	}
+}
+
+func TestRedisDataStructure_GetRange(t *testing.T) {
+	rds := openTestRDS(t)
+	if err := rds.Set([]byte("k"), 0, []byte("This is a string")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		start, end int64
+		want       string
+	}{
+		{0, 3, "This"},
+		{-3, -1, "ing"},
+		{0, -1, "This is a string"},
+		{10, 100, "string"},
+		{-100, 3, "This"},
+		{5, 3, ""},
+		{100, 200, ""},
+	}
+	for _, tt := range tests {
+		value, err := rds.GetRange([]byte("k"), tt.start, tt.end)
+		if err != nil || string(value) != tt.want {
+			t.Fatalf("GetRange(%d, %d) = %q, %v, want %q", tt.start, tt.end, value, err, tt.want)
+		}
+	}
+
+	// key不存在时返回空字符串
+	if value, err := rds.GetRange([]byte("missing"), 0, -1); err != nil || len(value) != 0 {
+		t.Fatalf("GetRange missing = %q, %v", value, err)
+	}
+}
+
+func TestRedisDataStructure_SetBit(t *testing.T) {
+	rds := openTestRDS(t)
+
+	// offset 0 为第一个字节的最高位，超出长度时用0扩展
+	old, err := rds.SetBit([]byte("k"), 7, true)
+	if err != nil || old {
+		t.Fatalf("SetBit(7) = %v, %v", old, err)
+	}
+	old, err = rds.SetBit([]byte("k"), 8, true)
+	if err != nil || old {
+		t.Fatalf("SetBit(8) = %v, %v", old, err)
+	}
+	if value, _ := rds.Get([]byte("k")); !bytes.Equal(value, []byte{0x01, 0x80}) {
+		t.Fatalf("value = %x", value)
+	}
+
+	// 返回此位原来的值
+	old, err = rds.SetBit([]byte("k"), 7, false)
+	if err != nil || !old {
+		t.Fatalf("SetBit(7, false) = %v, %v", old, err)
+	}
+	for offset, want := range map[int64]bool{7: false, 8: true, 1000: false} {
+		if bit, err := rds.GetBit([]byte("k"), offset); err != nil || bit != want {
+			t.Fatalf("GetBit(%d) = %v, %v", offset, bit, err)
+		}
+	}
+	if count, err := rds.BitCount([]byte("k"), 0, -1); err != nil || count != 1 {
+		t.Fatalf("BitCount = %d, %v", count, err)
+	}
+}
+
+func TestRedisDataStructure_SetBitOutOfRange(t *testing.T) {
+	rds := openTestRDS(t)
+	for _, offset := range []int64{-1, maxBitOffset, maxBitOffset + 8} {
+		if _, err := rds.SetBit([]byte("k"), offset, true); !errors.Is(err, ErrOffsetOutOfRange) {
+			t.Fatalf("SetBit(%d): %v", offset, err)
+		}
+		if _, err := rds.GetBit([]byte("k"), offset); !errors.Is(err, ErrOffsetOutOfRange) {
+			t.Fatalf("GetBit(%d): %v", offset, err)
+		}
+	}
+	if _, err := rds.Get([]byte("k")); !errors.Is(err, bitcask.ErrKeyNotFound) {
+		t.Fatalf("key created by rejected SetBit: %v", err)
+	}
+
+}
+
+// 读取hash元数据中记录的field数量
+func hashSize(t *testing.T, rds *RedisDataStructure, key []byte) uint32 {
+	t.Helper()
+	meta, err := findMetadata(rds.db, key, Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta.size
+}
+
+func TestRedisDataStructure_HMSet(t *testing.T) {
+	rds := openTestRDS(t)
+	key := []byte("h")
+	if _, err := rds.HSet(key, []byte("f1"), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rds.HSet(key, []byte("f2"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 覆盖已有的f1，新增f3和f4，重复出现的f3只计算一次
+	err := rds.HMSet(key,
+		[]byte("f1"), []byte("new1"),
+		[]byte("f3"), []byte("v3"),
+		[]byte("f4"), []byte("v4"),
+		[]byte("f3"), []byte("new3"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := hashSize(t, rds, key); size != 4 {
+		t.Fatalf("expected size 4, got %d", size)
+	}
+
+	values, err := rds.HMGet(key, []byte("f1"), []byte("missing"), []byte("f2"), []byte("f3"), []byte("f4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{[]byte("new1"), nil, []byte("v2"), []byte("new3"), []byte("v4")}
+	for i := range expected {
+		if !bytes.Equal(values[i], expected[i]) || (expected[i] == nil) != (values[i] == nil) {
+			t.Fatalf("HMGet[%d] = %q, want %q", i, values[i], expected[i])
+		}
+	}
+
+	// 只覆盖已有field时size不变
+	if err := rds.HMSet(key, []byte("f2"), []byte("new2")); err != nil {
+		t.Fatal(err)
+	}
+	if size := hashSize(t, rds, key); size != 4 {
+		t.Fatalf("expected size 4 after overwrite, got %d", size)
+	}
+	if ok, err := rds.HDel(key, []byte("f4")); err != nil || !ok {
+		t.Fatalf("HDel = %v, %v", ok, err)
+	}
+	if size := hashSize(t, rds, key); size != 3 {
+		t.Fatalf("expected size 3 after delete, got %d", size)
+	}
+}
+
+func TestRedisDataStructure_HMSetInvalid(t *testing.T) {
+	rds := openTestRDS(t)
+	if err := rds.HMSet([]byte("h"), []byte("f1")); !errors.Is(err, ErrInvalidFieldValues) {
+		t.Fatalf("expected ErrInvalidFieldValues, got %v", err)
+	}
+	if err := rds.Set([]byte("s"), 0, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.HMSet([]byte("s"), []byte("f"), []byte("v")); !errors.Is(err, ErrWrongTypeOperation) {
+		t.Fatalf("expected ErrWrongTypeOperation, got %v", err)
+	}
+	if _, err := rds.HMGet([]byte("s"), []byte("f")); !errors.Is(err, ErrWrongTypeOperation) {
+		t.Fatalf("expected ErrWrongTypeOperation, got %v", err)
+	}
+
+	// 不存在的hash返回全为nil的结果
+	values, err := rds.HMGet([]byte("missing"), []byte("a"), []byte("b"))
+	if err != nil || len(values) != 2 || values[0] != nil || values[1] != nil {
+		t.Fatalf("HMGet missing = %q, %v", values, err)
+	}
+}
+
+func TestRedisDataStructure_HSetNX(t *testing.T) {
+	rds := openTestRDS(t)
+	key := []byte("h")
+
+	// 第一次写入新增field
+	created, err := rds.HSetNX(key, []byte("f"), []byte("v1"))
+	if err != nil || !created {
+		t.Fatalf("HSetNX first = %v, %v", created, err)
+	}
+	if size := hashSize(t, rds, key); size != 1 {
+		t.Fatalf("expected size 1, got %d", size)
+	}
+
+	// 重复写入不修改已有的值，size不变
+	created, err = rds.HSetNX(key, []byte("f"), []byte("v2"))
+	if err != nil || created {
+		t.Fatalf("HSetNX repeat = %v, %v", created, err)
+	}
+	if value, err := rds.HGet(key, []byte("f")); err != nil || string(value) != "v1" {
+		t.Fatalf("HGet = %q, %v", value, err)
+	}
+	if size := hashSize(t, rds, key); size != 1 {
+		t.Fatalf("expected size 1 after repeat, got %d", size)
+	}
+
+	// 新增另一个field
+	created, err = rds.HSetNX(key, []byte("g"), []byte("v3"))
+	if err != nil || !created {
+		t.Fatalf("HSetNX second field = %v, %v", created, err)
+	}
+	if size := hashSize(t, rds, key); size != 2 {
+		t.Fatalf("expected size 2, got %d", size)
+	}
+}
+
+func TestRedisDataStructure_HSetNXWrongType(t *testing.T) {
+	rds := openTestRDS(t)
+	if err := rds.Set([]byte("s"), 0, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rds.HSetNX([]byte("s"), []byte("f"), []byte("v")); !errors.Is(err, ErrWrongTypeOperation) {
+		t.Fatalf("expected ErrWrongTypeOperation, got %v", err)
+	}
+	if value, err := rds.Get([]byte("s")); err != nil || string(value) != "v" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+}
+
+// 依次写入元素 e0..e(n-1)
+func pushList(t *testing.T, rds *RedisDataStructure, key []byte, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := rds.RPush(key, []byte{'e', byte('0' + i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 从左边弹出所有元素，检查剩余的元素以及数据部分的key都已被删除
+func expectList(t *testing.T, rds *RedisDataStructure, key []byte, expected ...string) {
+	t.Helper()
+	if count, err := rds.db.CountKeys(nil); err != nil || count != len(expected)+1 {
+		t.Fatalf("expected %d keys, got %d, %v", len(expected)+1, count, err)
+	}
+	for _, element := range expected {
+		value, err := rds.LPop(key)
+		if err != nil || string(value) != element {
+			t.Fatalf("LPop = %q, %v, expected %q", value, err, element)
+		}
+	}
+	if value, err := rds.LPop(key); err != nil || value != nil {
+		t.Fatalf("expected empty list, got %q, %v", value, err)
+	}
+}
+
+func TestRedisDataStructure_LTrim(t *testing.T) {
+	rds := openTestRDS(t)
+	key := []byte("list")
+	pushList(t, rds, key, 5)
+	if err := rds.LTrim(key, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	// 裁剪之后仍然可以从两端写入
+	if _, err := rds.LPush(key, []byte("l")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rds.RPush(key, []byte("r")); err != nil {
+		t.Fatal(err)
+	}
+	expectList(t, rds, key, "l", "e1", "e2", "e3", "r")
+}
+
+func TestRedisDataStructure_LTrimNegative(t *testing.T) {
+	rds := openTestRDS(t)
+	key := []byte("list")
+	pushList(t, rds, key, 5)
+	// 超出范围的下标被截断到列表的两端
+	if err := rds.LTrim(key, -3, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.LTrim(key, -100, -2); err != nil {
+		t.Fatal(err)
+	}
+	expectList(t, rds, key, "e2", "e3")
+}
+
+func TestRedisDataStructure_LTrimToEmpty(t *testing.T) {
+	rds := openTestRDS(t)
+	key := []byte("list")
+	pushList(t, rds, key, 3)
+	if err := rds.LTrim(key, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	// 元数据和所有元素都被删除
+	if count, err := rds.db.CountKeys(nil); err != nil || count != 0 {
+		t.Fatalf("expected no keys, got %d, %v", count, err)
+	}
+	if _, err := rds.Type(key); !errors.Is(err, bitcask.ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	// 不存在的key直接返回
+	if err := rds.LTrim([]byte("missing"), 0, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.Set([]byte("s"), 0, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.LTrim([]byte("s"), 0, -1); !errors.Is(err, ErrWrongTypeOperation) {
+		t.Fatalf("expected ErrWrongTypeOperation, got %v", err)
+	}
+}
+
+// 并发添加相同的member时只有一个返回true，size只增加一次
+func TestRedisDataStructure_SAddConcurrent(t *testing.T) {
+	rds := openTestRDS(t)
+	if _, err := rds.SAdd([]byte("s"), []byte("m0")); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var added atomic.Int32
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := rds.SAdd([]byte("s"), []byte("m1"))
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				added.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if added.Load() != 1 {
+		t.Fatalf("expected exactly one SAdd to add the member, got %d", added.Load())
+	}
+	meta, err := findMetadata(rds.db, []byte("s"), Set)
+	if err != nil || meta.size != 2 {
+		t.Fatalf("set size = %+v, %v", meta, err)
+	}
+	if ok, err := rds.SAdd([]byte("s"), []byte("m0")); err != nil || ok {
+		t.Fatalf("SAdd existing member = %v, %v", ok, err)
+	}
+}
+
+// 并发创建同一个Set时，每个返回true的member都能在最终生效的Set中找到
+func TestRedisDataStructure_SAddConcurrentCreate(t *testing.T) {
+	rds := openTestRDS(t)
+	for round := 0; round < 100; round++ {
+		key := []byte(fmt.Sprintf("s-%d", round))
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		results := make([]bool, 16)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				ok, err := rds.SAdd(key, []byte(fmt.Sprintf("m%d", i)))
+				if err != nil {
+					t.Error(err)
+				}
+				results[i] = ok
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+
+		for i, ok := range results {
+			if !ok {
+				t.Fatalf("round %d: SAdd of a new member m%d returned false", round, i)
+			}
+			if isMember, err := rds.SIsMember(key, []byte(fmt.Sprintf("m%d", i))); err != nil || !isMember {
+				t.Fatalf("round %d: member m%d is lost: %v, %v", round, i, isMember, err)
+			}
+		}
+		meta, err := findMetadata(rds.db, key, Set)
+		if err != nil || meta.size != uint32(len(results)) {
+			t.Fatalf("round %d: set size = %+v, %v", round, meta, err)
+		}
+	}
+}
+
+// 添加member和更新元数据之间Set被另一个SAdd创建：在生效的version下重新添加，两个member都保留
+func TestRedisDataStructure_SAddCreatedInBetween(t *testing.T) {
+	var rds *RedisDataStructure
+	var interleave atomic.Bool
+	options := bitcask.DefaultOptions
+	options.DirPath = t.TempDir()
+	options.Hooks.OnPut = func(key, value []byte, pos *data.LogRecordPos) {
+		// 第一个SAdd写入member之后、更新元数据之前，另一个SAdd完整地创建了Set
+		if len(value) == 0 && interleave.CompareAndSwap(true, false) {
+			if ok, err := rds.SAdd([]byte("s"), []byte("other")); err != nil || !ok {
+				t.Errorf("nested SAdd = %v, %v", ok, err)
+			}
+		}
+	}
+	rds, err := NewRedisDataStructure(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rds.Close()
+
+	interleave.Store(true)
+	if ok, err := rds.SAdd([]byte("s"), []byte("m")); err != nil || !ok {
+		t.Fatalf("SAdd = %v, %v", ok, err)
+	}
+	for _, member := range []string{"m", "other"} {
+		if isMember, err := rds.SIsMember([]byte("s"), []byte(member)); err != nil || !isMember {
+			t.Fatalf("member %s is lost: %v, %v", member, isMember, err)
+		}
+	}
+	meta, err := findMetadata(rds.db, []byte("s"), Set)
+	if err != nil || meta.size != 2 {
+		t.Fatalf("set size = %+v, %v", meta, err)
+	}
+}
+
+func TestRedisDataStructure_HGetAll(t *testing.T) {
+	rds := openTestRDS(t)
+	if fieldValues, err := rds.HGetAll([]byte("h")); err != nil || len(fieldValues) != 0 {
+		t.Fatalf("HGetAll missing = %q, %v", fieldValues, err)
+	}
+	if err := rds.HMSet([]byte("h"), []byte("b"), []byte("2"), []byte("a"), []byte("1"), []byte("c"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	// 前缀相同的其他key不会被列出
+	if _, err := rds.HSet([]byte("hh"), []byte("x"), []byte("y")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rds.HDel([]byte("h"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	fieldValues, err := rds.HGetAll([]byte("h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.Join(fieldValues, []byte(",")); string(got) != "a,1,b,2" {
+		t.Fatalf("HGetAll = %s", got)
+	}
+	if err := rds.Set([]byte("s"), 0, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rds.HGetAll([]byte("s")); !errors.Is(err, ErrWrongTypeOperation) {
+		t.Fatalf("expected ErrWrongTypeOperation, got %v", err)
+	}
+}
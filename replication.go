@@ -0,0 +1,317 @@
+package bitcask_go
+
+import (
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"bitcask-go/data"
+)
+
+// 每个复制流缓存的实时事件数量上限，超出后丢弃缓存的事件，改为从数据文件追赶
+const replicationPendingSize = 4096
+
+// 复制事件，对应一次已提交的写入或删除
+type ReplicationEvent struct {
+	SeqNo     uint64       // 复制序列号，由记录在数据文件中的位置构成（文件id << 32 | 偏移），按写入顺序递增
+	Key       []byte       // 实际key
+	Value     []byte       // 写入的value，删除时为nil
+	Type      KeyEventType // KeyEventPut、KeyEventDelete 或 KeyEventFlushAll（跟随者需要清空数据）
+	Timestamp int64        // 提交时间，Unix纳秒；从数据文件回放的历史记录没有保存写入时间，为0
+	Expire    int64        // 写入的key的过期时间，Unix纳秒，为0表示不过期
+}
+
+// 复制流
+type replicationFeed struct {
+	ch   chan *ReplicationEvent
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+
+	mu       sync.Mutex
+	pending  []*ReplicationEvent // 等待发送的实时事件
+	overflow bool                // 实时事件超出缓存上限，需要从数据文件追赶
+	notify   chan struct{}       // 有新的实时事件
+}
+
+// 订阅从 fromSeqNo 开始（包含）的所有已提交的写入，返回按复制序列号递增的事件通道以及取消订阅的函数
+// 先从数据文件回放订阅时已经写入的记录，之后转为接收实时事件；批量写入的记录在事务完成之后才会发送
+// 跟随者记录收到的最后一个事件的 SeqNo，断开之后从 SeqNo+1 继续订阅
+// merge在重新打开数据库时生效，被merge重写的文件中只保留有效数据，从这些文件中间继续订阅会丢失其中的删除，需要从0重新同步
+// 取消订阅或关闭数据库后通道会被关闭
+func (db *DB) Replication(fromSeqNo uint64) (<-chan *ReplicationEvent, func(), error) {
+	if db.closed.Load() {
+		return nil, nil, ErrDatabaseClosed
+	}
+	if db.options.DataFileSize > math.MaxUint32 {
+		return nil, nil, ErrReplicationUnsupported
+	}
+
+	feed := &replicationFeed{
+		ch:     make(chan *ReplicationEvent),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		notify: make(chan struct{}, 1),
+	}
+
+	// 持有写锁时记录当前的写入位置并注册，之后的写入都会作为实时事件收到
+	db.mu.Lock()
+	end := db.replicationEnd()
+	db.replMu.Lock()
+	db.replicas[feed] = struct{}{}
+	db.replMu.Unlock()
+	db.mu.Unlock()
+
+	go db.runReplication(feed, fromSeqNo, end)
+
+	cancel := func() {
+		db.replMu.Lock()
+		delete(db.replicas, feed)
+		db.replMu.Unlock()
+		feed.close()
+	}
+	return feed.ch, cancel, nil
+}
+
+func (feed *replicationFeed) close() {
+	feed.once.Do(func() { close(feed.stop) })
+	<-feed.done
+}
+
+// 复制序列号
+func replicationSeqNo(fid uint32, offset int64) uint64 {
+	return uint64(fid)<<32 | uint64(offset)
+}
+
+// 当前的写入位置（调用方需持有写锁）
+func (db *DB) replicationEnd() uint64 {
+	if db.activeFile == nil {
+		return 0
+	}
+	return replicationSeqNo(db.activeFile.FileId, db.activeFile.WriteOff)
+}
+
+// 通知复制流有新的写入（调用方需保证按写入顺序调用）
+func (db *DB) notifyReplicas(key, value []byte, eventType KeyEventType, pos *data.LogRecordPos) {
+	db.replMu.RLock()
+	defer db.replMu.RUnlock()
+	if len(db.replicas) == 0 {
+		return
+	}
+
+	event := &ReplicationEvent{
+		SeqNo:     replicationSeqNo(pos.Fid, pos.Offset),
+		Key:       append([]byte(nil), key...),
+		Type:      eventType,
+		Timestamp: time.Now().UnixNano(),
+	}
+	if eventType == KeyEventPut {
+		event.Value = append([]byte{}, value...)
+		event.Expire = pos.Expire
+	}
+	for feed := range db.replicas {
+		feed.mu.Lock()
+		if len(feed.pending) >= replicationPendingSize {
+			// 跟随者处理不及时，丢弃缓存的事件，由复制协程从数据文件追赶
+			feed.pending = nil
+			feed.overflow = true
+		} else if !feed.overflow {
+			feed.pending = append(feed.pending, event)
+		}
+		feed.mu.Unlock()
+
+		select {
+		case feed.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 关闭所有复制流并等待复制协程退出
+func (db *DB) closeReplicas() {
+	db.replMu.Lock()
+	feeds := db.replicas
+	db.replicas = make(map[*replicationFeed]struct{})
+	db.replMu.Unlock()
+	for feed := range feeds {
+		feed.close()
+	}
+}
+
+// 复制协程：回放数据文件中 [next, end) 范围内的记录，再发送实时事件；实时事件溢出时重新从数据文件追赶
+func (db *DB) runReplication(feed *replicationFeed, next, end uint64) {
+	defer close(feed.done)
+	defer close(feed.ch)
+
+	for {
+		var ok bool
+		if next, ok = db.replayLog(feed, next, end); !ok {
+			return
+		}
+
+		for {
+			feed.mu.Lock()
+			events, overflow := feed.pending, feed.overflow
+			feed.pending = nil
+			feed.mu.Unlock()
+
+			if overflow {
+				// 重新记录写入位置，之前丢弃的事件都在此位置之前，从数据文件中回放
+				db.mu.Lock()
+				end = db.replicationEnd()
+				feed.mu.Lock()
+				feed.pending, feed.overflow = nil, false
+				feed.mu.Unlock()
+				db.mu.Unlock()
+				break
+			}
+
+			for _, event := range events {
+				if event.SeqNo < next {
+					continue
+				}
+				if !feed.send(event) {
+					return
+				}
+				next = event.SeqNo + 1
+			}
+			if len(events) > 0 {
+				continue
+			}
+
+			select {
+			case <-feed.notify:
+			case <-feed.stop:
+				return
+			}
+		}
+	}
+}
+
+func (feed *replicationFeed) send(event *ReplicationEvent) bool {
+	select {
+	case feed.ch <- event:
+		return true
+	case <-feed.stop:
+		return false
+	}
+}
+
+// 按写入顺序回放数据文件中 [next, end) 范围内的记录，返回下一个要发送的复制序列号
+// 和从数据文件加载索引一样，事务的记录在读到事务完成的标识之后才发送
+func (db *DB) replayLog(feed *replicationFeed, next, end uint64) (uint64, bool) {
+	if next >= end {
+		return next, true
+	}
+
+	// 分段锁模式下切换活跃文件只持有数据文件锁
+	db.mu.RLock()
+	db.fileMu.RLock()
+	var fileIds []int
+	if db.activeFile != nil {
+		fileIds = append(fileIds, int(db.activeFile.FileId))
+	}
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, int(fid))
+	}
+	db.fileMu.RUnlock()
+	db.mu.RUnlock()
+	sort.Ints(fileIds)
+
+	transactionEvents := make(map[uint64][]*ReplicationEvent)
+	for _, fid := range fileIds {
+		fileId := uint32(fid)
+		if replicationSeqNo(fileId, math.MaxUint32) < next {
+			continue
+		}
+		var offset int64
+		for replicationSeqNo(fileId, offset) < end {
+			logRecord, size, err := db.readReplicationRecord(fileId, offset)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				db.options.Logger.Errorf("replication stopped, failed to read data file %d at offset %d: %v", fileId, offset, err)
+				return next, false
+			}
+			seqNo := replicationSeqNo(fileId, offset)
+			offset += size
+
+			realKey, txnSeqNo := parseLogRecordKey(logRecord.Key)
+			event := &ReplicationEvent{SeqNo: seqNo, Key: realKey, Type: KeyEventPut, Value: logRecord.Value, Expire: logRecord.Expire}
+			switch logRecord.Type {
+			case data.LogRecordDeleted:
+				event.Type, event.Value = KeyEventDelete, nil
+			case data.LogRecordFlushAll:
+				// 清空之前没有完成的事务不会再完成
+				event.Type, event.Value = KeyEventFlushAll, nil
+				transactionEvents = make(map[uint64][]*ReplicationEvent)
+			}
+
+			var events []*ReplicationEvent
+			switch {
+			case txnSeqNo == nonTransactionSeqNo:
+				events = []*ReplicationEvent{event}
+			case logRecord.Type == data.LogRecordTxnFinished:
+				events = transactionEvents[txnSeqNo]
+				delete(transactionEvents, txnSeqNo)
+			default:
+				transactionEvents[txnSeqNo] = append(transactionEvents[txnSeqNo], event)
+			}
+
+			for _, event := range events {
+				if event.SeqNo < next {
+					continue
+				}
+				if !feed.send(event) {
+					return next, false
+				}
+				next = event.SeqNo + 1
+			}
+		}
+	}
+	if next < end {
+		next = end
+	}
+	return next, true
+}
+
+// 读取数据文件中的一条记录，value存储在value log中时读取实际的value，压缩或加密的value解码之后返回
+func (db *DB) readReplicationRecord(fileId uint32, offset int64) (*data.LogRecord, int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.fileMu.RLock()
+	defer db.fileMu.RUnlock()
+
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == fileId {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[fileId]
+	}
+	if dataFile == nil {
+		return nil, 0, ErrDataFileNotFound
+	}
+
+	logRecord, size, err := dataFile.ReadLogRecord(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if logRecord.Type == data.LogRecordValuePointer {
+		value, err := db.readValueLog(logRecord.Value, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		logRecord.Value = value
+		logRecord.Type = data.LogRecordNormal
+	} else if logRecord.Type == data.LogRecordNormal {
+		// 复制流中的value都是解码之后的原始数据
+		if logRecord.Value, err = db.decodeValue(logRecord); err != nil {
+			return nil, 0, err
+		}
+		logRecord.Flags = 0
+	}
+	return logRecord, size, nil
+}
@@ -0,0 +1,355 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 跟随者：把复制事件应用到另一个数据库，直到收到 stopKey 的写入
+func followUntil(t *testing.T, events <-chan *ReplicationEvent, follower *DB, stopKey []byte) []*ReplicationEvent {
+	t.Helper()
+	var received []*ReplicationEvent
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("replication channel closed")
+			}
+			if len(received) > 0 && event.SeqNo <= received[len(received)-1].SeqNo {
+				t.Fatalf("seq no %d after %d", event.SeqNo, received[len(received)-1].SeqNo)
+			}
+			received = append(received, event)
+			var err error
+			switch event.Type {
+			case KeyEventPut:
+				err = follower.put(event.Key, event.Value, event.Expire)
+			case KeyEventDelete:
+				err = follower.Delete(event.Key)
+			case KeyEventFlushAll:
+				err = follower.FlushAll()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(event.Key, stopKey) {
+				return received
+			}
+		case <-timeout:
+			t.Fatalf("timed out after %d events", len(received))
+		}
+	}
+}
+
+func dumpDB(t *testing.T, db *DB) map[string]string {
+	t.Helper()
+	content := make(map[string]string)
+	if err := db.Fold(func(key []byte, value []byte) bool {
+		content[string(key)] = string(value)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func expectSameContent(t *testing.T, leader, follower *DB) {
+	t.Helper()
+	expected, got := dumpDB(t, leader), dumpDB(t, follower)
+	if len(expected) != len(got) {
+		t.Fatalf("expected %d keys, got %d", len(expected), len(got))
+	}
+	for key, value := range expected {
+		if got[key] != value {
+			t.Fatalf("key %s: expected %q, got %q", key, value, got[key])
+		}
+	}
+}
+
+// 写入一些数据：普通写入、覆盖、删除、批量写入和Update
+func writeReplicationData(t *testing.T, db *DB, round int) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		value := []byte(fmt.Sprintf("round-%d-%d", round, i))
+		if i%10 == 0 {
+			value = append(value, bytes.Repeat([]byte("v"), 128)...)
+		}
+		if err := db.Put(testKey(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i += 7 {
+		if err := db.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.stripes == nil {
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		for i := 100; i < 110; i++ {
+			if err := wb.Put(testKey(i), testValue(round)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := wb.Delete(testKey(1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := wb.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Update(testKey(2), func(oldValue []byte) ([]byte, error) {
+			return append(oldValue, '!'), nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_Replication(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.GroupCommit = true },
+		func(options *Options) { options.StripedLockCount = 16 },
+		func(options *Options) { options.ValueLogSeparationThreshold = 64 },
+		func(options *Options) {
+			options.Compression = Flate
+			options.EncryptionKey = testEncryptionKey
+		},
+	} {
+		leader := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 8 * 1024
+			if configure != nil {
+				configure(options)
+			}
+		})
+		follower := openTestDB(t, nil)
+
+		// 订阅之前写入的数据从数据文件回放
+		writeReplicationData(t, leader, 0)
+		events, cancel, err := leader.Replication(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 订阅之后并发写入的数据作为实时事件收到
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					if err := leader.Put([]byte(fmt.Sprintf("live-%d-%d", g, i%30)), testValue(i)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}(g)
+		}
+		writeReplicationData(t, leader, 1)
+		wg.Wait()
+		if err := leader.Put([]byte("stop"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+
+		received := followUntil(t, events, follower, []byte("stop"))
+		expectSameContent(t, leader, follower)
+		if received[0].Timestamp != 0 {
+			t.Fatalf("expected no timestamp for replayed event, got %d", received[0].Timestamp)
+		}
+		if received[len(received)-1].Timestamp == 0 {
+			t.Fatal("expected timestamp for live event")
+		}
+
+		cancel()
+		if _, ok := <-events; ok {
+			t.Fatal("expected closed channel")
+		}
+	}
+}
+
+// 跟随者断开之后从最后收到的位置继续，重启主库之后复制序列号保持不变
+func TestDB_ReplicationResume(t *testing.T) {
+	leader := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 8 * 1024
+	})
+	follower := openTestDB(t, nil)
+
+	writeReplicationData(t, leader, 0)
+	if err := leader.Put([]byte("stop-0"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel, err := leader.Replication(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := followUntil(t, events, follower, []byte("stop-0"))
+	cancel()
+
+	writeReplicationData(t, leader, 1)
+	leader = reopenTestDB(t, leader)
+	writeReplicationData(t, leader, 2)
+	if err := leader.Put([]byte("stop-1"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel, err = leader.Replication(received[len(received)-1].SeqNo + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	resumed := followUntil(t, events, follower, []byte("stop-1"))
+	if resumed[0].SeqNo <= received[len(received)-1].SeqNo {
+		t.Fatalf("resumed at %d, before %d", resumed[0].SeqNo, received[len(received)-1].SeqNo)
+	}
+	expectSameContent(t, leader, follower)
+
+	// 从头订阅收到的事件和两次订阅收到的事件一致
+	all, cancelAll, err := leader.Replication(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelAll()
+	full := followUntil(t, all, openTestDB(t, nil), []byte("stop-1"))
+	if len(full) != len(received)+len(resumed) {
+		t.Fatalf("expected %d events, got %d", len(received)+len(resumed), len(full))
+	}
+	for i, event := range append(received, resumed...) {
+		if full[i].SeqNo != event.SeqNo || !bytes.Equal(full[i].Key, event.Key) || !bytes.Equal(full[i].Value, event.Value) {
+			t.Fatalf("event %d differs: %d %s, %d %s", i, full[i].SeqNo, full[i].Key, event.SeqNo, event.Key)
+		}
+	}
+}
+
+// 跟随者处理不及时，实时事件超出缓存上限时从数据文件追赶，不丢失事件
+func TestDB_ReplicationSlowFollower(t *testing.T) {
+	leader := openTestDB(t, nil)
+	follower := openTestDB(t, nil)
+	events, cancel, err := leader.Replication(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	count := 3 * replicationPendingSize
+	for i := 0; i < count; i++ {
+		if err := leader.Put(testKey(i%1000), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := leader.Put([]byte("stop"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	received := followUntil(t, events, follower, []byte("stop"))
+	if len(received) != count+1 {
+		t.Fatalf("expected %d events, got %d", count+1, len(received))
+	}
+	expectSameContent(t, leader, follower)
+}
+
+func TestDB_ReplicationClose(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel, err := db.Replication(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 从不存在的位置订阅时只接收之后的写入
+	future, cancelFuture, err := db.Replication(1 << 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelFuture()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for event := range events {
+		keys = append(keys, string(event.Key))
+	}
+	if len(keys) > 1 || (len(keys) == 1 && keys[0] != "k") {
+		t.Fatalf("unexpected events %v", keys)
+	}
+	if _, ok := <-future; ok {
+		t.Fatal("expected closed channel")
+	}
+	// 关闭之后取消订阅直接返回
+	cancel()
+
+	options := DefaultOptions
+	options.DirPath = t.TempDir()
+	options.DataFileSize = 8 * 1024 * 1024 * 1024
+	large, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer large.Close()
+	if _, _, err := large.Replication(0); err != ErrReplicationUnsupported {
+		t.Fatalf("expected ErrReplicationUnsupported, got %v", err)
+	}
+}
+
+// 写入部分记录后超时的批次不会复制到跟随者，实时订阅和从数据文件回放都一样
+func TestDB_ReplicationCommitTimeout(t *testing.T) {
+	leader := openTestDB(t, nil)
+	for i := 0; i < 10; i++ {
+		if err := leader.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	liveEvents, cancel, err := leader.Replication(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	opts := DefaultWriteBatchOptions
+	opts.CommitTimeout = 100 * time.Millisecond
+	wb := leader.NewWriteBatch(opts)
+	for i := 0; i < 20; i++ {
+		if err := wb.Put(testKey(i), testValue(100+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 第3条记录写入之后变慢，等待超过提交超时；复制流同时在读取活跃文件，替换IO时持有数据库的锁
+	var writes int
+	leader.mu.Lock()
+	ioManager := leader.activeFile.IOManager
+	leader.activeFile.IOManager = &writeCallbackIO{IOManager: ioManager, afterWrite: func() {
+		if writes++; writes == 3 {
+			time.Sleep(3 * opts.CommitTimeout)
+		}
+	}}
+	leader.mu.Unlock()
+	if err := wb.Commit(); err != ErrCommitTimeout {
+		t.Fatalf("expected ErrCommitTimeout, got %v", err)
+	}
+	if writes != 3 {
+		t.Fatalf("expected 3 records written, got %d", writes)
+	}
+	leader.mu.Lock()
+	leader.activeFile.IOManager = ioManager
+	leader.mu.Unlock()
+	if err := leader.Put([]byte("stop"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	replayEvents, cancelReplay, err := leader.Replication(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelReplay()
+	for _, events := range []<-chan *ReplicationEvent{liveEvents, replayEvents} {
+		follower := openTestDB(t, nil)
+		received := followUntil(t, events, follower, []byte("stop"))
+		if len(received) != 11 {
+			t.Fatalf("expected 11 events, got %d", len(received))
+		}
+		expectSameContent(t, leader, follower)
+	}
+}
@@ -0,0 +1,84 @@
+package bitcask_go
+
+import (
+	"sync"
+
+	"bitcask-go/data"
+)
+
+// 一个已经封存的数据文件
+type rotationEvent struct {
+	fileId uint32
+	path   string
+	size   int64
+}
+
+// 数据文件封存的通知：切换活跃文件时在锁内入队，由后台协程按封存顺序调用 OnFileRotation，回调时不持有数据库的任何锁
+// 队列不限长度，回调较慢时不会阻塞写入
+type rotationNotifier struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  []rotationEvent
+	closed   bool
+	done     chan struct{}
+	callback func(fileId uint32, path string, size int64)
+}
+
+func newRotationNotifier(callback func(fileId uint32, path string, size int64)) *rotationNotifier {
+	n := &rotationNotifier{done: make(chan struct{}), callback: callback}
+	n.cond = sync.NewCond(&n.mu)
+	go n.run()
+	return n
+}
+
+func (n *rotationNotifier) add(event rotationEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	n.pending = append(n.pending, event)
+	n.cond.Signal()
+}
+
+func (n *rotationNotifier) run() {
+	defer close(n.done)
+	for {
+		n.mu.Lock()
+		for len(n.pending) == 0 && !n.closed {
+			n.cond.Wait()
+		}
+		if len(n.pending) == 0 {
+			n.mu.Unlock()
+			return
+		}
+		events := n.pending
+		n.pending = nil
+		n.mu.Unlock()
+
+		for _, event := range events {
+			n.callback(event.fileId, event.path, event.size)
+		}
+	}
+}
+
+// 调用完队列中剩余的通知后退出
+func (n *rotationNotifier) close() {
+	n.mu.Lock()
+	n.closed = true
+	n.cond.Signal()
+	n.mu.Unlock()
+	<-n.done
+}
+
+// 当前活跃文件已经持久化并移入旧的数据文件之后、打开新的活跃文件之前调用，配置了 OnFileRotation 时通知外部（调用方需持有锁）
+func (db *DB) notifyFileSealed(dataFile *data.DataFile) {
+	if db.rotations == nil {
+		return
+	}
+	db.rotations.add(rotationEvent{
+		fileId: dataFile.FileId,
+		path:   db.getDataFileName(db.options.DirPath, dataFile.FileId),
+		size:   dataFile.WriteOff,
+	})
+}
@@ -0,0 +1,194 @@
+package bitcask_go
+
+import (
+	"sync"
+	"time"
+)
+
+// 最多保留的统计快照数量，写满后覆盖最早的快照
+const statsHistorySize = 1024
+
+// 直方图桶的上边界（不包含），最后一个桶为 1024 字节及以上
+var histogramBounds = [...]int{8, 16, 64, 256, 1024}
+
+// 大小分布的直方图，Counts 依次为 [0,8)、[8,16)、[16,64)、[64,256)、[256,1024)、[1024,+∞) 字节的数量
+type Histogram struct {
+	Counts [len(histogramBounds) + 1]uint64
+}
+
+func (h *Histogram) add(size int) {
+	i := 0
+	for i < len(histogramBounds) && size >= histogramBounds[i] {
+		i++
+	}
+	h.Counts[i]++
+}
+
+// 第 i 个桶的大小范围 [min, max)，最后一个桶的 max 为 -1 表示没有上限
+func (h *Histogram) Bucket(i int) (min, max int) {
+	if i > 0 {
+		min = histogramBounds[i-1]
+	}
+	if i < len(histogramBounds) {
+		return min, histogramBounds[i]
+	}
+	return min, -1
+}
+
+// 所有桶的数量之和
+func (h *Histogram) Total() uint64 {
+	var total uint64
+	for _, count := range h.Counts {
+		total += count
+	}
+	return total
+}
+
+// 遍历内存索引统计key和value的大小分布，不读取数据文件（调用方需持有 mu 的读锁，不需要持有 fileMu）
+// value的大小由索引中记录的磁盘大小减去key的长度得到，包含Header和序列号的少量开销；开启键值分离时为数据文件中指针记录的大小
+func (db *DB) sizeHistograms() (keySizes, valueSizes *Histogram) {
+	keySizes, valueSizes = &Histogram{}, &Histogram{}
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keySize := len(iterator.Key())
+		keySizes.add(keySize)
+		valueSizes.add(max(int(iterator.Value().Size)-keySize, 0))
+	}
+	return keySizes, valueSizes
+}
+
+// 统计快照的环形缓冲区，可以并发访问
+type statsHistory struct {
+	mu    sync.Mutex
+	stats []*Stat
+	next  int  // 下一个快照写入的位置
+	full  bool // 缓冲区是否已经写满
+}
+
+func newStatsHistory(size int) *statsHistory {
+	return &statsHistory{stats: make([]*Stat, size)}
+}
+
+func (sh *statsHistory) add(stat *Stat) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.stats[sh.next] = stat
+	sh.next++
+	if sh.next == len(sh.stats) {
+		sh.next = 0
+		sh.full = true
+	}
+}
+
+// 最近的 n 个快照，按采样时间从早到晚排列
+func (sh *statsHistory) last(n int) []*Stat {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	count := sh.next
+	if sh.full {
+		count = len(sh.stats)
+	}
+	if n > count {
+		n = count
+	}
+	result := make([]*Stat, n)
+	for i := 0; i < n; i++ {
+		result[i] = sh.stats[(sh.next-n+i+len(sh.stats))%len(sh.stats)]
+	}
+	return result
+}
+
+// 后台统计采样协程
+type statsSampler struct {
+	interval time.Duration
+	stop     chan struct{} // 通知采样协程退出
+	done     chan struct{} // 采样协程已退出
+}
+
+// 启动统计采样协程（调用方需持有 statsMu 或者在打开数据库时调用）
+func (db *DB) startStatsSampler(interval time.Duration) {
+	sampler := &statsSampler{
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	db.statsSampler = sampler
+	go db.runStatsSampler(sampler)
+}
+
+// 每隔 interval 调用一次 Stat 并保存到环形缓冲区
+func (db *DB) runStatsSampler(sampler *statsSampler) {
+	defer close(sampler.done)
+	ticker := time.NewTicker(sampler.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sampler.stop:
+			return
+		case <-ticker.C:
+			db.statsHistory.add(db.Stat())
+		}
+	}
+}
+
+// 停止统计采样协程并等待其退出
+func (db *DB) stopStatsSampler() {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	if db.statsSampler != nil {
+		close(db.statsSampler.stop)
+		<-db.statsSampler.done
+		db.statsSampler = nil
+	}
+}
+
+// 修改统计采样的间隔，为0时停止采样；已经保存的快照保留
+func (db *DB) SetStatsInterval(interval time.Duration) error {
+	if interval < 0 {
+		return ErrInvalidStatsInterval
+	}
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	// 在锁内检查，避免和关闭数据库时停止采样交错
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if db.statsSampler != nil {
+		close(db.statsSampler.stop)
+		<-db.statsSampler.done
+		db.statsSampler = nil
+	}
+	if interval > 0 {
+		db.startStatsSampler(interval)
+	}
+	return nil
+}
+
+// 当前的统计采样间隔，未开启采样时为0
+func (db *DB) StatsInterval() time.Duration {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	if db.statsSampler == nil {
+		return 0
+	}
+	return db.statsSampler.interval
+}
+
+// 返回后台采样的最近 n 个统计快照，按采样时间从早到晚排列，快照数量不足 n 个时返回全部
+// 需要配置 StatsInterval 或调用 SetStatsInterval 开启采样，最多保留最近 1024 个快照
+func (db *DB) StatsHistory(n int) []*Stat {
+	if n <= 0 {
+		return nil
+	}
+	return db.statsHistory.last(n)
+}
+
+// 将读写次数和块缓存的命中次数等计数器清零，不影响key数量、磁盘占用等反映数据状态的统计信息
+func (db *DB) ResetStats() {
+	db.readCount.Store(0)
+	db.writeCount.Store(0)
+	if db.blockCache != nil {
+		db.blockCache.ResetStats()
+	}
+}
@@ -0,0 +1,227 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"bitcask-go/index"
+)
+
+// 等待采样到至少 n 个快照
+func waitStatsHistory(t *testing.T, db *DB, n int) []*Stat {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if history := db.StatsHistory(n); len(history) == n {
+			return history
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d stats snapshots, got %d", n, len(db.StatsHistory(n)))
+	return nil
+}
+
+func TestDB_StatsHistory(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.StatsInterval = time.Millisecond
+	})
+	// 采样和写入并发进行
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			if err := db.Put(testKey(i%50), testValue(i)); err != nil {
+				t.Error(err)
+				return
+			}
+			db.StatsHistory(5)
+		}
+	}()
+	wg.Wait()
+
+	// 可回收空间只增不减，快照按采样时间排列
+	history := waitStatsHistory(t, db, 3)
+	for i := 1; i < len(history); i++ {
+		if history[i].ReclaimableSize < history[i-1].ReclaimableSize || history[i].WriteCount < history[i-1].WriteCount {
+			t.Fatalf("snapshots out of order: %+v, %+v", history[i-1], history[i])
+		}
+	}
+	if db.StatsHistory(0) != nil || db.StatsHistory(-1) != nil {
+		t.Fatal("expected nil history for non-positive n")
+	}
+
+	// 停止采样之后不再增加快照
+	if err := db.SetStatsInterval(0); err != nil {
+		t.Fatal(err)
+	}
+	if db.StatsInterval() != 0 {
+		t.Fatalf("expected stats interval 0, got %v", db.StatsInterval())
+	}
+	latest := db.StatsHistory(1)[0]
+	time.Sleep(10 * time.Millisecond)
+	if db.StatsHistory(1)[0] != latest {
+		t.Fatal("expected no snapshots after sampling stopped")
+	}
+
+	if err := db.SetStatsInterval(-time.Second); err != ErrInvalidStatsInterval {
+		t.Fatalf("expected ErrInvalidStatsInterval, got %v", err)
+	}
+	if err := db.SetStatsInterval(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if db.StatsInterval() != time.Millisecond {
+		t.Fatalf("expected stats interval 1ms, got %v", db.StatsInterval())
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetStatsInterval(time.Millisecond); err != ErrDatabaseClosed {
+		t.Fatalf("expected ErrDatabaseClosed, got %v", err)
+	}
+}
+
+func TestStatsHistory_Wraparound(t *testing.T) {
+	history := newStatsHistory(3)
+	if len(history.last(5)) != 0 {
+		t.Fatal("expected empty history")
+	}
+	for i := 0; i < 5; i++ {
+		history.add(&Stat{KeyNum: uint(i)})
+	}
+	stats := history.last(5)
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 snapshots, got %d", len(stats))
+	}
+	for i, stat := range stats {
+		if stat.KeyNum != uint(i+2) {
+			t.Fatalf("snapshot %d: expected key num %d, got %d", i, i+2, stat.KeyNum)
+		}
+	}
+	if stats := history.last(1); len(stats) != 1 || stats[0].KeyNum != 4 {
+		t.Fatalf("unexpected latest snapshot %+v", stats)
+	}
+}
+
+// 清零计数器，不影响key数量等统计信息
+func TestDB_ResetStats(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.BlockCacheSize = 64 * 1024
+	})
+	for i := 0; i < 10; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := db.Get(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stat := db.Stat()
+	if stat.WriteCount != 10 || stat.ReadCount != 10 || stat.CacheHits+stat.CacheMisses == 0 {
+		t.Fatalf("unexpected counters: %+v", stat)
+	}
+
+	db.ResetStats()
+	reset := db.Stat()
+	if reset.WriteCount != 0 || reset.ReadCount != 0 || reset.CacheHits != 0 || reset.CacheMisses != 0 {
+		t.Fatalf("expected counters reset: %+v", reset)
+	}
+	if reset.KeyNum != stat.KeyNum || reset.DataFileNum != stat.DataFileNum || reset.DiskSize != stat.DiskSize {
+		t.Fatalf("structural stats changed: %+v, before %+v", reset, stat)
+	}
+
+	if _, err := db.Get(testKey(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(testKey(0)); err != nil {
+		t.Fatal(err)
+	}
+	if stat := db.Stat(); stat.ReadCount != 1 || stat.WriteCount != 1 {
+		t.Fatalf("unexpected counters after reset: %+v", stat)
+	}
+}
+
+func TestDB_StatSizeHistograms(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, BPlusTree} {
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+		})
+		// key的大小依次落在每个桶中，value的大小远离桶的边界，不受记录Header开销的影响
+		keySizes := []int{1, 7, 8, 15, 16, 63, 64, 255, 256, 1023, 1024, 4096}
+		valueSizes := []int{30, 30, 30, 30, 100, 100, 100, 500, 500, 500, 2000, 2000}
+		for i, size := range keySizes {
+			key := bytes.Repeat([]byte{byte('a' + i)}, size)
+			if err := db.Put(key, make([]byte, valueSizes[i])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 删除的key不计入
+		if err := db.Put([]byte("deleted"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete([]byte("deleted")); err != nil {
+			t.Fatal(err)
+		}
+
+		stat := db.Stat()
+		if expected := [...]uint64{2, 2, 2, 2, 2, 2}; stat.KeySizeHistogram.Counts != expected {
+			t.Fatalf("index %d: key size histogram %v, want %v", indexType, stat.KeySizeHistogram.Counts, expected)
+		}
+		if expected := [...]uint64{0, 0, 4, 3, 3, 2}; stat.ValueSizeHistogram.Counts != expected {
+			t.Fatalf("index %d: value size histogram %v, want %v", indexType, stat.ValueSizeHistogram.Counts, expected)
+		}
+		if total := stat.KeySizeHistogram.Total(); total != uint64(stat.KeyNum) {
+			t.Fatalf("index %d: histogram total %d, key num %d", indexType, total, stat.KeyNum)
+		}
+	}
+
+	var histogram Histogram
+	for i, expected := range [][2]int{{0, 8}, {8, 16}, {16, 64}, {64, 256}, {256, 1024}, {1024, -1}} {
+		if min, max := histogram.Bucket(i); min != expected[0] || max != expected[1] {
+			t.Fatalf("bucket %d = [%d, %d), want %v", i, min, max, expected)
+		}
+	}
+}
+
+// 遍历索引时检查是否持有 fileMu 的索引
+type fileMuCheckIndex struct {
+	index.Indexer
+	db         *DB
+	scans      int
+	fileMuHeld bool
+}
+
+func (i *fileMuCheckIndex) Iterator(reverse bool) index.Iterator {
+	i.scans++
+	if i.db.fileMu.TryLock() {
+		i.db.fileMu.Unlock()
+	} else {
+		i.fileMuHeld = true
+	}
+	return i.Indexer.Iterator(reverse)
+}
+
+// 分段锁模式下 Stat 遍历索引时不持有 fileMu，不阻塞写入
+func TestDB_StatScansIndexWithoutFileLock(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.StripedLockCount = 16
+	})
+	for i := 0; i < 100; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkIndex := &fileMuCheckIndex{Indexer: db.index, db: db}
+	db.index = checkIndex
+	stat := db.Stat()
+	if checkIndex.scans == 0 || checkIndex.fileMuHeld {
+		t.Fatalf("expected index scans without fileMu, scans %d, held %v", checkIndex.scans, checkIndex.fileMuHeld)
+	}
+	if stat.KeyNum != 100 || stat.KeySizeHistogram.Total() != 100 || len(stat.FileUsage) != 1 {
+		t.Fatalf("unexpected stat %+v", stat)
+	}
+}
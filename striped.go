@@ -0,0 +1,128 @@
+package bitcask_go
+
+import (
+	"sync"
+
+	"bitcask-go/data"
+)
+
+// 开启分段锁时的写入：持有key所在分段的写锁以及全局读锁
+// 同一个key的写入和索引更新按顺序执行，不同分段的写入只在追加数据文件时短暂互斥
+// 需要独占数据库的操作（批量写入、merge、关闭等）持有全局写锁，和这里的写入互斥
+func (db *DB) putStriped(key []byte, logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	db.stripes.Lock(key)
+	defer db.stripes.Unlock(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pos, ticket, err := db.appendLogRecordShared(logRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	// 内存索引自身是并发安全的
+	if oldPos := db.index.Put(key, pos); oldPos != nil {
+		db.addReclaimSize(int64(oldPos.Size))
+	}
+	db.commitSeq.do(ticket, func() {
+		db.notifyCommit(key, logRecord.Value, KeyEventPut, pos)
+	})
+	db.auditWrite(AuditPut, key, nonTransactionSeqNo)
+	return pos, nil
+}
+
+// 开启分段锁时的删除，加锁方式和 putStriped 相同
+func (db *DB) deleteStriped(key []byte) (bool, error) {
+	db.stripes.Lock(key)
+	defer db.stripes.Unlock(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 检查key是否存在
+	if pos := db.index.Get(key); pos == nil {
+		return false, nil
+	}
+
+	logRecord := &data.LogRecord{
+		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type: data.LogRecordDeleted,
+	}
+	pos, ticket, err := db.appendLogRecordShared(logRecord)
+	if err != nil {
+		return false, err
+	}
+
+	oldPos, ok := db.index.Delete(key)
+	if !ok {
+		db.commitSeq.do(ticket, func() {})
+		return false, ErrIndexUpdateFailed
+	}
+	reclaimed := int64(pos.Size)
+	if oldPos != nil {
+		reclaimed += int64(oldPos.Size)
+	}
+	db.addReclaimSize(reclaimed)
+	db.commitSeq.do(ticket, func() {
+		db.notifyCommit(key, nil, KeyEventDelete, pos)
+	})
+	db.auditWrite(AuditDelete, key, nonTransactionSeqNo)
+	return true, nil
+}
+
+// 只持有全局读锁时追加日志记录，通过数据文件锁和其他写入以及读取互斥，切换活跃文件也在此锁内完成
+// 返回的序号用于按追加顺序调用写入提交的回调
+func (db *DB) appendLogRecordShared(logRecord *data.LogRecord) (*data.LogRecordPos, uint64, error) {
+	db.fileMu.Lock()
+	defer db.fileMu.Unlock()
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pos, db.commitSeq.next(), nil
+}
+
+// 只持有全局读锁时累加可回收的数据量
+func (db *DB) addReclaimSize(size int64) {
+	db.fileMu.Lock()
+	db.reclaimSize += size
+	db.fileMu.Unlock()
+}
+
+// 分段锁模式下不同分段的写入并发更新索引，回调按追加数据文件时分配的序号依次调用，保证复制流按写入位置的顺序收到事件
+type commitSequencer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	issued  uint64 // 已分配的序号数量
+	current uint64 // 下一个可以调用回调的序号
+}
+
+func newCommitSequencer() *commitSequencer {
+	cs := &commitSequencer{}
+	cs.cond = sync.NewCond(&cs.mu)
+	return cs
+}
+
+// 分配下一个序号（调用方需持有数据文件锁）
+func (cs *commitSequencer) next() uint64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	ticket := cs.issued
+	cs.issued++
+	return ticket
+}
+
+// 等待之前的序号都调用完成之后调用fn，每个分配的序号都必须调用一次
+func (cs *commitSequencer) do(ticket uint64, fn func()) {
+	cs.mu.Lock()
+	for cs.current != ticket {
+		cs.cond.Wait()
+	}
+	cs.mu.Unlock()
+
+	fn()
+
+	cs.mu.Lock()
+	cs.current++
+	cs.cond.Broadcast()
+	cs.mu.Unlock()
+}
@@ -0,0 +1,141 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDB_StripedLockConcurrentWrites(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree} {
+		// 数据文件较小，并发写入时会多次切换活跃文件
+		db := openTestDB(t, func(options *Options) {
+			options.IndexType = indexType
+			options.DataFileSize = 64 * 1024
+			options.StripedLockCount = 16
+		})
+
+		const goroutines, perGoroutine = 8, 200
+		var wg sync.WaitGroup
+		errs := make(chan error, goroutines+1)
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < perGoroutine; i++ {
+					// 每个协程写入自己的key，并反复覆盖写入一个共享的key
+					key := testKey(g*perGoroutine + i)
+					if err := db.Put(key, testValue(i)); err != nil {
+						errs <- err
+						return
+					}
+					if err := db.Put([]byte("shared"), []byte(fmt.Sprintf("%d-%d", g, i))); err != nil {
+						errs <- err
+						return
+					}
+					if i%2 == 1 {
+						if err := db.Delete(key); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}
+			}(g)
+		}
+
+		// 写入的同时读取
+		var stop atomic.Bool
+		var readers sync.WaitGroup
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for !stop.Load() {
+				if _, err := db.Get([]byte("shared")); err != nil && err != ErrKeyNotFound {
+					errs <- err
+					return
+				}
+				_ = db.Stat()
+			}
+		}()
+		wg.Wait()
+		stop.Store(true)
+		readers.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+		if len(db.olderFiles) == 0 {
+			t.Fatalf("index %d: expected multiple data files", indexType)
+		}
+
+		check := func(db *DB) {
+			for g := 0; g < goroutines; g++ {
+				for i := 0; i < perGoroutine; i++ {
+					value, err := db.Get(testKey(g*perGoroutine + i))
+					if i%2 == 1 {
+						if err != ErrKeyNotFound {
+							t.Fatalf("index %d: expected deleted key, got %v", indexType, err)
+						}
+						continue
+					}
+					if err != nil || !bytes.Equal(value, testValue(i)) {
+						t.Fatalf("index %d: Get(%d) = %q, %v", indexType, g*perGoroutine+i, value, err)
+					}
+				}
+			}
+			if _, err := db.Get([]byte("shared")); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := db.CountKeys(nil); err != nil || n != goroutines*perGoroutine/2+1 {
+				t.Fatalf("index %d: expected %d keys, got %d", indexType, goroutines*perGoroutine/2+1, n)
+			}
+		}
+		check(db)
+		check(reopenTestDB(t, db))
+	}
+}
+
+func TestOpen_StripedLockOptions(t *testing.T) {
+	for name, modify := range map[string]func(*Options){
+		"negative":      func(options *Options) { options.StripedLockCount = -1 },
+		"multi version": func(options *Options) { options.StripedLockCount = 16; options.MaxVersionsPerKey = 2 },
+	} {
+		options := DefaultOptions
+		options.DirPath = t.TempDir()
+		modify(&options)
+		if _, err := Open(options); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func BenchmarkDB_ConcurrentPutStripedLock(b *testing.B) {
+	for _, stripedLockCount := range []int{0, 256} {
+		b.Run(fmt.Sprintf("striped-lock-%d", stripedLockCount), func(b *testing.B) {
+			options := DefaultOptions
+			options.DirPath = b.TempDir()
+			options.StripedLockCount = stripedLockCount
+			db, err := Open(options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+
+			var counter int64
+			value := bytes.Repeat([]byte("v"), 128)
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&counter, 1)
+					if err := db.Put(testKey(int(i)), value); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package bitcask_go
+
+import (
+	"time"
+)
+
+// 写入键值对并设置过期时间，ttl 必须大于0
+// 过期时间随记录一起持久化，重启、merge以及复制之后仍然有效；过期的key读取时视为不存在，merge时被清理
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// 读取key对应的value，返回的bool表示key是否存在
+// key已过期时返回 (nil, false, nil)，同时写入一条删除记录，使过期数据占用的空间可以被merge回收（只读模式下不写入）
+// key不存在时返回 (nil, false, ErrKeyNotFound)
+func (db *DB) GetEx(key []byte) ([]byte, bool, error) {
+	if db.closed.Load() {
+		return nil, false, ErrDatabaseClosed
+	}
+	if len(key) == 0 {
+		return nil, false, ErrKeyIsEmpty
+	}
+
+	db.mu.RLock()
+	pos := db.index.Get(key)
+	if pos == nil {
+		db.mu.RUnlock()
+		return nil, false, ErrKeyNotFound
+	}
+	if !pos.IsExpired(time.Now().UnixNano()) {
+		value, err := db.getValueByPosition(pos)
+		db.mu.RUnlock()
+		if err != nil {
+			return nil, false, err
+		}
+		return value, true, nil
+	}
+	db.mu.RUnlock()
+
+	if db.options.ReadOnly {
+		return nil, false, nil
+	}
+	if err := db.deleteExpired(key); err != nil {
+		return nil, false, err
+	}
+	return nil, false, nil
+}
+
+// 为已过期的key写入删除记录
+// 持有全局写锁，和分段锁模式下的写入同样互斥；获取锁之前key可能已经被重新写入，需要再次检查
+// 和 Delete 一样在获取锁之前等待写入限速，key已经被重新写入时同样计入
+func (db *DB) deleteExpired(key []byte) error {
+	if err := db.writeLimiter.wait(len(key)); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	pos := db.index.Get(key)
+	if pos == nil || !pos.IsExpired(time.Now().UnixNano()) {
+		return nil
+	}
+	_, err := db.deleteLocked(key)
+	return err
+}
+
+// 过期时间的统计信息
+type ExpireStat struct {
+	ExpiringKeyCount   uint  // 设置了过期时间并且还没有过期的key数量
+	NextExpiryUnixNano int64 // 其中最近的过期时间（Unix纳秒），没有这样的key时为0
+}
+
+// 在读锁下扫描内存索引中记录的过期时间进行统计，不读取数据文件；已经过期但还没有被删除的key不计入
+func (db *DB) ExpireStat() *ExpireStat {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stat := &ExpireStat{}
+	now := time.Now().UnixNano()
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.Expire == 0 || pos.IsExpired(now) {
+			continue
+		}
+		stat.ExpiringKeyCount++
+		if stat.NextExpiryUnixNano == 0 || pos.Expire < stat.NextExpiryUnixNano {
+			stat.NextExpiryUnixNano = pos.Expire
+		}
+	}
+	iterator.Close()
+	return stat
+}
@@ -0,0 +1,198 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// 写入已经过期的key
+func putExpired(t *testing.T, db *DB, key, value []byte) {
+	t.Helper()
+	if err := db.put(key, value, time.Now().Add(-time.Second).UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_GetEx(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.StripedLockCount = 16 },
+		func(options *Options) { options.IndexType = BPlusTree },
+	} {
+		db := openTestDB(t, configure)
+		if err := db.PutWithTTL([]byte("live"), []byte("v1"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		putExpired(t, db, []byte("expired"), []byte("v2"))
+
+		// 未过期的key
+		value, ok, err := db.GetEx([]byte("live"))
+		if err != nil || !ok || !bytes.Equal(value, []byte("v1")) {
+			t.Fatalf("get live key = %q, %v, %v", value, ok, err)
+		}
+
+		// 已过期的key返回 (nil, false, nil)，并写入删除记录
+		reclaimable := db.Stat().ReclaimableSize
+		value, ok, err = db.GetEx([]byte("expired"))
+		if err != nil || ok || value != nil {
+			t.Fatalf("get expired key = %q, %v, %v", value, ok, err)
+		}
+		if after := db.Stat().ReclaimableSize; after <= reclaimable {
+			t.Fatalf("reclaimable size %d -> %d, want larger", reclaimable, after)
+		}
+		// 删除记录写入之后key不存在
+		if _, ok, err = db.GetEx([]byte("expired")); ok || err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound after tombstone, got %v, %v", ok, err)
+		}
+
+		// 不存在的key
+		if _, ok, err = db.GetEx([]byte("absent")); ok || err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound, got %v, %v", ok, err)
+		}
+		if _, _, err = db.GetEx(nil); err != ErrKeyIsEmpty {
+			t.Fatalf("expected ErrKeyIsEmpty, got %v", err)
+		}
+		if err := db.PutWithTTL([]byte("k"), []byte("v"), 0); err != ErrInvalidTTL {
+			t.Fatalf("expected ErrInvalidTTL, got %v", err)
+		}
+
+		// 删除记录重启之后仍然有效
+		db = reopenTestDB(t, db)
+		if _, ok, err = db.GetEx([]byte("expired")); ok || err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound after reopen, got %v, %v", ok, err)
+		}
+	}
+}
+
+// 过期的key读取时视为不存在，遍历时被跳过
+func TestDB_ExpiredKeysHidden(t *testing.T) {
+	db := openTestDB(t, nil)
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			putExpired(t, db, testKey(i), testValue(i))
+		} else if err := db.PutWithTTL(testKey(i), testValue(i), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.Get(testKey(0)); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if ok, err := db.Exists(testKey(0)); err != nil || ok {
+		t.Fatalf("expired key exists: %v, %v", ok, err)
+	}
+	if keys := db.ListKeys(); len(keys) != 5 {
+		t.Fatalf("expected 5 keys, got %d", len(keys))
+	}
+	var folded int
+	if err := db.Fold(func(key []byte, value []byte) bool {
+		folded++
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if folded != 5 {
+		t.Fatalf("expected 5 keys, got %d", folded)
+	}
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	var iterated int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if !bytes.Equal(iterator.Key(), testKey(iterated*2+1)) {
+			t.Fatalf("unexpected key %s", iterator.Key())
+		}
+		iterated++
+	}
+	iterator.Close()
+	if iterated != 5 {
+		t.Fatalf("expected 5 keys, got %d", iterated)
+	}
+
+	// 重新写入之后清除过期时间
+	if err := db.Put(testKey(0), []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get(testKey(0)); err != nil || string(value) != "again" {
+		t.Fatalf("get = %q, %v", value, err)
+	}
+}
+
+// 过期时间在重启、merge之后保持，过期的key在merge时被清理
+func TestDB_TTLPersistence(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.PutWithTTL([]byte("live"), []byte("v"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	putExpired(t, db, []byte("expired"), []byte("v"))
+	if err := db.Put([]byte("plain"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"live": "v", "plain": "v"}
+
+	check := func(db *DB) {
+		t.Helper()
+		expectContent(t, db, expected)
+		pos := db.index.Get([]byte("live"))
+		if pos == nil || pos.Expire == 0 {
+			t.Fatalf("expected expire to be kept, got %+v", pos)
+		}
+		if pos := db.index.Get([]byte("plain")); pos == nil || pos.Expire != 0 {
+			t.Fatalf("unexpected expire %+v", pos)
+		}
+	}
+	db = reopenTestDB(t, db)
+	check(db)
+
+	// merge之后从hint文件加载索引
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	check(db)
+	if db.index.Get([]byte("expired")) != nil {
+		t.Fatal("expired key should be dropped by merge")
+	}
+}
+
+func TestDB_ExpireStat(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.IndexType = BPlusTree },
+	} {
+		db := openTestDB(t, configure)
+		if stat := db.ExpireStat(); stat.ExpiringKeyCount != 0 || stat.NextExpiryUnixNano != 0 {
+			t.Fatalf("unexpected stat for empty db %+v", stat)
+		}
+
+		ttls := []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour}
+		for i, ttl := range ttls {
+			if err := db.PutWithTTL(testKey(i), testValue(i), ttl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.Put([]byte("plain"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		putExpired(t, db, []byte("expired"), []byte("v"))
+
+		stat := db.ExpireStat()
+		if stat.ExpiringKeyCount != uint(len(ttls)) {
+			t.Fatalf("expected %d expiring keys, got %d", len(ttls), stat.ExpiringKeyCount)
+		}
+		if pos := db.index.Get(testKey(1)); stat.NextExpiryUnixNano != pos.Expire {
+			t.Fatalf("expected next expiry %d, got %d", pos.Expire, stat.NextExpiryUnixNano)
+		}
+
+		// 删除最近过期的key后，下一个过期时间随之变化
+		if err := db.Delete(testKey(1)); err != nil {
+			t.Fatal(err)
+		}
+		stat = db.ExpireStat()
+		if pos := db.index.Get(testKey(2)); stat.ExpiringKeyCount != 2 || stat.NextExpiryUnixNano != pos.Expire {
+			t.Fatalf("unexpected stat after delete %+v, want next expiry %d", stat, pos.Expire)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package bitcask_go
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"bitcask-go/data"
+)
+
+// 乐观并发控制的事务：在 WriteBatch 的基础上增加了读取，可以读到事务自身暂存的写入
+// 事务创建时记录当前的事务序列号作为快照，开启多版本时读取快照时刻的值，否则读取当前最新的值
+// 事务记录每个读取的key在数据文件中的位置，提交时在数据库的写锁下检查这些位置，
+// 任何一个key在快照（未开启多版本时为读取）之后被其他写入修改过，提交返回 ErrConflict，调用方应当重新创建事务重试
+// merge和vacuum重写记录后位置会变化，和它们同时进行的事务也可能返回 ErrConflict
+type Txn struct {
+	mu    sync.Mutex
+	db    *DB
+	batch *WriteBatch
+	seqNo uint64 // 创建事务时的事务序列号
+	done  bool   // 已提交或回滚
+}
+
+// 创建事务，使用默认的批量写入配置
+func (db *DB) Txn() *Txn {
+	batch := db.NewWriteBatch(DefaultWriteBatchOptions)
+	batch.readSet = make(map[string]*data.LogRecordPos)
+	return &Txn{
+		db:    db,
+		batch: batch,
+		seqNo: db.SeqNo(),
+	}
+}
+
+// 读取key的值：先查找事务中暂存的写入，再读取数据库
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if txn.db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return nil, ErrTxnFinished
+	}
+
+	txn.batch.mu.Lock()
+	record, pending := txn.batch.pendingWrites[string(key)]
+	txn.batch.mu.Unlock()
+	if pending {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return append([]byte(nil), record.Value...), nil
+	}
+
+	txn.db.mu.RLock()
+	defer txn.db.mu.RUnlock()
+	pos, err := txn.readPos(key)
+	if err != nil && err != ErrKeyNotFound {
+		return nil, err
+	}
+	// 只记录第一次读取的位置，两次读取之间被修改同样视为冲突
+	if _, ok := txn.batch.readSet[string(key)]; !ok {
+		txn.batch.readSet[string(key)] = pos
+	}
+	if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return txn.db.getValueByPosition(pos)
+}
+
+// 读取key在快照时刻（未开启多版本时为当前）的位置，key不存在时返回 (nil, ErrKeyNotFound)（调用方需持有数据库的读锁）
+func (txn *Txn) readPos(key []byte) (*data.LogRecordPos, error) {
+	if txn.db.options.MaxVersionsPerKey > 1 {
+		return txn.db.posAtSeqNo(key, txn.seqNo)
+	}
+	pos := txn.db.index.Get(key)
+	if pos == nil {
+		return nil, ErrKeyNotFound
+	}
+	return pos, nil
+}
+
+// 暂存写入，提交时生效
+func (txn *Txn) Put(key, value []byte) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnFinished
+	}
+	return txn.batch.Put(key, value)
+}
+
+// 暂存删除，提交时生效
+func (txn *Txn) Delete(key []byte) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnFinished
+	}
+	return txn.batch.Delete(key)
+}
+
+// 提交事务，无论成功与否事务都会结束
+// 读取的key被其他写入修改过时返回 ErrConflict，暂存的写入不会生效
+// ctx 在提交之前检查，设置了截止时间时作为提交的超时时间（超时返回 ErrCommitTimeout）
+func (txn *Txn) Commit(ctx context.Context) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnFinished
+	}
+	txn.done = true
+	defer txn.batch.Rollback()
+
+	if txn.db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+		txn.batch.options.CommitTimeout = timeout
+	}
+
+	// 只读的事务不写入数据，在读锁下检查冲突
+	txn.batch.mu.Lock()
+	readOnly := len(txn.batch.pendingWrites) == 0
+	txn.batch.mu.Unlock()
+	if readOnly {
+		txn.db.mu.RLock()
+		defer txn.db.mu.RUnlock()
+		if txn.batch.readSetChanged() {
+			return ErrConflict
+		}
+		return nil
+	}
+	return txn.batch.Commit()
+}
+
+// 丢弃事务中暂存的写入
+func (txn *Txn) Rollback() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnFinished
+	}
+	txn.done = true
+	txn.batch.Rollback()
+	return nil
+}
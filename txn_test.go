@@ -0,0 +1,237 @@
+package bitcask_go
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func expectTxnValue(t *testing.T, txn *Txn, key, expected string) {
+	t.Helper()
+	value, err := txn.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	if string(value) != expected {
+		t.Fatalf("key %s: expected %q, got %q", key, expected, value)
+	}
+}
+
+func TestTxn_ReadYourOwnWrites(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := db.Txn()
+	expectTxnValue(t, txn, "a", "1")
+	if err := txn.Put([]byte("a"), []byte("10")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put([]byte("c"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	expectTxnValue(t, txn, "a", "10")
+	expectTxnValue(t, txn, "c", "3")
+	if _, err := txn.Get([]byte("b")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound for deleted key, got %v", err)
+	}
+	if _, err := txn.Get([]byte("missing")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	// 提交之前其他读取看不到暂存的写入
+	expectContent(t, db, map[string]string{"a": "1", "b": "2"})
+
+	if err := txn.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, map[string]string{"a": "10", "c": "3"})
+
+	// 事务结束之后不能再使用
+	if _, err := txn.Get([]byte("a")); err != ErrTxnFinished {
+		t.Fatalf("expected ErrTxnFinished, got %v", err)
+	}
+	if err := txn.Put([]byte("a"), []byte("v")); err != ErrTxnFinished {
+		t.Fatalf("expected ErrTxnFinished, got %v", err)
+	}
+	if err := txn.Commit(context.Background()); err != ErrTxnFinished {
+		t.Fatalf("expected ErrTxnFinished, got %v", err)
+	}
+	if err := txn.Rollback(); err != ErrTxnFinished {
+		t.Fatalf("expected ErrTxnFinished, got %v", err)
+	}
+
+	// 回滚丢弃暂存的写入
+	txn = db.Txn()
+	if err := txn.Put([]byte("d"), []byte("4")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, map[string]string{"a": "10", "c": "3"})
+
+	// 提交之前ctx已经取消
+	txn = db.Txn()
+	if err := txn.Put([]byte("d"), []byte("4")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := txn.Commit(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	expectContent(t, db, map[string]string{"a": "10", "c": "3"})
+}
+
+func TestTxn_Conflict(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 读取的key被非事务写入修改
+	txn := db.Txn()
+	expectTxnValue(t, txn, "a", "1")
+	if err := txn.Put([]byte("b"), []byte("from txn")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("a"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(context.Background()); err != ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	expectContent(t, db, map[string]string{"a": "2"})
+
+	// 读取时不存在的key被其他事务创建
+	txn1, txn2 := db.Txn(), db.Txn()
+	if _, err := txn1.Get([]byte("b")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := txn1.Put([]byte("b"), []byte("txn1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn2.Get([]byte("b")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := txn2.Put([]byte("b"), []byte("txn2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn1.Commit(context.Background()); err != ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	expectContent(t, db, map[string]string{"a": "2", "b": "txn2"})
+
+	// 只读的事务同样检查冲突
+	txn = db.Txn()
+	expectTxnValue(t, txn, "b", "txn2")
+	if err := db.Delete([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(context.Background()); err != ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	// 只写入没有读取的key不冲突
+	txn = db.Txn()
+	expectTxnValue(t, txn, "a", "2")
+	if err := txn.Put([]byte("c"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("c"), []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, map[string]string{"a": "2", "c": "3"})
+}
+
+// 开启多版本时读取事务创建时刻的值
+func TestTxn_Snapshot(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.MaxVersionsPerKey = 3
+	})
+	if err := db.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	txn := db.Txn()
+	if err := db.Put([]byte("a"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("b"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	expectTxnValue(t, txn, "a", "1")
+	if _, err := txn.Get([]byte("b")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound before snapshot, got %v", err)
+	}
+	if err := txn.Put([]byte("a"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(context.Background()); err != ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	expectContent(t, db, map[string]string{"a": "2", "b": "new"})
+}
+
+// 并发的读取-修改-写入在冲突时重试，不会丢失更新
+func TestTxn_ConcurrentIncrement(t *testing.T) {
+	for _, stripes := range []int{0, 16} {
+		db := openTestDB(t, func(options *Options) {
+			options.StripedLockCount = stripes
+		})
+		const workers, increments = 8, 50
+		var wg sync.WaitGroup
+		var conflicts int64
+		var mu sync.Mutex
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < increments; i++ {
+					for {
+						txn := db.Txn()
+						var n int
+						value, err := txn.Get([]byte("counter"))
+						if err == nil {
+							n, _ = strconv.Atoi(string(value))
+						} else if err != ErrKeyNotFound {
+							t.Error(err)
+							return
+						}
+						if err := txn.Put([]byte("counter"), []byte(strconv.Itoa(n+1))); err != nil {
+							t.Error(err)
+							return
+						}
+						err = txn.Commit(context.Background())
+						if err == nil {
+							break
+						}
+						if err != ErrConflict {
+							t.Error(err)
+							return
+						}
+						mu.Lock()
+						conflicts++
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		expectContent(t, db, map[string]string{"counter": strconv.Itoa(workers * increments)})
+		t.Logf("stripes %d: %d conflicts", stripes, conflicts)
+	}
+}
@@ -0,0 +1,270 @@
+package bitcask_go
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// vacuum时临时文件所在的目录后缀（和数据目录在同一个父目录下，保证重命名是原子的）
+const vacuumDirName = "-vacuum"
+
+// 原地回收旧数据文件中的无效数据，不需要像merge一样在临时目录中保存整个数据集的副本
+// 活跃文件不参与vacuum
+func (db *DB) Vacuum() error {
+	return db.VacuumWithProgress(nil)
+}
+
+// 逐个文件回收无效数据：读取文件中的有效记录写入临时文件，再原子地替换原文件，并在同一个临界区内更新内存索引
+// 每处理完一个文件调用一次 progress（可以为nil），参数为已处理的文件数和需要处理的文件总数
+// vacuum会改变记录的偏移：merge生成的hint文件会被删除（下次打开时从数据文件加载索引），从被重写的文件中间继续复制的跟随者需要重新同步
+// B+树索引持久化在磁盘上，无法和数据文件原子地一起更新，不支持vacuum
+func (db *DB) VacuumWithProgress(progress func(vacuumed, total int)) error {
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.options.InMemory {
+		return ErrInMemoryUnsupported
+	}
+	if db.options.IndexType == BPlusTree {
+		return ErrVacuumUnsupported
+	}
+
+	db.mu.Lock()
+	// 和merge互斥，merge过程中会不加锁地读取旧的数据文件
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	db.isMerging = true
+	fileIds := make([]uint32, 0, len(db.olderFiles))
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	// 上次vacuum中断时残留的临时文件
+	vacuumPath := db.getVacuumPath()
+	if err := os.RemoveAll(vacuumPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(vacuumPath, os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(vacuumPath)
+	}()
+
+	db.options.Logger.Infof("vacuum started: %d data files", len(fileIds))
+	for i, fid := range fileIds {
+		if err := db.vacuumFile(vacuumPath, fid, i == 0, false); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(i+1, len(fileIds))
+		}
+	}
+	return nil
+}
+
+// vacuum后重写的记录
+type vacuumRecord struct {
+	realKey []byte
+	oldPos  *data.LogRecordPos
+	newPos  *data.LogRecordPos
+	indexed bool // 内存索引指向此记录
+}
+
+// 回收一个旧数据文件中的无效数据，oldest 表示此文件之前没有其他数据文件
+// updateHint 为 true 时更新hint文件中指向此文件的位置，否则在重写merge生成的文件时删除hint文件
+func (db *DB) vacuumFile(vacuumPath string, fid uint32, oldest, updateHint bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dataFile := db.olderFiles[fid]
+	if dataFile == nil {
+		return nil
+	}
+
+	// 历史版本引用的旧记录同样需要保留
+	versionRefs := make(map[int64]struct{})
+	for _, versions := range db.versions {
+		for _, v := range versions {
+			if v.pos != nil && v.pos.Fid == fid {
+				versionRefs[v.pos.Offset] = struct{}{}
+			}
+		}
+	}
+
+	tmpFile, err := data.OpenDataFileWithName(db.getDataFileName(vacuumPath, fid), fid, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	tmpFile.SetChecksumAlgorithm(db.options.ChecksumAlgorithm)
+	if db.options.ChecksumMode == PerBlock {
+		if err := tmpFile.UseBlockChecksum(true); err != nil {
+			_ = tmpFile.Close()
+			return err
+		}
+	}
+
+	var records []*vacuumRecord
+	var offset int64
+	var dropped bool
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = tmpFile.Close()
+			return err
+		}
+		oldPos := &data.LogRecordPos{Fid: fid, Offset: offset, Size: uint32(size)}
+		offset += size
+
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		record := &vacuumRecord{realKey: realKey, oldPos: oldPos}
+		switch logRecord.Type {
+		case data.LogRecordTxnFinished, data.LogRecordFlushAll:
+			// 事务的记录可能在之前的文件中，完成标识始终保留；清空标识之前的文件可能因异常退出没有删除，同样保留
+		case data.LogRecordDeleted:
+			// 删除记录之前的文件中可能还有这个key的旧记录，只有key已经被重新写入或者之前没有数据文件时才能丢弃
+			if _, ok := versionRefs[oldPos.Offset]; !ok && (oldest || db.index.Get(realKey) != nil) {
+				dropped = true
+				continue
+			}
+		default:
+			pos := db.index.Get(realKey)
+			record.indexed = pos != nil && pos.Fid == fid && pos.Offset == oldPos.Offset
+			if _, ok := versionRefs[oldPos.Offset]; !ok && !record.indexed {
+				dropped = true
+				continue
+			}
+		}
+
+		// 原样保留记录（包括事务序列号），重新打开时按原来的方式加载
+		encRecord, newSize := tmpFile.EncodeLogRecord(logRecord)
+		record.newPos = &data.LogRecordPos{Fid: fid, Offset: tmpFile.WriteOff, Size: uint32(newSize), Expire: logRecord.Expire}
+		if err := tmpFile.Write(encRecord); err != nil {
+			_ = tmpFile.Close()
+			return err
+		}
+		records = append(records, record)
+	}
+
+	// 没有无效数据，不需要重写
+	if !dropped {
+		_ = tmpFile.Close()
+		return os.Remove(db.getDataFileName(vacuumPath, fid))
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	db.writeLimiter.record(tmpFile.WriteOff)
+
+	newPositions := make(map[int64]*data.LogRecordPos, len(records))
+	for _, record := range records {
+		newPositions[record.oldPos.Offset] = record.newPos
+	}
+
+	// 重写merge生成的文件之后hint文件中的位置失效，删除hint文件和merge完成标识，下次打开时从数据文件加载索引
+	// 需要更新hint文件时，先在临时目录中写好新的hint文件，替换数据文件之后再放回数据目录
+	hintBound, err := db.hintFileIdBound()
+	if err != nil {
+		return err
+	}
+	updateHint = updateHint && fid < hintBound
+	if updateHint {
+		if err := db.writeUpdatedHintFile(vacuumPath, fid, newPositions); err != nil {
+			return err
+		}
+	}
+	if fid < hintBound {
+		for _, name := range []string{data.HintFileName, data.MergeFinishedFileName} {
+			if err := os.Remove(filepath.Join(db.options.DirPath, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	// 原子地替换原文件
+	if err := dataFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(db.getDataFileName(vacuumPath, fid), db.getDataFileName(db.options.DirPath, fid)); err != nil {
+		return err
+	}
+	newFile, err := db.openDataFile(fid, fio.StandardFIO, false)
+	if err != nil {
+		return err
+	}
+	db.olderFiles[fid] = newFile
+
+	// hint文件和merge完成标识依次生效，在此之前异常退出时重新打开会从数据文件加载索引
+	// 放回失败时仍然需要更新内存索引，最后再返回错误
+	var hintErr error
+	if updateHint {
+		for _, name := range []string{data.HintFileName, data.MergeFinishedFileName} {
+			if hintErr = os.Rename(filepath.Join(vacuumPath, name), filepath.Join(db.options.DirPath, name)); hintErr != nil {
+				break
+			}
+		}
+	}
+	if fid < hintBound && (!updateHint || hintErr != nil) {
+		db.mergedFormat = 0
+	}
+
+	// 更新内存索引和历史版本中的位置
+	for _, record := range records {
+		if record.indexed {
+			db.index.Put(record.realKey, record.newPos)
+		}
+	}
+	for _, versions := range db.versions {
+		for _, v := range versions {
+			if v.pos != nil && v.pos.Fid == fid {
+				v.pos = newPositions[v.pos.Offset]
+			}
+		}
+	}
+
+	reclaimed := offset - tmpFile.WriteOff
+	db.reclaimSize -= reclaimed
+	if db.reclaimSize < 0 {
+		db.reclaimSize = 0
+	}
+	db.options.Logger.Infof("vacuumed data file %d, reclaimed %d bytes", fid, reclaimed)
+	if err := db.writeManifest(); err != nil {
+		return err
+	}
+	return hintErr
+}
+
+// vacuum临时文件所在的目录，例如 /tmp/bitcask-vacuum
+func (db *DB) getVacuumPath() string {
+	dir := path.Dir(path.Clean(db.options.DirPath))
+	base := path.Base(db.options.DirPath)
+	return filepath.Join(dir, base+vacuumDirName)
+}
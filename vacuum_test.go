@@ -0,0 +1,248 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitcask-go/data"
+)
+
+// 写入数据：覆盖、删除以及批量写入，返回期望的内容
+func writeVacuumData(t *testing.T, db *DB) map[string]string {
+	t.Helper()
+	expected := make(map[string]string)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 100; i++ {
+			value := fmt.Sprintf("round-%d-%s", round, testValue(i))
+			if err := db.Put(testKey(i), []byte(value)); err != nil {
+				t.Fatal(err)
+			}
+			expected[string(testKey(i))] = value
+		}
+	}
+	for i := 0; i < 100; i += 3 {
+		if err := db.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+		delete(expected, string(testKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 100; i < 120; i++ {
+		if err := wb.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		expected[string(testKey(i))] = string(testValue(i))
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return expected
+}
+
+func expectContent(t *testing.T, db *DB, expected map[string]string) {
+	t.Helper()
+	got := dumpDB(t, db)
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d keys, got %d", len(expected), len(got))
+	}
+	for key, value := range expected {
+		if got[key] != value {
+			t.Fatalf("key %s: expected %q, got %q", key, value, got[key])
+		}
+	}
+}
+
+func TestDB_Vacuum(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.ChecksumMode = PerBlock },
+		func(options *Options) { options.ChecksumAlgorithm = CRC32C },
+		func(options *Options) { options.IndexType = ART },
+	} {
+		db := openTestDB(t, func(options *Options) {
+			options.DataFileSize = 4 * 1024
+			if configure != nil {
+				configure(options)
+			}
+		})
+		expected := writeVacuumData(t, db)
+
+		files := countDataFiles(t, db.options.DirPath)
+		before := dataFilesSize(t, db.options.DirPath)
+		reclaimable := db.Stat().ReclaimableSize
+		var calls, total int
+		if err := db.VacuumWithProgress(func(vacuumed, n int) {
+			calls++
+			if vacuumed != calls {
+				t.Fatalf("progress %d after %d calls", vacuumed, calls)
+			}
+			total = n
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if calls != files-1 || total != files-1 {
+			t.Fatalf("expected %d progress calls, got %d of %d", files-1, calls, total)
+		}
+		// 文件数量不变，旧文件中的无效数据被回收
+		if after := countDataFiles(t, db.options.DirPath); after != files {
+			t.Fatalf("data files %d -> %d", files, after)
+		}
+		if after := dataFilesSize(t, db.options.DirPath); after >= before {
+			t.Fatalf("data files size %d -> %d, want smaller", before, after)
+		}
+		if stat := db.Stat(); stat.ReclaimableSize >= reclaimable {
+			t.Fatalf("reclaimable size %d -> %d, want smaller", reclaimable, stat.ReclaimableSize)
+		}
+		if _, err := os.Stat(db.getVacuumPath()); !os.IsNotExist(err) {
+			t.Fatalf("vacuum directory should be removed: %v", err)
+		}
+
+		// vacuum之后内存索引指向新的位置，重启之后被删除的key不会重新出现
+		expectContent(t, db, expected)
+		if err := db.Put([]byte("after"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		expected["after"] = "v"
+		db = reopenTestDB(t, db)
+		expectContent(t, db, expected)
+
+		// 再次vacuum时没有可以回收的数据
+		before = dataFilesSize(t, db.options.DirPath)
+		if err := db.Vacuum(); err != nil {
+			t.Fatal(err)
+		}
+		if after := dataFilesSize(t, db.options.DirPath); after != before {
+			t.Fatalf("data files size %d -> %d, want unchanged", before, after)
+		}
+	}
+}
+
+// 删除记录之前的文件中有旧的数据时保留删除记录
+func TestDB_VacuumKeepsDeleteRecords(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+	})
+	for i := 0; i < 100; i++ {
+		if err := db.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 重新写入一部分被删除的key
+	for i := 0; i < 10; i++ {
+		if err := db.Put(testKey(i), []byte("again")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte("filler"), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Vacuum(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	for i := 0; i < 100; i++ {
+		value, err := db.Get(testKey(i))
+		if i < 10 {
+			if err != nil || string(value) != "again" {
+				t.Fatalf("get %s = %q, %v", testKey(i), value, err)
+			}
+		} else if err != ErrKeyNotFound {
+			t.Fatalf("get deleted key %s: %v", testKey(i), err)
+		}
+	}
+}
+
+// merge生成的文件被重写后，hint文件失效，重启时从数据文件加载索引
+func TestDB_VacuumMergedFiles(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+	})
+	expected := writeVacuumData(t, db)
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if db.mergedFileId == 0 {
+		t.Fatal("expected merged files")
+	}
+
+	// 覆盖merge生成的文件中的数据
+	for i := 1; i < 100; i += 3 {
+		value := "vacuum-" + string(testValue(i))
+		if err := db.Put(testKey(i), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		expected[string(testKey(i))] = value
+	}
+	if err := db.Vacuum(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.HintFileName)); !os.IsNotExist(err) {
+		t.Fatalf("hint file should be removed: %v", err)
+	}
+	expectContent(t, db, expected)
+	db = reopenTestDB(t, db)
+	expectContent(t, db, expected)
+}
+
+// 历史版本引用的记录在vacuum之后仍然可以读取
+func TestDB_VacuumKeepsVersions(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.DataFileSize = 4 * 1024
+		options.MaxVersionsPerKey = 3
+	})
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			if err := db.Put(testKey(i), []byte(fmt.Sprintf("%d-%s", round, testValue(i)))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.Vacuum(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *DB) {
+		for i := 0; i < 50; i++ {
+			for n := 0; n < 3; n++ {
+				value, err := db.GetVersion(testKey(i), n)
+				if err != nil {
+					t.Fatalf("version %d of %s: %v", n, testKey(i), err)
+				}
+				if want := []byte(fmt.Sprintf("%d-%s", 4-n, testValue(i))); !bytes.Equal(value, want) {
+					t.Fatalf("version %d of %s = %q, want %q", n, testKey(i), value, want)
+				}
+			}
+		}
+	}
+	check(db)
+	check(reopenTestDB(t, db))
+}
+
+func TestDB_VacuumUnsupported(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.IndexType = BPlusTree
+	})
+	if err := db.Vacuum(); err != ErrVacuumUnsupported {
+		t.Fatalf("expected ErrVacuumUnsupported, got %v", err)
+	}
+
+	// 和merge互斥
+	db = openTestDB(t, nil)
+	db.isMerging = true
+	defer func() { db.isMerging = false }()
+	if err := db.Vacuum(); err != ErrMergeIsProgress {
+		t.Fatalf("expected ErrMergeIsProgress, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+
+	"bitcask-go/data"
+)
+
+// value的压缩和加密（配置了 Compression 或 EncryptionKey 时使用）
+// 写入数据文件或value log之前先压缩再加密，并在记录的type字节中标记 FlagCompressed、FlagEncrypted；读取时按标记解密再解压
+// 标记随记录一起持久化，merge和vacuum原样复制已经编码的记录，修改配置之后已有的记录仍然按各自的标记读取
+type valueCodec struct {
+	compression CompressionType
+	aead        cipher.AEAD // AES-GCM，未配置 EncryptionKey 时为nil
+}
+
+// 根据配置项创建编码器，既不压缩也不加密时返回nil
+func newValueCodec(options Options) (*valueCodec, error) {
+	if options.Compression == NoCompression && len(options.EncryptionKey) == 0 {
+		return nil, nil
+	}
+	codec := &valueCodec{compression: options.Compression}
+	if len(options.EncryptionKey) > 0 {
+		block, err := aes.NewCipher(options.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if codec.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return codec, nil
+}
+
+// 编码value，返回编码后的value和标记；压缩之后没有变小时不压缩
+func (c *valueCodec) encode(value []byte) ([]byte, data.LogRecordFlag, error) {
+	var flags data.LogRecordFlag
+	if c.compression == Flate && len(value) > 0 {
+		var buf bytes.Buffer
+		writer, err := flate.NewWriter(&buf, flate.BestSpeed)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := writer.Write(value); err != nil {
+			return nil, 0, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, 0, err
+		}
+		if buf.Len() < len(value) {
+			value = buf.Bytes()
+			flags |= data.FlagCompressed
+		}
+	}
+	if c.aead != nil {
+		// 每条记录使用随机的nonce，保存在密文之前
+		nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(value)+c.aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, 0, err
+		}
+		value = c.aead.Seal(nonce, nonce, value, nil)
+		flags |= data.FlagEncrypted
+	}
+	return value, flags, nil
+}
+
+// 按标记解码value，c为nil时只能解码压缩的value
+func (c *valueCodec) decode(value []byte, flags data.LogRecordFlag) ([]byte, error) {
+	if flags&data.FlagEncrypted != 0 {
+		if c == nil || c.aead == nil {
+			return nil, ErrUnsupportedRecordFlags
+		}
+		nonceSize := c.aead.NonceSize()
+		if len(value) < nonceSize {
+			return nil, ErrDecryptionFailed
+		}
+		plain, err := c.aead.Open(nil, value[:nonceSize], value[nonceSize:], nil)
+		if err != nil {
+			return nil, ErrDecryptionFailed
+		}
+		value = plain
+	}
+	if flags&data.FlagCompressed != 0 {
+		reader := flate.NewReader(bytes.NewReader(value))
+		plain, err := io.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		value = plain
+	}
+	return value, nil
+}
+
+// 编码写入的普通记录，返回新的记录，不修改调用方的记录（调用方之后还会用原始value通知订阅者）
+// 删除记录、指针记录以及已经带有标记的记录（merge复制的记录）原样返回
+func (db *DB) encodeValue(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	if db.valueCodec == nil || logRecord.Type != data.LogRecordNormal || logRecord.Flags != 0 {
+		return logRecord, nil
+	}
+	value, flags, err := db.valueCodec.encode(logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	if flags == 0 {
+		return logRecord, nil
+	}
+	encoded := *logRecord
+	encoded.Value = value
+	encoded.Flags = flags
+	return &encoded, nil
+}
+
+// 解码记录中的value，没有标记时原样返回
+func (db *DB) decodeValue(logRecord *data.LogRecord) ([]byte, error) {
+	if logRecord.Flags == 0 {
+		return logRecord.Value, nil
+	}
+	return db.valueCodec.decode(logRecord.Value, logRecord.Flags)
+}
@@ -0,0 +1,164 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+// 可以压缩的value：同一个标记重复多次
+func compressibleValue(i int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("secret-%05d;", i)), 20)
+}
+
+// 数据目录中的所有文件是否包含data
+func dirContains(t *testing.T, dirPath string, data []byte) bool {
+	t.Helper()
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(content, data) {
+			return true
+		}
+	}
+	return false
+}
+
+// 开启压缩和加密时写入、重新打开以及merge之后都能读到原始的value
+func TestDB_ValueCodec(t *testing.T) {
+	for name, configure := range map[string]func(*Options){
+		"Compression": func(options *Options) { options.Compression = Flate },
+		"Encryption":  func(options *Options) { options.EncryptionKey = testEncryptionKey },
+		"Both": func(options *Options) {
+			options.Compression = Flate
+			options.EncryptionKey = testEncryptionKey
+		},
+		"BothWithValueLog": func(options *Options) {
+			options.Compression = Flate
+			options.EncryptionKey = testEncryptionKey
+			options.ValueLogSeparationThreshold = 128
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(options *Options) {
+				options.DataFileSize = 16 * 1024
+				configure(options)
+			})
+			expected := make(map[string]string)
+			for i := 0; i < 300; i++ {
+				// 短value压缩之后不会变小，不压缩
+				value := testValue(i)
+				if i%2 == 0 {
+					value = compressibleValue(i)
+				}
+				if err := db.Put(testKey(i), value); err != nil {
+					t.Fatal(err)
+				}
+				expected[string(testKey(i))] = string(value)
+			}
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			for i := 0; i < 300; i += 3 {
+				if err := wb.Delete(testKey(i)); err != nil {
+					t.Fatal(err)
+				}
+				delete(expected, string(testKey(i)))
+			}
+			if err := wb.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Put([]byte("empty"), nil); err != nil {
+				t.Fatal(err)
+			}
+			expected["empty"] = ""
+			expectContent(t, db, expected)
+			if value, err := db.Get(testKey(2)); err != nil || !bytes.Equal(value, compressibleValue(2)) {
+				t.Fatalf("Get = %q, %v", value, err)
+			}
+
+			db = reopenTestDB(t, db)
+			expectContent(t, db, expected)
+			if err := db.MergeForce(); err != nil {
+				t.Fatal(err)
+			}
+			db = reopenTestDB(t, db)
+			expectContent(t, db, expected)
+			if db.options.EncryptionKey != nil && dirContains(t, db.options.DirPath, []byte("secret-00002;")) {
+				t.Fatal("found plaintext value in the data directory")
+			}
+		})
+	}
+}
+
+// 开启加密之前写入的数据仍然可以读取；读取加密的记录需要写入时的密钥
+func TestDB_ValueCodecKey(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Put([]byte("plain"), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	options := db.options
+	options.EncryptionKey = testEncryptionKey
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("encrypted"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, db, map[string]string{"plain": "v1", "encrypted": "v2"})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range [][]byte{nil, []byte("fedcba9876543210")} {
+		options.EncryptionKey = key
+		db, err := Open(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedErr := ErrUnsupportedRecordFlags
+		if key != nil {
+			expectedErr = ErrDecryptionFailed
+		}
+		if _, err := db.Get([]byte("encrypted")); err != expectedErr {
+			t.Fatalf("key %q: expected %v, got %v", key, expectedErr, err)
+		}
+		if value, err := db.Get([]byte("plain")); err != nil || string(value) != "v1" {
+			t.Fatalf("key %q: get plain = %q, %v", key, value, err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_ValueCodecInvalidOptions(t *testing.T) {
+	for name, configure := range map[string]func(*Options){
+		"Compression":   func(options *Options) { options.Compression = 7 },
+		"EncryptionKey": func(options *Options) { options.EncryptionKey = []byte("short") },
+	} {
+		options := DefaultOptions
+		options.DirPath = t.TempDir()
+		configure(&options)
+		if db, err := Open(options); err == nil {
+			_ = db.Close()
+			t.Fatalf("%s: expected an error for invalid options", name)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package bitcask_go
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// 键值分离：大于等于阈值的value写入单独的value log文件，数据文件中只保存指向它的位置，
+// 这样数据文件体积较小，启动时重放数据文件构建索引更快
+// merge时有效的value会重写到新的value log中，参与merge的value log在merge生效后删除
+
+// 从磁盘加载value log文件
+func (db *DB) loadValueLogFiles() error {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.ValueLogFileSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.ValueLogFileSuffix))
+		if err != nil {
+			return ErrDataDirectoryCorrupted
+		}
+		fileIds = append(fileIds, fileId)
+	}
+	sort.Ints(fileIds)
+
+	for i, fid := range fileIds {
+		vlogFile, err := data.OpenValueLogFile(db.options.DirPath, uint32(fid), fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		if i == len(fileIds)-1 {
+			// value log只会追加写入，最后一个文件的大小即为下次写入的位置
+			size, err := vlogFile.IOManager.Size()
+			if err != nil {
+				return err
+			}
+			vlogFile.WriteOff = size
+			db.activeVlog = vlogFile
+		} else {
+			db.olderVlogs[uint32(fid)] = vlogFile
+		}
+	}
+	return nil
+}
+
+// 如果value需要分离，将其写入value log，并返回写入数据文件的指针记录（访问此方法前必须持有锁）
+func (db *DB) separateValue(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	threshold := db.options.ValueLogSeparationThreshold
+	if threshold <= 0 || logRecord.Type != data.LogRecordNormal || int64(len(logRecord.Value)) < threshold {
+		return logRecord, nil
+	}
+
+	// value log中同样以日志记录的格式保存，读取时可以校验crc
+	encRecord, size := data.EncodeLogRecordWithMode(logRecord, data.PerRecord, db.options.ChecksumAlgorithm)
+
+	if db.activeVlog == nil {
+		if err := db.setActiveVlog(); err != nil {
+			return nil, err
+		}
+	}
+	if db.activeVlog.WriteOff+size > db.options.DataFileSize {
+		if err := db.activeVlog.Sync(); err != nil {
+			return nil, err
+		}
+		db.olderVlogs[db.activeVlog.FileId] = db.activeVlog
+		if err := db.setActiveVlog(); err != nil {
+			return nil, err
+		}
+	}
+
+	writeOff := db.activeVlog.WriteOff
+	if err := db.activeVlog.Write(encRecord); err != nil {
+		return nil, err
+	}
+	db.bytesWrite += uint(size)
+
+	vlogPos := &data.LogRecordPos{
+		Fid:    db.activeVlog.FileId,
+		Offset: writeOff,
+		Size:   uint32(size),
+	}
+	return &data.LogRecord{
+		Key:    logRecord.Key,
+		Value:  data.EncodeLogRecordPos(vlogPos),
+		Type:   data.LogRecordValuePointer,
+		Expire: logRecord.Expire,
+	}, nil
+}
+
+// 打开新的活跃value log文件（访问此方法前必须持有锁）
+func (db *DB) setActiveVlog() error {
+	var fileId uint32 = 0
+	if db.activeVlog != nil {
+		fileId = db.activeVlog.FileId + 1
+	}
+	ioType := fio.StandardFIO
+	if db.options.InMemory {
+		ioType = fio.MemoryFIO
+	}
+	vlogFile, err := data.OpenValueLogFile(db.options.DirPath, fileId, ioType)
+	if err != nil {
+		return err
+	}
+	if err := db.syncDir(); err != nil {
+		_ = vlogFile.Close()
+		return err
+	}
+	db.activeVlog = vlogFile
+	return nil
+}
+
+// 根据数据文件中的指针读取value log中的value并解码，skipCRC 只用于读取路径（使用此方法前加锁）
+func (db *DB) readValueLog(pointer []byte, skipCRC bool) ([]byte, error) {
+	vlogPos := data.DecodeLogRecordPos(pointer)
+
+	var vlogFile *data.DataFile
+	if db.activeVlog != nil && db.activeVlog.FileId == vlogPos.Fid {
+		vlogFile = db.activeVlog
+	} else {
+		vlogFile = db.olderVlogs[vlogPos.Fid]
+	}
+	if vlogFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+
+	var logRecord *data.LogRecord
+	var err error
+	if skipCRC {
+		logRecord, _, err = vlogFile.ReadLogRecordSkipCRC(vlogPos.Offset)
+	} else {
+		logRecord, _, err = vlogFile.ReadLogRecord(vlogPos.Offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db.decodeValue(logRecord)
+}
+
+// merge时将指针记录指向的value重新写入活跃的value log，返回写入merge数据文件的记录
+func (db *DB) rewriteValueLog(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, err := db.readValueLog(logRecord.Value, false)
+	if err != nil {
+		return nil, err
+	}
+	// 按当前的配置重新编码，并按当前的阈值重新分离，阈值调大后较小的value会直接写回数据文件
+	encoded, err := db.encodeValue(&data.LogRecord{
+		Key:    logRecord.Key,
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: logRecord.Expire,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.separateValue(encoded)
+}
+
+// 持久化活跃的value log文件，需要在持久化数据文件之前调用，保证指针指向的数据已落盘（访问此方法前必须持有锁）
+func (db *DB) syncVlog() error {
+	if db.activeVlog == nil {
+		return nil
+	}
+	return db.activeVlog.Sync()
+}
+
+// 关闭所有value log文件
+func (db *DB) closeVlogs() error {
+	var errs []error
+	if db.activeVlog != nil {
+		errs = append(errs, db.activeVlog.Close())
+	}
+	for _, vlogFile := range db.olderVlogs {
+		errs = append(errs, vlogFile.Close())
+	}
+	return errors.Join(errs...)
+}
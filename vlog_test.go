@@ -0,0 +1,255 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"bitcask-go/data"
+)
+
+// 统计目录中value log文件的数量和总大小
+func valueLogFilesSize(t *testing.T, dirPath string) (int, int64) {
+	t.Helper()
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	var size int64
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.ValueLogFileSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		size += info.Size()
+	}
+	return count, size
+}
+
+func largeValue(i int) []byte {
+	return bytes.Repeat(testValue(i), 100)
+}
+
+func TestDB_ValueLogSeparation(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.ValueLogSeparationThreshold = 256
+	})
+	if err := db.Put([]byte("small"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("large"), largeValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	if err := wb.Put([]byte("batch"), largeValue(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := valueLogFilesSize(t, db.options.DirPath); count != 1 {
+		t.Fatalf("value log files = %d", count)
+	}
+
+	db = reopenTestDB(t, db)
+	for key, want := range map[string][]byte{"small": []byte("v"), "large": largeValue(1), "batch": largeValue(2)} {
+		value, err := db.Get([]byte(key))
+		if err != nil || !bytes.Equal(value, want) {
+			t.Fatalf("get %s = %d bytes, %v", key, len(value), err)
+		}
+	}
+}
+
+func TestDB_MergeReclaimsValueLog(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.ValueLogSeparationThreshold = 256
+		options.DataFileSize = 32 * 1024
+		options.DataFileMergeRatio = 0
+	})
+
+	// 写入后全部覆盖，之前写入value log的数据全部失效
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 200; i++ {
+			if err := db.Put(testKey(i), largeValue(i+round)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.Delete(testKey(0)); err != nil {
+		t.Fatal(err)
+	}
+	_, sizeBefore := valueLogFilesSize(t, db.options.DirPath)
+
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	// merge期间旧的value log仍然可以读取
+	value, err := db.Get(testKey(1))
+	if err != nil || !bytes.Equal(value, largeValue(2)) {
+		t.Fatalf("get after merge = %d bytes, %v", len(value), err)
+	}
+
+	// 重启后merge生效，失效的value log被删除
+	db = reopenTestDB(t, db)
+	_, sizeAfter := valueLogFilesSize(t, db.options.DirPath)
+	if sizeAfter >= sizeBefore*2/3 {
+		t.Fatalf("value log size %d -> %d, want reclaimed", sizeBefore, sizeAfter)
+	}
+
+	check := func(db *DB) {
+		if _, err := db.Get(testKey(0)); err != ErrKeyNotFound {
+			t.Fatalf("get deleted key: %v", err)
+		}
+		for i := 1; i < 200; i++ {
+			value, err := db.Get(testKey(i))
+			if err != nil || !bytes.Equal(value, largeValue(i+1)) {
+				t.Fatalf("get %s = %d bytes, %v", testKey(i), len(value), err)
+			}
+		}
+	}
+	check(db)
+
+	// 继续写入后再次重启，新旧value log中的数据都能读到
+	if err := db.Put([]byte("after-merge"), largeValue(7)); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	check(db)
+	if value, err := db.Get([]byte("after-merge")); err != nil || !bytes.Equal(value, largeValue(7)) {
+		t.Fatalf("get after-merge = %d bytes, %v", len(value), err)
+	}
+}
+
+// value log中的无效数据未达到 ValueLogMergeRatio 时，merge只重写数据文件，value log保持不变
+func TestDB_MergeKeyLogOnly(t *testing.T) {
+	db := openTestDB(t, func(options *Options) {
+		options.ValueLogSeparationThreshold = 256
+		options.ValueLogMergeRatio = 0.5
+		options.DataFileSize = 32 * 1024
+	})
+	expected := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		if err := db.Put(testKey(i), largeValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		expected[string(testKey(i))] = string(largeValue(i))
+	}
+	// 小value写入数据文件，多次覆盖产生的无效数据都在数据文件中
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 50; i++ {
+			key := []byte(fmt.Sprintf("small-%d", i))
+			if err := db.Put(key, testValue(round)); err != nil {
+				t.Fatal(err)
+			}
+			expected[string(key)] = string(testValue(round))
+		}
+	}
+	// 少量大value失效
+	for i := 0; i < 20; i++ {
+		if err := db.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+		delete(expected, string(testKey(i)))
+	}
+	vlogCount, vlogSize := valueLogFilesSize(t, db.options.DirPath)
+	dataSize := dataFilesSize(t, db.options.DirPath)
+
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	expectContent(t, db, expected)
+	if count, size := valueLogFilesSize(t, db.options.DirPath); count != vlogCount+1 || size != vlogSize {
+		t.Fatalf("expected value log unchanged (%d files, %d bytes), got %d files, %d bytes", vlogCount, vlogSize, count, size)
+	}
+	if size := dataFilesSize(t, db.options.DirPath); size >= dataSize/2 {
+		t.Fatalf("expected data files to be compacted, %d -> %d", dataSize, size)
+	}
+
+	// 覆盖大部分大value之后，merge重写value log并删除旧的value log
+	for i := 20; i < 200; i++ {
+		if err := db.Put(testKey(i), largeValue(i+1)); err != nil {
+			t.Fatal(err)
+		}
+		expected[string(testKey(i))] = string(largeValue(i + 1))
+	}
+	_, vlogSize = valueLogFilesSize(t, db.options.DirPath)
+	if err := db.MergeForce(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	expectContent(t, db, expected)
+	if _, size := valueLogFilesSize(t, db.options.DirPath); size >= vlogSize*2/3 {
+		t.Fatalf("expected value log to be reclaimed, %d -> %d", vlogSize, size)
+	}
+}
+
+// 开启键值分离后，value log中的无效数据较少时merge只需要重写较小的数据文件
+func BenchmarkMerge_ValueLogSeparation(b *testing.B) {
+	for _, bench := range []struct {
+		name      string
+		configure func(*Options)
+	}{
+		{"inline", nil},
+		{"separated", func(options *Options) {
+			options.ValueLogSeparationThreshold = 1024
+			options.ValueLogMergeRatio = 0.5
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			options := DefaultOptions
+			options.DirPath = b.TempDir()
+			options.DataFileSize = 4 * 1024 * 1024
+			if bench.configure != nil {
+				bench.configure(&options)
+			}
+			db, err := Open(options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer func() {
+				_ = db.Close()
+			}()
+
+			value := bytes.Repeat([]byte("v"), 4096)
+			for i := 0; i < 2000; i++ {
+				if err := db.Put(testKey(i), value); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				// 每轮删除少量key产生无效数据
+				b.StopTimer()
+				for i := 0; i < 20; i++ {
+					if err := db.Put([]byte(fmt.Sprintf("tmp-%d-%d", n, i)), value); err != nil {
+						b.Fatal(err)
+					}
+					if err := db.Delete([]byte(fmt.Sprintf("tmp-%d-%d", n, i))); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StartTimer()
+				if err := db.MergeForce(); err != nil {
+					b.Fatal(err)
+				}
+				// 让merge的结果生效
+				b.StopTimer()
+				if err := db.Close(); err != nil {
+					b.Fatal(err)
+				}
+				if db, err = Open(options); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
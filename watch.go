@@ -0,0 +1,104 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"sync"
+
+	"bitcask-go/data"
+)
+
+// 每个订阅者的事件缓冲区大小，缓冲区满时丢弃新的事件，不阻塞写入
+const watchChannelSize = 1024
+
+type KeyEventType = byte
+
+const (
+	// KeyEventPut key被写入
+	KeyEventPut KeyEventType = iota + 1
+
+	// KeyEventDelete key被删除
+	KeyEventDelete
+
+	// KeyEventFlushAll 数据库被清空（FlushAll），Key为空，发送给所有订阅者
+	KeyEventFlushAll
+)
+
+// key的变更事件
+type KeyEvent struct {
+	Key  []byte
+	Type KeyEventType
+}
+
+// 订阅者
+type watcher struct {
+	prefix []byte
+	ch     chan KeyEvent
+	once   sync.Once
+}
+
+// 订阅前缀为prefix的key的变更事件（prefix为空时订阅所有key），返回接收事件的通道以及取消订阅的函数
+// 事件在内存索引更新之后发送，尽力而为：订阅者处理不及时、缓冲区已满时新的事件会被丢弃
+// 取消订阅或关闭数据库后通道会被关闭
+func (db *DB) Watch(prefix []byte) (<-chan KeyEvent, func()) {
+	w := &watcher{
+		prefix: append([]byte(nil), prefix...),
+		ch:     make(chan KeyEvent, watchChannelSize),
+	}
+
+	db.watchMu.Lock()
+	db.watchers[w] = struct{}{}
+	db.watchMu.Unlock()
+
+	cancel := func() {
+		db.watchMu.Lock()
+		defer db.watchMu.Unlock()
+		delete(db.watchers, w)
+		w.close()
+	}
+	return w.ch, cancel
+}
+
+func (w *watcher) close() {
+	w.once.Do(func() { close(w.ch) })
+}
+
+// 写入提交之后的回调，在内存索引更新之后按写入顺序调用：通知订阅者以及复制流
+// value为写入的原始value（删除时为nil），pos为此次写入的日志记录在数据文件中的位置
+func (db *DB) notifyCommit(key, value []byte, eventType KeyEventType, pos *data.LogRecordPos) {
+	db.notifyWatchers(key, eventType)
+	db.notifyReplicas(key, value, eventType, pos)
+}
+
+// 通知订阅者key发生了变更
+func (db *DB) notifyWatchers(key []byte, eventType KeyEventType) {
+	db.watchMu.RLock()
+	defer db.watchMu.RUnlock()
+	if len(db.watchers) == 0 {
+		return
+	}
+
+	var event *KeyEvent
+	for w := range db.watchers {
+		if eventType != KeyEventFlushAll && !bytes.HasPrefix(key, w.prefix) {
+			continue
+		}
+		// 调用方的key可能被复用，拷贝一份再发送
+		if event == nil {
+			event = &KeyEvent{Key: append([]byte(nil), key...), Type: eventType}
+		}
+		select {
+		case w.ch <- *event:
+		default:
+		}
+	}
+}
+
+// 关闭所有订阅者的通道
+func (db *DB) closeWatchers() {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for w := range db.watchers {
+		w.close()
+	}
+	db.watchers = make(map[*watcher]struct{})
+}
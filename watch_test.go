@@ -0,0 +1,150 @@
+package bitcask_go
+
+import (
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, ch <-chan KeyEvent) KeyEvent {
+	t.Helper()
+	select {
+	case event, ok := <-ch:
+		if !ok {
+			t.Fatal("channel closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return KeyEvent{}
+}
+
+func expectNoEvent(t *testing.T, ch <-chan KeyEvent) {
+	t.Helper()
+	select {
+	case event := <-ch:
+		t.Fatalf("unexpected event %q %d", event.Key, event.Type)
+	default:
+	}
+}
+
+func TestDB_Watch(t *testing.T) {
+	for _, configure := range []func(*Options){
+		nil,
+		func(options *Options) { options.GroupCommit = true },
+		func(options *Options) { options.StripedLockCount = 16 },
+	} {
+		db := openTestDB(t, configure)
+		userEvents, cancelUser := db.Watch([]byte("user:"))
+		allEvents, cancelAll := db.Watch(nil)
+
+		if err := db.Put([]byte("user:1"), []byte("a")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put([]byte("order:1"), []byte("b")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete([]byte("user:1")); err != nil {
+			t.Fatal(err)
+		}
+		// 删除不存在的key不产生事件
+		if err := db.Delete([]byte("user:2")); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, expected := range []KeyEvent{{[]byte("user:1"), KeyEventPut}, {[]byte("user:1"), KeyEventDelete}} {
+			event := receiveEvent(t, userEvents)
+			if string(event.Key) != string(expected.Key) || event.Type != expected.Type {
+				t.Fatalf("expected %s %d, got %s %d", expected.Key, expected.Type, event.Key, event.Type)
+			}
+		}
+		expectNoEvent(t, userEvents)
+		for _, expected := range []string{"user:1", "order:1", "user:1"} {
+			if event := receiveEvent(t, allEvents); string(event.Key) != expected {
+				t.Fatalf("expected %s, got %s", expected, event.Key)
+			}
+		}
+
+		// 取消订阅后通道关闭，不再接收事件
+		cancelUser()
+		cancelUser()
+		if err := db.Put([]byte("user:3"), []byte("c")); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := <-userEvents; ok {
+			t.Fatal("expected closed channel")
+		}
+		receiveEvent(t, allEvents)
+		cancelAll()
+	}
+}
+
+func TestDB_WatchBatchAndUpdate(t *testing.T) {
+	db := openTestDB(t, nil)
+	events, cancel := db.Watch([]byte("k"))
+	defer cancel()
+
+	if err := db.Put([]byte("k2"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	if err := wb.Put([]byte("k1"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Delete([]byte("k2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update([]byte("k3"), func(oldValue []byte) ([]byte, error) { return []byte("v"), nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	receiveEvent(t, events)
+	got := map[string]KeyEventType{}
+	for i := 0; i < 3; i++ {
+		event := receiveEvent(t, events)
+		got[string(event.Key)] = event.Type
+	}
+	if got["k1"] != KeyEventPut || got["k2"] != KeyEventDelete || got["k3"] != KeyEventPut {
+		t.Fatalf("unexpected events: %v", got)
+	}
+}
+
+// 订阅者不读取事件时写入不会被阻塞，多余的事件被丢弃；关闭数据库时通道被关闭
+func TestDB_WatchSlowConsumer(t *testing.T) {
+	db := openTestDB(t, nil)
+	events, cancel := db.Watch(nil)
+
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 2*watchChannelSize; i++ {
+			if err := db.Put(testKey(i), testValue(i)); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes blocked by slow consumer")
+	}
+
+	if len(events) != watchChannelSize {
+		t.Fatalf("expected %d buffered events, got %d", watchChannelSize, len(events))
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+	// 关闭之后取消订阅不会重复关闭通道
+	cancel()
+}